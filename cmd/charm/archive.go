@@ -0,0 +1,242 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/csclient"
+)
+
+var pushCommand = &command{
+	name:    "push",
+	args:    "<dir or archive> <id>",
+	help:    "upload a charm or bundle to the charm store",
+	minArgs: 2,
+	maxArgs: 2,
+	run:     push,
+}
+
+// push uploads the charm or bundle in the given directory or archive
+// with the given id, and prints the id it has been given. The id must
+// include the series of charms. If it includes a revision, that
+// revision is used instead of the next available one.
+func push(client *csclient.Client, args []string) error {
+	path := args[0]
+	id, err := parseId(args[1])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	bundle, err := isBundle(path)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if bundle {
+		if id.Series == "" {
+			id.Series = "bundle"
+		}
+		b, err := readBundle(path)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if id.Revision != -1 {
+			err = client.UploadBundleWithRevision(id, b)
+		} else {
+			id, err = client.UploadBundle(id, b)
+		}
+		if err != nil {
+			return errgo.Notef(err, "cannot upload bundle")
+		}
+	} else {
+		if id.Series == "" {
+			return errgo.Newf("no series specified in %q", id)
+		}
+		ch, err := readCharm(path)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if id.Revision != -1 {
+			err = client.UploadCharmWithRevision(id, ch)
+		} else {
+			id, err = client.UploadCharm(id, ch)
+		}
+		if err != nil {
+			return errgo.Notef(err, "cannot upload charm")
+		}
+	}
+	fmt.Fprintln(stdout, id)
+	return nil
+}
+
+// isBundle reports whether the directory or
+// archive at the given path holds a bundle.
+func isBundle(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	if info.IsDir() {
+		_, err := os.Stat(filepath.Join(path, "bundle.yaml"))
+		return err == nil, nil
+	}
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot read archive %q", path)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name == "bundle.yaml" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readCharm reads the charm in the given directory or archive.
+func readCharm(path string) (charm.Charm, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if info.IsDir() {
+		ch, err := charm.ReadCharmDir(path)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot read charm directory")
+		}
+		return ch, nil
+	}
+	ch, err := charm.ReadCharmArchive(path)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read charm archive")
+	}
+	return ch, nil
+}
+
+// readBundle reads the bundle in the given directory or archive.
+func readBundle(path string) (charm.Bundle, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if info.IsDir() {
+		b, err := charm.ReadBundleDir(path)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot read bundle directory")
+		}
+		return b, nil
+	}
+	b, err := charm.ReadBundleArchive(path)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read bundle archive")
+	}
+	return b, nil
+}
+
+var pullArchive bool
+
+var pullCommand = &command{
+	name:    "pull",
+	args:    "<id> [<path>]",
+	help:    "download a charm or bundle from the charm store",
+	minArgs: 1,
+	maxArgs: 2,
+	setFlags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&pullArchive, "archive", false, "Save the archive itself instead of unpacking it.")
+	},
+	run: pull,
+}
+
+// pull downloads the charm or bundle with the given id and unpacks it
+// into the given directory, which must not exist, or into a directory
+// named after the entity in the current directory. It prints the fully
+// qualified id of the entity.
+func pull(client *csclient.Client, args []string) error {
+	id, err := parseId(args[0])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	path := id.Name
+	if pullArchive {
+		path += ".zip"
+	}
+	if len(args) > 1 {
+		path = args[1]
+	}
+	if _, err := os.Stat(path); err == nil {
+		return errgo.Newf("%q already exists", path)
+	}
+	if pullArchive {
+		eid, err := client.DownloadTo(id, path)
+		if err != nil {
+			return errgo.Notef(err, "cannot download %q", id)
+		}
+		fmt.Fprintln(stdout, eid)
+		return nil
+	}
+	tmpDir, err := ioutil.TempDir("", "charm-pull")
+	if err != nil {
+		return errgo.Notef(err, "cannot make temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+	archivePath := filepath.Join(tmpDir, "archive.zip")
+	eid, err := client.DownloadTo(id, archivePath)
+	if err != nil {
+		return errgo.Notef(err, "cannot download %q", id)
+	}
+	if err := expandArchive(archivePath, eid.Series == "bundle", path); err != nil {
+		return errgo.Notef(err, "cannot unpack %q", eid)
+	}
+	fmt.Fprintln(stdout, eid)
+	return nil
+}
+
+// expandArchive expands the charm or bundle
+// archive at the given path into dir.
+func expandArchive(path string, bundle bool, dir string) error {
+	if bundle {
+		b, err := charm.ReadBundleArchive(path)
+		if err != nil {
+			return errgo.Notef(err, "cannot read bundle archive")
+		}
+		return errgo.Mask(b.ExpandTo(dir))
+	}
+	ch, err := charm.ReadCharmArchive(path)
+	if err != nil {
+		return errgo.Notef(err, "cannot read charm archive")
+	}
+	return errgo.Mask(ch.ExpandTo(dir))
+}
+
+var deleteCommand = &command{
+	name:    "delete",
+	args:    "<id>",
+	help:    "delete a charm or bundle from the charm store",
+	minArgs: 1,
+	maxArgs: 1,
+	run:     deleteArchive,
+}
+
+// deleteArchive deletes the charm or bundle
+// with the given fully qualified id.
+func deleteArchive(client *csclient.Client, args []string) error {
+	id, err := parseId(args[0])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if id.Series == "" || id.Revision == -1 {
+		return errgo.Newf("%q is not fully qualified", id)
+	}
+	if err := client.DeleteArchive(id); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/charmstore/csclient"
+)
+
+var exportCommand = &command{
+	name:    "export",
+	args:    "<bundle id> [<path>]",
+	help:    "download a bundle and all its charms for offline use",
+	minArgs: 1,
+	maxArgs: 2,
+	run:     exportBundle,
+}
+
+// exportBundle downloads the export of the bundle with the given id
+// to the given path, which must not exist, or to a tar file named
+// after the bundle in the current directory.
+func exportBundle(client *csclient.Client, args []string) error {
+	id, err := parseId(args[0])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if id.Series == "" {
+		id.Series = "bundle"
+	}
+	path := id.Name + ".tar"
+	if len(args) > 1 {
+		path = args[1]
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	r, err := client.ExportBundle(id)
+	if err == nil {
+		_, err = io.Copy(f, r)
+		r.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return errgo.Notef(err, "cannot export %q", id)
+	}
+	return nil
+}
+
+var importCommand = &command{
+	name:    "import",
+	args:    "<path>",
+	help:    "upload a bundle export to the charm store",
+	minArgs: 1,
+	maxArgs: 1,
+	run:     importBundle,
+}
+
+// importBundle uploads the bundle export at the given path, as
+// saved by the export command, and prints the id of the bundle.
+func importBundle(client *csclient.Client, args []string) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer f.Close()
+	id, err := client.ImportBundle(f)
+	if err != nil {
+		return errgo.Notef(err, "cannot import %q", args[0])
+	}
+	fmt.Fprintln(stdout, id)
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/charmstore/csclient"
+	"github.com/juju/charmstore/params"
+)
+
+// defaultShowIncludes holds the metadata shown
+// when no -include flag is specified.
+var defaultShowIncludes = []string{
+	"id",
+	"archive-size",
+	"archive-upload-time",
+	"charm-metadata",
+	"bundle-metadata",
+	"extra-info",
+	"perm",
+	"promulgated",
+}
+
+var showIncludes listFlag
+
+var showCommand = &command{
+	name:    "show",
+	args:    "<id>",
+	help:    "show the metadata of a charm or bundle",
+	minArgs: 1,
+	maxArgs: 1,
+	setFlags: func(fs *flag.FlagSet) {
+		fs.Var(&showIncludes, "include", "Comma-separated metadata to show, for example \"charm-config,stats\". By default "+strings.Join(defaultShowIncludes, ", ")+".")
+	},
+	run: show,
+}
+
+// show prints the requested metadata of the
+// charm or bundle with the given id as JSON.
+func show(client *csclient.Client, args []string) error {
+	id, err := parseId(args[0])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	includes := showIncludes.values
+	if !showIncludes.set {
+		includes = defaultShowIncludes
+	}
+	if len(includes) == 0 {
+		return errgo.New("no metadata specified")
+	}
+	var result params.MetaAnyResponse
+	if err := client.Get("/"+id.Path()+"/meta/any?"+url.Values{"include": includes}.Encode(), &result); err != nil {
+		return errgo.Notef(err, "cannot get metadata of %q", id)
+	}
+	return printJSON(result.Meta)
+}
+
+var (
+	searchLimit   int
+	searchInclude listFlag
+	searchSeries  listFlag
+	searchOwner   listFlag
+	searchType    string
+)
+
+var searchCommand = &command{
+	name:    "search",
+	args:    "[<text>...]",
+	help:    "search for charms and bundles",
+	minArgs: 0,
+	maxArgs: -1,
+	setFlags: func(fs *flag.FlagSet) {
+		fs.IntVar(&searchLimit, "limit", 0, "Maximum number of results.")
+		fs.Var(&searchInclude, "include", "Comma-separated metadata to show for each result. If specified, the results are printed as JSON.")
+		fs.Var(&searchSeries, "series", "Comma-separated series of the results.")
+		fs.Var(&searchOwner, "owner", "Comma-separated owners of the results.")
+		fs.StringVar(&searchType, "type", "", "Type of the results: charm or bundle.")
+	},
+	run: search,
+}
+
+// search prints the ids of the charms and bundles matching the given
+// text, one per line, or the results as JSON if metadata is requested.
+func search(client *csclient.Client, args []string) error {
+	filters := make(map[string][]string)
+	if len(searchSeries.values) > 0 {
+		filters["series"] = searchSeries.values
+	}
+	if len(searchOwner.values) > 0 {
+		filters["owner"] = searchOwner.values
+	}
+	if searchType != "" {
+		filters["type"] = []string{searchType}
+	}
+	resp, err := client.Search(csclient.SearchParams{
+		Text:    strings.Join(args, " "),
+		Filters: filters,
+		Limit:   searchLimit,
+		Include: searchInclude.values,
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if len(searchInclude.values) > 0 {
+		return printJSON(resp.Results)
+	}
+	for _, result := range resp.Results {
+		fmt.Fprintln(stdout, result.Id)
+	}
+	return nil
+}
+
+var revisionsCommand = &command{
+	name:    "revisions",
+	args:    "<id>",
+	help:    "list the revisions of a charm or bundle",
+	minArgs: 1,
+	maxArgs: 1,
+	run:     revisions,
+}
+
+// revisions prints the ids of all the revisions of the charm
+// or bundle with the given id, most recent first.
+func revisions(client *csclient.Client, args []string) error {
+	id, err := parseId(args[0])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	info, err := client.RevisionInfo(id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, rid := range info.Revisions {
+		fmt.Fprintln(stdout, rid)
+	}
+	return nil
+}
+
+var (
+	logsLimit int
+	logsId    string
+	logsLevel string
+	logsType  string
+)
+
+var logsCommand = &command{
+	name:    "logs",
+	args:    "",
+	help:    "show the log messages stored in the charm store",
+	minArgs: 0,
+	maxArgs: 0,
+	setFlags: func(fs *flag.FlagSet) {
+		fs.IntVar(&logsLimit, "limit", 0, "Maximum number of messages.")
+		fs.StringVar(&logsId, "id", "", "Show only the messages about the given charm or bundle.")
+		fs.StringVar(&logsLevel, "level", "", "Show only the messages with the given level: info, warning or error.")
+		fs.StringVar(&logsType, "type", "", "Show only the messages with the given type, for instance ingestion.")
+	},
+	run: logs,
+}
+
+// logs prints the log messages matching
+// the flags, most recent first.
+func logs(client *csclient.Client, args []string) error {
+	p := csclient.LogsParams{
+		Limit: logsLimit,
+		Level: params.LogLevel(logsLevel),
+		Type:  params.LogType(logsType),
+	}
+	if logsId != "" {
+		id, err := parseId(logsId)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		p.Id = id
+	}
+	msgs, err := client.GetLogs(p)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, msg := range msgs {
+		urls := make([]string, len(msg.URLs))
+		for i, u := range msg.URLs {
+			urls[i] = u.String()
+		}
+		fmt.Fprintf(stdout, "%s %s %s %s", msg.Time.Format(time.RFC3339), msg.Level, msg.Type, logMessage(msg.Data))
+		if len(urls) > 0 {
+			fmt.Fprintf(stdout, " [%s]", strings.Join(urls, " "))
+		}
+		fmt.Fprintln(stdout)
+	}
+	return nil
+}
+
+// logMessage returns the given log data as text: strings
+// are returned unquoted, other values as JSON.
+func logMessage(data json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return s
+	}
+	return string(data)
+}
+
+// printJSON prints the given value as indented JSON.
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal result")
+	}
+	fmt.Fprintf(stdout, "%s\n", data)
+	return nil
+}
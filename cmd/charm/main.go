@@ -0,0 +1,205 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The charm command provides access to the charm store from the
+// command line: it can upload, download, show, search for and
+// delete charms and bundles, manage their permissions and
+// extra-info, and export bundles for offline use.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/csclient"
+)
+
+// stdout holds the writer the results of the commands are written to.
+var stdout io.Writer = os.Stdout
+
+// command holds a subcommand of the charm command.
+type command struct {
+	// name holds the name of the command.
+	name string
+
+	// args describes the positional arguments of the command.
+	args string
+
+	// help holds a one line description of the command.
+	help string
+
+	// minArgs and maxArgs hold the allowed number of positional
+	// arguments. If maxArgs is -1, any number is allowed.
+	minArgs, maxArgs int
+
+	// setFlags, if not nil, defines the flags specific to the command.
+	setFlags func(fs *flag.FlagSet)
+
+	// run runs the command with the given client and arguments.
+	run func(client *csclient.Client, args []string) error
+}
+
+// commands holds all the subcommands, in the order they are listed.
+var commands = []*command{
+	pushCommand,
+	pullCommand,
+	showCommand,
+	searchCommand,
+	revisionsCommand,
+	getPermCommand,
+	setPermCommand,
+	setExtraInfoCommand,
+	exportCommand,
+	importCommand,
+	deleteCommand,
+	logsCommand,
+}
+
+var prog = filepath.Base(os.Args[0])
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [options] [arguments]\n\ncommands:\n", prog)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s%s\n", cmd.name, cmd.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nuse \"%s <command> -help\" for the options of a command\n", prog)
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd := findCommand(os.Args[1])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "%s: unknown command %q\n", prog, os.Args[1])
+		usage()
+	}
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	var cf clientFlags
+	cf.register(fs)
+	if cmd.setFlags != nil {
+		cmd.setFlags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s [options] %s\n\n%s\n\noptions:\n", prog, cmd.name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	fs.Parse(os.Args[2:])
+	if fs.NArg() < cmd.minArgs || cmd.maxArgs >= 0 && fs.NArg() > cmd.maxArgs {
+		fs.Usage()
+	}
+	if cf.loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(cf.loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := cmd.run(cf.newClient(), fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", prog, cmd.name, err)
+		os.Exit(1)
+	}
+}
+
+// findCommand returns the command with the given
+// name, or nil if there is none.
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// clientFlags holds the flags common to all the commands,
+// which specify how to connect to the charm store.
+type clientFlags struct {
+	url           string
+	user          string
+	password      string
+	cookieFile    string
+	cacheDir      string
+	loggingConfig string
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.url, "url", csclient.ServerURL, "URL of the charm store.")
+	fs.StringVar(&f.user, "user", "", "User name for basic authentication with the charm store.")
+	fs.StringVar(&f.password, "password", "", "Password for basic authentication with the charm store.")
+	fs.StringVar(&f.cookieFile, "cookie-file", defaultCookieFile(), "File holding the authorization cookies, so that authentication is not required for each command. If empty, cookies are not saved.")
+	fs.StringVar(&f.cacheDir, "cache-dir", "", "Directory where downloaded archives are cached.")
+	fs.StringVar(&f.loggingConfig, "logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
+}
+
+// newClient returns a charm store client configured by the flags.
+func (f *clientFlags) newClient() *csclient.Client {
+	return csclient.New(csclient.Params{
+		URL:          f.url,
+		User:         f.user,
+		Password:     f.password,
+		CookieFile:   f.cookieFile,
+		CacheDir:     f.cacheDir,
+		RetryPolicy:  csclient.DefaultRetryPolicy,
+		VisitWebPage: visitWebPage,
+	})
+}
+
+// defaultCookieFile returns the cookie file used when
+// none is specified, or the empty string if the home
+// directory is not known.
+func defaultCookieFile() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".charmstore-cookies")
+}
+
+// visitWebPage asks the user to visit the given URL
+// to authenticate themselves.
+func visitWebPage(u *url.URL) error {
+	fmt.Fprintf(os.Stderr, "Please visit this web page to authenticate:\n%s\n", u)
+	return nil
+}
+
+// parseId parses the given charm or bundle id.
+func parseId(s string) (*charm.Reference, error) {
+	id, err := charm.ParseReference(s)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid id %q", s)
+	}
+	return id, nil
+}
+
+// listFlag implements flag.Value for comma-separated lists,
+// recording whether the flag was specified.
+type listFlag struct {
+	set    bool
+	values []string
+}
+
+func (f *listFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *listFlag) Set(s string) error {
+	f.set = true
+	f.values = []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			f.values = append(f.values, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/charmstore/csclient"
+)
+
+var getPermCommand = &command{
+	name:    "get-perm",
+	args:    "<id>",
+	help:    "show the permissions of a charm or bundle",
+	minArgs: 1,
+	maxArgs: 1,
+	run:     getPerm,
+}
+
+// getPerm prints the users and groups allowed to read
+// and write the charm or bundle with the given id.
+func getPerm(client *csclient.Client, args []string) error {
+	id, err := parseId(args[0])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	perm, err := client.Perm(id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	fmt.Fprintf(stdout, "read: %s\n", strings.Join(perm.Read, ", "))
+	fmt.Fprintf(stdout, "write: %s\n", strings.Join(perm.Write, ", "))
+	return nil
+}
+
+var setPermRead, setPermWrite listFlag
+
+var setPermCommand = &command{
+	name:    "set-perm",
+	args:    "<id>",
+	help:    "set the permissions of a charm or bundle",
+	minArgs: 1,
+	maxArgs: 1,
+	setFlags: func(fs *flag.FlagSet) {
+		fs.Var(&setPermRead, "read", "Comma-separated users and groups allowed to read the charm or bundle, or everyone. If not specified, the read permissions are unchanged.")
+		fs.Var(&setPermWrite, "write", "Comma-separated users and groups allowed to write the charm or bundle. If not specified, the write permissions are unchanged.")
+	},
+	run: setPerm,
+}
+
+// setPerm sets the permissions of the charm or bundle with the given
+// id. Only the permissions specified by the flags are changed.
+func setPerm(client *csclient.Client, args []string) error {
+	id, err := parseId(args[0])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if !setPermRead.set && !setPermWrite.set {
+		return errgo.New("no permissions specified")
+	}
+	perm, err := client.Perm(id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if setPermRead.set {
+		perm.Read = setPermRead.values
+	}
+	if setPermWrite.set {
+		perm.Write = setPermWrite.values
+	}
+	if err := client.SetPerm(id, *perm); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+var setExtraInfoCommand = &command{
+	name:    "set-extra-info",
+	args:    "<id> <key>=<value>...",
+	help:    "set extra-info values of a charm or bundle",
+	minArgs: 2,
+	maxArgs: -1,
+	run:     setExtraInfo,
+}
+
+// setExtraInfo sets the given extra-info values of the charm or bundle
+// with the given id. Values that are valid JSON are stored as such,
+// and other values as strings, so that for instance "x=1" stores
+// a number and "x=foo" a string.
+func setExtraInfo(client *csclient.Client, args []string) error {
+	id, err := parseId(args[0])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	info := make(map[string]interface{})
+	for _, arg := range args[1:] {
+		i := strings.Index(arg, "=")
+		if i <= 0 {
+			return errgo.Newf("invalid extra-info value %q: expected <key>=<value>", arg)
+		}
+		key, val := arg[:i], arg[i+1:]
+		var v interface{}
+		if err := json.Unmarshal([]byte(val), &v); err != nil {
+			v = val
+		}
+		info[key] = v
+	}
+	if err := client.PutExtraInfo(id, info); err != nil {
+		return errgo.Notef(err, "cannot set extra-info of %q", id)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/juju/loggo"
 	"gopkg.in/errgo.v1"
@@ -21,6 +22,10 @@ import (
 	"github.com/juju/charmstore/internal/elasticsearch"
 )
 
+// defaultWebhookDeliveryInterval holds the interval between
+// webhook deliveries used when none is configured.
+const defaultWebhookDeliveryInterval = 10 * time.Second
+
 var (
 	logger        = loggo.GetLogger("charmd")
 	loggingConfig = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
@@ -72,9 +77,22 @@ func serve(confPath string) error {
 
 	logger.Infof("setting up the API server")
 	cfg := charmstore.ServerParams{
-		AuthUsername:     conf.AuthUsername,
-		AuthPassword:     conf.AuthPassword,
-		IdentityLocation: conf.IdentityLocation,
+		AuthUsername:               conf.AuthUsername,
+		AuthPassword:               conf.AuthPassword,
+		IdentityLocation:           conf.IdentityLocation,
+		MaxArchiveSize:             conf.MaxArchiveSize,
+		MaxRevisions:               conf.MaxRevisions,
+		MaxUserStorage:             conf.MaxUserStorage,
+		MaxArchiveUncompressedSize: conf.MaxArchiveUncompressedSize,
+		MaxArchiveEntries:          conf.MaxArchiveEntries,
+		MaxArchiveCompressionRatio: conf.MaxArchiveCompressionRatio,
+		Validators:                 conf.Validators,
+		NamespaceValidators:        conf.NamespaceValidators,
+		WebhookDeliveryInterval:    time.Duration(conf.WebhookDeliveryInterval) * time.Second,
+		AllowPrivateWebhooks:       conf.AllowPrivateWebhooks,
+	}
+	if cfg.WebhookDeliveryInterval == 0 {
+		cfg.WebhookDeliveryInterval = defaultWebhookDeliveryInterval
 	}
 	var identityPublicKey bakery.PublicKey
 	err = identityPublicKey.UnmarshalText([]byte(conf.IdentityPublicKey))
@@ -88,6 +106,7 @@ func serve(confPath string) error {
 	if err != nil {
 		return errgo.Notef(err, "cannot create new server at %q", conf.APIAddr)
 	}
+	defer server.Close()
 
 	logger.Infof("starting the API server")
 	return http.ListenAndServe(conf.APIAddr, debug.Handler("", server))
@@ -0,0 +1,146 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The csmirror command copies charms and bundles from an upstream
+// charm store into a local one.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/charmstore/csclient"
+	"github.com/juju/charmstore/internal/mirror"
+)
+
+var logger = loggo.GetLogger("csmirror")
+
+var (
+	upstreamURL      = flag.String("upstream", csclient.ServerURL, "URL of the charm store to mirror.")
+	upstreamUser     = flag.String("upstream-user", "", "User name for the upstream charm store.")
+	upstreamPassword = flag.String("upstream-password", "", "Password for the upstream charm store.")
+	localUser        = flag.String("user", "", "Administrator user name for the local charm store.")
+	localPassword    = flag.String("password", "", "Administrator password for the local charm store.")
+	namespaces       = flag.String("namespaces", "", "Comma-separated users or teams whose charms and bundles are mirrored.")
+	series           = flag.String("series", "", "Comma-separated series of the charms and bundles to mirror.")
+	queries          = flag.String("queries", "", "Comma-separated search queries selecting the charms and bundles to mirror.")
+	preserveACLs     = flag.Bool("acls", false, "Copy the permissions of the charms and bundles.")
+	statePath        = flag.String("state", "", "File holding the synchronisation state, so that mirroring resumes from the last synced change.")
+	loggingConfig    = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <local charm store URL>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := mirrorStore(flag.Arg(0)); err != nil {
+		logger.Errorf("cannot mirror charm store: %v", err)
+		os.Exit(1)
+	}
+}
+
+func mirrorStore(localURL string) error {
+	state, err := readState(*statePath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	m := mirror.New(mirror.Params{
+		Upstream: csclient.New(csclient.Params{
+			URL:      *upstreamURL,
+			User:     *upstreamUser,
+			Password: *upstreamPassword,
+		}),
+		Local: csclient.New(csclient.Params{
+			URL:      localURL,
+			User:     *localUser,
+			Password: *localPassword,
+		}),
+		Namespaces:   splitList(*namespaces),
+		Series:       splitList(*series),
+		Queries:      splitList(*queries),
+		PreserveACLs: *preserveACLs,
+	})
+	ids, syncErr := m.Sync(state)
+	logger.Infof("mirrored %d charms and bundles", len(ids))
+	// Save the state even when the synchronisation failed, so that
+	// the charms and bundles already mirrored are not checked again.
+	if err := writeState(*statePath, state); err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(syncErr)
+}
+
+// readState reads the synchronisation state from the given
+// file. It returns the zero state if path is empty or the file
+// does not exist.
+func readState(path string) (*mirror.State, error) {
+	var state mirror.State
+	if path == "" {
+		return &state, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &state, nil
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read state file")
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal state file %q", path)
+	}
+	return &state, nil
+}
+
+// writeState writes the synchronisation state
+// to the given file, unless path is empty.
+func writeState(path string, state *mirror.State) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal state")
+	}
+	// Write to a temporary file first so that the
+	// state is never left partially written.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errgo.Notef(err, "cannot write state file")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errgo.Notef(err, "cannot write state file")
+	}
+	return nil
+}
+
+// splitList returns the elements of the
+// given comma-separated list.
+func splitList(s string) []string {
+	var elems []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
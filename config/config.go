@@ -24,6 +24,31 @@ type Config struct {
 	ESAddr            string `yaml:"elasticsearch-addr"` // elasticsearch is optional
 	IdentityPublicKey string `yaml:"identity-public-key"`
 	IdentityLocation  string `yaml:"identity-location"`
+
+	// The upload limits are optional; zero means no limit.
+	MaxArchiveSize int64 `yaml:"max-archive-size"`
+	MaxRevisions   int   `yaml:"max-revisions"`
+	MaxUserStorage int64 `yaml:"max-user-storage"`
+
+	// The archive inspection limits are optional; zero means
+	// that the default limit is used.
+	MaxArchiveUncompressedSize int64   `yaml:"max-archive-uncompressed-size"`
+	MaxArchiveEntries          int     `yaml:"max-archive-entries"`
+	MaxArchiveCompressionRatio float64 `yaml:"max-archive-compression-ratio"`
+
+	// Validators optionally lists the upload validators to run,
+	// and NamespaceValidators overrides them for specific users
+	// or teams.
+	Validators          []string            `yaml:"validators"`
+	NamespaceValidators map[string][]string `yaml:"namespace-validators"`
+
+	// WebhookDeliveryInterval optionally holds the number of
+	// seconds between attempts to deliver webhook payloads.
+	WebhookDeliveryInterval int `yaml:"webhook-delivery-interval"`
+
+	// AllowPrivateWebhooks allows webhooks on hosts that
+	// resolve to loopback, private or reserved addresses.
+	AllowPrivateWebhooks bool `yaml:"allow-private-webhooks"`
 }
 
 func (c *Config) validate() error {
@@ -46,6 +71,15 @@ func (c *Config) validate() error {
 	if len(missing) != 0 {
 		return fmt.Errorf("missing fields %s in config file", strings.Join(missing, ", "))
 	}
+	if c.MaxArchiveSize < 0 || c.MaxRevisions < 0 || c.MaxUserStorage < 0 {
+		return fmt.Errorf("upload limits must not be negative")
+	}
+	if c.MaxArchiveUncompressedSize < 0 || c.MaxArchiveEntries < 0 || c.MaxArchiveCompressionRatio < 0 {
+		return fmt.Errorf("archive limits must not be negative")
+	}
+	if c.WebhookDeliveryInterval < 0 {
+		return fmt.Errorf("webhook delivery interval must not be negative")
+	}
 	return nil
 }
 
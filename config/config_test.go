@@ -6,6 +6,7 @@ package config_test
 import (
 	"io/ioutil"
 	"path"
+	"strings"
 	"testing"
 
 	jujutesting "github.com/juju/testing"
@@ -34,6 +35,15 @@ auth-username: myuser
 auth-password: mypasswd
 identity-location: localhost:18082
 identity-public-key: 0000
+max-archive-size: 1000000
+max-revisions: 50
+max-user-storage: 20000000
+max-archive-entries: 500
+validators: [metadata, readme]
+namespace-validators:
+  acme: [metadata, readme, icon]
+webhook-delivery-interval: 30
+allow-private-webhooks: true
 `
 
 func (s *ConfigSuite) readConfig(c *gc.C, content string) (*config.Config, error) {
@@ -56,6 +66,16 @@ func (s *ConfigSuite) TestRead(c *gc.C) {
 		AuthPassword:      "mypasswd",
 		IdentityLocation:  "localhost:18082",
 		IdentityPublicKey: "0000",
+		MaxArchiveSize:    1000000,
+		MaxRevisions:      50,
+		MaxUserStorage:    20000000,
+		MaxArchiveEntries: 500,
+		Validators:        []string{"metadata", "readme"},
+		NamespaceValidators: map[string][]string{
+			"acme": {"metadata", "readme", "icon"},
+		},
+		WebhookDeliveryInterval: 30,
+		AllowPrivateWebhooks:    true,
 	})
 }
 
@@ -70,3 +90,15 @@ func (s *ConfigSuite) TestValidateConfigError(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, "missing fields mongo-url, api-addr, auth-username, auth-password in config file")
 	c.Assert(cfg, gc.IsNil)
 }
+
+func (s *ConfigSuite) TestValidateNegativeLimits(c *gc.C) {
+	cfg, err := s.readConfig(c, strings.Replace(testConfig, "max-revisions: 50", "max-revisions: -1", 1))
+	c.Assert(err, gc.ErrorMatches, "upload limits must not be negative")
+	c.Assert(cfg, gc.IsNil)
+}
+
+func (s *ConfigSuite) TestValidateNegativeWebhookDeliveryInterval(c *gc.C) {
+	cfg, err := s.readConfig(c, strings.Replace(testConfig, "webhook-delivery-interval: 30", "webhook-delivery-interval: -1", 1))
+	c.Assert(err, gc.ErrorMatches, "webhook delivery interval must not be negative")
+	c.Assert(cfg, gc.IsNil)
+}
@@ -0,0 +1,363 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/params"
+)
+
+// dateFormat holds the format of the dates sent
+// in the start and stop query parameters.
+const dateFormat = "2006-01-02"
+
+// SearchParams holds the parameters of a search request.
+type SearchParams struct {
+	// Text holds the text to search for.
+	Text string
+
+	// AutoComplete specifies that the text is matched as
+	// the prefix of charm and bundle names.
+	AutoComplete bool
+
+	// Filters holds the values to restrict the results to, keyed
+	// by field: one of "description", "name", "owner",
+	// "provides", "requires", "series", "summary", "tags"
+	// and "type".
+	Filters map[string][]string
+
+	// Limit holds the maximum number of results to return.
+	// If zero, the server default is used.
+	Limit int
+
+	// Skip holds the number of results to skip.
+	Skip int
+
+	// Include holds the metadata to return for each result,
+	// as for Meta.
+	Include []string
+
+	// Sort holds the fields to sort the results by, for example
+	// "name" or "-downloads" for descending order.
+	Sort []string
+}
+
+// Search searches the charm store for charms
+// and bundles matching the given parameters.
+func (c *Client) Search(p SearchParams) (*params.SearchResponse, error) {
+	v := make(url.Values)
+	if p.Text != "" {
+		v.Set("text", p.Text)
+	}
+	if p.AutoComplete {
+		v.Set("autocomplete", "1")
+	}
+	for k, vals := range p.Filters {
+		v[k] = vals
+	}
+	if p.Limit > 0 {
+		v.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Skip > 0 {
+		v.Set("skip", strconv.Itoa(p.Skip))
+	}
+	addIncludes(v, p.Include)
+	if len(p.Sort) > 0 {
+		v.Set("sort", strings.Join(p.Sort, ","))
+	}
+	var result params.SearchResponse
+	if err := c.Get(withQuery("/search", v), &result); err != nil {
+		return nil, errgo.NoteMask(err, "cannot search", errgo.Any)
+	}
+	return &result, nil
+}
+
+// StatsCounterParams holds the parameters of a stats counter request.
+type StatsCounterParams struct {
+	// Key holds the elements of the counter key,
+	// for instance {"archive-download", "trusty"}.
+	Key []string
+
+	// Prefix specifies that all the counters whose
+	// key starts with Key are counted.
+	Prefix bool
+
+	// List specifies that the counts for each key
+	// one level below Key are returned separately.
+	List bool
+
+	// By holds the period the counts are grouped by:
+	// either "day", "week" or empty for no grouping.
+	By string
+
+	// Start and Stop restrict the counts to the given
+	// days, when not zero.
+	Start, Stop time.Time
+}
+
+// StatsCounter returns the values of the
+// counters specified by the given parameters.
+func (c *Client) StatsCounter(p StatsCounterParams) ([]params.Statistic, error) {
+	if len(p.Key) == 0 {
+		return nil, errgo.New("no counter key specified")
+	}
+	key := strings.Join(p.Key, ":")
+	if p.Prefix {
+		key += ":*"
+	}
+	v := make(url.Values)
+	if p.List {
+		v.Set("list", "1")
+	}
+	if p.By != "" {
+		v.Set("by", p.By)
+	}
+	addDateRange(v, p.Start, p.Stop)
+	var result []params.Statistic
+	if err := c.Get(withQuery("/stats/counter/"+key, v), &result); err != nil {
+		return nil, errgo.NoteMask(err, "cannot get stats counter", errgo.Any)
+	}
+	return result, nil
+}
+
+// PublishedParams holds the parameters of a request
+// for the charms and bundles published in the charm store.
+type PublishedParams struct {
+	// Limit holds the maximum number of results to return.
+	// If zero, all the results are returned.
+	Limit int
+
+	// Start and Stop restrict the results to the charms and
+	// bundles published on the given days, when not zero.
+	Start, Stop time.Time
+
+	// Cursor holds the cursor returned by the previous
+	// request, to get the next page of results.
+	Cursor string
+
+	// Owners, Series and Type restrict the results to the
+	// charms and bundles owned by one of the given users,
+	// with one of the given series, or of the given type
+	// ("charm" or "bundle").
+	Owners []string
+	Series []string
+	Type   string
+
+	// Include holds the metadata to return for each
+	// result, as for Meta.
+	Include []string
+}
+
+// Published returns the charms and bundles published in the charm
+// store, most recently published first. When Limit is specified and
+// there may be more results, it also returns the cursor to pass in
+// the next request to get them.
+func (c *Client) Published(p PublishedParams) (results []params.Published, nextCursor string, err error) {
+	v := make(url.Values)
+	if p.Limit > 0 {
+		v.Set("limit", strconv.Itoa(p.Limit))
+	}
+	addDateRange(v, p.Start, p.Stop)
+	if p.Cursor != "" {
+		v.Set("cursor", p.Cursor)
+	}
+	if len(p.Owners) > 0 {
+		v["owner"] = p.Owners
+	}
+	if len(p.Series) > 0 {
+		v["series"] = p.Series
+	}
+	if p.Type != "" {
+		v.Set("type", p.Type)
+	}
+	addIncludes(v, p.Include)
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		return nil, "", errgo.Notef(err, "cannot make new request")
+	}
+	resp, err := c.Do(req, withQuery("/changes/published", v))
+	if err != nil {
+		return nil, "", errgo.NoteMask(err, "cannot get published entities", errgo.Any)
+	}
+	defer resp.Body.Close()
+	if err := parseResponseBody(resp.Body, &results); err != nil {
+		return nil, "", errgo.Mask(err)
+	}
+	return results, resp.Header.Get(params.NextCursorHeader), nil
+}
+
+// ExpandId returns the fully qualified ids of the
+// charms and bundles matching the given id.
+func (c *Client) ExpandId(id *charm.Reference) ([]params.ExpandedId, error) {
+	var result []params.ExpandedId
+	if err := c.Get("/"+id.Path()+"/expand-id", &result); err != nil {
+		return nil, errgo.NoteMask(err, "cannot expand id", errgo.Any)
+	}
+	return result, nil
+}
+
+// Perm returns the permissions of the charm or bundle with the given
+// id. The permissions are shared by all the revisions and series of
+// an entity.
+func (c *Client) Perm(id *charm.Reference) (*params.PermResponse, error) {
+	var result params.PermResponse
+	if err := c.Get("/"+id.Path()+"/meta/perm", &result); err != nil {
+		return nil, errgo.NoteMask(err, "cannot get permissions", errgo.Any)
+	}
+	return &result, nil
+}
+
+// SetPerm sets the permissions of the charm
+// or bundle with the given id.
+func (c *Client) SetPerm(id *charm.Reference, perm params.PermResponse) error {
+	body := struct {
+		Meta map[string][]string
+	}{
+		Meta: map[string][]string{
+			"perm/read":  perm.Read,
+			"perm/write": perm.Write,
+		},
+	}
+	if err := c.Put("/"+id.Path()+"/meta/any", body); err != nil {
+		return errgo.NoteMask(err, "cannot set permissions", errgo.Any)
+	}
+	return nil
+}
+
+// RevisionInfo returns the revisions of the charm or
+// bundle with the given id, most recent first.
+func (c *Client) RevisionInfo(id *charm.Reference) (*params.RevisionInfoResponse, error) {
+	var result params.RevisionInfoResponse
+	if err := c.Get("/"+id.Path()+"/meta/revision-info", &result); err != nil {
+		return nil, errgo.NoteMask(err, "cannot get revision info", errgo.Any)
+	}
+	return &result, nil
+}
+
+// LogsParams holds the parameters of a request for log messages.
+type LogsParams struct {
+	// Limit holds the maximum number of messages to return.
+	// If zero, the server default is used.
+	Limit int
+
+	// Skip holds the number of messages to skip.
+	Skip int
+
+	// Id, Level and Type restrict the results to the
+	// messages associated with the given id, with
+	// the given level or of the given type.
+	Id    *charm.Reference
+	Level params.LogLevel
+	Type  params.LogType
+}
+
+// GetLogs returns the log messages stored in the
+// charm store, most recent first.
+func (c *Client) GetLogs(p LogsParams) ([]params.LogResponse, error) {
+	v := make(url.Values)
+	if p.Limit > 0 {
+		v.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Skip > 0 {
+		v.Set("skip", strconv.Itoa(p.Skip))
+	}
+	if p.Id != nil {
+		v.Set("id", p.Id.String())
+	}
+	if p.Level != "" {
+		v.Set("level", string(p.Level))
+	}
+	if p.Type != "" {
+		v.Set("type", string(p.Type))
+	}
+	var result []params.LogResponse
+	if err := c.Get(withQuery("/log", v), &result); err != nil {
+		return nil, errgo.NoteMask(err, "cannot get logs", errgo.Any)
+	}
+	return result, nil
+}
+
+// GetReadMe returns the README file of the
+// charm or bundle with the given id.
+func (c *Client) GetReadMe(id *charm.Reference) (io.ReadCloser, error) {
+	return c.getContent(id, "readme")
+}
+
+// GetIcon returns the SVG icon of the charm with the given id.
+func (c *Client) GetIcon(id *charm.Reference) (io.ReadCloser, error) {
+	return c.getContent(id, "icon.svg")
+}
+
+// GetDiagram returns the SVG diagram of the bundle with the given id.
+func (c *Client) GetDiagram(id *charm.Reference) (io.ReadCloser, error) {
+	return c.getContent(id, "diagram.svg")
+}
+
+// getContent returns the body of the response
+// to a GET request to the given id endpoint.
+func (c *Client) getContent(id *charm.Reference, endpoint string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot make new request")
+	}
+	resp, err := c.Do(req, "/"+id.Path()+"/"+endpoint)
+	if err != nil {
+		return nil, errgo.NoteMask(err, "cannot get "+endpoint, errgo.Any)
+	}
+	return resp.Body, nil
+}
+
+// DeleteArchive deletes the charm or bundle with the given
+// id, which must be fully qualified.
+func (c *Client) DeleteArchive(id *charm.Reference) error {
+	req, err := http.NewRequest("DELETE", "", nil)
+	if err != nil {
+		return errgo.Notef(err, "cannot make new request")
+	}
+	resp, err := c.Do(req, "/"+id.Path()+"/archive")
+	if err != nil {
+		return errgo.NoteMask(err, "cannot delete archive", errgo.Any)
+	}
+	resp.Body.Close()
+	if c.cache != nil {
+		c.cache.remove(id)
+	}
+	return nil
+}
+
+// withQuery returns the given path with
+// the given query parameters appended.
+func withQuery(path string, v url.Values) string {
+	if len(v) == 0 {
+		return path
+	}
+	return path + "?" + v.Encode()
+}
+
+// addIncludes adds the given metadata includes to v.
+func addIncludes(v url.Values, include []string) {
+	if len(include) > 0 {
+		v["include"] = include
+	}
+}
+
+// addDateRange adds the given start and stop
+// days to v, when they are not zero.
+func addDateRange(v url.Values, start, stop time.Time) {
+	if !start.IsZero() {
+		v.Set("start", start.Format(dateFormat))
+	}
+	if !stop.IsZero() {
+		v.Set("stop", stop.Format(dateFormat))
+	}
+}
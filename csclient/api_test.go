@@ -0,0 +1,369 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/csclient"
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+// recordingRoundTripper records the URL of each request
+// and responds with the given JSON-encoded value.
+type recordingRoundTripper struct {
+	header http.Header
+	result interface{}
+	urls   []*url.URL
+}
+
+func (r *recordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.urls = append(r.urls, req.URL)
+	header := http.Header{"Content-Type": {"application/json"}}
+	for k, v := range r.header {
+		header[k] = v
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       ioutil.NopCloser(bytes.NewReader(mustMarshalJSON(r.result))),
+	}, nil
+}
+
+func recordingClient(rt *recordingRoundTripper) *csclient.Client {
+	return csclient.New(csclient.Params{
+		URL: "http://0.1.2.3",
+		HTTPClient: &http.Client{
+			Transport: rt,
+		},
+	})
+}
+
+func (s *suite) TestSearch(c *gc.C) {
+	rt := &recordingRoundTripper{
+		result: params.SearchResponse{
+			Total: 1,
+			Results: []params.SearchResult{{
+				Id: charm.MustParseReference("cs:trusty/wordpress-3"),
+			}},
+		},
+	}
+	result, err := recordingClient(rt).Search(csclient.SearchParams{
+		Text:         "word",
+		AutoComplete: true,
+		Filters: map[string][]string{
+			"series": {"trusty", "precise"},
+			"owner":  {""},
+		},
+		Limit:   5,
+		Skip:    10,
+		Include: []string{"archive-size", "charm-metadata"},
+		Sort:    []string{"-downloads", "name"},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, &params.SearchResponse{
+		Total: 1,
+		Results: []params.SearchResult{{
+			Id: charm.MustParseReference("cs:trusty/wordpress-3"),
+		}},
+	})
+	c.Assert(rt.urls, gc.HasLen, 1)
+	c.Assert(rt.urls[0].Path, gc.Equals, "/v4/search")
+	c.Assert(rt.urls[0].Query(), jc.DeepEquals, url.Values{
+		"text":         {"word"},
+		"autocomplete": {"1"},
+		"series":       {"trusty", "precise"},
+		"owner":        {""},
+		"limit":        {"5"},
+		"skip":         {"10"},
+		"include":      {"archive-size", "charm-metadata"},
+		"sort":         {"-downloads,name"},
+	})
+}
+
+func (s *suite) TestSearchNoParams(c *gc.C) {
+	rt := &recordingRoundTripper{
+		result: params.SearchResponse{},
+	}
+	_, err := recordingClient(rt).Search(csclient.SearchParams{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(rt.urls[0].RawQuery, gc.Equals, "")
+}
+
+func (s *suite) TestStatsCounter(c *gc.C) {
+	for _, key := range [][]string{
+		{"a", "b"},
+		{"a", "b"},
+		{"a", "c"},
+		{"d"},
+	} {
+		err := s.store.IncCounter(key)
+		c.Assert(err, gc.IsNil)
+	}
+	stats, err := s.client.StatsCounter(csclient.StatsCounterParams{
+		Key: []string{"a", "b"},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(stats, jc.DeepEquals, []params.Statistic{{
+		Count: 2,
+	}})
+	stats, err = s.client.StatsCounter(csclient.StatsCounterParams{
+		Key:    []string{"a"},
+		Prefix: true,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(stats, jc.DeepEquals, []params.Statistic{{
+		Count: 3,
+	}})
+}
+
+func (s *suite) TestStatsCounterParams(c *gc.C) {
+	rt := &recordingRoundTripper{
+		result: []params.Statistic{},
+	}
+	_, err := recordingClient(rt).StatsCounter(csclient.StatsCounterParams{
+		Key:    []string{"archive-download", "trusty"},
+		Prefix: true,
+		List:   true,
+		By:     "week",
+		Start:  time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC),
+		Stop:   time.Date(2015, 3, 31, 0, 0, 0, 0, time.UTC),
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(rt.urls[0].Path, gc.Equals, "/v4/stats/counter/archive-download:trusty:*")
+	c.Assert(rt.urls[0].Query(), jc.DeepEquals, url.Values{
+		"list":  {"1"},
+		"by":    {"week"},
+		"start": {"2015-03-01"},
+		"stop":  {"2015-03-31"},
+	})
+}
+
+func (s *suite) TestStatsCounterNoKey(c *gc.C) {
+	_, err := s.client.StatsCounter(csclient.StatsCounterParams{})
+	c.Assert(err, gc.ErrorMatches, "no counter key specified")
+}
+
+func (s *suite) TestPublished(c *gc.C) {
+	ids := []string{
+		"cs:~who/utopic/wordpress-0",
+		"cs:~who/trusty/mysql-0",
+		"cs:~bob/utopic/mysql-0",
+	}
+	for _, id := range ids {
+		ref := charm.MustParseReference(id)
+		err := s.store.AddCharmWithArchive(ref, storetesting.Charms.CharmArchive(c.MkDir(), ref.Name))
+		c.Assert(err, gc.IsNil)
+	}
+	// Follow the cursors to get all the results.
+	var got []string
+	p := csclient.PublishedParams{
+		Limit:  2,
+		Owners: []string{"who", "bob"},
+	}
+	for {
+		results, cursor, err := s.client.Published(p)
+		c.Assert(err, gc.IsNil)
+		c.Assert(len(results) <= 2, gc.Equals, true)
+		for _, r := range results {
+			got = append(got, r.Id.String())
+		}
+		if cursor == "" {
+			break
+		}
+		p.Cursor = cursor
+	}
+	sort.Strings(got)
+	sort.Strings(ids)
+	c.Assert(got, jc.DeepEquals, ids)
+
+	// Filters and includes are sent to the charm store.
+	results, _, err := s.client.Published(csclient.PublishedParams{
+		Owners:  []string{"who"},
+		Series:  []string{"utopic"},
+		Include: []string{"id-name"},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(results, gc.HasLen, 1)
+	c.Assert(results[0].Id.String(), gc.Equals, "cs:~who/utopic/wordpress-0")
+	c.Assert(results[0].Meta, jc.DeepEquals, map[string]interface{}{
+		"id-name": map[string]interface{}{"Name": "wordpress"},
+	})
+}
+
+func (s *suite) TestPublishedParams(c *gc.C) {
+	rt := &recordingRoundTripper{
+		header: http.Header{params.NextCursorHeader: {"next"}},
+		result: []params.Published{},
+	}
+	_, cursor, err := recordingClient(rt).Published(csclient.PublishedParams{
+		Limit:  10,
+		Start:  time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC),
+		Cursor: "cursor",
+		Type:   "bundle",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(cursor, gc.Equals, "next")
+	c.Assert(rt.urls[0].Path, gc.Equals, "/v4/changes/published")
+	c.Assert(rt.urls[0].Query(), jc.DeepEquals, url.Values{
+		"limit":  {"10"},
+		"start":  {"2015-03-01"},
+		"cursor": {"cursor"},
+		"type":   {"bundle"},
+	})
+}
+
+func (s *suite) TestExpandId(c *gc.C) {
+	for _, id := range []string{"~who/utopic/wordpress-0", "~who/trusty/wordpress-1"} {
+		err := s.store.AddCharmWithArchive(charm.MustParseReference(id), storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	result, err := s.client.ExpandId(charm.MustParseReference("~who/wordpress"))
+	c.Assert(err, gc.IsNil)
+	var got []string
+	for _, id := range result {
+		got = append(got, id.Id)
+	}
+	sort.Strings(got)
+	c.Assert(got, jc.DeepEquals, []string{"cs:~who/trusty/wordpress-1", "cs:~who/utopic/wordpress-0"})
+
+	_, err = s.client.ExpandId(charm.MustParseReference("~who/mysql"))
+	c.Assert(err, gc.ErrorMatches, `cannot expand id: .*not found`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *suite) TestPerm(c *gc.C) {
+	id := charm.MustParseReference("~who/utopic/wordpress-0")
+	err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = s.client.SetPerm(id, params.PermResponse{
+		Read:  []string{"who", "bob"},
+		Write: []string{"who"},
+	})
+	c.Assert(err, gc.IsNil)
+	perm, err := s.client.Perm(charm.MustParseReference("~who/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(perm, jc.DeepEquals, &params.PermResponse{
+		Read:  []string{"who", "bob"},
+		Write: []string{"who"},
+	})
+}
+
+func (s *suite) TestRevisionInfo(c *gc.C) {
+	for _, id := range []string{"~who/utopic/wordpress-0", "~who/utopic/wordpress-1"} {
+		err := s.store.AddCharmWithArchive(charm.MustParseReference(id), storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	info, err := s.client.RevisionInfo(charm.MustParseReference("~who/utopic/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(info, jc.DeepEquals, &params.RevisionInfoResponse{
+		Revisions: []*charm.Reference{
+			charm.MustParseReference("cs:~who/utopic/wordpress-1"),
+			charm.MustParseReference("cs:~who/utopic/wordpress-0"),
+		},
+	})
+}
+
+func (s *suite) TestGetLogs(c *gc.C) {
+	id := charm.MustParseReference("cs:~who/utopic/wordpress")
+	err := s.client.Log(params.IngestionType, params.InfoLevel, "info", id)
+	c.Assert(err, gc.IsNil)
+	err = s.client.Log(params.IngestionType, params.ErrorLevel, "error")
+	c.Assert(err, gc.IsNil)
+	err = s.client.Log(params.LegacyStatisticsType, params.InfoLevel, "stats")
+	c.Assert(err, gc.IsNil)
+
+	logs, err := s.client.GetLogs(csclient.LogsParams{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(logs, gc.HasLen, 3)
+	c.Assert(string(logs[0].Data), gc.Equals, `"stats"`)
+
+	logs, err = s.client.GetLogs(csclient.LogsParams{
+		Type:  params.IngestionType,
+		Level: params.InfoLevel,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(logs, gc.HasLen, 1)
+	c.Assert(string(logs[0].Data), gc.Equals, `"info"`)
+	c.Assert(logs[0].URLs, jc.DeepEquals, []*charm.Reference{id})
+
+	logs, err = s.client.GetLogs(csclient.LogsParams{
+		Id: id,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(logs, gc.HasLen, 1)
+
+	logs, err = s.client.GetLogs(csclient.LogsParams{
+		Limit: 1,
+		Skip:  1,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(logs, gc.HasLen, 1)
+	c.Assert(string(logs[0].Data), gc.Equals, `"error"`)
+}
+
+func (s *suite) TestGetReadMe(c *gc.C) {
+	s.prepareBundleCharms(c)
+	id := charm.MustParseReference("~who/bundle/wordpress-simple-0")
+	err := s.store.AddBundleWithArchive(id, storetesting.Charms.BundleArchive(c.MkDir(), "wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+	r, err := s.client.GetReadMe(id)
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	expect, err := ioutil.ReadFile(filepath.Join(storetesting.Charms.BundleDirPath("wordpress-simple"), "README.md"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(got), gc.Equals, string(expect))
+}
+
+func (s *suite) TestGetIcon(c *gc.C) {
+	id := charm.MustParseReference("~who/utopic/wordpress-0")
+	err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"))
+	c.Assert(err, gc.IsNil)
+	r, err := s.client.GetIcon(id)
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	// The charm has no icon, so the default one is returned.
+	c.Assert(strings.Contains(string(got), "<svg"), gc.Equals, true)
+}
+
+func (s *suite) TestGetDiagramForCharm(c *gc.C) {
+	id := charm.MustParseReference("~who/utopic/wordpress-0")
+	err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"))
+	c.Assert(err, gc.IsNil)
+	_, err = s.client.GetDiagram(id)
+	c.Assert(err, gc.ErrorMatches, "cannot get diagram.svg: diagrams not supported for charms")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *suite) TestDeleteArchive(c *gc.C) {
+	id := charm.MustParseReference("~who/utopic/wordpress-0")
+	err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = s.client.DeleteArchive(id)
+	c.Assert(err, gc.IsNil)
+	_, err = s.store.FindEntity(id)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	err = s.client.DeleteArchive(id)
+	c.Assert(err, gc.ErrorMatches, "cannot delete archive: .*not found")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
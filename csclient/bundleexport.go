@@ -0,0 +1,173 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient
+
+import (
+	"archive/tar"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/params"
+)
+
+// ExportBundle returns a tar archive holding the bundle with the
+// given id and the archives of all the charms it uses, so that it
+// can be deployed where the charm store cannot be reached. See
+// params.ExportManifest for the contents of the archive. The
+// archive can be loaded into another charm store with ImportBundle.
+func (c *Client) ExportBundle(id *charm.Reference) (io.ReadCloser, error) {
+	return c.getContent(id, "export")
+}
+
+// ImportBundle loads the bundle and the charms held in the given tar
+// archive, as returned by ExportBundle, into the charm store, and
+// returns the id of the bundle. The archives are checked against the
+// manifest before being uploaded. The bundle and the charms keep their
+// ids; those already present in the charm store with the same content
+// are left unchanged.
+func (c *Client) ImportBundle(r io.Reader) (*charm.Reference, error) {
+	dir, err := ioutil.TempDir("", "csclient-import")
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot make temporary directory")
+	}
+	defer os.RemoveAll(dir)
+	manifest, files, err := readExport(r, dir)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	// Upload the charms first, so that the
+	// bundle can be verified against them.
+	charms := make(map[string]params.ExportedArchive)
+	for _, archive := range manifest.Charms {
+		charms[archive.Path] = archive
+	}
+	paths := make([]string, 0, len(charms))
+	for path := range charms {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := c.importArchive(charms[path], files[path]); err != nil {
+			return nil, errgo.Mask(err, errgo.Any)
+		}
+	}
+	if err := c.importArchive(manifest.Bundle, files[manifest.Bundle.Path]); err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return manifest.Bundle.Id, nil
+}
+
+// readExport reads the bundle export held in the given tar archive,
+// storing the archives listed in its manifest in the given directory.
+// It returns the manifest and the paths of the stored archives, keyed
+// by their path in the export.
+func readExport(r io.Reader, dir string) (*params.ExportManifest, map[string]string, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot read export")
+	}
+	if hdr.Name != params.ExportManifestPath {
+		return nil, nil, errgo.Newf("export does not start with %s", params.ExportManifestPath)
+	}
+	var manifest params.ExportManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, nil, errgo.Notef(err, "cannot unmarshal manifest")
+	}
+	if manifest.Bundle.Id == nil {
+		return nil, nil, errgo.New("no bundle in manifest")
+	}
+	archives := map[string]params.ExportedArchive{
+		manifest.Bundle.Path: manifest.Bundle,
+	}
+	for _, archive := range manifest.Charms {
+		if archive.Id == nil {
+			return nil, nil, errgo.Newf("no id for %q in manifest", archive.Path)
+		}
+		archives[archive.Path] = archive
+	}
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errgo.Notef(err, "cannot read export")
+		}
+		archive, ok := archives[hdr.Name]
+		if !ok || files[hdr.Name] != "" {
+			continue
+		}
+		// Name the file after its index rather than its
+		// path in the export, which cannot be trusted.
+		path := filepath.Join(dir, fmt.Sprint(len(files)))
+		if err := writeExportedArchive(path, tr, archive); err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+		files[hdr.Name] = path
+	}
+	for path := range archives {
+		if files[path] == "" {
+			return nil, nil, errgo.Newf("%s not found in export", path)
+		}
+	}
+	return &manifest, files, nil
+}
+
+// writeExportedArchive writes the given archive read from r
+// to the file at the given path, checking its hash and size.
+func writeExportedArchive(path string, r io.Reader, archive params.ExportedArchive) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errgo.Notef(err, "cannot create file")
+	}
+	defer f.Close()
+	h := sha512.New384()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return errgo.Notef(err, "cannot read %s from export", archive.Path)
+	}
+	if size != archive.Size || fmt.Sprintf("%x", h.Sum(nil)) != archive.Hash {
+		return errgo.Newf("%s does not match the manifest", archive.Path)
+	}
+	return nil
+}
+
+// importArchive uploads the given exported archive, held in the file
+// at the given path, unless the charm store already holds it.
+func (c *Client) importArchive(archive params.ExportedArchive, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer f.Close()
+	err = c.putArchive(archive.Id, f, archive.Hash, archive.Size, UploadFlags{})
+	if errgo.Cause(err) == params.ErrDuplicateUpload {
+		// Check that the existing entity has the same content.
+		var meta struct {
+			Hash params.HashResponse
+		}
+		if _, err := c.Meta(archive.Id, &meta); err != nil {
+			return errgo.Notef(err, "cannot get hash of existing archive of %q", archive.Id)
+		}
+		if meta.Hash.Sum != archive.Hash {
+			return errgo.Newf("%q already exists with different content", archive.Id)
+		}
+		return nil
+	}
+	if err != nil {
+		return errgo.NoteMask(err, fmt.Sprintf("cannot import %q", archive.Id), errgo.Any)
+	}
+	return nil
+}
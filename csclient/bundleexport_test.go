@@ -0,0 +1,148 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/csclient"
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+// exportBundle adds the wordpress-simple bundle and its
+// charms to the store and returns its id and its export.
+func (s *suite) exportBundle(c *gc.C) (*charm.Reference, []byte) {
+	s.prepareBundleCharms(c)
+	id := charm.MustParseReference("bundle/wordpress-simple-3")
+	err := s.store.AddBundleWithArchive(id, storetesting.Charms.BundleArchive(c.MkDir(), "wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+	r, err := s.client.ExportBundle(id)
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	return id, data
+}
+
+func (s *suite) TestExportImportBundle(c *gc.C) {
+	id, data := s.exportBundle(c)
+	ids := []*charm.Reference{
+		id,
+		charm.MustParseReference("utopic/wordpress-42"),
+		charm.MustParseReference("utopic/mysql-47"),
+	}
+	hashes := make(map[string]string)
+	for _, id := range ids {
+		_, hash, err := s.store.BlobNameAndHash(id)
+		c.Assert(err, gc.IsNil)
+		hashes[id.String()] = hash
+		err = s.client.DeleteArchive(id)
+		c.Assert(err, gc.IsNil)
+	}
+
+	// Import the export into the store, restoring the entities.
+	importedId, err := s.client.ImportBundle(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(importedId, gc.DeepEquals, id)
+	for _, id := range ids {
+		_, hash, err := s.store.BlobNameAndHash(id)
+		c.Assert(err, gc.IsNil)
+		c.Assert(hash, gc.Equals, hashes[id.String()])
+	}
+
+	// Importing the same export again is a no-op.
+	importedId, err = s.client.ImportBundle(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(importedId, gc.DeepEquals, id)
+}
+
+func (s *suite) TestImportBundleExistingChecksHash(c *gc.C) {
+	_, data := s.exportBundle(c)
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		s.srv.Config.Handler.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL:      srv.URL,
+		User:     s.serverParams.AuthUsername,
+		Password: s.serverParams.AuthPassword,
+	})
+
+	// The entities already exist, so the import only checks
+	// their hashes, without downloading their archives.
+	_, err := client.ImportBundle(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	for _, r := range requests {
+		c.Assert(strings.HasPrefix(r, "GET ") && strings.HasSuffix(r, "/archive"), gc.Equals, false, gc.Commentf("request %s", r))
+	}
+	c.Assert(requests, jc.Contains, "GET /v4/utopic/mysql-47/meta/any")
+}
+
+func (s *suite) TestImportBundleExistingWithDifferentContent(c *gc.C) {
+	_, data := s.exportBundle(c)
+	id := charm.MustParseReference("utopic/mysql-47")
+	err := s.client.DeleteArchive(id)
+	c.Assert(err, gc.IsNil)
+	err = s.store.AddCharmWithArchive(id, storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"))
+	c.Assert(err, gc.IsNil)
+
+	_, err = s.client.ImportBundle(bytes.NewReader(data))
+	c.Assert(err, gc.ErrorMatches, `"cs:utopic/mysql-47" already exists with different content`)
+}
+
+func (s *suite) TestImportBundleCorrupted(c *gc.C) {
+	_, data := s.exportBundle(c)
+
+	// Rewrite the export, changing the contents of the bundle archive.
+	var buf bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(data))
+	tw := tar.NewWriter(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, gc.IsNil)
+		content, err := ioutil.ReadAll(tr)
+		c.Assert(err, gc.IsNil)
+		if hdr.Name == "bundle.zip" {
+			content[0]++
+		}
+		err = tw.WriteHeader(hdr)
+		c.Assert(err, gc.IsNil)
+		_, err = tw.Write(content)
+		c.Assert(err, gc.IsNil)
+	}
+	err := tw.Close()
+	c.Assert(err, gc.IsNil)
+
+	_, err = s.client.ImportBundle(&buf)
+	c.Assert(err, gc.ErrorMatches, "bundle.zip does not match the manifest")
+}
+
+func (s *suite) TestImportBundleTruncated(c *gc.C) {
+	_, data := s.exportBundle(c)
+	_, err := s.client.ImportBundle(bytes.NewReader(data[:len(data)/2]))
+	c.Assert(err, gc.ErrorMatches, "cannot read .*")
+}
+
+func (s *suite) TestExportBundleNotFound(c *gc.C) {
+	_, err := s.client.ExportBundle(charm.MustParseReference("bundle/no-such-1"))
+	c.Assert(err, gc.ErrorMatches, "cannot get export: entity not found")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
@@ -0,0 +1,320 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+)
+
+// DefaultCacheSize holds the maximum total size of the
+// cached archives used if Params.CacheSize is zero.
+const DefaultCacheSize = 1024 * 1024 * 1024
+
+// cacheTempExpiry holds how old a temporary file left in the
+// cache, for instance by a process that was killed while
+// downloading, must be before it is removed.
+const cacheTempExpiry = time.Hour
+
+// archiveCache holds archives on disk, keyed by entity id and hash.
+//
+// The archives are stored in the blobs directory, named after their
+// hash, and the ids directory holds a file for each requested entity
+// id holding the hash of its archive and, on a second line, the id
+// the charm store resolved it to, which may differ, for instance
+// for promulgated charms. Files are only ever added by
+// renaming complete files into place, so the cache can be shared
+// safely by concurrent processes. When the total size of the
+// archives exceeds the maximum, the least recently used ones are
+// removed.
+type archiveCache struct {
+	dir     string
+	maxSize int64
+}
+
+func newArchiveCache(dir string, maxSize int64) *archiveCache {
+	if maxSize <= 0 {
+		maxSize = DefaultCacheSize
+	}
+	return &archiveCache{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+}
+
+func (c *archiveCache) blobPath(hash string) string {
+	return filepath.Join(c.dir, "blobs", hash)
+}
+
+// idPath returns the path of the file recorded for the given id.
+// Ids with and without a schema share the same file.
+func (c *archiveCache) idPath(id *charm.Reference) string {
+	key := *id
+	key.Schema = "cs"
+	return filepath.Join(c.dir, "ids", url.QueryEscape(key.String()))
+}
+
+// readId returns the hash of the archive recorded for the given id
+// and the id it was resolved to. It reports whether the id was found
+// in the cache.
+func (c *archiveCache) readId(id *charm.Reference) (hash string, eid *charm.Reference, ok bool) {
+	data, err := ioutil.ReadFile(c.idPath(id))
+	if err != nil {
+		return "", nil, false
+	}
+	hash, eidStr := parseIdFile(data)
+	if eidStr == "" {
+		// The id was recorded by an older client,
+		// which only recorded resolved ids.
+		resolved := *id
+		resolved.Schema = "cs"
+		return hash, &resolved, true
+	}
+	eid, err = charm.ParseReference(eidStr)
+	if err != nil {
+		return "", nil, false
+	}
+	return hash, eid, true
+}
+
+// parseIdFile returns the archive hash and the resolved id held
+// in the given contents of a file in the ids directory. The
+// resolved id is empty if it was not recorded.
+func parseIdFile(data []byte) (hash, eid string) {
+	hash = string(data)
+	if i := strings.Index(hash, "\n"); i >= 0 {
+		return hash[:i], hash[i+1:]
+	}
+	return hash, ""
+}
+
+// open returns the cached archive of the entity with the given fully
+// qualified id, the id it was resolved to, its hash and its size. It
+// reports whether the archive was found in the cache.
+func (c *archiveCache) open(id *charm.Reference) (f *os.File, eid *charm.Reference, hash string, size int64, ok bool) {
+	hash, eid, ok = c.readId(id)
+	if !ok {
+		return nil, nil, "", 0, false
+	}
+	f, err := os.Open(c.blobPath(hash))
+	if err != nil {
+		return nil, nil, "", 0, false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, "", 0, false
+	}
+	// Record the access for the least recently used eviction.
+	now := time.Now()
+	os.Chtimes(f.Name(), now, now)
+	return f, eid, hash, info.Size(), true
+}
+
+// create returns a new temporary file in the cache, to be
+// added to it with add once it holds a complete archive.
+func (c *archiveCache) create() (*os.File, error) {
+	for _, sub := range []string{"blobs", "ids", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(c.dir, sub), 0755); err != nil {
+			return nil, errgo.Notef(err, "cannot make cache directory")
+		}
+	}
+	f, err := ioutil.TempFile(filepath.Join(c.dir, "tmp"), "archive")
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot make temporary file")
+	}
+	return f, nil
+}
+
+// add adds the archive held in the temporary file with the given
+// path as the archive with the given hash of the entity with the
+// given resolved id, requested with the given id, which is recorded
+// too if it is fully qualified. It removes the least recently used
+// archives if the cache has become too large.
+func (c *archiveCache) add(tmpPath string, id, eid *charm.Reference, hash string) error {
+	if err := os.Rename(tmpPath, c.blobPath(hash)); err != nil {
+		os.Remove(tmpPath)
+		return errgo.Notef(err, "cannot add archive to cache")
+	}
+	ids := []*charm.Reference{eid}
+	if isFullyQualified(id) && c.idPath(id) != c.idPath(eid) {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		if err := c.addId(id, eid, hash); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return errgo.Mask(c.evict())
+}
+
+// addId records that the given id resolves to the
+// given entity id, whose archive has the given hash.
+func (c *archiveCache) addId(id, eid *charm.Reference, hash string) error {
+	f, err := ioutil.TempFile(filepath.Join(c.dir, "tmp"), "id")
+	if err != nil {
+		return errgo.Notef(err, "cannot make temporary file")
+	}
+	_, err = f.WriteString(hash + "\n" + eid.String())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.idPath(id))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errgo.Notef(err, "cannot add id to cache")
+	}
+	return nil
+}
+
+// remove removes the given id from the cache, along with any
+// other id resolved to the same entity. The archive itself is
+// left for eviction, as other ids may refer to it.
+func (c *archiveCache) remove(id *charm.Reference) {
+	_, eid, ok := c.readId(id)
+	if !ok {
+		return
+	}
+	os.Remove(c.idPath(id))
+	os.Remove(c.idPath(eid))
+	ids, err := ioutil.ReadDir(filepath.Join(c.dir, "ids"))
+	if err != nil {
+		return
+	}
+	for _, info := range ids {
+		path := filepath.Join(c.dir, "ids", info.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if _, other := parseIdFile(data); other == eid.String() {
+			os.Remove(path)
+		}
+	}
+}
+
+// evict removes the least recently used archives until the total
+// size of the cache is no more than its maximum size, the ids of
+// archives that are no longer in the cache, and old temporary files.
+func (c *archiveCache) evict() error {
+	blobs, err := ioutil.ReadDir(filepath.Join(c.dir, "blobs"))
+	if err != nil {
+		return errgo.Notef(err, "cannot read cache directory")
+	}
+	sort.Sort(filesByModTime(blobs))
+	var size int64
+	for _, info := range blobs {
+		size += info.Size()
+	}
+	present := make(map[string]bool)
+	for _, info := range blobs {
+		if size > c.maxSize {
+			if err := os.Remove(c.blobPath(info.Name())); err == nil || os.IsNotExist(err) {
+				size -= info.Size()
+				continue
+			}
+		}
+		present[info.Name()] = true
+	}
+	ids, err := ioutil.ReadDir(filepath.Join(c.dir, "ids"))
+	if err != nil {
+		return errgo.Notef(err, "cannot read cache directory")
+	}
+	for _, info := range ids {
+		path := filepath.Join(c.dir, "ids", info.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if hash, _ := parseIdFile(data); !present[hash] {
+			os.Remove(path)
+		}
+	}
+	tmps, err := ioutil.ReadDir(filepath.Join(c.dir, "tmp"))
+	if err != nil {
+		return errgo.Notef(err, "cannot read cache directory")
+	}
+	for _, info := range tmps {
+		if time.Since(info.ModTime()) > cacheTempExpiry {
+			os.Remove(filepath.Join(c.dir, "tmp", info.Name()))
+		}
+	}
+	return nil
+}
+
+// filesByModTime sorts files from the least
+// to the most recently modified.
+type filesByModTime []os.FileInfo
+
+func (f filesByModTime) Len() int           { return len(f) }
+func (f filesByModTime) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f filesByModTime) Less(i, j int) bool { return f[i].ModTime().Before(f[j].ModTime()) }
+
+// cachingReader copies the data read from an archive into a temporary
+// file, which is added to the cache once the whole archive has been
+// read and verified. Caching is done on a best effort basis: failing
+// to cache the archive does not affect the data read.
+type cachingReader struct {
+	r     io.ReadCloser
+	f     *os.File
+	cache *archiveCache
+	id    *charm.Reference
+	eid   *charm.Reference
+	hash  string
+}
+
+func (r *cachingReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	if r.f == nil {
+		return n, err
+	}
+	if _, werr := r.f.Write(buf[:n]); werr != nil {
+		r.discard()
+		return n, err
+	}
+	if err == io.EOF {
+		// The archive reader returns io.EOF only
+		// once the archive has been verified.
+		path := r.f.Name()
+		closeErr := r.f.Close()
+		r.f = nil
+		if closeErr != nil {
+			os.Remove(path)
+		} else {
+			r.cache.add(path, r.id, r.eid, r.hash)
+		}
+	}
+	return n, err
+}
+
+// discard removes the partially cached archive.
+func (r *cachingReader) discard() {
+	if r.f != nil {
+		r.f.Close()
+		os.Remove(r.f.Name())
+		r.f = nil
+	}
+}
+
+func (r *cachingReader) Close() error {
+	r.discard()
+	return r.r.Close()
+}
+
+// isFullyQualified reports whether the given id
+// specifies both the series and the revision.
+func isFullyQualified(id *charm.Reference) bool {
+	return id.Series != "" && id.Revision != -1
+}
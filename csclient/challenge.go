@@ -0,0 +1,78 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/params"
+)
+
+// proveArchive tries to upload the archive with the given body, hash,
+// size and flags to the given id, using the given method, without sending
+// its content: if the charm store already holds the content, it issues
+// a challenge that is answered by hashing part of the body. It returns
+// the resulting id, or nil if the content must be uploaded as usual.
+//
+// Charm stores that do not support challenges fail the body-less
+// request, so any failure results in the content being uploaded as
+// usual, and challenges are not tried again by the client once the
+// charm store has failed to issue one.
+func (c *Client) proveArchive(method string, id *charm.Reference, body io.ReadSeeker, hash string, size int64, flags UploadFlags) *charm.Reference {
+	if atomic.LoadInt32(&c.noChallenges) != 0 {
+		return nil
+	}
+	query := fmt.Sprintf("?hash=%s&size=%d", hash, size) + flags.query()
+	var result params.ArchiveUploadResponse
+	err := c.archiveRequest(method, id, query+"&challenge=1", &result)
+	if err != nil {
+		switch errgo.Cause(err) {
+		case params.ErrContentRequired, params.ErrUnauthorized:
+		default:
+			atomic.StoreInt32(&c.noChallenges, 1)
+		}
+		return nil
+	}
+	if result.Challenge == nil {
+		// The content is the same as the latest revision.
+		return result.Id
+	}
+	resp, err := params.NewContentChallengeResponse(result.Challenge, body)
+	if err != nil {
+		return nil
+	}
+	query += "&challenge-id=" + url.QueryEscape(resp.RequestId) + "&challenge-response=" + resp.Hash
+	result = params.ArchiveUploadResponse{}
+	if err := c.archiveRequest(method, id, query, &result); err != nil {
+		return nil
+	}
+	return result.Id
+}
+
+// archiveRequest makes a request with no body to the archive
+// of the given id, using the given method and query string,
+// and parses the response into the given result.
+func (c *Client) archiveRequest(method string, id *charm.Reference, query string, result *params.ArchiveUploadResponse) error {
+	req, err := http.NewRequest(method, "", nil)
+	if err != nil {
+		return errgo.Notef(err, "cannot make new request")
+	}
+	resp, err := c.Do(req, "/"+id.Path()+"/archive"+query)
+	if err != nil {
+		return errgo.NoteMask(err, "cannot "+strings.ToLower(method)+" archive", errgo.Any)
+	}
+	defer resp.Body.Close()
+	if err := parseResponseBody(resp.Body, result); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/publicsuffix"
+	"gopkg.in/errgo.v1"
+)
+
+// DefaultCookieLifetime holds how long cookies that do not specify
+// an expiry time, such as the macaroons acquired when authorizing
+// requests, are kept in the file specified by Params.CookieFile.
+const DefaultCookieLifetime = 24 * time.Hour
+
+const (
+	// cookieLockTimeout holds how long to wait
+	// for the cookie file lock before giving up.
+	cookieLockTimeout = 5 * time.Second
+
+	// cookieLockExpiry holds how old a lock file left by a process
+	// that was killed while holding it must be before it is broken.
+	cookieLockExpiry = 30 * time.Second
+)
+
+// cookieJar implements http.CookieJar, keeping cookies in a file so
+// that they are shared by all the clients and processes using it.
+//
+// The file is only ever replaced by renaming a complete file into
+// place, so it can be read at any time. Updates are serialized by a
+// lock file next to it, so that cookies set concurrently by several
+// processes are all kept. Persisting cookies is done on a best effort
+// basis: if the file cannot be read or written, cookies are still
+// kept in memory.
+type cookieJar struct {
+	path string
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// cookies holds the cookies in the jar, keyed by cookie id.
+	cookies map[string]*jarCookie
+
+	// modTime and size hold the modification time and size of
+	// the file when it was last read, so that it is only read
+	// again when another jar has changed it.
+	modTime time.Time
+	size    int64
+}
+
+// jarCookie holds a cookie as stored in the cookie file.
+type jarCookie struct {
+	Name   string
+	Value  string
+	Domain string
+	// HostOnly specifies that the cookie is sent to
+	// Domain only, not to its subdomains.
+	HostOnly bool `json:",omitempty"`
+	Path     string
+	Secure   bool `json:",omitempty"`
+	Expires  time.Time
+}
+
+func newCookieJar(path string) *cookieJar {
+	return &cookieJar{
+		path:    path,
+		cookies: make(map[string]*jarCookie),
+	}
+}
+
+// id returns the key identifying the cookie in the jar.
+func (c *jarCookie) id() string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+// matches reports whether the cookie should be sent
+// with requests to the given URL at the given time.
+func (c *jarCookie) matches(u *url.URL, now time.Time) bool {
+	if !now.Before(c.Expires) {
+		return false
+	}
+	if c.Secure && u.Scheme != "https" {
+		return false
+	}
+	host := canonicalHost(u)
+	if c.HostOnly {
+		if host != c.Domain {
+			return false
+		}
+	} else if !domainMatch(host, c.Domain) {
+		return false
+	}
+	return pathMatch(requestPath(u), c.Path)
+}
+
+// Cookies implements http.CookieJar.Cookies.
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.refresh()
+	now := time.Now()
+	var matching []*jarCookie
+	for _, c := range j.cookies {
+		if c.matches(u, now) {
+			matching = append(matching, c)
+		}
+	}
+	// Cookies with longer paths are sent first (see RFC 6265).
+	sort.Stable(cookiesByPath(matching))
+	cookies := make([]*http.Cookie, len(matching))
+	for i, c := range matching {
+		cookies[i] = &http.Cookie{
+			Name:  c.Name,
+			Value: c.Value,
+		}
+	}
+	return cookies
+}
+
+// SetCookies implements http.CookieJar.SetCookies.
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	unlock, err := lockFile(j.path + ".lock")
+	if err == nil {
+		defer unlock()
+		// Read the file again so that cookies
+		// set by other jars are not lost.
+		j.modTime = time.Time{}
+		j.refresh()
+	}
+	now := time.Now()
+	for _, c := range cookies {
+		jc, ok := newJarCookie(u, c, now)
+		if !ok {
+			continue
+		}
+		if now.Before(jc.Expires) {
+			j.cookies[jc.id()] = jc
+		} else {
+			delete(j.cookies, jc.id())
+		}
+	}
+	if err == nil {
+		j.save()
+	}
+}
+
+// newJarCookie returns the cookie to store in the jar when the
+// given cookie is set by the given URL at the given time. It
+// reports whether the cookie can be set by the URL. The cookie
+// has expired if it is to be removed from the jar.
+func newJarCookie(u *url.URL, c *http.Cookie, now time.Time) (*jarCookie, bool) {
+	jc := &jarCookie{
+		Name:   c.Name,
+		Value:  c.Value,
+		Path:   c.Path,
+		Secure: c.Secure,
+	}
+	host := canonicalHost(u)
+	if c.Domain == "" {
+		jc.Domain = host
+		jc.HostOnly = true
+	} else {
+		domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+		switch {
+		case net.ParseIP(host) != nil || isPublicSuffix(domain):
+			// Cookies set by an IP address, or for a public
+			// suffix such as "co.uk", could be sent to hosts
+			// that do not belong to the host setting them, so
+			// they are only ever sent back to that host (see
+			// RFC 6265 section 5.3).
+			if domain != host {
+				return nil, false
+			}
+			jc.Domain = host
+			jc.HostOnly = true
+		case !domainMatch(host, domain):
+			return nil, false
+		default:
+			jc.Domain = domain
+		}
+	}
+	if jc.Path == "" || jc.Path[0] != '/' {
+		jc.Path = defaultPath(requestPath(u))
+	}
+	switch {
+	case c.MaxAge < 0:
+		jc.Expires = now
+	case c.MaxAge > 0:
+		jc.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+	case !c.Expires.IsZero():
+		jc.Expires = c.Expires
+	default:
+		jc.Expires = now.Add(DefaultCookieLifetime)
+	}
+	return jc, true
+}
+
+// refresh reads the cookie file if it has changed since
+// it was last read. Expired cookies are discarded.
+// It must be called with j.mu held.
+func (j *cookieJar) refresh() {
+	info, err := os.Stat(j.path)
+	if err != nil || (info.ModTime().Equal(j.modTime) && info.Size() == j.size) {
+		return
+	}
+	data, err := ioutil.ReadFile(j.path)
+	if err != nil {
+		return
+	}
+	var cookies []*jarCookie
+	if err := json.Unmarshal(data, &cookies); err != nil {
+		return
+	}
+	now := time.Now()
+	j.cookies = make(map[string]*jarCookie)
+	for _, c := range cookies {
+		if now.Before(c.Expires) {
+			j.cookies[c.id()] = c
+		}
+	}
+	j.modTime, j.size = info.ModTime(), info.Size()
+}
+
+// save writes the unexpired cookies in the jar to the
+// cookie file, which is only readable by its owner.
+// It must be called with j.mu and the file lock held.
+func (j *cookieJar) save() error {
+	now := time.Now()
+	cookies := make([]*jarCookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if now.Before(c.Expires) {
+			cookies = append(cookies, c)
+		}
+	}
+	data, err := json.Marshal(cookies)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal cookies")
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return errgo.Notef(err, "cannot make cookie directory")
+	}
+	// The temporary file is created with mode 0600.
+	f, err := ioutil.TempFile(filepath.Dir(j.path), filepath.Base(j.path)+".tmp")
+	if err != nil {
+		return errgo.Notef(err, "cannot make temporary file")
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), j.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errgo.Notef(err, "cannot write cookie file")
+	}
+	if info, err := os.Stat(j.path); err == nil {
+		j.modTime, j.size = info.ModTime(), info.Size()
+	}
+	return nil
+}
+
+// lockFile acquires the lock held by creating the file with the
+// given path, and returns a function that releases it. Locks older
+// than cookieLockExpiry are assumed to have been abandoned.
+func lockFile(path string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errgo.Notef(err, "cannot make lock directory")
+	}
+	deadline := time.Now().Add(cookieLockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() {
+				os.Remove(path)
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errgo.Notef(err, "cannot create lock file")
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > cookieLockExpiry {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errgo.Newf("timed out waiting for lock %q", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// canonicalHost returns the lower case host name of
+// the given URL, without the port.
+func canonicalHost(u *url.URL) string {
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// domainMatch reports whether the given host
+// is in the given domain (see RFC 6265 section 5.1.3).
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	// IP addresses have no subdomains.
+	return net.ParseIP(host) == nil && strings.HasSuffix(host, "."+domain)
+}
+
+// isPublicSuffix reports whether the given domain is a public suffix,
+// under which anyone can register names, such as "com" or "co.uk".
+func isPublicSuffix(domain string) bool {
+	return publicsuffix.PublicSuffix(domain) == domain
+}
+
+// requestPath returns the path of the given URL, or "/" if empty.
+func requestPath(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// defaultPath returns the path of cookies that do not specify one,
+// set in response to a request with the given path (see RFC 6265
+// section 5.1.4).
+func defaultPath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// pathMatch reports whether cookies with the given path are sent
+// with requests with the given path (see RFC 6265 section 5.1.4).
+func pathMatch(reqPath, cookiePath string) bool {
+	if !strings.HasPrefix(reqPath, cookiePath) {
+		return false
+	}
+	return len(reqPath) == len(cookiePath) ||
+		strings.HasSuffix(cookiePath, "/") ||
+		reqPath[len(cookiePath)] == '/'
+}
+
+// cookiesByPath sorts cookies from the longest to the shortest path.
+type cookiesByPath []*jarCookie
+
+func (c cookiesByPath) Len() int           { return len(c) }
+func (c cookiesByPath) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c cookiesByPath) Less(i, j int) bool { return len(c[i].Path) > len(c[j].Path) }
@@ -0,0 +1,251 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/macaroon-bakery.v0/bakery/checkers"
+
+	"github.com/juju/charmstore/csclient"
+)
+
+type cookieJarSuite struct{}
+
+var _ = gc.Suite(&cookieJarSuite{})
+
+func mustParseURL(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// cookieNames returns the names of the cookies sent by
+// the given jar with requests to the given URL.
+func cookieNames(jar http.CookieJar, u string) []string {
+	var names []string
+	for _, c := range jar.Cookies(mustParseURL(u)) {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func (s *cookieJarSuite) TestPersistence(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "cookies", "jar")
+	jar := csclient.NewCookieJar(path)
+	jar.SetCookies(mustParseURL("http://example.com/foo/bar"), []*http.Cookie{{
+		Name:  "a",
+		Value: "a-value",
+	}})
+
+	info, err := os.Stat(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().Perm(), gc.Equals, os.FileMode(0600))
+	_, err = os.Stat(path + ".lock")
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	// The cookie is available from another jar using the same file.
+	jar = csclient.NewCookieJar(path)
+	cookies := jar.Cookies(mustParseURL("http://example.com/foo/baz"))
+	c.Assert(cookies, gc.DeepEquals, []*http.Cookie{{
+		Name:  "a",
+		Value: "a-value",
+	}})
+}
+
+func (s *cookieJarSuite) TestConcurrentJars(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "jar")
+	jar0 := csclient.NewCookieJar(path)
+	jar1 := csclient.NewCookieJar(path)
+	u := mustParseURL("http://example.com/")
+	jar0.SetCookies(u, []*http.Cookie{{Name: "a", Value: "a"}})
+	jar1.SetCookies(u, []*http.Cookie{{Name: "b", Value: "b"}})
+
+	// Both cookies are kept, and the first jar
+	// sees the cookie set by the second one.
+	c.Assert(cookieNames(jar0, "http://example.com/"), gc.HasLen, 2)
+	c.Assert(cookieNames(csclient.NewCookieJar(path), "http://example.com/"), gc.HasLen, 2)
+}
+
+func (s *cookieJarSuite) TestExpiry(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "jar")
+	jar := csclient.NewCookieJar(path)
+	u := mustParseURL("http://example.com/")
+	jar.SetCookies(u, []*http.Cookie{{
+		Name: "session",
+	}, {
+		Name:   "max-age",
+		MaxAge: 60,
+	}, {
+		Name:    "expires",
+		Expires: time.Now().Add(time.Minute),
+	}, {
+		Name:    "expired",
+		Expires: time.Now().Add(-time.Minute),
+	}})
+	c.Assert(cookieNames(jar, "http://example.com/"), gc.HasLen, 3)
+
+	// A negative max age removes the cookie.
+	jar.SetCookies(u, []*http.Cookie{{
+		Name:   "max-age",
+		MaxAge: -1,
+	}})
+	c.Assert(cookieNames(csclient.NewCookieJar(path), "http://example.com/"), gc.HasLen, 2)
+
+	// Expired cookies stored in the file are ignored.
+	err := ioutil.WriteFile(path, []byte(`[{
+		"Name": "old",
+		"Domain": "example.com",
+		"HostOnly": true,
+		"Path": "/",
+		"Expires": "2015-01-01T00:00:00Z"
+	}]`), 0600)
+	c.Assert(err, gc.IsNil)
+	c.Assert(cookieNames(csclient.NewCookieJar(path), "http://example.com/"), gc.HasLen, 0)
+}
+
+func (s *cookieJarSuite) TestAbandonedLock(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "jar")
+	// An abandoned lock is broken.
+	err := ioutil.WriteFile(path+".lock", nil, 0600)
+	c.Assert(err, gc.IsNil)
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(path+".lock", old, old)
+	c.Assert(err, gc.IsNil)
+
+	jar := csclient.NewCookieJar(path)
+	jar.SetCookies(mustParseURL("http://example.com/"), []*http.Cookie{{Name: "a"}})
+	c.Assert(cookieNames(csclient.NewCookieJar(path), "http://example.com/"), gc.DeepEquals, []string{"a"})
+}
+
+var cookieMatchTests = []struct {
+	about  string
+	setURL string
+	cookie http.Cookie
+	url    string
+	expect bool
+}{{
+	about:  "same host",
+	setURL: "http://example.com/",
+	url:    "http://example.com:8080/foo",
+	expect: true,
+}, {
+	about:  "host only",
+	setURL: "http://example.com/",
+	url:    "http://www.example.com/",
+}, {
+	about:  "domain",
+	setURL: "http://example.com/",
+	cookie: http.Cookie{Domain: ".example.com"},
+	url:    "http://www.example.com/",
+	expect: true,
+}, {
+	about:  "domain not matching the host",
+	setURL: "http://example.com/",
+	cookie: http.Cookie{Domain: "other.com"},
+	url:    "http://other.com/",
+}, {
+	about:  "public suffix domain",
+	setURL: "http://example.co.uk/",
+	cookie: http.Cookie{Domain: "co.uk"},
+	url:    "http://other.co.uk/",
+}, {
+	about:  "top level domain",
+	setURL: "http://example.com/",
+	cookie: http.Cookie{Domain: ".com"},
+	url:    "http://other.com/",
+}, {
+	about:  "public suffix domain set by itself is host only",
+	setURL: "http://co.uk/",
+	cookie: http.Cookie{Domain: "co.uk"},
+	url:    "http://example.co.uk/",
+}, {
+	about:  "registrable parent domain",
+	setURL: "http://www.example.co.uk/",
+	cookie: http.Cookie{Domain: "example.co.uk"},
+	url:    "http://api.example.co.uk/",
+	expect: true,
+}, {
+	about:  "IP address",
+	setURL: "http://127.0.0.1/",
+	cookie: http.Cookie{Domain: "127.0.0.1"},
+	url:    "http://127.0.0.1:8080/",
+	expect: true,
+}, {
+	about:  "default path",
+	setURL: "http://example.com/v4/wordpress/meta/any",
+	url:    "http://example.com/v4/wordpress/meta/id",
+	expect: true,
+}, {
+	about:  "default path not matching",
+	setURL: "http://example.com/v4/wordpress/meta/any",
+	url:    "http://example.com/v4/wordpress/archive",
+}, {
+	about:  "path prefix not matching",
+	setURL: "http://example.com/",
+	cookie: http.Cookie{Path: "/v4"},
+	url:    "http://example.com/v40",
+}, {
+	about:  "secure",
+	setURL: "https://example.com/",
+	cookie: http.Cookie{Secure: true},
+	url:    "https://example.com/",
+	expect: true,
+}, {
+	about:  "secure with insecure request",
+	setURL: "https://example.com/",
+	cookie: http.Cookie{Secure: true},
+	url:    "http://example.com/",
+}}
+
+func (s *cookieJarSuite) TestCookieMatch(c *gc.C) {
+	for i, test := range cookieMatchTests {
+		c.Logf("test %d: %s", i, test.about)
+		jar := csclient.NewCookieJar(filepath.Join(c.MkDir(), "jar"))
+		cookie := test.cookie
+		cookie.Name = "a"
+		jar.SetCookies(mustParseURL(test.setURL), []*http.Cookie{&cookie})
+		c.Assert(len(cookieNames(jar, test.url)) == 1, gc.Equals, test.expect)
+	}
+}
+
+func (s *suite) TestCookieFile(c *gc.C) {
+	id := s.addWordpress(c)
+	err := s.client.Put("/"+id.Path()+"/meta/perm/read", []string{"bob"})
+	c.Assert(err, gc.IsNil)
+
+	s.discharge = func(cond, arg string) ([]checkers.Caveat, error) {
+		return []checkers.Caveat{checkers.DeclaredCaveat("username", "bob")}, nil
+	}
+	path := filepath.Join(c.MkDir(), "cookies")
+	client := csclient.New(csclient.Params{
+		URL:        s.srv.URL,
+		CookieFile: path,
+	})
+	var result struct{ IdRevision struct{ Revision int } }
+	_, err = client.Meta(id, &result)
+	c.Assert(err, gc.IsNil)
+
+	// A new client using the same cookie file
+	// does not need to acquire a discharge.
+	s.discharge = func(cond, arg string) ([]checkers.Caveat, error) {
+		return nil, fmt.Errorf("unexpected discharge request")
+	}
+	client = csclient.New(csclient.Params{
+		URL:        s.srv.URL,
+		CookieFile: path,
+	})
+	_, err = client.Meta(id, &result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.IdRevision.Revision, gc.Equals, 0)
+}
@@ -15,6 +15,7 @@ import (
 	"net/url"
 	"reflect"
 	"strings"
+	"time"
 	"unicode"
 
 	"gopkg.in/errgo.v1"
@@ -36,6 +37,15 @@ var ServerURL = "https://api.jujucharms.com/charmstore"
 // Client represents the client side of a charm store.
 type Client struct {
 	params Params
+	cache  *archiveCache
+
+	// noChallenges is set to 1 when the charm store fails to
+	// issue a proof-of-content challenge. It is accessed atomically.
+	noChallenges int32
+
+	// noPartUploads is set to 1 when the charm store does not
+	// support uploads in parts. It is accessed atomically.
+	noPartUploads int32
 }
 
 // Params holds parameters for creating a new charm store client.
@@ -57,10 +67,51 @@ type Params struct {
 	// be used.
 	HTTPClient *http.Client
 
+	// CookieFile holds the path of the file where cookies, such as
+	// the macaroons acquired when authorizing requests, are stored.
+	// The file is created readable only by its owner, and may be
+	// shared by several clients and processes, so that they
+	// authenticate only once until the macaroons expire. Cookies
+	// that do not specify an expiry time are kept for
+	// DefaultCookieLifetime. If empty, cookies are kept in memory
+	// only, in the cookie jar of HTTPClient.
+	CookieFile string
+
 	// VisitWebPage is called when authorization requires that
 	// the user visits a web page to authenticate themselves.
 	// If nil, a default function that returns an error will be used.
 	VisitWebPage func(url *url.URL) error
+
+	// UploadPartSize holds the size of the parts in which archives
+	// are uploaded. Archives larger than this are uploaded one part
+	// at a time, so that a failed upload can be resumed from the
+	// last part received by the charm store, unless the charm
+	// store does not support uploads in parts. If zero,
+	// DefaultUploadPartSize is used.
+	UploadPartSize int64
+
+	// RetryPolicy holds the policy used to retry requests that
+	// fail because of transient errors. If nil, requests are
+	// not retried. DefaultRetryPolicy may be used.
+	RetryPolicy *RetryPolicy
+
+	// Cancel, if not nil, holds a channel that cancels the requests
+	// in progress, including the reading of their response bodies,
+	// and stops them from being retried when it is closed. Requests
+	// made once it is closed fail immediately.
+	Cancel <-chan struct{}
+
+	// CacheDir holds the directory where downloaded archives are
+	// cached. Archives requested with a fully qualified id are read
+	// from the cache when present there. The directory may be shared
+	// by several clients and processes. If empty, archives are not
+	// cached.
+	CacheDir string
+
+	// CacheSize holds the maximum total size in bytes of the cached
+	// archives. When it is exceeded, the least recently used archives
+	// are removed from the cache. If zero, DefaultCacheSize is used.
+	CacheSize int64
 }
 
 // New returns a new charm store client.
@@ -74,9 +125,20 @@ func New(p Params) *Client {
 	if p.HTTPClient == nil {
 		p.HTTPClient = httpbakery.NewHTTPClient()
 	}
-	return &Client{
+	if p.CookieFile != "" {
+		// Make a copy of the HTTP client so that
+		// the one provided is left unchanged.
+		hc := *p.HTTPClient
+		hc.Jar = newCookieJar(p.CookieFile)
+		p.HTTPClient = &hc
+	}
+	c := &Client{
 		params: p,
 	}
+	if p.CacheDir != "" {
+		c.cache = newArchiveCache(p.CacheDir, p.CacheSize)
+	}
+	return c
 }
 
 func noVisit(url *url.URL) error {
@@ -91,7 +153,23 @@ func (c *Client) ServerURL() string {
 // GetArchive retrieves the archive for the given charm or bundle, returning a
 // reader its data can be read from, the fully qualified id of the
 // corresponding entity, the SHA384 hash of the data and its size.
+// The size is -1 if it is not known in advance.
+//
+// The reader checks the data against the hash and size as it is read:
+// once all the data has been read, it returns an error instead of
+// io.EOF if they do not match. If the download is interrupted, it
+// is transparently resumed from where it stopped.
+//
+// If Params.CacheDir is set, the archives of fully qualified ids are
+// read from the cache when possible, and downloaded archives are
+// added to the cache once they have been read and verified.
 func (c *Client) GetArchive(id *charm.Reference) (r io.ReadCloser, eid *charm.Reference, hash string, size int64, err error) {
+	if c.cache != nil && isFullyQualified(id) {
+		if f, eid, hash, size, ok := c.cache.open(id); ok {
+			return f, eid, hash, size, nil
+		}
+	}
+
 	// Create the request.
 	req, err := http.NewRequest("GET", "", nil)
 	if err != nil {
@@ -127,13 +205,22 @@ func (c *Client) GetArchive(id *charm.Reference) (r io.ReadCloser, eid *charm.Re
 		return nil, nil, "", 0, errgo.Newf("no %s header found in response", params.ContentHashHeader)
 	}
 
-	// Validate the response contents.
-	if resp.ContentLength < 0 {
-		// TODO frankban: handle the case the contents are chunked.
-		resp.Body.Close()
-		return nil, nil, "", 0, errgo.Newf("no content length found in response")
+	// The content length is -1 when the contents are chunked.
+	size = resp.ContentLength
+	r = newArchiveReader(c, eid, hash, size, resp.Body)
+	if c.cache != nil {
+		if f, err := c.cache.create(); err == nil {
+			r = &cachingReader{
+				r:     r,
+				f:     f,
+				cache: c.cache,
+				id:    id,
+				eid:   eid,
+				hash:  hash,
+			}
+		}
 	}
-	return resp.Body, eid, hash, resp.ContentLength, nil
+	return r, eid, hash, size, nil
 }
 
 // UploadCharm uploads the given charm to the charm store with the given id.
@@ -161,6 +248,111 @@ func (c *Client) UploadBundle(id *charm.Reference, b charm.Bundle) (*charm.Refer
 	return c.uploadArchive(id, r, hash, size)
 }
 
+// UploadCharmWithRevision uploads the given charm to the charm store with
+// the given id, which must include the series and the revision. It can be
+// used to copy charms between charm stores while preserving their
+// revision numbers. The accepted charm implementations are charm.CharmDir
+// and charm.CharmArchive.
+func (c *Client) UploadCharmWithRevision(id *charm.Reference, ch charm.Charm) error {
+	return c.UploadCharmWithRevisionAndFlags(id, ch, UploadFlags{})
+}
+
+// UploadCharmWithRevisionAndFlags is like UploadCharmWithRevision
+// except that the given flags are specified for the upload.
+func (c *Client) UploadCharmWithRevisionAndFlags(id *charm.Reference, ch charm.Charm, flags UploadFlags) error {
+	r, hash, size, err := openArchive(ch)
+	if err != nil {
+		return errgo.Notef(err, "cannot open charm archive")
+	}
+	defer r.Close()
+	return c.putArchive(id, r, hash, size, flags)
+}
+
+// UploadBundleWithRevision uploads the given bundle to the charm store with
+// the given id, which must include the "bundle" series and the revision.
+// The accepted bundle implementations are charm.BundleDir and
+// charm.BundleArchive.
+func (c *Client) UploadBundleWithRevision(id *charm.Reference, b charm.Bundle) error {
+	return c.UploadBundleWithRevisionAndFlags(id, b, UploadFlags{})
+}
+
+// UploadBundleWithRevisionAndFlags is like UploadBundleWithRevision
+// except that the given flags are specified for the upload.
+func (c *Client) UploadBundleWithRevisionAndFlags(id *charm.Reference, b charm.Bundle, flags UploadFlags) error {
+	r, hash, size, err := openArchive(b)
+	if err != nil {
+		return errgo.Notef(err, "cannot open bundle archive")
+	}
+	defer r.Close()
+	return c.putArchive(id, r, hash, size, flags)
+}
+
+// UploadFlags holds flags specifying the visibility
+// of the charms and bundles uploaded to the charm store.
+type UploadFlags struct {
+	// Private specifies that a newly created charm or bundle is
+	// readable only by its owner. It has no effect on the upload
+	// of a new revision of an existing charm or bundle.
+	Private bool
+
+	// Hidden specifies that the uploaded revision is
+	// hidden from users without write access.
+	Hidden bool
+}
+
+// query returns the query parameters specifying the
+// flags, to be appended to an existing query string.
+func (f UploadFlags) query() string {
+	query := ""
+	if f.Private {
+		query += "&private=1"
+	}
+	if f.Hidden {
+		query += "&hidden=1"
+	}
+	return query
+}
+
+// putArchive is like uploadArchive except that the given id must
+// include the revision, which is preserved by the charm store, and
+// that the given flags are specified for the upload.
+func (c *Client) putArchive(id *charm.Reference, body io.ReadSeeker, hash string, size int64, flags UploadFlags) error {
+	// Validate the entity id.
+	if id.Series == "" {
+		return errgo.Newf("no series specified in %q", id)
+	}
+	if id.Revision == -1 {
+		return errgo.Newf("no revision specified in %q", id)
+	}
+
+	// Avoid sending the archive if the charm store already holds its content.
+	if eid := c.proveArchive("PUT", id, body, hash, size, flags); eid != nil {
+		return nil
+	}
+
+	// Upload the archive in parts if it is large.
+	query, length, getBody, err := c.archiveBody(id, body, hash, size, flags)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+
+	// Prepare the request.
+	req, err := http.NewRequest("PUT", "", nil)
+	if err != nil {
+		return errgo.Notef(err, "cannot make new request")
+	}
+	req.Header.Set("Content-Type", "application/zip")
+	req.ContentLength = length
+
+	// Send the request.
+	resp, err := c.doWithBody(req, "/"+id.Path()+"/archive"+query, getBody, archiveRetryMode(query, retryByMethod))
+	if err != nil {
+		return errgo.NoteMask(err, "cannot put archive", errgo.Any)
+	}
+	resp.Body.Close()
+	return nil
+}
+
 // uploadArchive pushes the archive for the charm or bundle represented by
 // the given body, its SHA384 hash and its size. It returns the resulting
 // entity reference. The given id should include the series and should not
@@ -174,16 +366,28 @@ func (c *Client) uploadArchive(id *charm.Reference, body io.ReadSeeker, hash str
 		return nil, errgo.Newf("revision specified in %q, but should not be specified", id)
 	}
 
+	// Avoid sending the archive if the charm store already holds its content.
+	if eid := c.proveArchive("POST", id, body, hash, size, UploadFlags{}); eid != nil {
+		return eid, nil
+	}
+
+	// Upload the archive in parts if it is large.
+	query, length, getBody, err := c.archiveBody(id, body, hash, size, UploadFlags{})
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+
 	// Prepare the request.
 	req, err := http.NewRequest("POST", "", nil)
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot make new request")
 	}
 	req.Header.Set("Content-Type", "application/zip")
-	req.ContentLength = size
+	req.ContentLength = length
 
-	// Send the request.
-	resp, err := c.DoWithBody(req, "/"+id.Path()+"/archive?hash="+hash, httpbakery.SeekerBody(body))
+	// Send the request. Posting the same content again results in
+	// the same revision, so the request may be retried.
+	resp, err := c.doWithBody(req, "/"+id.Path()+"/archive"+query, getBody, archiveRetryMode(query, retryIdempotent))
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot post archive")
 	}
@@ -243,29 +447,62 @@ func (c *Client) PutExtraInfo(id *charm.Reference, info map[string]interface{})
 //	}
 //	id, err := client.Meta(id, &result)
 func (c *Client) Meta(id *charm.Reference, result interface{}) (*charm.Reference, error) {
+	resultt, err := metaResultType(result)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	includes, err := metaIncludes(resultt)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	// We unmarshal into rawResult, then unmarshal each field
+	// separately into its place in the final result value.
+	// Note that we can't use params.MetaAnyResponse because
+	// that will unpack all the values inside the Meta field,
+	// but we want to keep them raw so that we can unmarshal
+	// them ourselves.
+	var rawResult rawMetaAnyResponse
+	path := "/" + id.Path() + "/meta/any"
+	if len(includes) > 0 {
+		path += "?" + strings.Join(includes, "&")
+	}
+	if err := c.Get(path, &rawResult); err != nil {
+		return nil, errgo.NoteMask(err, fmt.Sprintf("cannot get %q", path), errgo.Any)
+	}
+	if err := setMetaFields(reflect.ValueOf(result).Elem(), rawResult.Meta); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return rawResult.Id, nil
+}
+
+// rawMetaAnyResponse is like params.MetaAnyResponse
+// except that the metadata values are left raw.
+type rawMetaAnyResponse struct {
+	Id   *charm.Reference
+	Meta map[string]json.RawMessage
+}
+
+// metaResultType returns the struct type pointed to by
+// the type of the given result value, as accepted by Client.Meta.
+func metaResultType(result interface{}) (reflect.Type, error) {
 	if result == nil {
 		return nil, fmt.Errorf("expected valid result pointer, not nil")
 	}
-	resultv := reflect.ValueOf(result)
-	resultt := resultv.Type()
+	resultt := reflect.TypeOf(result)
 	if resultt.Kind() != reflect.Ptr {
 		return nil, fmt.Errorf("expected pointer, not %T", result)
 	}
-	resultt = resultt.Elem()
-	if resultt.Kind() != reflect.Struct {
+	if resultt.Elem().Kind() != reflect.Struct {
 		return nil, fmt.Errorf("expected pointer to struct, not %T", result)
 	}
-	resultv = resultv.Elem()
-
-	// At this point, resultv refers to the struct value pointed
-	// to by result, and resultt is its type.
+	return resultt.Elem(), nil
+}
 
+// metaIncludes returns the include parameters
+// requesting the fields of the given struct type.
+func metaIncludes(resultt reflect.Type) ([]string, error) {
 	numField := resultt.NumField()
 	includes := make([]string, 0, numField)
-
-	// results holds an entry for each field in the result value,
-	// pointing to the value for that field.
-	results := make(map[string]reflect.Value)
 	for i := 0; i < numField; i++ {
 		field := resultt.Field(i)
 		if field.PkgPath != "" {
@@ -278,35 +515,39 @@ func (c *Client) Meta(id *charm.Reference, result interface{}) (*charm.Reference
 			// additional complexity doesn't seem worth it.
 			return nil, fmt.Errorf("anonymous fields not supported")
 		}
-		apiName := field.Tag.Get("csclient")
-		if apiName == "" {
-			apiName = hyphenate(field.Name)
-		}
-		includes = append(includes, "include="+apiName)
-		results[apiName] = resultv.FieldByName(field.Name).Addr()
-	}
-	// We unmarshal into rawResult, then unmarshal each field
-	// separately into its place in the final result value.
-	// Note that we can't use params.MetaAnyResponse because
-	// that will unpack all the values inside the Meta field,
-	// but we want to keep them raw so that we can unmarshal
-	// them ourselves.
-	var rawResult struct {
-		Id   *charm.Reference
-		Meta map[string]json.RawMessage
+		includes = append(includes, "include="+metaFieldName(field))
 	}
-	path := "/" + id.Path() + "/meta/any"
-	if len(includes) > 0 {
-		path += "?" + strings.Join(includes, "&")
+	return includes, nil
+}
+
+// metaFieldName returns the name of the metadata
+// held in the given field of a Meta result value.
+func metaFieldName(field reflect.StructField) string {
+	if apiName := field.Tag.Get("csclient"); apiName != "" {
+		return apiName
 	}
-	if err := c.Get(path, &rawResult); err != nil {
-		return nil, errgo.NoteMask(err, fmt.Sprintf("cannot get %q", path), errgo.Any)
+	return hyphenate(field.Name)
+}
+
+// setMetaFields unmarshals the given raw metadata into
+// the corresponding fields of the given struct value.
+func setMetaFields(resultv reflect.Value, meta map[string]json.RawMessage) error {
+	resultt := resultv.Type()
+	// results holds an entry for each field in the result value,
+	// pointing to the value for that field.
+	results := make(map[string]reflect.Value)
+	for i := 0; i < resultt.NumField(); i++ {
+		field := resultt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		results[metaFieldName(field)] = resultv.Field(i).Addr()
 	}
 	// Note that the server is not required to send back values
 	// for all fields. "If there is no metadata for the given meta path, the
 	// element will be omitted"
 	// See http://tinyurl.com/q5vcjpk
-	for name, r := range rawResult.Meta {
+	for name, r := range meta {
 		v, ok := results[name]
 		if !ok {
 			// The server has produced a result that we
@@ -316,10 +557,160 @@ func (c *Client) Meta(id *charm.Reference, result interface{}) (*charm.Reference
 		// Unmarshal the raw JSON into the final struct field.
 		err := json.Unmarshal(r, v.Interface())
 		if err != nil {
-			return nil, errgo.Notef(err, "cannot unmarshal %s", name)
+			return errgo.Notef(err, "cannot unmarshal %s", name)
 		}
 	}
-	return rawResult.Id, nil
+	return nil
+}
+
+// maxMetaManyIds holds the maximum number of ids
+// sent in a single bulk metadata request.
+var maxMetaManyIds = 50
+
+// MetaMany is like Meta except that it fetches metadata on the charms
+// and bundles with all the given ids, splitting the ids across several
+// requests when there are many of them. The resultType value must be a
+// pointer to a struct, as for Meta; it is used only for its type.
+//
+// It returns a map from the string form of each id to a newly
+// allocated value of the same type as resultType holding the
+// metadata for that id. Ids for which there is no charm or bundle
+// are omitted from the map.
+//
+// If the metadata of some ids cannot be retrieved, for instance
+// because the user is not allowed to read them, the map holds the
+// metadata of the other ids, and the returned error is a *params.Error
+// with the params.ErrMultipleErrors code whose Info field holds the
+// error for each failed id.
+func (c *Client) MetaMany(ids []*charm.Reference, resultType interface{}) (map[string]interface{}, error) {
+	resultt, err := metaResultType(resultType)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	includes, err := metaIncludes(resultt)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	idStrs := make([]string, 0, len(ids))
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if s := id.String(); !seen[s] {
+			seen[s] = true
+			idStrs = append(idStrs, s)
+		}
+	}
+	results := make(map[string]interface{})
+	var multiErr *params.Error
+	addError := func(id string, err *params.Error) {
+		if multiErr == nil {
+			multiErr = &params.Error{
+				Code: params.ErrMultipleErrors,
+				Info: make(map[string]*params.Error),
+			}
+		}
+		multiErr.Info[id] = err
+	}
+	// singleIds holds the ids that must be requested on their own.
+	var singleIds []string
+	for len(idStrs) > 0 || len(singleIds) > 0 {
+		var batch []string
+		if len(singleIds) > 0 {
+			batch, singleIds = singleIds[:1], singleIds[1:]
+		} else {
+			n := len(idStrs)
+			if n > maxMetaManyIds {
+				n = maxMetaManyIds
+			}
+			batch, idStrs = idStrs[:n], idStrs[n:]
+		}
+		rawResults, err := c.getMetaMany(batch, includes)
+		if err != nil {
+			perr, ok := err.(*params.Error)
+			if !ok || perr.Code != params.ErrMultipleErrors {
+				if isTransportError(err) {
+					return nil, errgo.NoteMask(err, "cannot get metadata", errgo.Any)
+				}
+				if len(batch) > 1 {
+					// The charm store fails the whole request when
+					// the metadata of a single id cannot be retrieved,
+					// for instance because the user is not allowed
+					// to read it, so find out which ids fail by
+					// getting each one on its own.
+					singleIds = append(singleIds, batch...)
+					continue
+				}
+				addError(batch[0], metaManyError(err))
+				continue
+			}
+			// The server may not return the results of the ids
+			// that succeeded along with the failures, so get
+			// those on their own.
+			var succeeded []string
+			for _, id := range batch {
+				if info, ok := perr.Info[id]; ok {
+					addError(id, info)
+				} else {
+					succeeded = append(succeeded, id)
+				}
+			}
+			if len(succeeded) == len(batch) {
+				// None of the failures are for ids we know
+				// about, so retrying would fail in the same way.
+				return nil, errgo.NoteMask(err, "cannot get metadata", errgo.Any)
+			}
+			idStrs = append(idStrs, succeeded...)
+			continue
+		}
+		for id, raw := range rawResults {
+			v := reflect.New(resultt)
+			if err := setMetaFields(v.Elem(), raw.Meta); err != nil {
+				return nil, errgo.Notef(err, "cannot get metadata for %q", id)
+			}
+			results[id] = v.Interface()
+		}
+	}
+	if multiErr != nil {
+		multiErr.Message = fmt.Sprintf("cannot get metadata for %d ids", len(multiErr.Info))
+		return results, multiErr
+	}
+	return results, nil
+}
+
+// metaManyError returns the error reported by MetaMany
+// for an id whose metadata cannot be retrieved.
+func metaManyError(err error) *params.Error {
+	if perr, ok := errgo.Cause(err).(*params.Error); ok {
+		return perr
+	}
+	return &params.Error{
+		Message: err.Error(),
+	}
+}
+
+// getMetaMany makes a bulk metadata request for the given ids and
+// includes. Any error response from the charm store is returned
+// unchanged.
+func (c *Client) getMetaMany(ids []string, includes []string) (map[string]rawMetaAnyResponse, error) {
+	v := make(url.Values)
+	v["id"] = ids
+	path := "/meta/any?" + v.Encode()
+	if len(includes) > 0 {
+		path += "&" + strings.Join(includes, "&")
+	}
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot make new request")
+	}
+	resp, err := c.Do(req, path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var results map[string]rawMetaAnyResponse
+	if err := parseResponseBody(resp.Body, &results); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return results, nil
 }
 
 // hyphenate returns the hyphenated version of the given
@@ -364,6 +755,26 @@ func (c *Client) Get(path string, result interface{}) error {
 	return nil
 }
 
+// Put makes a PUT request to the charm store, sending the
+// given value marshaled as JSON as the request body.
+func (c *Client) Put(path string, val interface{}) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal PUT body")
+	}
+	req, err := http.NewRequest("PUT", "", nil)
+	if err != nil {
+		return errgo.Notef(err, "cannot make new request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.DoWithBody(req, path, httpbakery.SeekerBody(bytes.NewReader(data)))
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	resp.Body.Close()
+	return nil
+}
+
 func parseResponseBody(body io.Reader, result interface{}) error {
 	data, err := ioutil.ReadAll(body)
 	if err != nil {
@@ -381,8 +792,16 @@ func parseResponseBody(body io.Reader, result interface{}) error {
 
 // DoWithBody is like Do except that the given getBody function is
 // called to obtain the body for the HTTP request. Any body returned
-// by getBody will be closed before DoWithBody returns.
+// by getBody will be closed before DoWithBody returns. If the request
+// is retried (see Params.RetryPolicy), getBody is called again for
+// each attempt.
 func (c *Client) DoWithBody(req *http.Request, path string, getBody httpbakery.BodyGetter) (*http.Response, error) {
+	return c.doWithBody(req, path, getBody, retryByMethod)
+}
+
+// doWithBody is like DoWithBody except that it also specifies
+// whether the request may be retried.
+func (c *Client) doWithBody(req *http.Request, path string, getBody httpbakery.BodyGetter, mode retryMode) (*http.Response, error) {
 	if c.params.User != "" {
 		userPass := c.params.User + ":" + c.params.Password
 		authBasic := base64.StdEncoding.EncodeToString([]byte(userPass))
@@ -400,35 +819,69 @@ func (c *Client) DoWithBody(req *http.Request, path string, getBody httpbakery.B
 	req.URL = u
 
 	// Send the request.
-	resp, err := httpbakery.DoWithBody(c.params.HTTPClient, req, getBody, c.params.VisitWebPage)
+	if c.canceled() {
+		return nil, errgo.New("request canceled")
+	}
+	stop := c.watchRequest(req, c.params.Cancel, time.Time{})
+	var resp *http.Response
+	if c.params.RetryPolicy == nil {
+		resp, _, err = c.doOnce(c.params.HTTPClient, req, getBody)
+	} else {
+		resp, err = c.doWithRetries(c.params.RetryPolicy, req, getBody, mode)
+	}
 	if err != nil {
-		return nil, errgo.Mask(err)
+		stop()
+		return nil, err
+	}
+	if c.params.Cancel != nil {
+		// Keep watching the request until its body is closed,
+		// so that the reading of the body can be canceled.
+		resp.Body = &cancelingBody{resp.Body, stop}
+	}
+	return resp, nil
+}
+
+// doOnce sends the given request once, using the given HTTP client.
+// When the request fails, it also returns the minimum delay before
+// the request may be retried, or noRetry if the failure is not
+// transient.
+func (c *Client) doOnce(client *http.Client, req *http.Request, getBody httpbakery.BodyGetter) (*http.Response, time.Duration, error) {
+	resp, err := httpbakery.DoWithBody(client, req, getBody, c.params.VisitWebPage)
+	if err != nil {
+		if isTransportError(err) {
+			return nil, 0, errgo.Mask(err)
+		}
+		return nil, noRetry, errgo.Mask(err)
 	}
 	if resp.StatusCode == http.StatusOK {
-		return resp, nil
+		return resp, 0, nil
+	}
+	if resp.StatusCode == http.StatusPartialContent && req.Header.Get("Range") != "" {
+		return resp, 0, nil
 	}
 	defer resp.Body.Close()
 
 	// Parse the response error.
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errgo.Notef(err, "cannot read response body")
+		return nil, 0, errgo.Notef(err, "cannot read response body")
 	}
 	var perr params.Error
 	if err := json.Unmarshal(data, &perr); err != nil {
-		return nil, errgo.Notef(err, "cannot unmarshal error response %q", sizeLimit(data))
+		return nil, retryDelay(resp, ""), errgo.Notef(err, "cannot unmarshal error response %q", sizeLimit(data))
 	}
 	if perr.Message == "" {
-		return nil, errgo.Newf("error response with empty message %s", sizeLimit(data))
+		return nil, retryDelay(resp, ""), errgo.Newf("error response with empty message %s", sizeLimit(data))
 	}
-	return nil, &perr
+	return nil, retryDelay(resp, string(perr.Code)), &perr
 }
 
 // Do makes an arbitrary request to the charm store.
 // It adds appropriate headers to the given HTTP request,
 // sends it to the charm store, and returns the resulting
 // response. Do never returns a response with a status
-// that is not http.StatusOK.
+// that is not http.StatusOK, except for http.StatusPartialContent
+// in response to a request with a Range header.
 //
 // The URL field in the request is ignored and overwritten.
 //
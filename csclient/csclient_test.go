@@ -13,6 +13,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 
@@ -207,6 +208,321 @@ func (s *suite) TestGetArchive(c *gc.C) {
 	c.Assert(fmt.Sprintf("%x", h.Sum(nil)), gc.Equals, expectHash)
 }
 
+// archiveProxy forwards requests to the charm store,
+// altering the responses to archive downloads.
+type archiveProxy struct {
+	handler http.Handler
+
+	// chunked holds whether archives are sent
+	// without a Content-Length header.
+	chunked bool
+
+	// corrupt holds whether archive contents are corrupted.
+	corrupt bool
+
+	// truncate holds the number of archive responses
+	// that are cut off halfway through.
+	truncate int
+
+	// ranges holds the Range header of each archive request.
+	ranges []string
+
+	// aliases maps request paths to the paths they are
+	// forwarded to, as if the charm store resolved the
+	// requested ids to other entities.
+	aliases map[string]string
+}
+
+func (p *archiveProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" || !strings.HasSuffix(req.URL.Path, "/archive") {
+		p.handler.ServeHTTP(w, req)
+		return
+	}
+	if path, ok := p.aliases[req.URL.Path]; ok {
+		req.URL.Path = path
+	}
+	p.ranges = append(p.ranges, req.Header.Get("Range"))
+	rec := httptest.NewRecorder()
+	p.handler.ServeHTTP(rec, req)
+	for k, v := range rec.HeaderMap {
+		w.Header()[k] = v
+	}
+	body := rec.Body.Bytes()
+	if p.corrupt {
+		body[0] ^= 1
+	}
+	if p.chunked {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(rec.Code)
+		// Flushing before writing the body
+		// forces a chunked response.
+		w.(http.Flusher).Flush()
+		w.Write(body)
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	w.WriteHeader(rec.Code)
+	if p.truncate > 0 {
+		// The connection is closed as the body is
+		// shorter than its declared length.
+		p.truncate--
+		w.Write(body[:len(body)/2])
+		return
+	}
+	w.Write(body)
+}
+
+// startArchiveProxy adds the wordpress charm to the store and starts a
+// server forwarding requests to it through the given proxy. It returns
+// the charm id, the archive contents and a client using the proxy.
+func (s *suite) startArchiveProxy(c *gc.C, p *archiveProxy) (*charm.Reference, []byte, *csclient.Client) {
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	id := charm.MustParseReference("~who/utopic/wordpress-0")
+	err := s.store.AddCharmWithArchive(id, ch)
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadFile(ch.Path)
+	c.Assert(err, gc.IsNil)
+	p.handler = s.srv.Config.Handler
+	srv := httptest.NewServer(p)
+	s.AddCleanup(func(*gc.C) {
+		srv.Close()
+	})
+	return id, data, csclient.New(csclient.Params{
+		URL: srv.URL,
+	})
+}
+
+func (s *suite) TestGetArchiveChunked(c *gc.C) {
+	id, data, client := s.startArchiveProxy(c, &archiveProxy{
+		chunked: true,
+	})
+	r, eid, hash, size, err := client.GetArchive(id)
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	c.Assert(eid, jc.DeepEquals, id)
+	c.Assert(hash, gc.Equals, hashOfBytes(data))
+	c.Assert(size, gc.Equals, int64(-1))
+	got, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.DeepEquals, data)
+}
+
+func (s *suite) TestGetArchiveHashMismatch(c *gc.C) {
+	id, data, client := s.startArchiveProxy(c, &archiveProxy{
+		corrupt: true,
+	})
+	r, _, _, _, err := client.GetArchive(id)
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, gc.ErrorMatches, "archive hash mismatch: got [0-9a-f]+, expected "+hashOfBytes(data))
+}
+
+func (s *suite) TestGetArchiveResumesDownload(c *gc.C) {
+	p := &archiveProxy{
+		truncate: 1,
+	}
+	id, data, client := s.startArchiveProxy(c, p)
+	r, _, _, size, err := client.GetArchive(id)
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	c.Assert(size, gc.Equals, int64(len(data)))
+	got, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.DeepEquals, data)
+	c.Assert(p.ranges, jc.DeepEquals, []string{"", fmt.Sprintf("bytes=%d-", len(data)/2)})
+}
+
+func (s *suite) TestGetArchiveResumeFails(c *gc.C) {
+	id, _, client := s.startArchiveProxy(c, &archiveProxy{
+		truncate: 1000,
+	})
+	r, _, _, _, err := client.GetArchive(id)
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, gc.ErrorMatches, "cannot read archive: unexpected EOF")
+}
+
+func (s *suite) TestDownloadTo(c *gc.C) {
+	id, data, client := s.startArchiveProxy(c, &archiveProxy{
+		truncate: 1,
+	})
+	dir := c.MkDir()
+	path := filepath.Join(dir, "archive.zip")
+	eid, err := client.DownloadTo(charm.MustParseReference("~who/wordpress"), path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(eid, jc.DeepEquals, id)
+	got, err := ioutil.ReadFile(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.DeepEquals, data)
+}
+
+func (s *suite) TestDownloadToHashMismatch(c *gc.C) {
+	id, _, client := s.startArchiveProxy(c, &archiveProxy{
+		corrupt: true,
+	})
+	dir := c.MkDir()
+	path := filepath.Join(dir, "archive.zip")
+	err := ioutil.WriteFile(path, []byte("old contents"), 0644)
+	c.Assert(err, gc.IsNil)
+	_, err = client.DownloadTo(id, path)
+	c.Assert(err, gc.ErrorMatches, `cannot download "cs:~who/utopic/wordpress-0": archive hash mismatch: .*`)
+
+	// The existing file is left alone, and no temporary file remains.
+	got, err := ioutil.ReadFile(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(got), gc.Equals, "old contents")
+	infos, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 1)
+}
+
+// cachingClient returns a client that uses the same server
+// as the given client and caches archives in the given directory.
+func cachingClient(client *csclient.Client, dir string, size int64) *csclient.Client {
+	return csclient.New(csclient.Params{
+		URL:       client.ServerURL(),
+		CacheDir:  dir,
+		CacheSize: size,
+	})
+}
+
+func (s *suite) TestGetArchiveCached(c *gc.C) {
+	p := &archiveProxy{}
+	id, data, client := s.startArchiveProxy(c, p)
+	dir := c.MkDir()
+	client = cachingClient(client, dir, 0)
+	for i := 0; i < 3; i++ {
+		c.Logf("attempt %d", i)
+		r, eid, hash, size, err := client.GetArchive(id)
+		c.Assert(err, gc.IsNil)
+		c.Assert(eid, jc.DeepEquals, id)
+		c.Assert(hash, gc.Equals, hashOfBytes(data))
+		c.Assert(size, gc.Equals, int64(len(data)))
+		got, err := ioutil.ReadAll(r)
+		c.Assert(err, gc.IsNil)
+		c.Assert(got, gc.DeepEquals, data)
+		r.Close()
+	}
+	// Only the first request reached the charm store.
+	c.Assert(p.ranges, gc.HasLen, 1)
+
+	// Another client sharing the cache directory uses it too.
+	r, _, _, _, err := cachingClient(client, dir, 0).GetArchive(id)
+	c.Assert(err, gc.IsNil)
+	r.Close()
+	c.Assert(p.ranges, gc.HasLen, 1)
+
+	// Ids that are not fully qualified are always resolved by the
+	// charm store, as they may refer to a different entity later.
+	r, eid, _, _, err := client.GetArchive(charm.MustParseReference("~who/wordpress"))
+	c.Assert(err, gc.IsNil)
+	r.Close()
+	c.Assert(eid, jc.DeepEquals, id)
+	c.Assert(p.ranges, gc.HasLen, 2)
+}
+
+func (s *suite) TestGetArchiveCachedWithResolvedId(c *gc.C) {
+	p := &archiveProxy{
+		aliases: map[string]string{
+			"/v4/utopic/wordpress-0/archive": "/v4/~who/utopic/wordpress-0/archive",
+		},
+	}
+	id, _, client := s.startArchiveProxy(c, p)
+	client = cachingClient(client, c.MkDir(), 0)
+	alias := charm.MustParseReference("utopic/wordpress-0")
+	for i := 0; i < 2; i++ {
+		c.Logf("attempt %d", i)
+		r, eid, _, _, err := client.GetArchive(alias)
+		c.Assert(err, gc.IsNil)
+		_, err = ioutil.ReadAll(r)
+		c.Assert(err, gc.IsNil)
+		r.Close()
+		// The resolved id is returned, even from the cache.
+		c.Assert(eid, jc.DeepEquals, id)
+	}
+	c.Assert(p.ranges, gc.HasLen, 1)
+
+	// The archive is cached under the resolved id too.
+	r, eid, _, _, err := client.GetArchive(id)
+	c.Assert(err, gc.IsNil)
+	r.Close()
+	c.Assert(eid, jc.DeepEquals, id)
+	c.Assert(p.ranges, gc.HasLen, 1)
+}
+
+func (s *suite) TestGetArchiveCachedOnlyWhenVerified(c *gc.C) {
+	p := &archiveProxy{
+		corrupt: true,
+	}
+	id, _, client := s.startArchiveProxy(c, p)
+	dir := c.MkDir()
+	client = cachingClient(client, dir, 0)
+	for i := 0; i < 2; i++ {
+		r, _, _, _, err := client.GetArchive(id)
+		c.Assert(err, gc.IsNil)
+		_, err = ioutil.ReadAll(r)
+		c.Assert(err, gc.ErrorMatches, "archive hash mismatch: .*")
+		r.Close()
+	}
+	c.Assert(p.ranges, gc.HasLen, 2)
+
+	// Archives that are only partly read are not cached either.
+	p.corrupt = false
+	r, _, _, _, err := client.GetArchive(id)
+	c.Assert(err, gc.IsNil)
+	_, err = r.Read(make([]byte, 10))
+	c.Assert(err, gc.IsNil)
+	r.Close()
+	r, _, _, _, err = client.GetArchive(id)
+	c.Assert(err, gc.IsNil)
+	r.Close()
+	c.Assert(p.ranges, gc.HasLen, 4)
+	infos, err := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 0)
+}
+
+func (s *suite) TestGetArchiveCacheEviction(c *gc.C) {
+	p := &archiveProxy{}
+	id, data, client := s.startArchiveProxy(c, p)
+	mysqlId := charm.MustParseReference("~who/utopic/mysql-0")
+	mysql := storetesting.Charms.CharmArchive(c.MkDir(), "mysql")
+	err := s.store.AddCharmWithArchive(mysqlId, mysql)
+	c.Assert(err, gc.IsNil)
+	info, err := os.Stat(mysql.Path)
+	c.Assert(err, gc.IsNil)
+
+	// The cache can hold only one archive.
+	maxSize := int64(len(data))
+	if info.Size() > maxSize {
+		maxSize = info.Size()
+	}
+	client = cachingClient(client, c.MkDir(), maxSize)
+	download := func(id *charm.Reference) {
+		r, _, _, _, err := client.GetArchive(id)
+		c.Assert(err, gc.IsNil)
+		defer r.Close()
+		_, err = ioutil.ReadAll(r)
+		c.Assert(err, gc.IsNil)
+	}
+	download(id)
+	download(id)
+	c.Assert(p.ranges, gc.HasLen, 1)
+	download(mysqlId)
+	c.Assert(p.ranges, gc.HasLen, 2)
+	// The wordpress archive has been evicted.
+	download(id)
+	c.Assert(p.ranges, gc.HasLen, 3)
+}
+
+func hashOfBytes(data []byte) string {
+	h := blobstore.NewHash()
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 var getArchiveWithBadResponseTests = []struct {
 	about       string
 	response    *http.Response
@@ -278,22 +594,6 @@ var getArchiveWithBadResponseTests = []struct {
 		ContentLength: fakeSize,
 	},
 	expectError: "no " + params.ContentHashHeader + " header found in response",
-}, {
-	about: "no content length",
-	response: &http.Response{
-		Status:     "200 OK",
-		StatusCode: 200,
-		Proto:      "HTTP/1.0",
-		ProtoMajor: 1,
-		ProtoMinor: 0,
-		Header: http.Header{
-			params.ContentHashHeader: {fakeHash},
-			params.EntityIdHeader:    {"cs:utopic/django-42"},
-		},
-		Body:          ioutil.NopCloser(strings.NewReader("")),
-		ContentLength: -1,
-	},
-	expectError: "no content length found in response",
 }}
 
 func (s *suite) TestGetArchiveWithBadResponse(c *gc.C) {
@@ -588,6 +888,343 @@ func (s *suite) checkUploadBundle(c *gc.C, id *charm.Reference, b charm.Bundle)
 	c.Assert(result.Data(), jc.DeepEquals, b.Data())
 }
 
+func (s *suite) TestUploadCharmWithRevision(c *gc.C) {
+	ch := storetesting.Charms.CharmDir("wordpress")
+	id := charm.MustParseReference("~who/trusty/wordpress-42")
+	err := s.client.UploadCharmWithRevision(id, ch)
+	c.Assert(err, gc.IsNil)
+	s.checkUploadCharm(c, id, ch)
+
+	// Uploading again with the same revision fails.
+	err = s.client.UploadCharmWithRevision(id, ch)
+	c.Assert(err, gc.ErrorMatches, "cannot put archive: .*")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrDuplicateUpload)
+}
+
+func (s *suite) TestUploadCharmWithRevisionAndFlags(c *gc.C) {
+	ch := storetesting.Charms.CharmDir("wordpress")
+	id := charm.MustParseReference("~who/trusty/wordpress-42")
+	err := s.client.UploadCharmWithRevisionAndFlags(id, ch, csclient.UploadFlags{
+		Private: true,
+		Hidden:  true,
+	})
+	c.Assert(err, gc.IsNil)
+	s.checkUploadCharm(c, id, ch)
+	var meta struct {
+		Perm   params.PermResponse
+		Hidden params.HiddenResponse
+	}
+	_, err = s.client.Meta(id, &meta)
+	c.Assert(err, gc.IsNil)
+	c.Assert(meta.Perm.Read, jc.DeepEquals, []string{"who"})
+	c.Assert(meta.Hidden.Hidden, gc.Equals, true)
+}
+
+func (s *suite) TestUploadCharmWithRevisionErrorNoRevision(c *gc.C) {
+	err := s.client.UploadCharmWithRevision(
+		charm.MustParseReference("trusty/wordpress"),
+		storetesting.Charms.CharmDir("wordpress"),
+	)
+	c.Assert(err, gc.ErrorMatches, `no revision specified in "cs:trusty/wordpress"`)
+}
+
+func (s *suite) TestUploadBundleWithRevision(c *gc.C) {
+	s.prepareBundleCharms(c)
+	b := storetesting.Charms.BundleDir("wordpress-simple")
+	id := charm.MustParseReference("~who/bundle/wordpress-simple-7")
+	err := s.client.UploadBundleWithRevision(id, b)
+	c.Assert(err, gc.IsNil)
+	s.checkUploadBundle(c, id, b)
+}
+
+func (s *suite) TestUploadBundleWithRevisionErrorNoSeries(c *gc.C) {
+	err := s.client.UploadBundleWithRevision(
+		charm.MustParseReference("wordpress-simple-1"),
+		storetesting.Charms.BundleDir("wordpress-simple"),
+	)
+	c.Assert(err, gc.ErrorMatches, `no series specified in "cs:wordpress-simple-1"`)
+}
+
+func (s *suite) TestUploadCharmInParts(c *gc.C) {
+	client := csclient.New(csclient.Params{
+		URL:            s.srv.URL,
+		User:           s.serverParams.AuthUsername,
+		Password:       s.serverParams.AuthPassword,
+		UploadPartSize: 100,
+	})
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	id, err := client.UploadCharm(charm.MustParseReference("~who/utopic/wordpress"), ch)
+	c.Assert(err, gc.IsNil)
+	c.Assert(id.String(), gc.Equals, "cs:~who/utopic/wordpress-0")
+	s.checkUploadCharm(c, id, ch)
+
+	err = client.UploadCharmWithRevision(charm.MustParseReference("~who/utopic/wordpress-5"), ch)
+	c.Assert(err, gc.IsNil)
+	s.checkUploadCharm(c, charm.MustParseReference("~who/utopic/wordpress-5"), ch)
+
+	// The uploads are removed once committed.
+	n, err := s.store.DB.Uploads().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+// failingPartsHandler forwards requests to the charm store, except
+// that the PUT requests of the given part fail while failures is
+// positive. If storeFailed is true, the part is stored anyway, as
+// if only the response had been lost.
+type failingPartsHandler struct {
+	handler     http.Handler
+	part        string
+	failures    int
+	storeFailed bool
+	puts        []string
+}
+
+func (h *failingPartsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "PUT" || !strings.Contains(req.URL.Path, "/upload/") {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+	part := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	h.puts = append(h.puts, part)
+	if part != h.part || h.failures == 0 {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+	h.failures--
+	if h.storeFailed {
+		h.handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(params.Error{
+		Message: "connection dropped",
+	})
+}
+
+func (s *suite) TestUploadInPartsResumes(c *gc.C) {
+	handler := &failingPartsHandler{
+		handler:     s.srv.Config.Handler,
+		part:        "1",
+		failures:    1,
+		storeFailed: true,
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL:            srv.URL,
+		User:           s.serverParams.AuthUsername,
+		Password:       s.serverParams.AuthPassword,
+		UploadPartSize: 100,
+	})
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	id, err := client.UploadCharm(charm.MustParseReference("~who/utopic/wordpress"), ch)
+	c.Assert(err, gc.IsNil)
+	s.checkUploadCharm(c, id, ch)
+
+	// The upload resumed after the part that was stored.
+	c.Assert(handler.puts[:3], jc.DeepEquals, []string{"0", "1", "2"})
+}
+
+func (s *suite) TestUploadInPartsFails(c *gc.C) {
+	handler := &failingPartsHandler{
+		handler:  s.srv.Config.Handler,
+		part:     "1",
+		failures: 100,
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL:            srv.URL,
+		User:           s.serverParams.AuthUsername,
+		Password:       s.serverParams.AuthPassword,
+		UploadPartSize: 100,
+	})
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	_, err := client.UploadCharm(charm.MustParseReference("~who/utopic/wordpress"), ch)
+	c.Assert(err, gc.ErrorMatches, "cannot upload part 1: connection dropped")
+	c.Assert(handler.puts, jc.DeepEquals, []string{"0", "1", "1", "1"})
+}
+
+// partUploadRejecter simulates a charm store that does not
+// support uploads in parts, failing the requests to start an
+// upload session, and counts those requests.
+type partUploadRejecter struct {
+	handler http.Handler
+	count   int
+}
+
+func (h *partUploadRejecter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" && strings.HasSuffix(req.URL.Path, "/upload") {
+		h.count++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"Message": "not found", "Code": "not found"}`))
+		return
+	}
+	h.handler.ServeHTTP(w, req)
+}
+
+func (s *suite) TestUploadCharmWithoutUploadInPartsSupport(c *gc.C) {
+	handler := &partUploadRejecter{
+		handler: s.srv.Config.Handler,
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL:            srv.URL,
+		User:           s.serverParams.AuthUsername,
+		Password:       s.serverParams.AuthPassword,
+		UploadPartSize: 100,
+	})
+
+	// The archive is uploaded in a single request when the upload
+	// session cannot be started, and no upload session is started
+	// for later uploads.
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	id, err := client.UploadCharm(charm.MustParseReference("~who/trusty/wordpress"), ch)
+	c.Assert(err, gc.IsNil)
+	s.checkUploadCharm(c, id, ch)
+	ch = storetesting.Charms.CharmArchive(c.MkDir(), "mysql")
+	err = client.UploadCharmWithRevision(charm.MustParseReference("~who/precise/mysql-3"), ch)
+	c.Assert(err, gc.IsNil)
+	s.checkUploadCharm(c, charm.MustParseReference("~who/precise/mysql-3"), ch)
+	c.Assert(handler.count, gc.Equals, 1)
+}
+
+// bodySizeHandler forwards requests to the charm store,
+// recording the total size of the request bodies.
+type bodySizeHandler struct {
+	handler http.Handler
+	size    int64
+}
+
+func (h *bodySizeHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.ContentLength > 0 {
+		h.size += req.ContentLength
+	}
+	h.handler.ServeHTTP(w, req)
+}
+
+func (s *suite) TestUploadCharmWithContentChallenge(c *gc.C) {
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	_, err := s.client.UploadCharm(charm.MustParseReference("~who/utopic/wordpress"), ch)
+	c.Assert(err, gc.IsNil)
+
+	handler := &bodySizeHandler{
+		handler: s.srv.Config.Handler,
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL:      srv.URL,
+		User:     s.serverParams.AuthUsername,
+		Password: s.serverParams.AuthPassword,
+	})
+
+	// The content is already in the charm store, so it is not sent again.
+	id, err := client.UploadCharm(charm.MustParseReference("~who/trusty/wordpress"), ch)
+	c.Assert(err, gc.IsNil)
+	c.Assert(id.String(), gc.Equals, "cs:~who/trusty/wordpress-0")
+	s.checkUploadCharm(c, id, ch)
+
+	err = client.UploadCharmWithRevision(charm.MustParseReference("~who/precise/wordpress-3"), ch)
+	c.Assert(err, gc.IsNil)
+	s.checkUploadCharm(c, charm.MustParseReference("~who/precise/wordpress-3"), ch)
+
+	c.Assert(handler.size, gc.Equals, int64(0))
+}
+
+// challengeRejecter simulates a charm store that does not support
+// proof-of-content challenges, failing the requests that hold the
+// given parameter, and counts those requests.
+type challengeRejecter struct {
+	handler http.Handler
+	param   string
+	count   int
+}
+
+func (h *challengeRejecter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Query().Get(h.param) != "" {
+		h.count++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"Message": "cannot put archive blob: hash mismatch"}`))
+		return
+	}
+	h.handler.ServeHTTP(w, req)
+}
+
+func (s *suite) TestUploadCharmWithoutContentChallengeSupport(c *gc.C) {
+	handler := &challengeRejecter{
+		handler: s.srv.Config.Handler,
+		param:   "challenge",
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL:      srv.URL,
+		User:     s.serverParams.AuthUsername,
+		Password: s.serverParams.AuthPassword,
+	})
+
+	// The archive is uploaded as usual when the challenge fails,
+	// and no challenge is requested for later uploads.
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	id, err := client.UploadCharm(charm.MustParseReference("~who/trusty/wordpress"), ch)
+	c.Assert(err, gc.IsNil)
+	s.checkUploadCharm(c, id, ch)
+	err = client.UploadCharmWithRevision(charm.MustParseReference("~who/precise/wordpress-3"), ch)
+	c.Assert(err, gc.IsNil)
+	s.checkUploadCharm(c, charm.MustParseReference("~who/precise/wordpress-3"), ch)
+	c.Assert(handler.count, gc.Equals, 1)
+}
+
+func (s *suite) TestUploadCharmWithFailedContentChallenge(c *gc.C) {
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	_, err := s.client.UploadCharm(charm.MustParseReference("~who/utopic/wordpress"), ch)
+	c.Assert(err, gc.IsNil)
+
+	handler := &challengeRejecter{
+		handler: s.srv.Config.Handler,
+		param:   "challenge-id",
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL:      srv.URL,
+		User:     s.serverParams.AuthUsername,
+		Password: s.serverParams.AuthPassword,
+	})
+
+	// The archive is uploaded as usual when the
+	// response to the challenge fails.
+	id, err := client.UploadCharm(charm.MustParseReference("~who/trusty/wordpress"), ch)
+	c.Assert(err, gc.IsNil)
+	c.Assert(id.String(), gc.Equals, "cs:~who/trusty/wordpress-0")
+	s.checkUploadCharm(c, id, ch)
+	c.Assert(handler.count, gc.Equals, 1)
+}
+
+func (s *suite) TestPut(c *gc.C) {
+	id := charm.MustParseReference("~who/utopic/wordpress-0")
+	err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = s.client.Put("/~who/utopic/wordpress-0/meta/perm/read", []string{"who", "bob"})
+	c.Assert(err, gc.IsNil)
+	var result struct {
+		Perm params.PermResponse
+	}
+	_, err = s.client.Meta(id, &result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Perm.Read, jc.DeepEquals, []string{"who", "bob"})
+}
+
+func (s *suite) TestPutWithError(c *gc.C) {
+	err := s.client.Put("/wordpress/meta/perm/read", []string{"bob"})
+	c.Assert(err, gc.ErrorMatches, `no matching charm or bundle for "cs:wordpress"`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
 func (s *suite) TestDoAuthorization(c *gc.C) {
 	// Add a charm to be deleted.
 	ch := storetesting.Charms.CharmDir("wordpress")
@@ -903,6 +1540,217 @@ func (s *suite) TestMeta(c *gc.C) {
 	}
 }
 
+type metaManyResult struct {
+	Id            *params.IdResponse
+	CharmMetadata *charm.Meta
+}
+
+func (s *suite) TestMetaMany(c *gc.C) {
+	wordpress := storetesting.Charms.CharmDir("wordpress")
+	err := s.store.AddCharmWithArchive(charm.MustParseReference("utopic/wordpress-42"), wordpress)
+	c.Assert(err, gc.IsNil)
+	mysql := storetesting.Charms.CharmDir("mysql")
+	err = s.store.AddCharmWithArchive(charm.MustParseReference("utopic/mysql-47"), mysql)
+	c.Assert(err, gc.IsNil)
+
+	results, err := s.client.MetaMany([]*charm.Reference{
+		charm.MustParseReference("wordpress"),
+		charm.MustParseReference("utopic/mysql-47"),
+		charm.MustParseReference("wordpress"),
+		charm.MustParseReference("bogus"),
+	}, (*metaManyResult)(nil))
+	c.Assert(err, gc.IsNil)
+	c.Assert(results, jc.DeepEquals, map[string]interface{}{
+		"cs:wordpress": &metaManyResult{
+			Id: &params.IdResponse{
+				Id:       charm.MustParseReference("cs:utopic/wordpress-42"),
+				Series:   "utopic",
+				Name:     "wordpress",
+				Revision: 42,
+			},
+			CharmMetadata: wordpress.Meta(),
+		},
+		"cs:utopic/mysql-47": &metaManyResult{
+			Id: &params.IdResponse{
+				Id:       charm.MustParseReference("cs:utopic/mysql-47"),
+				Series:   "utopic",
+				Name:     "mysql",
+				Revision: 47,
+			},
+			CharmMetadata: mysql.Meta(),
+		},
+	})
+}
+
+func (s *suite) TestMetaManyBadType(c *gc.C) {
+	ids := []*charm.Reference{charm.MustParseReference("wordpress")}
+	for _, test := range metaBadTypeTests {
+		_, err := s.client.MetaMany(ids, test.result)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+func (s *suite) TestMetaManySplitsRequests(c *gc.C) {
+	s.PatchValue(csclient.MaxMetaManyIds, 2)
+	var ids []*charm.Reference
+	for i := 0; i < 5; i++ {
+		id := charm.MustParseReference(fmt.Sprintf("~who/utopic/wordpress-%d", i))
+		err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"))
+		c.Assert(err, gc.IsNil)
+		ids = append(ids, id)
+	}
+	var requestIds [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		requestIds = append(requestIds, req.Form["id"])
+		s.srv.Config.Handler.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL: srv.URL,
+	})
+	results, err := client.MetaMany(ids, &struct {
+		IdRevision params.IdRevisionResponse
+	}{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(results, gc.HasLen, 5)
+	for i, id := range ids {
+		c.Assert(results[id.String()], jc.DeepEquals, &struct {
+			IdRevision params.IdRevisionResponse
+		}{params.IdRevisionResponse{Revision: i}})
+	}
+	c.Assert(requestIds, jc.DeepEquals, [][]string{
+		{ids[0].String(), ids[1].String()},
+		{ids[2].String(), ids[3].String()},
+		{ids[4].String()},
+	})
+}
+
+func (s *suite) TestMetaManyMultipleErrors(c *gc.C) {
+	var requestIds [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		ids := req.Form["id"]
+		requestIds = append(requestIds, ids)
+		w.Header().Set("Content-Type", "application/json")
+		if len(ids) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(mustMarshalJSON(params.Error{
+				Message: "multiple (1) errors",
+				Code:    params.ErrMultipleErrors,
+				Info: map[string]*params.Error{
+					"cs:~who/private": {
+						Message: "unauthorized",
+						Code:    params.ErrUnauthorized,
+					},
+				},
+			}))
+			return
+		}
+		w.Write(mustMarshalJSON(map[string]params.MetaAnyResponse{
+			"cs:~who/public": {
+				Id: charm.MustParseReference("cs:~who/utopic/public-0"),
+				Meta: map[string]interface{}{
+					"id-name": params.IdNameResponse{Name: "public"},
+				},
+			},
+		}))
+	}))
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL: srv.URL,
+	})
+	results, err := client.MetaMany([]*charm.Reference{
+		charm.MustParseReference("~who/private"),
+		charm.MustParseReference("~who/public"),
+	}, &struct {
+		IdName params.IdNameResponse
+	}{})
+	c.Assert(err, gc.ErrorMatches, "cannot get metadata for 1 ids")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrMultipleErrors)
+	c.Assert(err.(*params.Error).Info, jc.DeepEquals, map[string]*params.Error{
+		"cs:~who/private": {
+			Message: "unauthorized",
+			Code:    params.ErrUnauthorized,
+		},
+	})
+	// The metadata of the other ids is still returned.
+	c.Assert(results, jc.DeepEquals, map[string]interface{}{
+		"cs:~who/public": &struct {
+			IdName params.IdNameResponse
+		}{params.IdNameResponse{Name: "public"}},
+	})
+	c.Assert(requestIds, jc.DeepEquals, [][]string{
+		{"cs:~who/private", "cs:~who/public"},
+		{"cs:~who/public"},
+	})
+}
+
+func (s *suite) TestMetaManyUnreadable(c *gc.C) {
+	wordpress := charm.MustParseReference("~who/utopic/wordpress-0")
+	err := s.store.AddCharmWithArchive(wordpress, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = s.client.Put("/"+wordpress.Path()+"/meta/perm/read", []string{params.Everyone})
+	c.Assert(err, gc.IsNil)
+	private := charm.MustParseReference("~who/utopic/private-0")
+	err = s.store.AddCharmWithArchive(private, storetesting.Charms.CharmDir("mysql"))
+	c.Assert(err, gc.IsNil)
+	err = s.client.Put("/"+private.Path()+"/meta/perm/read", []string{"who"})
+	c.Assert(err, gc.IsNil)
+
+	s.discharge = func(cond, arg string) ([]checkers.Caveat, error) {
+		return []checkers.Caveat{checkers.DeclaredCaveat("username", "bob")}, nil
+	}
+	var requestIds [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		requestIds = append(requestIds, req.Form["id"])
+		s.srv.Config.Handler.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL: srv.URL,
+	})
+	results, err := client.MetaMany([]*charm.Reference{private, wordpress}, &struct {
+		IdName params.IdNameResponse
+	}{})
+	c.Assert(err, gc.ErrorMatches, "cannot get metadata for 1 ids")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrMultipleErrors)
+	info := err.(*params.Error).Info
+	c.Assert(info, gc.HasLen, 1)
+	c.Assert(info["cs:~who/utopic/private-0"].Code, gc.Equals, params.ErrUnauthorized)
+
+	// The metadata of the readable id is still returned.
+	c.Assert(results, jc.DeepEquals, map[string]interface{}{
+		"cs:~who/utopic/wordpress-0": &struct {
+			IdName params.IdNameResponse
+		}{params.IdNameResponse{Name: "wordpress"}},
+	})
+
+	// The batch was retried id by id after failing as a whole.
+	c.Assert(requestIds[0], jc.DeepEquals, []string{"cs:~who/utopic/private-0", "cs:~who/utopic/wordpress-0"})
+	c.Assert(requestIds[len(requestIds)-2:], jc.DeepEquals, [][]string{
+		{"cs:~who/utopic/private-0"},
+		{"cs:~who/utopic/wordpress-0"},
+	})
+}
+
+func (s *suite) TestMetaManyError(c *gc.C) {
+	client := csclient.New(csclient.Params{
+		URL: "http://0.1.2.3",
+		HTTPClient: &http.Client{
+			Transport: &cannedRoundTripper{
+				error: errgo.New("an error"),
+			},
+		},
+	})
+	results, err := client.MetaMany([]*charm.Reference{
+		charm.MustParseReference("wordpress"),
+	}, &struct{}{})
+	c.Assert(err, gc.ErrorMatches, "cannot get metadata: .*an error")
+	c.Assert(results, gc.IsNil)
+}
+
 func (s *suite) TestPutExtraInfo(c *gc.C) {
 	ch := storetesting.Charms.CharmDir("wordpress")
 	url := charm.MustParseReference("utopic/wordpress-42")
@@ -0,0 +1,160 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/params"
+)
+
+// maxDownloadResumes holds the maximum number of times in a row
+// that an interrupted archive download is resumed without any
+// data being read.
+const maxDownloadResumes = 3
+
+// archiveReader reads the archive of an entity. It verifies the
+// archive hash when the end of the archive is reached, and resumes
+// the download with a range request when it is interrupted.
+type archiveReader struct {
+	client *Client
+
+	// id holds the fully qualified id of the entity.
+	id *charm.Reference
+
+	// hash and size hold the expected hash and size of the
+	// archive. The size is -1 if it is not known.
+	hash string
+	size int64
+
+	body    io.ReadCloser
+	h       hash.Hash
+	n       int64
+	resumes int
+
+	// err holds any error that has terminated the download.
+	err error
+}
+
+func newArchiveReader(c *Client, id *charm.Reference, hash string, size int64, body io.ReadCloser) *archiveReader {
+	return &archiveReader{
+		client: c,
+		id:     id,
+		hash:   hash,
+		size:   size,
+		body:   body,
+		h:      params.NewHash(),
+	}
+}
+
+// Read implements io.Reader.Read. When the end of the archive is
+// reached, it returns an error if the archive does not have the
+// expected hash and size.
+func (r *archiveReader) Read(buf []byte) (int, error) {
+	for {
+		if r.err != nil {
+			return 0, r.err
+		}
+		n, err := r.body.Read(buf)
+		r.h.Write(buf[:n])
+		r.n += int64(n)
+		if n > 0 {
+			r.resumes = 0
+		}
+		switch {
+		case err == io.EOF:
+			r.err = r.verify()
+		case err != nil:
+			r.err = r.resume(err)
+		}
+		if n > 0 {
+			// Any error is returned by the next call.
+			return n, nil
+		}
+	}
+}
+
+// verify checks that the archive read so far is the whole
+// expected archive. It returns io.EOF if it is.
+func (r *archiveReader) verify() error {
+	if r.size >= 0 && r.n != r.size {
+		return errgo.Newf("archive size mismatch: got %d bytes, expected %d", r.n, r.size)
+	}
+	if got := fmt.Sprintf("%x", r.h.Sum(nil)); got != r.hash {
+		return errgo.Newf("archive hash mismatch: got %s, expected %s", got, r.hash)
+	}
+	return io.EOF
+}
+
+// resume resumes the download after it has
+// been interrupted by the given error.
+func (r *archiveReader) resume(readErr error) error {
+	r.body.Close()
+	if r.resumes >= maxDownloadResumes {
+		return errgo.Notef(readErr, "cannot read archive")
+	}
+	r.resumes++
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		return errgo.Notef(err, "cannot make new request")
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.n))
+	// Do not count the resumed download as a new download.
+	resp, err := r.client.Do(req, "/"+r.id.Path()+"/archive?stats=0")
+	if err != nil {
+		return errgo.Notef(err, "cannot resume download after error %q", readErr)
+	}
+	if resp.StatusCode != http.StatusPartialContent ||
+		!strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", r.n)) ||
+		resp.Header.Get(params.ContentHashHeader) != r.hash {
+		resp.Body.Close()
+		return errgo.Newf("cannot resume download after error %q: unexpected response", readErr)
+	}
+	r.body = resp.Body
+	return nil
+}
+
+// Close implements io.Closer.Close.
+func (r *archiveReader) Close() error {
+	return r.body.Close()
+}
+
+// DownloadTo downloads the archive of the charm or bundle with the
+// given id to the file at the given path, and returns the fully
+// qualified id of the entity. The archive hash is verified and
+// interrupted downloads are resumed. The file is replaced atomically,
+// so it is never left holding a partial or corrupted archive.
+func (c *Client) DownloadTo(id *charm.Reference, path string) (*charm.Reference, error) {
+	r, eid, _, _, err := c.GetArchive(id)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	defer r.Close()
+	f, err := ioutil.TempFile(filepath.Dir(path), ".csclient-download")
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot make temporary file")
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, errgo.Notef(err, "cannot download %q", eid)
+	}
+	return eid, nil
+}
@@ -4,6 +4,9 @@
 package csclient
 
 var (
-	Hyphenate     = hyphenate
-	UploadArchive = (*Client).uploadArchive
+	Hyphenate      = hyphenate
+	MaxMetaManyIds = &maxMetaManyIds
+	NewCookieJar   = newCookieJar
+	Sleep          = &sleep
+	UploadArchive  = (*Client).uploadArchive
 )
@@ -0,0 +1,322 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/macaroon-bakery.v0/httpbakery"
+)
+
+// RetryPolicy specifies how requests that fail
+// because of transient errors are retried.
+//
+// A request is retried when it cannot be sent or its response
+// cannot be received, or when the charm store responds with a
+// "too many requests", "bad gateway", "service unavailable" or
+// "gateway timeout" status, or with an unexpected internal error.
+type RetryPolicy struct {
+	// MaxAttempts holds the maximum number of times a request
+	// is sent, including the first attempt. If it is less than
+	// two, requests are not retried.
+	MaxAttempts int
+
+	// MinDelay holds the delay before the first retry. The delay
+	// doubles after each attempt, up to MaxDelay. A random jitter
+	// of up to half the delay is subtracted from each delay, so
+	// that many clients failing at once do not retry in lockstep.
+	// A longer delay is used when the charm store responds with a
+	// Retry-After header.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// RetryNonIdempotent specifies that requests with methods that
+	// are not idempotent, such as POST, are retried too. By
+	// default only GET, HEAD, PUT, DELETE and OPTIONS requests,
+	// and archive uploads, are retried. Requests committing an
+	// upload in parts are never retried.
+	RetryNonIdempotent bool
+
+	// Timeout holds the maximum duration of a request, including
+	// all its attempts and the delays between them, up to the
+	// receipt of the response headers. The reading of the response
+	// body is not limited, so that large archives can be downloaded
+	// over slow connections; Params.Cancel may be used to abandon
+	// it. If zero, requests have no deadline.
+	Timeout time.Duration
+}
+
+// DefaultRetryPolicy holds a retry policy
+// suitable for use in Params.RetryPolicy.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: 5,
+	MinDelay:    100 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// sleep is defined as a variable so that it can be replaced in tests.
+var sleep = time.Sleep
+
+// statusTooManyRequests holds the HTTP status returned
+// when requests are being rate limited.
+const statusTooManyRequests = 429
+
+// noRetry is returned by Client.doOnce when a failed
+// request must not be retried.
+const noRetry time.Duration = -1
+
+// retryMode specifies whether a failed request may be retried.
+type retryMode int
+
+const (
+	// retryByMethod specifies that the request may be
+	// retried if its method is idempotent.
+	retryByMethod retryMode = iota
+
+	// retryIdempotent specifies that the request is idempotent,
+	// and so may be retried, even though its method is not.
+	retryIdempotent
+
+	// retryNever specifies that the request must not be retried,
+	// even though its method is idempotent, because repeating it
+	// after a lost response would fail.
+	retryNever
+)
+
+// canRetry reports whether requests with the given method and
+// retry mode can be retried.
+func (p *RetryPolicy) canRetry(method string, mode retryMode) bool {
+	switch {
+	case mode == retryNever:
+		return false
+	case mode == retryIdempotent || p.RetryNonIdempotent:
+		return true
+	}
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// delay returns the delay to wait for after
+// the given failed attempt, counting from one.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.MinDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d - time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// doWithRetries sends the given request using the given retry policy.
+// It returns the result of the last attempt.
+func (c *Client) doWithRetries(policy *RetryPolicy, req *http.Request, getBody httpbakery.BodyGetter, mode retryMode) (*http.Response, error) {
+	var deadline time.Time
+	if policy.Timeout > 0 {
+		deadline = time.Now().Add(policy.Timeout)
+	}
+	for attempt := 1; ; attempt++ {
+		stop := c.watchRequest(req, nil, deadline)
+		resp, retryAfter, err := c.doOnce(c.params.HTTPClient, req, getBody)
+		stop()
+		if err == nil || retryAfter == noRetry || attempt >= policy.MaxAttempts || !policy.canRetry(req.Method, mode) || c.canceled() {
+			return resp, err
+		}
+		d := policy.delay(attempt)
+		if retryAfter > d {
+			d = retryAfter
+		}
+		if !deadline.IsZero() && !time.Now().Add(d).Before(deadline) {
+			// There is no time left for another attempt.
+			return resp, err
+		}
+		if !c.wait(d) {
+			return resp, err
+		}
+	}
+}
+
+// canceled reports whether the requests
+// made by the client have been canceled.
+func (c *Client) canceled() bool {
+	select {
+	case <-c.params.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for the given duration and reports whether it
+// elapsed before the requests made by the client were canceled.
+func (c *Client) wait(d time.Duration) bool {
+	if c.params.Cancel == nil {
+		sleep(d)
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		sleep(d)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-c.params.Cancel:
+		return false
+	}
+}
+
+// watchRequest cancels the given request when the given channel
+// is closed or the given deadline, if not zero, is reached,
+// until the returned function is called.
+func (c *Client) watchRequest(req *http.Request, cancel <-chan struct{}, deadline time.Time) (stop func()) {
+	if cancel == nil && deadline.IsZero() {
+		return func() {}
+	}
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer = time.NewTimer(deadline.Sub(time.Now()))
+		timeout = timer.C
+	}
+	var mu sync.Mutex
+	stopped := false
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+		case <-timeout:
+		case <-done:
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// The request may have stopped being watched, and its
+		// response body be in use, since the select returned.
+		if !stopped {
+			c.cancelRequest(req)
+		}
+	}()
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
+		stopped = true
+		close(done)
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// cancelRequest cancels the given request in progress,
+// if the transport of the HTTP client supports it.
+func (c *Client) cancelRequest(req *http.Request) {
+	t := c.params.HTTPClient.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	if t, ok := t.(interface {
+		CancelRequest(*http.Request)
+	}); ok {
+		t.CancelRequest(req)
+	}
+}
+
+// cancelingBody wraps a response body so that the request
+// stops being watched for cancellation when it is closed.
+type cancelingBody struct {
+	io.ReadCloser
+	stop func()
+}
+
+// Close implements io.Closer.Close.
+func (b *cancelingBody) Close() error {
+	b.stop()
+	return b.ReadCloser.Close()
+}
+
+// isTransportError reports whether the given error, as returned by
+// httpbakery.DoWithBody, was caused by a failure to send the request
+// or to receive its response.
+func isTransportError(err error) bool {
+	for err != nil {
+		switch err.(type) {
+		case *url.Error, net.Error:
+			return true
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return true
+		}
+		u, ok := err.(interface {
+			Underlying() error
+		})
+		if !ok {
+			return false
+		}
+		err = u.Underlying()
+	}
+	return false
+}
+
+// retryDelay returns the delay before which a request that failed
+// with the given response status and error code may be retried,
+// or noRetry if the failure is not transient.
+func retryDelay(resp *http.Response, code string) time.Duration {
+	switch resp.StatusCode {
+	case http.StatusInternalServerError:
+		// Errors with a code are returned consistently
+		// by the charm store, so retrying them is useless.
+		if code != "" {
+			return noRetry
+		}
+	case statusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+	default:
+		return noRetry
+	}
+	return parseRetryAfter(resp.Header.Get("Retry-After"))
+}
+
+// parseRetryAfter returns the delay specified by the given
+// Retry-After header value, which holds either a number of
+// seconds or a date. It returns zero if the value is not valid.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0
+	}
+	if d := t.Sub(time.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,435 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/csclient"
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+// flakyHandler forwards requests to the charm store,
+// failing the first requests it receives.
+type flakyHandler struct {
+	handler http.Handler
+
+	// failures holds the number of requests to fail.
+	failures int
+
+	// status and header hold the status and headers of the
+	// failed responses. If status is zero, the connection
+	// is closed without a response.
+	status int
+	header http.Header
+
+	// body holds the body of the failed responses.
+	body interface{}
+
+	// requests holds the method and
+	// path of each request received.
+	requests []string
+}
+
+func (h *flakyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.requests = append(h.requests, req.Method+" "+req.URL.Path)
+	if h.failures == 0 {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+	h.failures--
+	if h.status == 0 {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		conn.Close()
+		return
+	}
+	for k, v := range h.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(h.status)
+	w.Write(mustMarshalJSON(h.body))
+}
+
+// startFlakyServer starts a server forwarding requests to the charm
+// store through the given handler, and returns a client using it with
+// the given retry policy.
+func (s *suite) startFlakyServer(c *gc.C, h *flakyHandler, policy *csclient.RetryPolicy) *csclient.Client {
+	return s.startFlakyServerWithParams(c, h, csclient.Params{
+		RetryPolicy: policy,
+	})
+}
+
+// startFlakyServerWithParams is like startFlakyServer except
+// that the client is created with the given parameters.
+func (s *suite) startFlakyServerWithParams(c *gc.C, h *flakyHandler, p csclient.Params) *csclient.Client {
+	h.handler = s.srv.Config.Handler
+	if h.body == nil {
+		h.body = params.Error{
+			Message: "temporarily unavailable",
+		}
+	}
+	srv := httptest.NewServer(h)
+	s.AddCleanup(func(*gc.C) {
+		srv.Close()
+	})
+	p.URL = srv.URL
+	p.User = s.serverParams.AuthUsername
+	p.Password = s.serverParams.AuthPassword
+	return csclient.New(p)
+}
+
+var testRetryPolicy = &csclient.RetryPolicy{
+	MaxAttempts: 3,
+	MinDelay:    time.Millisecond,
+	MaxDelay:    10 * time.Millisecond,
+}
+
+func (s *suite) addWordpress(c *gc.C) *charm.Reference {
+	id := charm.MustParseReference("~who/utopic/wordpress-0")
+	err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"))
+	c.Assert(err, gc.IsNil)
+	return id
+}
+
+var retryTests = []struct {
+	about          string
+	handler        flakyHandler
+	expectError    string
+	expectRequests int
+}{{
+	about: "service unavailable",
+	handler: flakyHandler{
+		failures: 2,
+		status:   http.StatusServiceUnavailable,
+	},
+	expectRequests: 3,
+}, {
+	about: "bad gateway with non-JSON body",
+	handler: flakyHandler{
+		failures: 1,
+		status:   http.StatusBadGateway,
+		body:     "<html>",
+	},
+	expectRequests: 2,
+}, {
+	about: "internal error with no code",
+	handler: flakyHandler{
+		failures: 1,
+		status:   http.StatusInternalServerError,
+	},
+	expectRequests: 2,
+}, {
+	about: "connection closed",
+	handler: flakyHandler{
+		failures: 2,
+	},
+	expectRequests: 3,
+}, {
+	about: "too many failures",
+	handler: flakyHandler{
+		failures: 3,
+		status:   http.StatusServiceUnavailable,
+	},
+	expectError:    "temporarily unavailable",
+	expectRequests: 3,
+}, {
+	about: "internal error with a code",
+	handler: flakyHandler{
+		failures: 1,
+		status:   http.StatusInternalServerError,
+		body: params.Error{
+			Message: "multiple (1) errors",
+			Code:    params.ErrMultipleErrors,
+		},
+	},
+	expectError:    `multiple \(1\) errors`,
+	expectRequests: 1,
+}, {
+	about: "client error",
+	handler: flakyHandler{
+		failures: 1,
+		status:   http.StatusBadRequest,
+		body: params.Error{
+			Message: "bad request",
+			Code:    params.ErrBadRequest,
+		},
+	},
+	expectError:    "bad request",
+	expectRequests: 1,
+}}
+
+func (s *suite) TestRetry(c *gc.C) {
+	id := s.addWordpress(c)
+	for i, test := range retryTests {
+		c.Logf("test %d: %s", i, test.about)
+		h := test.handler
+		client := s.startFlakyServer(c, &h, testRetryPolicy)
+		var result params.IdNameResponse
+		err := client.Get("/"+id.Path()+"/meta/id-name", &result)
+		c.Assert(len(h.requests), gc.Equals, test.expectRequests)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(result.Name, gc.Equals, "wordpress")
+	}
+}
+
+func (s *suite) TestNoRetryPolicy(c *gc.C) {
+	id := s.addWordpress(c)
+	h := &flakyHandler{
+		failures: 1,
+		status:   http.StatusServiceUnavailable,
+	}
+	client := s.startFlakyServer(c, h, nil)
+	err := client.Get("/"+id.Path()+"/meta/id-name", nil)
+	c.Assert(err, gc.ErrorMatches, "temporarily unavailable")
+	c.Assert(h.requests, gc.HasLen, 1)
+}
+
+func (s *suite) TestRetryNonIdempotent(c *gc.C) {
+	h := &flakyHandler{
+		failures: 1,
+		status:   http.StatusServiceUnavailable,
+	}
+	client := s.startFlakyServer(c, h, testRetryPolicy)
+	err := client.Log(params.IngestionType, params.InfoLevel, "message")
+	c.Assert(err, gc.ErrorMatches, "cannot send log message: temporarily unavailable")
+	c.Assert(h.requests, gc.HasLen, 1)
+
+	policy := *testRetryPolicy
+	policy.RetryNonIdempotent = true
+	h = &flakyHandler{
+		failures: 1,
+		status:   http.StatusServiceUnavailable,
+	}
+	client = s.startFlakyServer(c, h, &policy)
+	err = client.Log(params.IngestionType, params.InfoLevel, "message")
+	c.Assert(err, gc.IsNil)
+	c.Assert(h.requests, gc.HasLen, 2)
+}
+
+func (s *suite) TestRetryUploadArchive(c *gc.C) {
+	// The first request is the content challenge, which is not
+	// retried, and the second one is the archive upload.
+	h := &flakyHandler{
+		status: http.StatusServiceUnavailable,
+	}
+	client := s.startFlakyServer(c, h, testRetryPolicy)
+	h.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(h.requests) == 1 {
+			h.failures = 2
+		}
+		s.srv.Config.Handler.ServeHTTP(w, req)
+	})
+	ch := storetesting.Charms.CharmDir("wordpress")
+	id, err := client.UploadCharm(charm.MustParseReference("~who/utopic/wordpress"), ch)
+	c.Assert(err, gc.IsNil)
+	c.Assert(id.String(), gc.Equals, "cs:~who/utopic/wordpress-0")
+	c.Assert(h.requests, gc.DeepEquals, []string{
+		"POST /v4/~who/utopic/wordpress/archive",
+		"POST /v4/~who/utopic/wordpress/archive",
+		"POST /v4/~who/utopic/wordpress/archive",
+		"POST /v4/~who/utopic/wordpress/archive",
+	})
+	// The whole body was sent again.
+	s.checkUploadCharm(c, id, ch)
+}
+
+func (s *suite) TestNoRetryUploadCommit(c *gc.C) {
+	// The commit of the upload succeeds but its response is lost.
+	var commits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "PUT" || !strings.HasSuffix(req.URL.Path, "/archive") || req.URL.Query().Get("upload") == "" {
+			s.srv.Config.Handler.ServeHTTP(w, req)
+			return
+		}
+		commits++
+		s.srv.Config.Handler.ServeHTTP(httptest.NewRecorder(), req)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+	client := csclient.New(csclient.Params{
+		URL:            srv.URL,
+		User:           s.serverParams.AuthUsername,
+		Password:       s.serverParams.AuthPassword,
+		UploadPartSize: 100,
+		RetryPolicy:    testRetryPolicy,
+	})
+	id := charm.MustParseReference("~who/utopic/wordpress-5")
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	err := client.UploadCharmWithRevision(id, ch)
+	c.Assert(err, gc.ErrorMatches, "cannot put archive: .*")
+
+	// The commit was not retried, and so did not fail with
+	// a misleading "upload not found" error.
+	c.Assert(commits, gc.Equals, 1)
+	s.checkUploadCharm(c, id, ch)
+}
+
+func (s *suite) TestRetryAfter(c *gc.C) {
+	var delays []time.Duration
+	s.PatchValue(csclient.Sleep, func(d time.Duration) {
+		delays = append(delays, d)
+	})
+	id := s.addWordpress(c)
+	h := &flakyHandler{
+		failures: 2,
+		status:   http.StatusServiceUnavailable,
+		header:   http.Header{"Retry-After": {"3"}},
+	}
+	client := s.startFlakyServer(c, h, testRetryPolicy)
+	err := client.Get("/"+id.Path()+"/meta/id-name", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(delays, gc.DeepEquals, []time.Duration{3 * time.Second, 3 * time.Second})
+}
+
+func (s *suite) TestRetryBackoff(c *gc.C) {
+	var delays []time.Duration
+	s.PatchValue(csclient.Sleep, func(d time.Duration) {
+		delays = append(delays, d)
+	})
+	id := s.addWordpress(c)
+	h := &flakyHandler{
+		failures: 5,
+		status:   http.StatusServiceUnavailable,
+	}
+	client := s.startFlakyServer(c, h, &csclient.RetryPolicy{
+		MaxAttempts: 6,
+		MinDelay:    time.Second,
+		MaxDelay:    5 * time.Second,
+	})
+	err := client.Get("/"+id.Path()+"/meta/id-name", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(delays, gc.HasLen, 5)
+	for i, max := range []time.Duration{1, 2, 4, 5, 5} {
+		max *= time.Second
+		c.Logf("delay %d: %v", i, delays[i])
+		c.Assert(delays[i] <= max, gc.Equals, true)
+		c.Assert(delays[i] >= max/2, gc.Equals, true)
+	}
+}
+
+func (s *suite) TestRetryTimeout(c *gc.C) {
+	id := s.addWordpress(c)
+	h := &flakyHandler{
+		failures: 1,
+		status:   http.StatusServiceUnavailable,
+		header:   http.Header{"Retry-After": {"10"}},
+	}
+	policy := *testRetryPolicy
+	policy.Timeout = time.Second
+	client := s.startFlakyServer(c, h, &policy)
+	// The delay required by the charm store
+	// is longer than the request timeout.
+	err := client.Get("/"+id.Path()+"/meta/id-name", nil)
+	c.Assert(err, gc.ErrorMatches, "temporarily unavailable")
+	c.Assert(h.requests, gc.HasLen, 1)
+
+	// Requests that take too long are abandoned.
+	h = &flakyHandler{}
+	client = s.startFlakyServer(c, h, &policy)
+	h.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(2 * time.Second)
+	})
+	start := time.Now()
+	err = client.Get("/"+id.Path()+"/meta/id-name", nil)
+	c.Assert(err, gc.NotNil)
+	c.Assert(time.Since(start) < 2*time.Second, gc.Equals, true)
+	c.Assert(h.requests, gc.HasLen, 1)
+}
+
+func (s *suite) TestRetryTimeoutDoesNotLimitBody(c *gc.C) {
+	h := &flakyHandler{}
+	policy := *testRetryPolicy
+	policy.Timeout = 500 * time.Millisecond
+	client := s.startFlakyServer(c, h, &policy)
+	h.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`"slow`))
+		w.(http.Flusher).Flush()
+		time.Sleep(time.Second)
+		w.Write([]byte(` body"`))
+	})
+	var result string
+	err := client.Get("/meta/any", &result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, gc.Equals, "slow body")
+	c.Assert(h.requests, gc.HasLen, 1)
+}
+
+var cancelTests = []struct {
+	about   string
+	handler func(w http.ResponseWriter, unblock <-chan struct{})
+}{{
+	about: "cancel while waiting for the response",
+	handler: func(w http.ResponseWriter, unblock <-chan struct{}) {
+		<-unblock
+	},
+}, {
+	about: "cancel while reading the response body",
+	handler: func(w http.ResponseWriter, unblock <-chan struct{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`"slow`))
+		w.(http.Flusher).Flush()
+		<-unblock
+	},
+}, {
+	about: "cancel while waiting to retry",
+	handler: func(w http.ResponseWriter, unblock <-chan struct{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(mustMarshalJSON(params.Error{
+			Message: "temporarily unavailable",
+		}))
+	},
+}}
+
+func (s *suite) TestCancel(c *gc.C) {
+	for i, test := range cancelTests {
+		c.Logf("test %d: %s", i, test.about)
+		unblock := make(chan struct{})
+		h := &flakyHandler{}
+		cancel := make(chan struct{})
+		client := s.startFlakyServerWithParams(c, h, csclient.Params{
+			RetryPolicy: testRetryPolicy,
+			Cancel:      cancel,
+		})
+		h.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			test.handler(w, unblock)
+		})
+		time.AfterFunc(100*time.Millisecond, func() {
+			close(cancel)
+		})
+		start := time.Now()
+		var result string
+		err := client.Get("/meta/any", &result)
+		close(unblock)
+		c.Assert(err, gc.NotNil)
+		c.Assert(time.Since(start) < 5*time.Second, gc.Equals, true)
+		c.Assert(h.requests, gc.HasLen, 1)
+
+		// Requests made once the client is canceled fail immediately.
+		err = client.Get("/meta/any", &result)
+		c.Assert(err, gc.ErrorMatches, "request canceled")
+		c.Assert(h.requests, gc.HasLen, 1)
+	}
+}
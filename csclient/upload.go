@@ -0,0 +1,189 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package csclient
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+	"gopkg.in/macaroon-bakery.v0/httpbakery"
+
+	"github.com/juju/charmstore/params"
+)
+
+// DefaultUploadPartSize holds the part size used when uploading
+// archives in parts if Params.UploadPartSize is zero. Archives
+// no larger than the part size are uploaded in a single request.
+const DefaultUploadPartSize = 16 * 1024 * 1024
+
+// maxUploadPartAttempts holds the maximum number of times
+// the upload of a part is attempted before giving up.
+const maxUploadPartAttempts = 3
+
+// uploadPartSize returns the size of the parts
+// used when uploading archives in parts.
+func (c *Client) uploadPartSize() int64 {
+	if c.params.UploadPartSize > 0 {
+		return c.params.UploadPartSize
+	}
+	return DefaultUploadPartSize
+}
+
+// startUpload starts a new upload session for the given id and
+// returns its id. It returns an empty id and no error if the archive
+// should be uploaded in a single request instead, because the charm
+// store does not support uploads in parts or does not allow another
+// upload session to be started. Uploads in parts are not tried again
+// by the client once the charm store has failed to support them.
+func (c *Client) startUpload(id *charm.Reference) (string, error) {
+	if atomic.LoadInt32(&c.noPartUploads) != 0 {
+		return "", nil
+	}
+	var upload params.UploadResponse
+	err := c.post("/"+id.Path()+"/upload", &upload)
+	switch errgo.Cause(err) {
+	case nil:
+		return upload.UploadId, nil
+	case params.ErrNotFound, params.ErrMethodNotAllowed:
+		atomic.StoreInt32(&c.noPartUploads, 1)
+		return "", nil
+	case params.ErrQuotaExceeded:
+		return "", nil
+	}
+	return "", errgo.NoteMask(err, "cannot start upload", errgo.Any)
+}
+
+// uploadInParts uploads the archive read from the given body, which
+// has the given size, to the given upload session, one part at a time.
+// If a part cannot be uploaded, the upload resumes from the first part
+// not acknowledged by the charm store.
+func (c *Client) uploadInParts(uploadId string, body io.ReadSeeker, size int64) error {
+	partSize := c.uploadPartSize()
+	nparts := int((size + partSize - 1) / partSize)
+	attempts := 0
+	for part := 0; part < nparts; {
+		err := c.putUploadPart(uploadId, part, body, int64(part)*partSize, partSize)
+		if err == nil {
+			part++
+			attempts = 0
+			continue
+		}
+		attempts++
+		if attempts >= maxUploadPartAttempts {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot upload part %d", part), errgo.Any)
+		}
+		// Find out where to resume from: the part may have been
+		// stored even though its response was lost.
+		var info params.UploadInfoResponse
+		if err := c.Get("/upload/"+uploadId, &info); err != nil {
+			return errgo.NoteMask(err, "cannot get upload info", errgo.Any)
+		}
+		part = acknowledgedParts(&info)
+	}
+	return nil
+}
+
+// putUploadPart uploads the given part of an upload session. The part
+// holds at most size bytes read from the given body at the given offset.
+func (c *Client) putUploadPart(uploadId string, part int, body io.ReadSeeker, offset, size int64) error {
+	if _, err := body.Seek(offset, 0); err != nil {
+		return errgo.Notef(err, "cannot seek")
+	}
+	h := sha512.New384()
+	n, err := io.Copy(h, io.LimitReader(body, size))
+	if err != nil {
+		return errgo.Notef(err, "cannot calculate hash")
+	}
+	req, err := http.NewRequest("PUT", "", nil)
+	if err != nil {
+		return errgo.Notef(err, "cannot make new request")
+	}
+	req.ContentLength = n
+	getBody := func() (io.ReadCloser, error) {
+		if _, err := body.Seek(offset, 0); err != nil {
+			return nil, errgo.Notef(err, "cannot seek")
+		}
+		return ioutil.NopCloser(io.LimitReader(body, n)), nil
+	}
+	path := fmt.Sprintf("/upload/%s/%d?hash=%x", uploadId, part, h.Sum(nil))
+	resp, err := c.DoWithBody(req, path, getBody)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// acknowledgedParts returns the number of contiguous
+// parts, starting from zero, stored in the given upload.
+func acknowledgedParts(info *params.UploadInfoResponse) int {
+	n := 0
+	for _, part := range info.Parts {
+		if part.Part != n {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// post makes a POST request with no body to the charm store,
+// parsing the result as JSON into the given result value.
+func (c *Client) post(path string, result interface{}) error {
+	req, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		return errgo.Notef(err, "cannot make new request")
+	}
+	resp, err := c.Do(req, path)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	defer resp.Body.Close()
+	if err := parseResponseBody(resp.Body, result); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// archiveBody returns the query string and body getter to use when
+// posting or putting an archive with the given body, hash, size and
+// upload flags to the given id. Archives larger than the upload part size are first
+// uploaded in parts, and the request commits the resulting upload,
+// unless the charm store cannot start an upload session.
+func (c *Client) archiveBody(id *charm.Reference, body io.ReadSeeker, hash string, size int64, flags UploadFlags) (string, int64, httpbakery.BodyGetter, error) {
+	query := "?hash=" + hash + flags.query()
+	if size <= c.uploadPartSize() {
+		return query, size, httpbakery.SeekerBody(body), nil
+	}
+	uploadId, err := c.startUpload(id)
+	if err != nil {
+		return "", 0, nil, errgo.Mask(err, errgo.Any)
+	}
+	if uploadId == "" {
+		return query, size, httpbakery.SeekerBody(body), nil
+	}
+	if err := c.uploadInParts(uploadId, body, size); err != nil {
+		return "", 0, nil, errgo.Mask(err, errgo.Any)
+	}
+	return query + "&upload=" + uploadId, 0, noBody, nil
+}
+
+// archiveRetryMode returns the retry mode of an archive upload request
+// with the given query string, as returned by archiveBody. Requests
+// committing an upload in parts are never retried, because the upload
+// is removed once committed, so a retry following a lost response
+// would fail; otherwise the given mode is returned.
+func archiveRetryMode(query string, mode retryMode) retryMode {
+	if strings.Contains(query, "&upload=") {
+		return retryNever
+	}
+	return mode
+}
@@ -4,18 +4,26 @@
 package blobstore
 
 import (
-	"crypto/sha512"
 	"fmt"
 	"hash"
 	"io"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/juju/blobstore"
 	"github.com/juju/errors"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/mgo.v2"
+
+	"github.com/juju/charmstore/params"
 )
 
+// ErrContentRequired is returned by Store.Put when no
+// content has been provided and the content is not
+// already in the store.
+var ErrContentRequired = errgo.New("content required")
+
 type ReadSeekCloser interface {
 	io.Reader
 	io.Seeker
@@ -50,36 +58,44 @@ type ContentChallengeResponse struct {
 }
 
 // NewHash is used to calculate checksums for the blob store.
+// It is the same as params.NewHash.
 func NewHash() hash.Hash {
-	return sha512.New384()
+	return params.NewHash()
 }
 
 // NewContentChallengeResponse can be used by a client to respond to a content
 // challenge. The returned value should be passed to BlobStorage.Put
-// when the client retries the request.
+// when the client retries the request. It is the same as
+// params.NewContentChallengeResponse.
 func NewContentChallengeResponse(chal *ContentChallenge, r io.ReadSeeker) (*ContentChallengeResponse, error) {
-	_, err := r.Seek(chal.RangeStart, 0)
-	if err != nil {
-		return nil, errgo.Mask(err)
-	}
-	hash := NewHash()
-	nw, err := io.CopyN(hash, r, chal.RangeLength)
+	resp, err := params.NewContentChallengeResponse((*params.ContentChallenge)(chal), r)
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
-	if nw != chal.RangeLength {
-		return nil, errgo.Newf("content is not long enough")
-	}
-	return &ContentChallengeResponse{
-		RequestId: chal.RequestId,
-		Hash:      fmt.Sprintf("%x", hash.Sum(nil)),
-	}, nil
+	return (*ContentChallengeResponse)(resp), nil
 }
 
+// challengeExpiry holds how long the name of the blob a
+// challenge was issued for is remembered.
+var challengeExpiry = 5 * time.Minute
+
 // Store stores data blobs in mongodb, de-duplicating by
 // blob hash.
 type Store struct {
 	mstore blobstore.ManagedStorage
+
+	// mu guards challenges.
+	mu sync.Mutex
+
+	// challenges holds the outstanding challenges,
+	// keyed by request id.
+	challenges map[string]challenge
+}
+
+// challenge holds an outstanding challenge.
+type challenge struct {
+	name    string
+	expires time.Time
 }
 
 // New returns a new blob store that writes to the given database,
@@ -87,16 +103,58 @@ type Store struct {
 func New(db *mgo.Database, prefix string) *Store {
 	rs := blobstore.NewGridFS(db.Name, prefix, db.Session)
 	return &Store{
-		mstore: blobstore.NewManagedStorage(db, rs),
+		mstore:     blobstore.NewManagedStorage(db, rs),
+		challenges: make(map[string]challenge),
+	}
+}
+
+// addChallenge records that the challenge with the given
+// request id was issued for the blob with the given name.
+func (s *Store) addChallenge(requestId, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, chal := range s.challenges {
+		if now.After(chal.expires) {
+			delete(s.challenges, id)
+		}
+	}
+	s.challenges[requestId] = challenge{
+		name:    name,
+		expires: now.Add(challengeExpiry),
 	}
 }
 
-func (s *Store) challengeResponse(resp *ContentChallengeResponse) error {
+// removeChallenge removes the challenge with the given request
+// id and reports whether it was issued for the blob with the
+// given name.
+func (s *Store) removeChallenge(requestId, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chal, ok := s.challenges[requestId]
+	delete(s.challenges, requestId)
+	return ok && chal.name == name
+}
+
+// challengeResponse checks the given response to a challenge issued
+// for the blob with the given name. Challenges are only remembered by
+// the store that issued them, for a limited time, so if the challenge
+// is unknown, because it has expired or was issued by another store,
+// an error with an ErrContentRequired cause is returned: the client
+// must then upload the content instead.
+func (s *Store) challengeResponse(name string, resp *ContentChallengeResponse) error {
 	id, err := strconv.ParseInt(resp.RequestId, 10, 64)
 	if err != nil {
-		return errgo.Newf("invalid request id %q", id)
+		return errgo.Newf("invalid request id %q", resp.RequestId)
+	}
+	if !s.removeChallenge(resp.RequestId, name) {
+		return errgo.WithCausef(nil, ErrContentRequired, "no challenge %q issued for %q", resp.RequestId, name)
 	}
-	return s.mstore.ProofOfAccessResponse(blobstore.NewPutResponse(id, resp.Hash))
+	err = s.mstore.ProofOfAccessResponse(blobstore.NewPutResponse(id, resp.Hash))
+	if err == blobstore.ErrRequestExpired {
+		return errgo.WithCausef(nil, ErrContentRequired, "challenge %q has expired", resp.RequestId)
+	}
+	return err
 }
 
 // Put tries to stream the content from the given reader into blob
@@ -106,14 +164,19 @@ func (s *Store) challengeResponse(resp *ContentChallengeResponse) error {
 // satisfied by a client to prove that they have access to the content.
 // If the proof has already been acquired, it should be passed in as the
 // proof argument.
+//
+// The reader may be nil, in which case the content is never uploaded:
+// if it is not already in the store, or if the proof answers a
+// challenge that the store does not know about, an error with an
+// ErrContentRequired cause is returned.
 func (s *Store) Put(r io.Reader, name string, size int64, hash string, proof *ContentChallengeResponse) (*ContentChallenge, error) {
 	if proof != nil {
-		err := s.challengeResponse(proof)
+		err := s.challengeResponse(name, proof)
 		if err == nil {
 			return nil, nil
 		}
 		if err != blobstore.ErrResourceDeleted {
-			return nil, errgo.Mask(err)
+			return nil, errgo.Mask(err, errgo.Is(ErrContentRequired))
 		}
 		// The blob has been deleted since the challenge
 		// was created, so continue on with uploading
@@ -122,6 +185,9 @@ func (s *Store) Put(r io.Reader, name string, size int64, hash string, proof *Co
 	resp, err := s.mstore.PutForEnvironmentRequest("", name, hash)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			if r == nil {
+				return nil, ErrContentRequired
+			}
 			if err := s.mstore.PutForEnvironmentAndCheckHash("", name, r, size, hash); err != nil {
 				return nil, errgo.Mask(err)
 			}
@@ -129,6 +195,7 @@ func (s *Store) Put(r io.Reader, name string, size int64, hash string, proof *Co
 		}
 		return nil, err
 	}
+	s.addChallenge(fmt.Sprint(resp.RequestId), name)
 	return &ContentChallenge{
 		RequestId:   fmt.Sprint(resp.RequestId),
 		RangeStart:  resp.RangeStart,
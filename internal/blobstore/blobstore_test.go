@@ -13,6 +13,7 @@ import (
 
 	jujutesting "github.com/juju/testing"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
 
 	"github.com/juju/charmstore/internal/blobstore"
 	"github.com/juju/charmstore/internal/storetesting"
@@ -70,6 +71,67 @@ func (s *BlobStoreSuite) TestPutInvalidHash(c *gc.C) {
 	c.Assert(length, gc.Equals, int64(0))
 }
 
+func (s *BlobStoreSuite) TestPutWithoutContent(c *gc.C) {
+	store := blobstore.New(s.Session.DB("db"), "blobstore")
+	content := "some data"
+	chal, err := store.Put(nil, "x", int64(len(content)), hashOf(content), nil)
+	c.Assert(err, gc.Equals, blobstore.ErrContentRequired)
+	c.Assert(chal, gc.IsNil)
+
+	err = store.PutUnchallenged(strings.NewReader(content), "x", int64(len(content)), hashOf(content))
+	c.Assert(err, gc.IsNil)
+
+	// Once the content is stored, it can be put again
+	// by proving access to it.
+	chal, err = store.Put(nil, "y", int64(len(content)), hashOf(content), nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(chal, gc.NotNil)
+	resp, err := blobstore.NewContentChallengeResponse(chal, strings.NewReader(content))
+	c.Assert(err, gc.IsNil)
+	chal, err = store.Put(nil, "y", int64(len(content)), hashOf(content), resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(chal, gc.IsNil)
+
+	rc, _, err := store.Open("y")
+	c.Assert(err, gc.IsNil)
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, content)
+}
+
+func (s *BlobStoreSuite) TestPutProofForDifferentName(c *gc.C) {
+	store := blobstore.New(s.Session.DB("db"), "blobstore")
+	content := "some data"
+	err := store.PutUnchallenged(strings.NewReader(content), "x", int64(len(content)), hashOf(content))
+	c.Assert(err, gc.IsNil)
+	chal, err := store.Put(nil, "y", int64(len(content)), hashOf(content), nil)
+	c.Assert(err, gc.IsNil)
+	resp, err := blobstore.NewContentChallengeResponse(chal, strings.NewReader(content))
+	c.Assert(err, gc.IsNil)
+	_, err = store.Put(nil, "z", int64(len(content)), hashOf(content), resp)
+	c.Assert(err, gc.ErrorMatches, `no challenge ".*" issued for "z"`)
+	c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrContentRequired)
+}
+
+func (s *BlobStoreSuite) TestPutProofForUnknownChallenge(c *gc.C) {
+	content := "some data"
+	store := blobstore.New(s.Session.DB("db"), "blobstore")
+	err := store.PutUnchallenged(strings.NewReader(content), "x", int64(len(content)), hashOf(content))
+	c.Assert(err, gc.IsNil)
+	chal, err := store.Put(nil, "y", int64(len(content)), hashOf(content), nil)
+	c.Assert(err, gc.IsNil)
+	resp, err := blobstore.NewContentChallengeResponse(chal, strings.NewReader(content))
+	c.Assert(err, gc.IsNil)
+
+	// Another store, as used by another server, does not
+	// know about the challenge, so the content is required.
+	otherStore := blobstore.New(s.Session.DB("db"), "blobstore")
+	_, err = otherStore.Put(nil, "y", int64(len(content)), hashOf(content), resp)
+	c.Assert(err, gc.ErrorMatches, `no challenge ".*" issued for "y"`)
+	c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrContentRequired)
+}
+
 func (s *BlobStoreSuite) TestPutUnchallenged(c *gc.C) {
 	store := blobstore.New(s.Session.DB("db"), "blobstore")
 
@@ -0,0 +1,163 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/params"
+)
+
+// ArchiveLimits holds the limits enforced by CheckArchive.
+// A zero value for any field means that the corresponding
+// value from DefaultArchiveLimits is used.
+type ArchiveLimits struct {
+	// MaxUncompressedSize holds the maximum total size
+	// in bytes of the uncompressed archive contents.
+	MaxUncompressedSize int64
+
+	// MaxEntries holds the maximum number of entries
+	// in the archive.
+	MaxEntries int
+
+	// MaxCompressionRatio holds the maximum ratio between
+	// the uncompressed contents size and the archive size.
+	// It is only checked for archives whose uncompressed
+	// contents are larger than minRatioCheckSize.
+	MaxCompressionRatio float64
+}
+
+// DefaultArchiveLimits holds the archive limits used
+// when none are explicitly configured.
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxUncompressedSize: 512 * 1024 * 1024,
+	MaxEntries:          10000,
+	MaxCompressionRatio: 100,
+}
+
+// minRatioCheckSize holds the uncompressed size below which
+// the compression ratio is not checked, so that small but
+// highly compressible archives are not rejected.
+const minRatioCheckSize = 1024 * 1024
+
+// maxSymlinkSize holds the maximum length of a symbolic link target.
+const maxSymlinkSize = 1024
+
+// withDefaults returns a copy of l with all zero
+// fields set to the default values.
+func (l ArchiveLimits) withDefaults() ArchiveLimits {
+	if l.MaxUncompressedSize == 0 {
+		l.MaxUncompressedSize = DefaultArchiveLimits.MaxUncompressedSize
+	}
+	if l.MaxEntries == 0 {
+		l.MaxEntries = DefaultArchiveLimits.MaxEntries
+	}
+	if l.MaxCompressionRatio == 0 {
+		l.MaxCompressionRatio = DefaultArchiveLimits.MaxCompressionRatio
+	}
+	return l
+}
+
+// CheckArchive inspects the zip archive of the given size read from r
+// before its contents are parsed as a charm or bundle. It returns the
+// zip reader for the archive if the archive is within the given limits
+// and holds no dangerous entries.
+//
+// If the archive exceeds the limits, the returned error has a
+// params.ErrArchiveTooLarge cause. If the archive contains entries
+// with names or symbolic links that refer outside the archive,
+// the returned error has a params.ErrUnsafeArchive cause.
+func CheckArchive(r io.ReaderAt, size int64, limits ArchiveLimits) (*zip.Reader, error) {
+	limits = limits.withDefaults()
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(zr.File) > limits.MaxEntries {
+		return nil, errgo.WithCausef(nil, params.ErrArchiveTooLarge, "archive has %d entries, exceeding the maximum of %d", len(zr.File), limits.MaxEntries)
+	}
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+		if total > uint64(limits.MaxUncompressedSize) {
+			return nil, errgo.WithCausef(nil, params.ErrArchiveTooLarge, "archive contents exceed the maximum uncompressed size of %d bytes", limits.MaxUncompressedSize)
+		}
+		if err := checkArchiveEntry(f); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(params.ErrUnsafeArchive))
+		}
+	}
+	if total > minRatioCheckSize && size > 0 && float64(total)/float64(size) > limits.MaxCompressionRatio {
+		return nil, errgo.WithCausef(nil, params.ErrArchiveTooLarge, "archive compression ratio exceeds the maximum of %g", limits.MaxCompressionRatio)
+	}
+	return zr, nil
+}
+
+// checkArchiveEntry checks that the given zip entry
+// does not refer to anything outside the archive.
+func checkArchiveEntry(f *zip.File) error {
+	if !isLocalPath(f.Name) {
+		return errgo.WithCausef(nil, params.ErrUnsafeArchive, "archive entry %q refers outside the archive", f.Name)
+	}
+	if f.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return errgo.Notef(err, "cannot open symlink %q", f.Name)
+	}
+	defer rc.Close()
+	target, err := ioutil.ReadAll(io.LimitReader(rc, maxSymlinkSize+1))
+	if err != nil {
+		return errgo.Notef(err, "cannot read symlink %q", f.Name)
+	}
+	if len(target) > maxSymlinkSize {
+		return errgo.WithCausef(nil, params.ErrUnsafeArchive, "symlink %q has a target that is too long", f.Name)
+	}
+	if !isLocalPath(path.Join(path.Dir(f.Name), string(target))) || path.IsAbs(string(target)) {
+		return errgo.WithCausef(nil, params.ErrUnsafeArchive, "symlink %q refers outside the archive", f.Name)
+	}
+	return nil
+}
+
+// isLocalPath reports whether the given slash-separated
+// path name refers to a location inside the archive root.
+func isLocalPath(name string) bool {
+	if name == "" || path.IsAbs(name) || strings.Contains(name, `\`) {
+		return false
+	}
+	name = path.Clean(name)
+	return name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// CheckCharmHooks checks that all the hooks of the given charm
+// that are present in the archive read by zr are executable
+// regular files. If they are not, the returned error has a
+// params.ErrInvalidArchive cause.
+func CheckCharmHooks(zr *zip.Reader, meta *charm.Meta) error {
+	hooks := meta.Hooks()
+	for _, f := range zr.File {
+		name := path.Clean(f.Name)
+		if path.Dir(name) != "hooks" || !hooks[path.Base(name)] {
+			continue
+		}
+		mode := f.Mode()
+		if mode&os.ModeSymlink != 0 {
+			// The symlink target has already been checked
+			// and is assumed to be executable.
+			continue
+		}
+		if !mode.IsRegular() || mode&0100 == 0 {
+			return errgo.WithCausef(nil, params.ErrInvalidArchive, "hook %q is not executable", path.Base(name))
+		}
+	}
+	return nil
+}
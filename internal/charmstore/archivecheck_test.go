@@ -0,0 +1,180 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"strings"
+
+	jujutesting "github.com/juju/testing"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/charmstore"
+	"github.com/juju/charmstore/params"
+)
+
+type archiveCheckSuite struct {
+	jujutesting.IsolationSuite
+}
+
+var _ = gc.Suite(&archiveCheckSuite{})
+
+// archiveEntry holds an entry to be written to a test archive.
+type archiveEntry struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+func makeArchive(c *gc.C, entries []archiveEntry) *bytes.Reader {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{
+			Name:   e.name,
+			Method: zip.Deflate,
+		}
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header.SetMode(mode)
+		f, err := w.CreateHeader(header)
+		c.Assert(err, gc.IsNil)
+		_, err = f.Write([]byte(e.content))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(w.Close(), gc.IsNil)
+	return bytes.NewReader(buf.Bytes())
+}
+
+var checkArchiveTests = []struct {
+	about       string
+	entries     []archiveEntry
+	limits      charmstore.ArchiveLimits
+	expectError string
+	expectCause error
+}{{
+	about: "valid archive",
+	entries: []archiveEntry{
+		{name: "metadata.yaml", content: "name: foo"},
+		{name: "hooks/install", content: "#!/bin/sh", mode: 0755},
+		{name: "hooks/start", content: "install", mode: os.ModeSymlink | 0777},
+		{name: "lib/up", content: "../hooks", mode: os.ModeSymlink | 0777},
+	},
+}, {
+	about: "too many entries",
+	entries: []archiveEntry{
+		{name: "a"}, {name: "b"}, {name: "c"},
+	},
+	limits:      charmstore.ArchiveLimits{MaxEntries: 2},
+	expectError: "archive has 3 entries, exceeding the maximum of 2",
+	expectCause: params.ErrArchiveTooLarge,
+}, {
+	about: "uncompressed size too big",
+	entries: []archiveEntry{
+		{name: "a", content: strings.Repeat("x", 60)},
+		{name: "b", content: strings.Repeat("x", 60)},
+	},
+	limits:      charmstore.ArchiveLimits{MaxUncompressedSize: 100},
+	expectError: "archive contents exceed the maximum uncompressed size of 100 bytes",
+	expectCause: params.ErrArchiveTooLarge,
+}, {
+	about: "compression ratio too high",
+	entries: []archiveEntry{
+		{name: "a", content: strings.Repeat("x", 4*1024*1024)},
+	},
+	expectError: "archive compression ratio exceeds the maximum of 100",
+	expectCause: params.ErrArchiveTooLarge,
+}, {
+	about: "parent directory name",
+	entries: []archiveEntry{
+		{name: "hooks/../../etc/passwd"},
+	},
+	expectError: `archive entry "hooks/../../etc/passwd" refers outside the archive`,
+	expectCause: params.ErrUnsafeArchive,
+}, {
+	about: "absolute name",
+	entries: []archiveEntry{
+		{name: "/etc/passwd"},
+	},
+	expectError: `archive entry "/etc/passwd" refers outside the archive`,
+	expectCause: params.ErrUnsafeArchive,
+}, {
+	about: "backslash name",
+	entries: []archiveEntry{
+		{name: `..\passwd`},
+	},
+	expectError: `archive entry "..\\\\passwd" refers outside the archive`,
+	expectCause: params.ErrUnsafeArchive,
+}, {
+	about: "symlink to absolute path",
+	entries: []archiveEntry{
+		{name: "hooks/install", content: "/bin/sh", mode: os.ModeSymlink | 0777},
+	},
+	expectError: `symlink "hooks/install" refers outside the archive`,
+	expectCause: params.ErrUnsafeArchive,
+}, {
+	about: "symlink escaping the archive",
+	entries: []archiveEntry{
+		{name: "hooks/install", content: "../../bin/sh", mode: os.ModeSymlink | 0777},
+	},
+	expectError: `symlink "hooks/install" refers outside the archive`,
+	expectCause: params.ErrUnsafeArchive,
+}}
+
+func (s *archiveCheckSuite) TestCheckArchive(c *gc.C) {
+	for i, test := range checkArchiveTests {
+		c.Logf("test %d: %s", i, test.about)
+		r := makeArchive(c, test.entries)
+		zr, err := charmstore.CheckArchive(r, r.Size(), test.limits)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err), gc.Equals, test.expectCause)
+			c.Assert(zr, gc.IsNil)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(zr.File, gc.HasLen, len(test.entries))
+	}
+}
+
+func (s *archiveCheckSuite) TestCheckArchiveInvalidZip(c *gc.C) {
+	r := strings.NewReader("invalid zip content")
+	_, err := charmstore.CheckArchive(r, r.Size(), charmstore.ArchiveLimits{})
+	c.Assert(err, gc.ErrorMatches, "zip: not a valid zip file")
+}
+
+func (s *archiveCheckSuite) TestCheckCharmHooks(c *gc.C) {
+	meta := &charm.Meta{
+		Name: "foo",
+		Provides: map[string]charm.Relation{
+			"website": {Name: "website", Interface: "http", Role: charm.RoleProvider},
+		},
+	}
+	r := makeArchive(c, []archiveEntry{
+		{name: "hooks/install", content: "#!/bin/sh", mode: 0755},
+		{name: "hooks/start", content: "install", mode: os.ModeSymlink | 0777},
+		{name: "hooks/lib.sh", content: "helpers"},
+		{name: "hooks/.gitkeep"},
+	})
+	zr, err := zip.NewReader(r, r.Size())
+	c.Assert(err, gc.IsNil)
+	err = charmstore.CheckCharmHooks(zr, meta)
+	c.Assert(err, gc.IsNil)
+
+	r = makeArchive(c, []archiveEntry{
+		{name: "hooks/install", content: "#!/bin/sh", mode: 0755},
+		{name: "hooks/website-relation-joined", content: "#!/bin/sh"},
+	})
+	zr, err = zip.NewReader(r, r.Size())
+	c.Assert(err, gc.IsNil)
+	err = charmstore.CheckCharmHooks(zr, meta)
+	c.Assert(err, gc.ErrorMatches, `hook "website-relation-joined" is not executable`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrInvalidArchive)
+}
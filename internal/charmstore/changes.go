@@ -0,0 +1,123 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/params"
+)
+
+// Changes returns the Mongo collection where the log
+// of changes made to the charm store is stored.
+func (s StoreDatabase) Changes() *mgo.Collection {
+	return s.C("changes")
+}
+
+// changeNotifier is used to wake up the readers of
+// the change log when a change is added.
+type changeNotifier struct {
+	mu sync.Mutex
+	c  chan struct{}
+}
+
+// wait returns a channel that is closed
+// when the next change is added.
+func (n *changeNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.c == nil {
+		n.c = make(chan struct{})
+	}
+	return n.c
+}
+
+// notify wakes up all the current waiters.
+func (n *changeNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.c != nil {
+		close(n.c)
+		n.c = nil
+	}
+}
+
+// AddChange appends the change described by the given payload
+// to the change log. Only the users and groups in the given ACL
+// are allowed to see the change.
+func (s *Store) AddChange(payload *params.WebhookPayload, readACL []string) error {
+	if payload.Time.IsZero() {
+		payload.Time = time.Now()
+	}
+	payload.Time = payload.Time.UTC()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal change")
+	}
+	change := &mongodoc.Change{
+		Event:   string(payload.Event),
+		ReadACL: readACL,
+		Payload: data,
+		Time:    payload.Time,
+	}
+	// Changes are numbered in sequence. When another change is added
+	// concurrently with the same number, try again with the next one.
+	for retry := 30; retry > 0; retry-- {
+		change.Id, err = s.LastChangeId()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		change.Id++
+		err = s.DB.Changes().Insert(change)
+		if err == nil {
+			s.changes.notify()
+			return nil
+		}
+		if !mgo.IsDup(err) {
+			break
+		}
+	}
+	return errgo.Notef(err, "cannot add change")
+}
+
+// LastChangeId returns the id of the most recent change
+// in the change log, or zero if there are no changes.
+func (s *Store) LastChangeId() (int64, error) {
+	var change mongodoc.Change
+	err := s.DB.Changes().Find(nil).Sort("-_id").Select(bson.D{{"_id", 1}}).One(&change)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot get last change")
+	}
+	return change.Id, nil
+}
+
+// ChangesSince returns at most limit changes made after the change
+// with the given id, oldest first. The caller is responsible for
+// checking that the changes may be seen (see mongodoc.Change.ReadACL).
+func (s *Store) ChangesSince(id int64, limit int) ([]mongodoc.Change, error) {
+	var changes []mongodoc.Change
+	if err := s.DB.Changes().Find(bson.D{
+		{"_id", bson.D{{"$gt", id}}},
+	}).Sort("_id").Limit(limit).All(&changes); err != nil {
+		return nil, errgo.Notef(err, "cannot get changes")
+	}
+	return changes, nil
+}
+
+// WaitChange returns a channel that is closed when the next
+// change is added to the change log through this Store.
+// Changes added by other servers are not notified.
+func (s *Store) WaitChange() <-chan struct{} {
+	return s.changes.wait()
+}
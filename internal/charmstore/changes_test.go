@@ -0,0 +1,131 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"encoding/json"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+type changesSuite struct {
+	storetesting.IsolatedMgoSuite
+	store *Store
+}
+
+var _ = gc.Suite(&changesSuite{})
+
+func (s *changesSuite) SetUpTest(c *gc.C) {
+	s.IsolatedMgoSuite.SetUpTest(c)
+	store, err := NewStore(s.Session.DB("juju_test"), nil, nil)
+	c.Assert(err, gc.IsNil)
+	s.store = store
+}
+
+func (s *changesSuite) TestLastChangeIdNoChanges(c *gc.C) {
+	last, err := s.store.LastChangeId()
+	c.Assert(err, gc.IsNil)
+	c.Assert(last, gc.Equals, int64(0))
+}
+
+func (s *changesSuite) TestAddChange(c *gc.C) {
+	changes := []struct {
+		payload *params.WebhookPayload
+		readACL []string
+	}{{
+		payload: &params.WebhookPayload{
+			Event: params.UploadEvent,
+			Id:    charm.MustParseReference("cs:~who/trusty/wordpress-0"),
+		},
+		readACL: []string{params.Everyone, "who"},
+	}, {
+		payload: &params.WebhookPayload{
+			Event:  params.UploadEvent,
+			Id:     charm.MustParseReference("cs:~who/trusty/wordpress-1"),
+			Hidden: true,
+		},
+		readACL: []string{"who"},
+	}, {
+		payload: &params.WebhookPayload{
+			Event: params.DeleteEvent,
+			Id:    charm.MustParseReference("cs:~who/trusty/wordpress-0"),
+		},
+	}}
+	before := time.Now()
+	for _, change := range changes {
+		err := s.store.AddChange(change.payload, change.readACL)
+		c.Assert(err, gc.IsNil)
+	}
+	after := time.Now()
+
+	last, err := s.store.LastChangeId()
+	c.Assert(err, gc.IsNil)
+	c.Assert(last, gc.Equals, int64(3))
+
+	// All the changes are returned along with their ACLs,
+	// so that the caller can filter them.
+	got, err := s.store.ChangesSince(0, 10)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.HasLen, 3)
+	for i, change := range got {
+		c.Logf("change %d", i)
+		expect := changes[i]
+		c.Assert(change.Id, gc.Equals, int64(i+1))
+		c.Assert(change.Event, gc.Equals, string(expect.payload.Event))
+		c.Assert(change.ReadACL, jc.DeepEquals, expect.readACL)
+		c.Assert(change.Time, jc.TimeBetween(before, after))
+		var payload params.WebhookPayload
+		err := json.Unmarshal(change.Payload, &payload)
+		c.Assert(err, gc.IsNil)
+		c.Assert(payload.Event, gc.Equals, expect.payload.Event)
+		c.Assert(payload.Id.String(), gc.Equals, expect.payload.Id.String())
+		c.Assert(payload.Hidden, gc.Equals, expect.payload.Hidden)
+	}
+
+	got, err = s.store.ChangesSince(1, 10)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.HasLen, 2)
+	c.Assert(got[0].Id, gc.Equals, int64(2))
+
+	got, err = s.store.ChangesSince(0, 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.HasLen, 1)
+	c.Assert(got[0].Id, gc.Equals, int64(1))
+
+	got, err = s.store.ChangesSince(3, 10)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.HasLen, 0)
+}
+
+func (s *changesSuite) TestWaitChange(c *gc.C) {
+	changed := s.store.WaitChange()
+	select {
+	case <-changed:
+		c.Fatalf("change notified before any change was added")
+	default:
+	}
+	err := s.store.AddChange(&params.WebhookPayload{
+		Event: params.DeleteEvent,
+		Id:    charm.MustParseReference("cs:~who/trusty/wordpress-0"),
+	}, []string{params.Everyone})
+	c.Assert(err, gc.IsNil)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("change not notified")
+	}
+
+	// A new wait channel is returned after a change.
+	select {
+	case <-s.store.WaitChange():
+		c.Fatalf("change notified before any change was added")
+	default:
+	}
+}
@@ -4,3 +4,5 @@
 package charmstore
 
 var TimeToStamp = timeToStamp
+
+var LatestChangelogEntry = latestChangelogEntry
@@ -0,0 +1,308 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/juju/xml"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/charmstore/internal/mongodoc"
+)
+
+// MaxIconSize holds the maximum size in bytes of a charm icon.
+// Larger icons are never served.
+const MaxIconSize = 256 * 1024
+
+const svgNamespace = "http://www.w3.org/2000/svg"
+
+// unsafeIconElements holds the (lower case) names of the elements
+// that are removed from icons, along with all their contents.
+// Style sheets may import external resources, and animation
+// elements may set attributes, such as href, to any value.
+var unsafeIconElements = map[string]bool{
+	"script":           true,
+	"foreignobject":    true,
+	"iframe":           true,
+	"embed":            true,
+	"object":           true,
+	"style":            true,
+	"set":              true,
+	"animate":          true,
+	"animatecolor":     true,
+	"animatemotion":    true,
+	"animatetransform": true,
+}
+
+// scriptURLSchemes holds the URL schemes that run scripts.
+var scriptURLSchemes = []string{
+	"javascript:",
+	"vbscript:",
+}
+
+// allowedDataURLPrefixes holds the data URLs that may be
+// referred to by icons. Other data URLs, such as embedded SVG
+// or HTML documents, may hold scripts.
+var allowedDataURLPrefixes = []string{
+	"data:image/png",
+	"data:image/jpeg",
+	"data:image/gif",
+}
+
+// ProcessIcon reads an icon SVG from r and writes a sanitized
+// version of it to w. Scripts, foreign objects, style sheets,
+// animations, event handler attributes, script URLs and
+// references to resources outside the icon are removed, and a
+// viewBox attribute is added to the <svg> element if necessary.
+// It returns a description of each kind of content that was
+// removed.
+func ProcessIcon(w io.Writer, r io.Reader) (removed []string, err error) {
+	dec := xml.NewDecoder(r)
+	dec.DefaultSpace = svgNamespace
+	enc := xml.NewEncoder(w)
+	found := make(map[string]bool)
+	remove := func(f string, a ...interface{}) {
+		msg := fmt.Sprintf(f, a...)
+		if !found[msg] {
+			found[msg] = true
+			removed = append(removed, msg)
+		}
+	}
+	rootSeen, ensured := false, false
+	// skipDepth holds the element depth inside an unsafe
+	// element that is being removed.
+	skipDepth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token: %v", err)
+		}
+		if skipDepth > 0 {
+			switch tok.(type) {
+			case xml.StartElement:
+				skipDepth++
+			case xml.EndElement:
+				skipDepth--
+			}
+			continue
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if !rootSeen {
+				if t.Name.Local != "svg" {
+					return nil, errgo.Newf("root element is %q", t.Name.Local)
+				}
+				rootSeen = true
+			}
+			if unsafeIconElements[strings.ToLower(t.Name.Local)] {
+				remove("%s element", t.Name.Local)
+				skipDepth = 1
+				continue
+			}
+			t.Attr = sanitizeIconAttrs(t.Attr, remove)
+			tok = t
+		case xml.ProcInst:
+			if t.Target != "xml" {
+				remove("processing instruction %q", t.Target)
+				continue
+			}
+		case xml.Directive:
+			// Directives may define entities which
+			// can expand to huge amounts of data.
+			remove("directive")
+			continue
+		}
+		if !ensured {
+			tok, ensured = ensureViewbox(tok)
+		}
+		if err := enc.EncodeToken(tok); err != nil {
+			return nil, fmt.Errorf("cannot encode token %#v: %v", tok, err)
+		}
+	}
+	if !rootSeen {
+		return nil, errgo.New("no svg element found")
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, fmt.Errorf("cannot flush output: %v", err)
+	}
+	return removed, nil
+}
+
+// sanitizeIconAttrs returns attrs without event handlers
+// and references to external resources. The remove function
+// is called to record each removed attribute.
+func sanitizeIconAttrs(attrs []xml.Attr, remove func(string, ...interface{})) []xml.Attr {
+	safe := attrs[:0]
+	for _, attr := range attrs {
+		name := strings.ToLower(attr.Name.Local)
+		switch {
+		case strings.HasPrefix(name, "on"):
+			remove("event handler attribute %q", attr.Name.Local)
+			continue
+		case name == "href" && !isLocalIconRef(attr.Value):
+			remove("external reference %q", attr.Value)
+			continue
+		case !urlRefsAreLocal(attr.Value):
+			remove("external reference in attribute %q", attr.Name.Local)
+			continue
+		case hasScriptURL(attr.Value):
+			remove("script URL in attribute %q", attr.Name.Local)
+			continue
+		}
+		safe = append(safe, attr)
+	}
+	return safe
+}
+
+// isLocalIconRef reports whether the given reference
+// refers to a resource held inside the icon.
+func isLocalIconRef(ref string) bool {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "#") {
+		return true
+	}
+	lref := strings.ToLower(ref)
+	for _, prefix := range allowedDataURLPrefixes {
+		if strings.HasPrefix(lref, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasScriptURL reports whether the given value holds a URL that
+// runs a script, such as the values set by animation elements.
+// Browsers ignore white space and control characters inside
+// URL schemes, so they are ignored too.
+func hasScriptURL(value string) bool {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, value)
+	for _, scheme := range scriptURLSchemes {
+		if strings.Contains(value, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlRefsAreLocal reports whether all the CSS url()
+// references in the given value are local to the icon.
+// CSS escapes could hide references, so values holding
+// backslashes are not considered local.
+func urlRefsAreLocal(value string) bool {
+	if strings.Contains(value, `\`) {
+		return false
+	}
+	value = strings.ToLower(value)
+	for {
+		i := strings.Index(value, "url(")
+		if i == -1 {
+			return true
+		}
+		value = value[i+len("url("):]
+		end := strings.Index(value, ")")
+		if end == -1 {
+			return false
+		}
+		if !isLocalIconRef(strings.Trim(value[:end], ` '"`)) {
+			return false
+		}
+		value = value[end:]
+	}
+}
+
+func ensureViewbox(tok0 xml.Token) (_ xml.Token, found bool) {
+	tok, ok := tok0.(xml.StartElement)
+	if !ok || tok.Name.Space != svgNamespace || tok.Name.Local != "svg" {
+		return tok0, false
+	}
+	var width, height string
+	for _, attr := range tok.Attr {
+		if attr.Name.Space != "" {
+			continue
+		}
+		switch attr.Name.Local {
+		case "width":
+			width = attr.Value
+		case "height":
+			height = attr.Value
+		case "viewBox":
+			return tok, true
+		}
+	}
+	if width == "" || height == "" {
+		// Width and/or height have not been specified,
+		// so leave viewbox unspecified too.
+		return tok, true
+	}
+	tok.Attr = append(tok.Attr, xml.Attr{
+		Name: xml.Name{
+			Local: "viewBox",
+		},
+		Value: fmt.Sprintf("0 0 %s %s", width, height),
+	})
+	return tok, true
+}
+
+// ReadIcon reads and sanitizes the icon from r.
+// It returns an error if the icon is larger than
+// MaxIconSize or is not a valid SVG document.
+func ReadIcon(r io.Reader) (icon []byte, removed []string, err error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxIconSize+1))
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot read icon")
+	}
+	if len(data) > MaxIconSize {
+		return nil, nil, errgo.Newf("icon is larger than %d bytes", MaxIconSize)
+	}
+	var buf bytes.Buffer
+	removed, err = ProcessIcon(&buf, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	return buf.Bytes(), removed, nil
+}
+
+// IconContents returns the entry for the icon held in the
+// given charm archive, suitable for storing in the entity's
+// Contents field. If the archive holds a valid icon, the
+// returned entry holds its sanitized form. If there is no
+// icon, an invalid ZipFile is returned, recording that the
+// archive need not be searched again.
+func IconContents(zr *zip.Reader) (mongodoc.ZipFile, error) {
+	for _, f := range zr.File {
+		if path.Clean(f.Name) != "icon.svg" {
+			continue
+		}
+		zipf, err := NewZipFile(f)
+		if err != nil {
+			return mongodoc.ZipFile{}, errgo.Mask(err)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return mongodoc.ZipFile{}, errgo.Notef(err, "cannot open icon")
+		}
+		defer r.Close()
+		if icon, _, err := ReadIcon(r); err == nil {
+			zipf.Data = icon
+		}
+		return zipf, nil
+	}
+	return mongodoc.ZipFile{}, nil
+}
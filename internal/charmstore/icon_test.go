@@ -0,0 +1,194 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	jujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/charmstore/internal/charmstore"
+)
+
+type iconSuite struct {
+	jujutesting.IsolationSuite
+}
+
+var _ = gc.Suite(&iconSuite{})
+
+var processIconTests = []struct {
+	about         string
+	icon          string
+	expectRemoved []string
+	expectError   string
+	// expectContains and expectNotContains hold strings
+	// that must and must not be in the processed icon.
+	expectContains    []string
+	expectNotContains []string
+}{{
+	about:          "safe icon",
+	icon:           `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><circle r="1" fill="url(#grad)"/></svg>`,
+	expectContains: []string{`viewBox="0 0 10 10"`, `r="1"`, `fill="url(#grad)"`},
+}, {
+	about:             "script",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><script>alert("hello")</script><circle r="1"/></svg>`,
+	expectRemoved:     []string{"script element"},
+	expectContains:    []string{`r="1"`},
+	expectNotContains: []string{"script", "alert"},
+}, {
+	about:             "foreign object with nested elements",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><foreignObject><div><iframe src="http://0.1.2.3/"></iframe></div></foreignObject><rect/></svg>`,
+	expectRemoved:     []string{"foreignObject element"},
+	expectContains:    []string{"rect"},
+	expectNotContains: []string{"foreignObject", "div", "iframe", "0.1.2.3"},
+}, {
+	about:             "event handlers",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg" onload="evil()"><rect onClick="evil()" onload="evil()" width="1"/></svg>`,
+	expectRemoved:     []string{`event handler attribute "onload"`, `event handler attribute "onClick"`},
+	expectContains:    []string{`width="1"`},
+	expectNotContains: []string{"evil"},
+}, {
+	about:             "external references",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="http://0.1.2.3/a.png"/><use href="#local"/><rect fill="url('http://0.1.2.3/x.svg#g')"/><image href="data:image/png;base64,AAAA"/></svg>`,
+	expectRemoved:     []string{`external reference "http://0.1.2.3/a.png"`, `external reference in attribute "fill"`},
+	expectContains:    []string{`#local`, `data:image/png;base64,AAAA`},
+	expectNotContains: []string{"0.1.2.3"},
+}, {
+	about:             "svg data URL",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><image href="data:image/svg+xml;base64,AAAA"/></svg>`,
+	expectRemoved:     []string{`external reference "data:image/svg+xml;base64,AAAA"`},
+	expectNotContains: []string{"data:"},
+}, {
+	about:             "style element importing a style sheet",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><style>@import url(http://0.1.2.3/style.css); rect { fill: red }</style><rect/></svg>`,
+	expectRemoved:     []string{"style element"},
+	expectContains:    []string{"rect"},
+	expectNotContains: []string{"0.1.2.3", "@import", "style"},
+}, {
+	about:             "set element changing href",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><a href="#x"><set attributeName="href" to="javascript:alert(1)"/><rect/></a></svg>`,
+	expectRemoved:     []string{"set element"},
+	expectContains:    []string{`href="#x"`, "rect"},
+	expectNotContains: []string{"javascript", "set"},
+}, {
+	about:             "animate element with to attribute",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><a><animate attributeName="href" to="javascript:alert(1)"/></a></svg>`,
+	expectRemoved:     []string{"animate element"},
+	expectNotContains: []string{"javascript", "animate"},
+}, {
+	about:             "animate element with values attribute",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><a><animate attributeName="href" values="#x;javascript:alert(1)"/></a></svg>`,
+	expectRemoved:     []string{"animate element"},
+	expectNotContains: []string{"javascript", "animate"},
+}, {
+	about:             "animate element with from attribute",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><a><animate attributeName="href" from="javascript:alert(1)" to="#x"/></a></svg>`,
+	expectRemoved:     []string{"animate element"},
+	expectNotContains: []string{"javascript", "animate"},
+}, {
+	about:             "other animation elements",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><rect><animateTransform attributeName="transform"/><animateMotion path="M0 0"/><animateColor attributeName="fill"/></rect></svg>`,
+	expectRemoved:     []string{"animateTransform element", "animateMotion element", "animateColor element"},
+	expectContains:    []string{"rect"},
+	expectNotContains: []string{"animate"},
+}, {
+	about:             "script URLs in attributes",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><rect to="JavaScript:alert(1)" from="java&#9;script:alert(1)" values="#x; vbscript:alert(1)" width="1"/></svg>`,
+	expectRemoved:     []string{`script URL in attribute "to"`, `script URL in attribute "from"`, `script URL in attribute "values"`},
+	expectContains:    []string{`width="1"`},
+	expectNotContains: []string{"script", "alert"},
+}, {
+	about:             "escaped external reference in style attribute",
+	icon:              `<svg xmlns="http://www.w3.org/2000/svg"><rect style="fill: u\72l(http://0.1.2.3/x.svg#g)" width="1"/></svg>`,
+	expectRemoved:     []string{`external reference in attribute "style"`},
+	expectContains:    []string{`width="1"`},
+	expectNotContains: []string{"0.1.2.3"},
+}, {
+	about:             "processing instructions and directives",
+	icon:              `<?xml version="1.0"?><?xml-stylesheet href="http://0.1.2.3/style.css"?><!DOCTYPE svg [<!ENTITY a "aaaa">]><svg xmlns="http://www.w3.org/2000/svg"></svg>`,
+	expectRemoved:     []string{`processing instruction "xml-stylesheet"`, "directive"},
+	expectContains:    []string{`<?xml version="1.0"?>`},
+	expectNotContains: []string{"0.1.2.3", "ENTITY"},
+}, {
+	about:       "not an svg document",
+	icon:        `<html><script>alert("hello")</script></html>`,
+	expectError: `root element is "html"`,
+}, {
+	about:       "no elements",
+	icon:        `<?xml version="1.0"?>`,
+	expectError: `no svg element found`,
+}, {
+	about:       "invalid xml",
+	icon:        `<svg xmlns="http://www.w3.org/2000/svg"><rect></svg>`,
+	expectError: `failed to read token: .*`,
+}}
+
+func (s *iconSuite) TestProcessIcon(c *gc.C) {
+	for i, test := range processIconTests {
+		c.Logf("test %d: %s", i, test.about)
+		var buf bytes.Buffer
+		removed, err := charmstore.ProcessIcon(&buf, strings.NewReader(test.icon))
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(removed, jc.DeepEquals, test.expectRemoved)
+		for _, str := range test.expectContains {
+			c.Assert(buf.String(), jc.Contains, str)
+		}
+		for _, str := range test.expectNotContains {
+			c.Assert(strings.Contains(buf.String(), str), gc.Equals, false, gc.Commentf("found %q in %q", str, buf.String()))
+		}
+
+		// The processed icon should not need any more sanitization.
+		removed, err = charmstore.ProcessIcon(ioutil.Discard, bytes.NewReader(buf.Bytes()))
+		c.Assert(err, gc.IsNil)
+		c.Assert(removed, gc.HasLen, 0)
+	}
+}
+
+func (s *iconSuite) TestReadIconTooLarge(c *gc.C) {
+	icon := `<svg xmlns="http://www.w3.org/2000/svg">` + strings.Repeat(" ", charmstore.MaxIconSize) + `</svg>`
+	_, _, err := charmstore.ReadIcon(strings.NewReader(icon))
+	c.Assert(err, gc.ErrorMatches, `icon is larger than 262144 bytes`)
+}
+
+func (s *iconSuite) TestIconContents(c *gc.C) {
+	// An archive without an icon.
+	r := makeArchive(c, []archiveEntry{{name: "README", content: "foo"}})
+	zr, err := zip.NewReader(r, r.Size())
+	c.Assert(err, gc.IsNil)
+	zipf, err := charmstore.IconContents(zr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(zipf.IsValid(), gc.Equals, false)
+
+	// An archive with a valid icon.
+	r = makeArchive(c, []archiveEntry{
+		{name: "README", content: "foo"},
+		{name: "icon.svg", content: `<svg xmlns="http://www.w3.org/2000/svg"><script/></svg>`},
+	})
+	zr, err = zip.NewReader(r, r.Size())
+	c.Assert(err, gc.IsNil)
+	zipf, err = charmstore.IconContents(zr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(zipf.IsValid(), gc.Equals, true)
+	c.Assert(string(zipf.Data), gc.Equals, `<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+
+	// An archive with an invalid icon.
+	r = makeArchive(c, []archiveEntry{
+		{name: "icon.svg", content: `<html></html>`},
+	})
+	zr, err = zip.NewReader(r, r.Size())
+	c.Assert(err, gc.IsNil)
+	zipf, err = charmstore.IconContents(zr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(zipf.IsValid(), gc.Equals, true)
+	c.Assert(zipf.Data, gc.IsNil)
+}
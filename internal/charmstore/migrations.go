@@ -4,10 +4,13 @@
 package charmstore
 
 import (
+	"archive/zip"
+
 	"gopkg.in/errgo.v1"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
+	"github.com/juju/charmstore/internal/blobstore"
 	"github.com/juju/charmstore/internal/mongodoc"
 	"github.com/juju/charmstore/params"
 )
@@ -31,6 +34,15 @@ var migrations = []migration{{
 }, {
 	name:    "write acl creation",
 	migrate: populateWriteACL,
+}, {
+	name:    "entity manifest population",
+	migrate: populateManifests,
+}, {
+	name:    "sanitized icon removal",
+	migrate: removeSanitizedIcons,
+}, {
+	name:    "readme name population",
+	migrate: populateReadMeNames,
 }}
 
 // migration holds a migration function with its corresponding name.
@@ -222,3 +234,130 @@ func populateWriteACL(db StoreDatabase) error {
 	logger.Infof("%d base entities updated", counter)
 	return nil
 }
+
+// populateManifests adds the archive manifest to entities not having it,
+// along with the locations of any frequently accessed archive files
+// not already recorded in the entity contents. Entities whose archive
+// cannot be read are left unchanged, so that the manifest will be read
+// from the archive when required.
+func populateManifests(db StoreDatabase) error {
+	entities := db.Entities()
+	blobStore := blobstore.New(db.Database, "entitystore")
+	iter := entities.Find(bson.D{{
+		"manifest", bson.D{{"$exists", false}},
+	}}).Select(bson.D{{"_id", 1}, {"blobname", 1}, {"contents", 1}}).Iter()
+	defer iter.Close()
+
+	counter := 0
+	for {
+		var entity mongodoc.Entity
+		if !iter.Next(&entity) {
+			break
+		}
+		update, err := archiveFields(blobStore, &entity)
+		if err != nil {
+			logger.Errorf("cannot populate manifest for entity %s: %v", entity.URL, err)
+			continue
+		}
+		if err := entities.UpdateId(entity.URL, bson.D{{"$set", update}}); err != nil {
+			return errgo.Notef(err, "cannot populate manifest for entity %s", entity.URL)
+		}
+		counter++
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot iterate entities")
+	}
+	logger.Infof("%d entities updated", counter)
+	return nil
+}
+
+// removeSanitizedIcons removes the sanitized icons stored in the
+// entity contents, so that icons sanitized before style sheets and
+// animations were removed are sanitized again when next served.
+// The locations of the icons in the archives are retained.
+func removeSanitizedIcons(db StoreDatabase) error {
+	info, err := db.Entities().UpdateAll(bson.D{{
+		"contents.icon.data", bson.D{{"$exists", true}},
+	}}, bson.D{{
+		"$unset", bson.D{{"contents.icon.data", true}},
+	}})
+	if err != nil {
+		return errgo.Notef(err, "cannot remove sanitized icons")
+	}
+	logger.Infof("%d entities updated", info.Updated)
+	return nil
+}
+
+// populateReadMeNames records the names of the README files whose
+// locations were cached in the entity contents before their names
+// were recorded, so that their format can be determined. The names
+// are taken from the entity manifests.
+func populateReadMeNames(db StoreDatabase) error {
+	entities := db.Entities()
+	iter := entities.Find(bson.D{
+		{"contents.readme.offset", bson.D{{"$gt", 0}}},
+		{"contents.readme.name", bson.D{{"$exists", false}}},
+	}).Select(bson.D{{"_id", 1}, {"contents", 1}, {"manifest", 1}}).Iter()
+	defer iter.Close()
+
+	counter := 0
+	for {
+		var entity mongodoc.Entity
+		if !iter.Next(&entity) {
+			break
+		}
+		name := ""
+		offset := entity.Contents[mongodoc.FileReadMe].Offset
+		for _, f := range entity.Manifest {
+			if f.Offset == offset {
+				name = f.Name
+				break
+			}
+		}
+		if name == "" {
+			logger.Errorf("cannot find README name for entity %s", entity.URL)
+			continue
+		}
+		if err := entities.UpdateId(entity.URL, bson.D{{
+			"$set", bson.D{{"contents.readme.name", name}},
+		}}); err != nil {
+			return errgo.Notef(err, "cannot populate README name for entity %s", entity.URL)
+		}
+		counter++
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot iterate entities")
+	}
+	logger.Infof("%d entities updated", counter)
+	return nil
+}
+
+// archiveFields reads the archive blob of the given entity and returns
+// the fields to set to record its manifest and the locations of the
+// frequently accessed files it holds.
+func archiveFields(blobStore *blobstore.Store, entity *mongodoc.Entity) (bson.D, error) {
+	blob, size, err := blobStore.Open(entity.BlobName)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot open archive blob")
+	}
+	defer blob.Close()
+	zr, err := zip.NewReader(&readerAtSeeker{blob}, size)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read archive data")
+	}
+	manifest, err := NewManifest(zr)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	contents, err := ArchiveContents(zr)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	update := bson.D{{"manifest", manifest}}
+	for _, id := range []mongodoc.FileId{mongodoc.FileReadMe, mongodoc.FileIcon} {
+		if _, ok := entity.Contents[id]; !ok {
+			update = append(update, bson.DocElem{"contents." + string(id), contents[id]})
+		}
+	}
+	return update, nil
+}
@@ -4,6 +4,7 @@
 package charmstore
 
 import (
+	"io/ioutil"
 	"net/http"
 	"sort"
 	"sync"
@@ -186,6 +187,9 @@ func (s *migrationsSuite) TestMigrateMigrationList(c *gc.C) {
 		"base entities creation",
 		"read acl creation",
 		"write acl creation",
+		"entity manifest population",
+		"sanitized icon removal",
+		"readme name population",
 	}
 	for i, name := range existing {
 		m := migrations[i]
@@ -793,6 +797,116 @@ func (s *migrationsSuite) TestPopulateWriteACLSomeUpdates(c *gc.C) {
 	})
 }
 
+func (s *migrationsSuite) TestPopulateManifests(c *gc.C) {
+	s.patchMigrations(c, getMigrations("entity manifest population"))
+	store, err := NewStore(s.db.Database, nil, nil)
+	c.Assert(err, gc.IsNil)
+
+	// Add a bundle and a charm, and remove the information
+	// recorded about their archives to simulate entities
+	// added before it was recorded. The icon location of the
+	// charm is retained, as if it had already been cached.
+	bundleId := charm.MustParseReference("cs:bundle/wordpress-simple-42")
+	err = store.AddBundleWithArchive(bundleId, storetesting.Charms.BundleDir("wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+	charmId := charm.MustParseReference("cs:trusty/wordpress-0")
+	err = store.AddCharmWithArchive(charmId, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	expectBundle, err := store.FindEntity(bundleId)
+	c.Assert(err, gc.IsNil)
+	expectCharm, err := store.FindEntity(charmId)
+	c.Assert(err, gc.IsNil)
+	err = s.db.Entities().UpdateId(bundleId, bson.D{{"$unset", bson.D{{"manifest", true}, {"contents", true}}}})
+	c.Assert(err, gc.IsNil)
+	err = s.db.Entities().UpdateId(charmId, bson.D{{"$unset", bson.D{{"manifest", true}, {"contents.readme", true}}}})
+	c.Assert(err, gc.IsNil)
+	err = s.db.Entities().UpdateId(charmId, bson.D{{"$set", bson.D{{"contents.icon.data", []byte("icon")}}}})
+	c.Assert(err, gc.IsNil)
+	expectCharm.Contents[mongodoc.FileIcon] = mongodoc.ZipFile{Data: []byte("icon")}
+
+	// Add an entity with no archive.
+	id := charm.MustParseReference("~who/trusty/django-42")
+	s.insertEntity(c, id, "django", 12)
+
+	// Start the server.
+	err = s.newServer(c)
+	c.Assert(err, gc.IsNil)
+
+	// Ensure the archive information has been restored.
+	for _, expect := range []*mongodoc.Entity{expectBundle, expectCharm} {
+		entity, err := store.FindEntity(expect.URL, "blobname", "manifest", "contents")
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Contents, jc.DeepEquals, expect.Contents)
+		r, _, err := store.BlobStore.Open(entity.BlobName)
+		c.Assert(err, gc.IsNil)
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		c.Assert(err, gc.IsNil)
+		assertManifest(c, entity.Manifest, data)
+	}
+
+	// The entity with no archive has been left unchanged.
+	s.checkEntity(c, &mongodoc.Entity{
+		URL:     id,
+		BaseURL: baseURL(id),
+		Name:    "django",
+		Size:    12,
+	})
+}
+
+func (s *migrationsSuite) TestRemoveSanitizedIcons(c *gc.C) {
+	s.patchMigrations(c, getMigrations("sanitized icon removal"))
+	store, err := NewStore(s.db.Database, nil, nil)
+	c.Assert(err, gc.IsNil)
+	id := charm.MustParseReference("cs:trusty/wordpress-0")
+	err = store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = s.db.Entities().UpdateId(id, bson.D{{"$set", bson.D{{"contents.icon.data", []byte("icon")}}}})
+	c.Assert(err, gc.IsNil)
+	expect, err := store.FindEntity(id, "contents")
+	c.Assert(err, gc.IsNil)
+	iconFile := expect.Contents[mongodoc.FileIcon]
+	iconFile.Data = nil
+	expect.Contents[mongodoc.FileIcon] = iconFile
+
+	// Start the server.
+	err = s.newServer(c)
+	c.Assert(err, gc.IsNil)
+
+	// The sanitized icon has been removed, but
+	// the other archive contents are unchanged.
+	entity, err := store.FindEntity(id, "contents")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Contents, jc.DeepEquals, expect.Contents)
+}
+
+func (s *migrationsSuite) TestPopulateReadMeNames(c *gc.C) {
+	s.patchMigrations(c, getMigrations("readme name population"))
+	store, err := NewStore(s.db.Database, nil, nil)
+	c.Assert(err, gc.IsNil)
+
+	// Add a bundle and remove the name of its README file
+	// to simulate a README location cached before the name
+	// was recorded.
+	id := charm.MustParseReference("cs:bundle/wordpress-simple-42")
+	err = store.AddBundleWithArchive(id, storetesting.Charms.BundleDir("wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+	expect, err := store.FindEntity(id, "contents")
+	c.Assert(err, gc.IsNil)
+	c.Assert(expect.Contents[mongodoc.FileReadMe].Name, gc.Equals, "README.md")
+	err = s.db.Entities().UpdateId(id, bson.D{{"$unset", bson.D{{"contents.readme.name", true}}}})
+	c.Assert(err, gc.IsNil)
+
+	// Start the server.
+	err = s.newServer(c)
+	c.Assert(err, gc.IsNil)
+
+	// The README name has been restored.
+	entity, err := store.FindEntity(id, "contents")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Contents, jc.DeepEquals, expect.Contents)
+}
+
 func (s *migrationsSuite) checkEntity(c *gc.C, expectEntity *mongodoc.Entity) {
 	var entity mongodoc.Entity
 	err := s.db.Entities().FindId(expectEntity.URL).One(&entity)
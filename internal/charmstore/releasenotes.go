@@ -0,0 +1,177 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/errgo.v1"
+)
+
+// MaxReleaseNotesSize holds the maximum size in bytes
+// of the release notes stored for an entity.
+const MaxReleaseNotesSize = 64 * 1024
+
+// maxChangelogSize holds the maximum number of bytes read
+// from a CHANGELOG file when extracting release notes.
+const maxChangelogSize = 1024 * 1024
+
+var allowedChangelog = map[string]bool{
+	"changelog":          true,
+	"changelog.md":       true,
+	"changelog.markdown": true,
+	"changelog.rst":      true,
+	"changelog.txt":      true,
+}
+
+// IsChangelogFile reports whether the archive entry with the given
+// name is used as the CHANGELOG file of a charm or bundle.
+func IsChangelogFile(name string) bool {
+	return allowedChangelog[strings.ToLower(path.Clean(name))]
+}
+
+// ReleaseNotes returns the release notes found in the CHANGELOG file
+// held in the given archive: the most recent entry in the file. It
+// returns the empty string if there is no CHANGELOG file or if it is
+// not valid UTF-8 text.
+func ReleaseNotes(zr *zip.Reader) (string, error) {
+	for _, f := range zr.File {
+		if !IsChangelogFile(f.Name) {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return "", errgo.Notef(err, "cannot open %q", f.Name)
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(r, maxChangelogSize))
+		if err != nil {
+			return "", errgo.Notef(err, "cannot read %q", f.Name)
+		}
+		if !utf8.Valid(data) {
+			return "", nil
+		}
+		return latestChangelogEntry(string(data)), nil
+	}
+	return "", nil
+}
+
+// latestChangelogEntry returns the first entry in the given CHANGELOG
+// text, truncated to MaxReleaseNotesSize bytes.
+//
+// When the text holds Markdown or reStructuredText headings, entries
+// are introduced by headings at the highest level that is used more
+// than once, so any title of the whole file is skipped. Otherwise
+// an entry starts at each unindented line following a blank line.
+// If no heading level is used more than once, the whole text is
+// returned.
+func latestChangelogEntry(text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	start, end := latestEntryBounds(lines)
+	entry := strings.TrimSpace(strings.Join(lines[start:end], ""))
+	if len(entry) <= MaxReleaseNotesSize {
+		return entry
+	}
+	entry = entry[:MaxReleaseNotesSize]
+	if i := strings.LastIndex(entry, "\n"); i > 0 {
+		return entry[:i]
+	}
+	// Avoid leaving an incomplete character at the end.
+	for !utf8.ValidString(entry) {
+		entry = entry[:len(entry)-1]
+	}
+	return entry
+}
+
+// latestEntryBounds returns the range of lines
+// holding the first entry in a CHANGELOG file.
+func latestEntryBounds(lines []string) (start, end int) {
+	levels := make([]int, len(lines))
+	count := make(map[int]int)
+	for i := range lines {
+		levels[i] = headingLevel(lines, i)
+		if levels[i] > 0 {
+			count[levels[i]]++
+		}
+	}
+	entryLevel := 0
+	for level := 1; level <= 6; level++ {
+		if count[level] > 1 {
+			entryLevel = level
+			break
+		}
+	}
+	if entryLevel > 0 {
+		start = -1
+		for i, level := range levels {
+			switch {
+			case level == 0 || level > entryLevel:
+			case start == -1:
+				if level == entryLevel {
+					start = i
+				}
+			default:
+				return start, i
+			}
+		}
+		return start, len(lines)
+	}
+	if len(count) > 0 {
+		// There is only a single heading at each level,
+		// so the whole file is taken to be a single entry.
+		return 0, len(lines)
+	}
+	start = 0
+	for start < len(lines) && isBlank(lines[start]) {
+		start++
+	}
+	for i := start + 1; i < len(lines); i++ {
+		if isBlank(lines[i-1]) && !isBlank(lines[i]) && !isIndented(lines[i]) {
+			return start, i
+		}
+	}
+	return start, len(lines)
+}
+
+// headingLevel returns the level of the heading started by lines[i],
+// or zero if it does not start a heading. It recognizes Markdown ATX
+// headings ("## Title") and headings underlined with "=" (level 1)
+// or "-" (level 2).
+func headingLevel(lines []string, i int) int {
+	line := strings.TrimRight(lines[i], "\r\n")
+	if strings.HasPrefix(line, "#") {
+		level := len(line) - len(strings.TrimLeft(line, "#"))
+		rest := line[level:]
+		if level <= 6 && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			return level
+		}
+		return 0
+	}
+	if isBlank(line) || isIndented(line) || i+1 >= len(lines) {
+		return 0
+	}
+	underline := strings.TrimSpace(lines[i+1])
+	switch {
+	case len(underline) < 2:
+		return 0
+	case strings.Trim(underline, "=") == "":
+		return 1
+	case strings.Trim(underline, "-") == "":
+		return 2
+	}
+	return 0
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
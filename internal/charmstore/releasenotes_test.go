@@ -0,0 +1,146 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore_test
+
+import (
+	"archive/zip"
+	"strings"
+
+	jujutesting "github.com/juju/testing"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/charmstore/internal/charmstore"
+)
+
+type releaseNotesSuite struct {
+	jujutesting.IsolationSuite
+}
+
+var _ = gc.Suite(&releaseNotesSuite{})
+
+var latestChangelogEntryTests = []struct {
+	about  string
+	text   string
+	expect string
+}{{
+	about:  "empty",
+	text:   "",
+	expect: "",
+}, {
+	about: "markdown with title",
+	text: `# Changelog
+
+## 1.2 - 2015-03-04
+- Add the port option.
+- Fix the install hook.
+
+### Notes
+Upgrade with care.
+
+## 1.1 - 2015-02-01
+- Initial release.
+`,
+	expect: `## 1.2 - 2015-03-04
+- Add the port option.
+- Fix the install hook.
+
+### Notes
+Upgrade with care.`,
+}, {
+	about: "markdown without title",
+	text: `# 1.2
+Second.
+# 1.1
+First.
+`,
+	expect: "# 1.2\nSecond.",
+}, {
+	about: "single heading",
+	text: `# Changelog
+
+Everything changed.
+`,
+	expect: "# Changelog\n\nEverything changed.",
+}, {
+	about: "underlined headings",
+	text: `Changelog
+=========
+
+1.2
+---
+* Add the port option.
+
+1.1
+---
+* Initial release.
+`,
+	expect: "1.2\n---\n* Add the port option.",
+}, {
+	about: "plain text",
+	text: `
+1.2 (2015-03-04)
+  * Add the port option.
+
+  * Fix the install hook.
+
+1.1 (2015-02-01)
+  * Initial release.
+`,
+	expect: "1.2 (2015-03-04)\n  * Add the port option.\n\n  * Fix the install hook.",
+}, {
+	about:  "hash without space is not a heading",
+	text:   "#1 fixed\n#2 fixed\n\nold\n",
+	expect: "#1 fixed\n#2 fixed",
+}}
+
+func (s *releaseNotesSuite) TestLatestChangelogEntry(c *gc.C) {
+	for i, test := range latestChangelogEntryTests {
+		c.Logf("test %d: %s", i, test.about)
+		c.Assert(charmstore.LatestChangelogEntry(test.text), gc.Equals, test.expect)
+	}
+}
+
+func (s *releaseNotesSuite) TestLatestChangelogEntryTruncated(c *gc.C) {
+	line := strings.Repeat("x", 99) + "\n"
+	entry := charmstore.LatestChangelogEntry(strings.Repeat(line, 1000))
+	c.Assert(entry, gc.Equals, strings.Repeat(line, charmstore.MaxReleaseNotesSize/100-1)+line[:99])
+}
+
+var releaseNotesTests = []struct {
+	about   string
+	entries []archiveEntry
+	expect  string
+}{{
+	about: "no changelog",
+	entries: []archiveEntry{
+		{name: "metadata.yaml", content: "name: foo"},
+		{name: "docs/CHANGELOG", content: "not the changelog"},
+	},
+	expect: "",
+}, {
+	about: "changelog",
+	entries: []archiveEntry{
+		{name: "metadata.yaml", content: "name: foo"},
+		{name: "./ChangeLog.md", content: "## 2\nTwo.\n## 1\nOne.\n"},
+	},
+	expect: "## 2\nTwo.",
+}, {
+	about: "binary changelog",
+	entries: []archiveEntry{
+		{name: "CHANGELOG", content: "\xff\xfe"},
+	},
+	expect: "",
+}}
+
+func (s *releaseNotesSuite) TestReleaseNotes(c *gc.C) {
+	for i, test := range releaseNotesTests {
+		c.Logf("test %d: %s", i, test.about)
+		r := makeArchive(c, test.entries)
+		zr, err := zip.NewReader(r, r.Size())
+		c.Assert(err, gc.IsNil)
+		notes, err := charmstore.ReleaseNotes(zr)
+		c.Assert(err, gc.IsNil)
+		c.Assert(notes, gc.Equals, test.expect)
+	}
+}
@@ -70,7 +70,16 @@ func (s *Store) UpdateSearchAsync(r *charm.Reference) {
 // UpdateSearch updates the search record for the entity reference r.
 // The search index only includes the latest revision of each entity so
 // the latest revision of the charm specified by r will be indexed.
+// Hidden revisions are never indexed.
 func (s *Store) UpdateSearch(r *charm.Reference) error {
+	return s.updateSearch(r, false)
+}
+
+// updateSearch implements UpdateSearch. If replace is true, any
+// existing search record is replaced even when it refers to a later
+// revision than the one now being indexed, as happens when the latest
+// revision is hidden.
+func (s *Store) updateSearch(r *charm.Reference, replace bool) error {
 	if s.ES == nil || s.ES.Database == nil {
 		return nil
 	}
@@ -78,11 +87,21 @@ func (s *Store) UpdateSearch(r *charm.Reference) error {
 		return nil
 	}
 	var entity mongodoc.Entity
-	if err := s.DB.Entities().Find(bson.M{"user": r.User, "name": r.Name, "series": r.Series}).Sort("-revision").One(&entity); err != nil {
-		if err == mgo.ErrNotFound {
-			return errgo.WithCausef(nil, params.ErrNotFound, "entity not found %s", r)
+	if err := s.DB.Entities().Find(bson.M{
+		"user":   r.User,
+		"name":   r.Name,
+		"series": r.Series,
+		"hidden": bson.M{"$ne": true},
+	}).Sort("-revision").One(&entity); err != nil {
+		if err != mgo.ErrNotFound {
+			return errgo.Notef(err, "cannot get %s", r)
+		}
+		// There are no visible revisions, so make sure
+		// that nothing is left in the index.
+		if err := s.ES.delete(r); err != nil {
+			return errgo.Notef(err, "cannot remove %s from search index", r)
 		}
-		return errgo.Notef(err, "cannot get %s", r)
+		return nil
 	}
 	baseEntity, err := s.FindBaseEntity(entity.BaseURL, "acls")
 	if err != nil {
@@ -92,7 +111,11 @@ func (s *Store) UpdateSearch(r *charm.Reference) error {
 	if err != nil {
 		return errgo.Mask(err)
 	}
-	if err := s.ES.update(doc); err != nil {
+	update := s.ES.update
+	if replace {
+		update = s.ES.replace
+	}
+	if err := update(doc); err != nil {
 		return errgo.Notef(err, "cannot update search index")
 	}
 	return nil
@@ -104,17 +127,22 @@ func (s *Store) UpdateSearchFields(r *charm.Reference, fields map[string]interfa
 	if s.ES == nil || s.ES.Database == nil {
 		return nil
 	}
-	var needUpdate bool
+	var needUpdate, needReplace bool
 	for k := range fields {
 		// Add any additional fields here that should update the search index.
-		if k == "extrainfo.legacy-download-stats" {
+		switch k {
+		case "extrainfo.legacy-download-stats":
 			needUpdate = true
+		case "hidden":
+			// Hiding a revision may mean that an earlier
+			// revision must now be indexed instead.
+			needReplace = true
 		}
 	}
-	if !needUpdate {
+	if !needUpdate && !needReplace {
 		return nil
 	}
-	if err := s.UpdateSearch(r); err != nil {
+	if err := s.updateSearch(r, needReplace); err != nil {
 		return errgo.Mask(err)
 	}
 	return nil
@@ -153,6 +181,34 @@ func (si *SearchIndex) update(doc *SearchDoc) error {
 	return nil
 }
 
+// replace is like update except that the document is
+// written regardless of the revision currently indexed.
+func (si *SearchIndex) replace(doc *SearchDoc) error {
+	if si == nil || si.Database == nil {
+		return nil
+	}
+	if err := si.delete(doc.URL); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := si.PutDocument(si.Index, typeName, si.getID(doc.URL), doc); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// delete removes the search record for the entity reference r,
+// if there is one.
+func (si *SearchIndex) delete(r *charm.Reference) error {
+	if si == nil || si.Database == nil {
+		return nil
+	}
+	err := si.DeleteDocument(si.Index, typeName, si.getID(r))
+	if err != nil && err != elasticsearch.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
 // getID returns an ID for the elasticsearch document based on the contents of the
 // mongoDB document. This is to allow elasticsearch documents to be replaced with
 // updated versions when charm data is changed.
@@ -8,6 +8,8 @@ package charmstore
 
 import (
 	"net/http"
+	"sync"
+	"time"
 
 	"gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v0/bakery"
@@ -34,6 +36,72 @@ type ServerParams struct {
 	// PublicKeyLocator holds a public key store.
 	// It may be nil.
 	PublicKeyLocator bakery.PublicKeyLocator
+
+	// MaxArchiveSize holds the maximum size in bytes
+	// of an uploaded archive. If it is zero, there is no limit.
+	MaxArchiveSize int64
+
+	// MaxRevisions holds the maximum number of revisions
+	// that can be stored for a charm or bundle, across
+	// all series. If it is zero, there is no limit.
+	MaxRevisions int
+
+	// MaxUserStorage holds the maximum total size in bytes
+	// of the archives owned by a single user or team.
+	// If it is zero, there is no limit.
+	MaxUserStorage int64
+
+	// MaxArchiveUncompressedSize, MaxArchiveEntries and
+	// MaxArchiveCompressionRatio hold the limits checked when
+	// inspecting uploaded archives. If any of them is zero,
+	// a default limit is used.
+	MaxArchiveUncompressedSize int64
+	MaxArchiveEntries          int
+	MaxArchiveCompressionRatio float64
+
+	// Validators holds the names of the validators run
+	// on uploaded charms and bundles. If it is nil,
+	// the default validators are used.
+	Validators []string
+
+	// NamespaceValidators holds the validators run on charms
+	// and bundles owned by specific users or teams, keyed by
+	// user or team name. Each entry overrides Validators.
+	NamespaceValidators map[string][]string
+
+	// WebhookDeliveryInterval holds the interval between
+	// attempts to deliver queued webhook payloads. If it is
+	// zero, payloads are queued but not delivered.
+	WebhookDeliveryInterval time.Duration
+
+	// AllowPrivateWebhooks allows webhooks to be registered
+	// with, and payloads to be delivered to, hosts that resolve
+	// to loopback, private or other reserved addresses.
+	AllowPrivateWebhooks bool
+}
+
+// HTTPCloseHandler represents a HTTP handler that
+// must be closed after use.
+type HTTPCloseHandler interface {
+	http.Handler
+	Close()
+}
+
+// server is the handler returned by NewServer.
+type server struct {
+	http.Handler
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// Close stops the delivery of webhook payloads, waiting
+// for any delivery in progress to finish.
+func (srv *server) Close() {
+	srv.closeOnce.Do(func() {
+		close(srv.stop)
+	})
+	<-srv.done
 }
 
 // NewServer returns a handler that serves the given charm store API
@@ -42,10 +110,19 @@ type ServerParams struct {
 // elasticsearch is not being used then si can be set to nil.
 // The key of the versions map is the version name.
 // The handler configuration is provided to all version handlers.
-func NewServer(db *mgo.Database, si *SearchIndex, config ServerParams, versions map[string]NewAPIHandlerFunc) (http.Handler, error) {
+// The handler must be closed after use.
+func NewServer(db *mgo.Database, si *SearchIndex, config ServerParams, versions map[string]NewAPIHandlerFunc) (HTTPCloseHandler, error) {
 	if len(versions) == 0 {
 		return nil, errgo.Newf("charm store server must serve at least one version of the API")
 	}
+	if err := CheckValidators(config.Validators); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for _, names := range config.NamespaceValidators {
+		if err := CheckValidators(names); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
 	bparams := bakery.NewServiceParams{
 		// TODO The location is attached to any macaroons that we
 		// mint. Currently we don't know the location of the current
@@ -66,7 +143,21 @@ func NewServer(db *mgo.Database, si *SearchIndex, config ServerParams, versions
 	for vers, newAPI := range versions {
 		handle(mux, "/"+vers, newAPI(store, config))
 	}
-	return mux, nil
+	srv := &server{
+		Handler: mux,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if config.WebhookDeliveryInterval > 0 {
+		client := newWebhookClient(config.AllowPrivateWebhooks)
+		go func() {
+			defer close(srv.done)
+			store.runWebhookDeliveries(client, config.WebhookDeliveryInterval, srv.stop)
+		}()
+	} else {
+		close(srv.done)
+	}
+	return srv, nil
 }
 
 func handle(mux *router.ServeMux, path string, handler http.Handler) {
@@ -5,12 +5,17 @@ package charmstore
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"time"
 
 	"github.com/juju/testing/httptesting"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
 
+	"github.com/juju/charmstore/internal/mongodoc"
 	"github.com/juju/charmstore/internal/router"
 	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
 )
 
 var serverParams = ServerParams{
@@ -120,6 +125,53 @@ func (s *ServerSuite) TestNewServerWithElasticSearch(c *gc.C) {
 	})
 }
 
+func (s *ServerSuite) TestCloseStopsWebhookDeliveries(c *gc.C) {
+	db := s.Session.DB("foo")
+	store, err := NewStore(db, nil, nil)
+	c.Assert(err, gc.IsNil)
+	recorder := &webhookRecorder{}
+	hookSrv := httptest.NewServer(recorder)
+	defer hookSrv.Close()
+	err = store.AddWebhook(&mongodoc.Webhook{
+		URL:    hookSrv.URL,
+		Secret: "secret",
+	})
+	c.Assert(err, gc.IsNil)
+	notify := func() {
+		err := store.NotifyWebhooks(&params.WebhookPayload{
+			Event: params.UploadEvent,
+			Id:    charm.MustParseReference("cs:precise/wordpress-0"),
+		})
+		c.Assert(err, gc.IsNil)
+	}
+
+	p := serverParams
+	p.WebhookDeliveryInterval = 10 * time.Millisecond
+	p.AllowPrivateWebhooks = true
+	h, err := NewServer(db, nil, p, map[string]NewAPIHandlerFunc{
+		"version1": func(*Store, ServerParams) http.Handler {
+			return http.NotFoundHandler()
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	notify()
+	timeout := time.After(5 * time.Second)
+	for recorder.count() == 0 {
+		select {
+		case <-timeout:
+			c.Fatalf("timed out waiting for payload delivery")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// Payloads are not delivered once the server is closed.
+	h.Close()
+	h.Close()
+	notify()
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(recorder.count(), gc.Equals, 1)
+}
+
 func assertServesVersion(c *gc.C, h http.Handler, vers string) {
 	path := vers
 	if path != "" {
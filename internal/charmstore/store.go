@@ -39,6 +39,10 @@ type Store struct {
 	statsIdOld    map[string]int
 	statsTokenNew map[int]string
 	statsTokenOld map[int]string
+
+	// changes is notified when a change is added
+	// to the change log.
+	changes changeNotifier
 }
 
 // NewStore returns a Store that uses the given database
@@ -94,12 +98,30 @@ func (s *Store) ensureIndexes() error {
 	}, {
 		s.DB.Entities(),
 		mgo.Index{Key: []string{"uploadtime"}},
+	}, {
+		s.DB.Entities(),
+		mgo.Index{Key: []string{"-uploadtime", "-_id"}},
+	}, {
+		s.DB.Entities(),
+		mgo.Index{Key: []string{"user"}},
 	}, {
 		s.DB.BaseEntities(),
 		mgo.Index{Key: []string{"public"}},
 	}, {
 		s.DB.Logs(),
 		mgo.Index{Key: []string{"urls"}},
+	}, {
+		s.DB.Webhooks(),
+		mgo.Index{Key: []string{"namespace"}},
+	}, {
+		s.DB.WebhookDeliveries(),
+		mgo.Index{Key: []string{"status", "nextattempt"}},
+	}, {
+		s.DB.WebhookDeliveries(),
+		mgo.Index{Key: []string{"webhookid"}},
+	}, {
+		s.DB.Uploads(),
+		mgo.Index{Key: []string{"expires"}},
 	}}
 	for _, idx := range indexes {
 		err := idx.c.EnsureIndex(idx.i)
@@ -132,16 +154,12 @@ func (s *Store) putArchive(archive blobstore.ReadSeekCloser) (blobName, blobHash
 // This method is provided principally so that
 // tests can easily create content in the store.
 func (s *Store) AddCharmWithArchive(url *charm.Reference, ch charm.Charm) error {
-	blobName, blobHash, blobSize, err := s.uploadCharmOrBundle(ch)
+	p, err := s.uploadCharmOrBundle(ch)
 	if err != nil {
 		return errgo.Mask(err)
 	}
-	return s.AddCharm(ch, AddParams{
-		URL:      url,
-		BlobName: blobName,
-		BlobHash: blobHash,
-		BlobSize: blobSize,
-	})
+	p.URL = url
+	return s.AddCharm(ch, p)
 }
 
 // AddBundleWithArchive is like AddBundle but
@@ -149,25 +167,51 @@ func (s *Store) AddCharmWithArchive(url *charm.Reference, ch charm.Charm) error
 // This method is provided principally so that
 // tests can easily create content in the store.
 func (s *Store) AddBundleWithArchive(url *charm.Reference, b charm.Bundle) error {
-	blobName, blobHash, size, err := s.uploadCharmOrBundle(b)
+	p, err := s.uploadCharmOrBundle(b)
 	if err != nil {
 		return errgo.Mask(err)
 	}
-	return s.AddBundle(b, AddParams{
-		URL:      url,
-		BlobName: blobName,
-		BlobHash: blobHash,
-		BlobSize: size,
-	})
+	p.URL = url
+	return s.AddBundle(b, p)
 }
 
-func (s *Store) uploadCharmOrBundle(c interface{}) (blobName, blobHash string, size int64, err error) {
+// uploadCharmOrBundle adds the archive of the given charm or bundle
+// to the blob store and returns the parameters describing it.
+// The URL field of the returned parameters is not set.
+func (s *Store) uploadCharmOrBundle(c interface{}) (AddParams, error) {
 	archive, err := getArchive(c)
 	if err != nil {
-		return "", "", 0, errgo.Mask(err)
+		return AddParams{}, errgo.Mask(err)
 	}
 	defer archive.Close()
-	return s.putArchive(archive)
+	blobName, blobHash, size, err := s.putArchive(archive)
+	if err != nil {
+		return AddParams{}, errgo.Mask(err)
+	}
+	zr, err := zip.NewReader(ReaderAtSeeker(archive), size)
+	if err != nil {
+		return AddParams{}, errgo.Notef(err, "cannot read archive")
+	}
+	manifest, err := NewManifest(zr)
+	if err != nil {
+		return AddParams{}, errgo.Mask(err)
+	}
+	contents, err := ArchiveContents(zr)
+	if err != nil {
+		return AddParams{}, errgo.Mask(err)
+	}
+	releaseNotes, err := ReleaseNotes(zr)
+	if err != nil {
+		return AddParams{}, errgo.Mask(err)
+	}
+	return AddParams{
+		BlobName:     blobName,
+		BlobHash:     blobHash,
+		BlobSize:     size,
+		Contents:     contents,
+		Manifest:     manifest,
+		ReleaseNotes: releaseNotes,
+	}, nil
 }
 
 // AddParams holds parameters held in common between the
@@ -189,6 +233,10 @@ type AddParams struct {
 	// entity's archive blob.
 	Contents map[mongodoc.FileId]mongodoc.ZipFile
 
+	// Manifest holds the manifest of the
+	// entity's archive blob (see NewManifest).
+	Manifest []mongodoc.ManifestFile
+
 	// PromulgatedURL holds the promulgated URL of the entity. If the entity
 	// is not promulgated this should be set to nil.
 	PromulgatedURL *charm.Reference
@@ -196,6 +244,23 @@ type AddParams struct {
 	// PromulgatedRevision holds the revision number from the promulgated URL.
 	// If the entity is not promulgated this should be set to -1.
 	PromulgatedRevision int
+
+	// Private specifies whether the charm or bundle should be
+	// readable only by its owner. It is only taken into account
+	// when the first revision of the charm or bundle is added.
+	Private bool
+
+	// Hidden specifies whether the new revision should be hidden
+	// from users without write access to the charm or bundle.
+	Hidden bool
+
+	// LintWarnings holds the warnings produced by
+	// the upload validators.
+	LintWarnings []mongodoc.LintWarning
+
+	// ReleaseNotes holds the release notes for
+	// the entity (see ReleaseNotes).
+	ReleaseNotes string
 }
 
 // AddCharm adds a charm entities collection with the given
@@ -221,8 +286,12 @@ func (s *Store) AddCharm(c charm.Charm, p AddParams) (err error) {
 		CharmProvidedInterfaces: interfacesForRelations(c.Meta().Provides),
 		CharmRequiredInterfaces: interfacesForRelations(c.Meta().Requires),
 		Contents:                p.Contents,
+		Manifest:                p.Manifest,
 		PromulgatedURL:          p.PromulgatedURL,
 		PromulgatedRevision:     p.PromulgatedRevision,
+		Hidden:                  p.Hidden,
+		LintWarnings:            p.LintWarnings,
+		ReleaseNotes:            p.ReleaseNotes,
 	}
 
 	// Check that we're not going to create a charm that duplicates
@@ -236,7 +305,7 @@ func (s *Store) AddCharm(c charm.Charm, p AddParams) (err error) {
 			return errgo.Newf("charm name duplicates bundle name %v", entity.URL)
 		}
 	}
-	if err := s.insertEntity(entity); err != nil {
+	if err := s.insertEntity(entity, p.Private); err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
 	}
 	return nil
@@ -244,20 +313,24 @@ func (s *Store) AddCharm(c charm.Charm, p AddParams) (err error) {
 
 var everyonePerm = []string{params.Everyone}
 
-func (s *Store) insertEntity(entity *mongodoc.Entity) (err error) {
-	readPerm := everyonePerm
-	var writePerm []string
+// insertEntity adds the given entity to the database, creating
+// its base entity if necessary. If private is true, a newly
+// created base entity will be readable only by the entity's owner.
+func (s *Store) insertEntity(entity *mongodoc.Entity, private bool) (err error) {
+	var readPerm, writePerm []string
 	if entity.User != "" {
-		readPerm = []string{params.Everyone, entity.User}
+		readPerm = []string{entity.User}
 		writePerm = []string{entity.User}
 	}
+	if !private {
+		readPerm = append([]string{params.Everyone}, readPerm...)
+	}
 	// Add the base entity to the database.
 	baseEntity := &mongodoc.BaseEntity{
-		URL:  entity.BaseURL,
-		User: entity.User,
-		Name: entity.Name,
-		// TODO frankban: allow specifying non-public charms on initial upload.
-		Public: true,
+		URL:    entity.BaseURL,
+		User:   entity.User,
+		Name:   entity.Name,
+		Public: !private,
 		ACLs: mongodoc.ACL{
 			Read:  readPerm,
 			Write: writePerm,
@@ -383,6 +456,60 @@ func (s *Store) ExpandURL(url *charm.Reference) ([]*charm.Reference, error) {
 	return urls, nil
 }
 
+// ExpandVisibleURL is like ExpandURL except that hidden
+// revisions are only included if canSeeHidden returns true.
+// The canSeeHidden function is called at most once, and only
+// if any of the matching revisions is hidden.
+func (s *Store) ExpandVisibleURL(url *charm.Reference, canSeeHidden func() bool) ([]*charm.Reference, error) {
+	entities, err := s.FindEntities(url, "_id", "hidden")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	urls := make([]*charm.Reference, 0, len(entities))
+	checked, allowed := false, false
+	for _, entity := range entities {
+		if entity.Hidden {
+			if !checked {
+				allowed, checked = canSeeHidden(), true
+			}
+			if !allowed {
+				continue
+			}
+		}
+		urls = append(urls, entity.URL)
+	}
+	return urls, nil
+}
+
+// RevisionCount returns the number of revisions stored for the charm
+// or bundle with the given id, across all series.
+func (s *Store) RevisionCount(url *charm.Reference) (int, error) {
+	n, err := s.DB.Entities().Find(bson.D{{"baseurl", baseURL(url)}}).Count()
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot count revisions")
+	}
+	return n, nil
+}
+
+// UserStorage returns the total size in bytes of all the
+// archives owned by the given user or team.
+func (s *Store) UserStorage(user string) (int64, error) {
+	var result struct {
+		Size int64
+	}
+	err := s.DB.Entities().Pipe([]bson.D{
+		{{"$match", bson.D{{"user", user}}}},
+		{{"$group", bson.D{{"_id", nil}, {"size", bson.D{{"$sum", "$size"}}}}}},
+	}).One(&result)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot calculate storage used by %q", user)
+	}
+	return result.Size, nil
+}
+
 func matchURL(url, pattern *charm.Reference) bool {
 	if pattern.Series != "" && url.Series != pattern.Series {
 		return false
@@ -446,8 +573,12 @@ func (s *Store) AddBundle(b charm.Bundle, p AddParams) error {
 		BundleReadMe:        b.ReadMe(),
 		BundleCharms:        urls,
 		Contents:            p.Contents,
+		Manifest:            p.Manifest,
 		PromulgatedURL:      p.PromulgatedURL,
 		PromulgatedRevision: p.PromulgatedRevision,
+		Hidden:              p.Hidden,
+		LintWarnings:        p.LintWarnings,
+		ReleaseNotes:        p.ReleaseNotes,
 	}
 
 	// Check that we're not going to create a bundle that duplicates
@@ -461,7 +592,7 @@ func (s *Store) AddBundle(b charm.Bundle, p AddParams) error {
 			return errgo.Newf("bundle name duplicates charm name %s", entity.URL)
 		}
 	}
-	if err := s.insertEntity(entity); err != nil {
+	if err := s.insertEntity(entity, p.Private); err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
 	}
 	return nil
@@ -499,11 +630,36 @@ func (s *Store) BlobNameAndHash(id *charm.Reference) (name, hash string, err err
 	return entity.BlobName, entity.BlobHash, nil
 }
 
+// EntityManifest returns the manifest of the given entity's archive
+// blob. The manifest recorded when the entity was added is used
+// if present, otherwise the manifest is read from the archive.
+//
+// When retrieving the entity, at least the BlobName and
+// Manifest fields must be populated.
+func (s *Store) EntityManifest(entity *mongodoc.Entity) ([]mongodoc.ManifestFile, error) {
+	if len(entity.Manifest) > 0 {
+		return entity.Manifest, nil
+	}
+	if entity.BlobName == "" {
+		return nil, errgo.New("provided entity does not have required fields")
+	}
+	blob, size, err := s.BlobStore.Open(entity.BlobName)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot open archive blob")
+	}
+	defer blob.Close()
+	zipReader, err := zip.NewReader(&readerAtSeeker{blob}, size)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read archive data")
+	}
+	return NewManifest(zipReader)
+}
+
 // OpenCachedBlobFile opens a file from the given entity's archive blob.
-// The file is identified by the provided fileId. If the file has not
-// previously been opened on this entity, the isFile function will be
-// used to determine which file in the zip file to use. The result will
-// be cached for the next time.
+// The file is identified by the provided fileId. The location of the
+// file is usually recorded when the entity is added; for entities where
+// it is not, the isFile function will be used to determine which file
+// in the zip file to use, and the result will be cached for the next time.
 //
 // When retrieving the entity, at least the BlobName and
 // Contents fields must be populated.
@@ -541,18 +697,18 @@ func (s *Store) OpenCachedBlobFile(
 		if err != nil && errgo.Cause(err) != params.ErrNotFound {
 			return nil, errgo.Mask(err)
 		}
-	}
-	// We update the content entry regardless of whether we've
-	// found a file, so that the next time that serveIcon is called
-	// it can know that we've already looked.
-	err = s.DB.Entities().UpdateId(
-		entity.URL,
-		bson.D{{"$set",
-			bson.D{{"contents." + string(fileId), zipf}},
-		}},
-	)
-	if err != nil {
-		return nil, errgo.Notef(err, "cannot update %q", entity.URL)
+		// We update the content entry regardless of whether we've
+		// found a file, so that the next time that serveIcon is called
+		// it can know that we've already looked.
+		err = s.DB.Entities().UpdateId(
+			entity.URL,
+			bson.D{{"$set",
+				bson.D{{"contents." + string(fileId), zipf}},
+			}},
+		)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot update %q", entity.URL)
+		}
 	}
 	if !zipf.IsValid() {
 		// We searched for the file and didn't find it.
@@ -573,6 +729,58 @@ func (s *Store) OpenCachedBlobFile(
 	}{r, blob}, nil
 }
 
+// SetCachedBlobFileData records the processed form of the file
+// with the given id in the archive of the entity with the given
+// URL, so that it need not be processed again. The file must
+// previously have been opened with OpenCachedBlobFile.
+func (s *Store) SetCachedBlobFileData(url *charm.Reference, fileId mongodoc.FileId, data []byte) error {
+	err := s.DB.Entities().UpdateId(
+		url,
+		bson.D{{"$set",
+			bson.D{{"contents." + string(fileId) + ".data", data}},
+		}},
+	)
+	if err != nil {
+		return errgo.Notef(err, "cannot update %q", url)
+	}
+	return nil
+}
+
+// RenderedReadMe returns the HTML rendering of the README file held
+// in the archive blob with the given hash, as cached by
+// SetRenderedReadMe. The rendering must have been produced by the
+// given renderer version. If there is no such rendering, it returns
+// an error with a params.ErrNotFound cause.
+func (s *Store) RenderedReadMe(blobHash string, version int) ([]byte, error) {
+	var doc mongodoc.RenderedReadMe
+	err := s.DB.RenderedReadMes().Find(bson.D{
+		{"_id", blobHash},
+		{"version", version},
+	}).One(&doc)
+	if err == mgo.ErrNotFound {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "")
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get rendered README")
+	}
+	return doc.HTML, nil
+}
+
+// SetRenderedReadMe caches the HTML rendering of the README file held
+// in the archive blob with the given hash, produced by the given
+// renderer version.
+func (s *Store) SetRenderedReadMe(blobHash string, version int, html []byte) error {
+	_, err := s.DB.RenderedReadMes().UpsertId(blobHash, &mongodoc.RenderedReadMe{
+		BlobHash: blobHash,
+		Version:  version,
+		HTML:     html,
+	})
+	if err != nil {
+		return errgo.Notef(err, "cannot cache rendered README")
+	}
+	return nil
+}
+
 func (s *Store) findZipFile(blob io.ReadSeeker, size int64, isFile func(f *zip.File) bool) (mongodoc.ZipFile, error) {
 	zipReader, err := zip.NewReader(&readerAtSeeker{blob}, size)
 	if err != nil {
@@ -740,6 +948,12 @@ func (s StoreDatabase) Macaroons() *mgo.Collection {
 	return s.C("macaroons")
 }
 
+// RenderedReadMes returns the Mongo collection where
+// README files rendered as HTML are cached.
+func (s StoreDatabase) RenderedReadMes() *mgo.Collection {
+	return s.C("readmes")
+}
+
 // allCollections holds for each collection used by the charm store a
 // function returns that collection.
 var allCollections = []func(StoreDatabase) *mgo.Collection{
@@ -750,6 +964,11 @@ var allCollections = []func(StoreDatabase) *mgo.Collection{
 	StoreDatabase.Logs,
 	StoreDatabase.Migrations,
 	StoreDatabase.Macaroons,
+	StoreDatabase.RenderedReadMes,
+	StoreDatabase.Webhooks,
+	StoreDatabase.WebhookDeliveries,
+	StoreDatabase.Changes,
+	StoreDatabase.Uploads,
 }
 
 // Collections returns a slice of all the collections used
@@ -21,6 +21,7 @@ import (
 	gc "gopkg.in/check.v1"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/juju/charm.v4"
+	"gopkg.in/mgo.v2/bson"
 
 	"github.com/juju/charmstore/internal/blobstore"
 	"github.com/juju/charmstore/internal/elasticsearch"
@@ -79,6 +80,10 @@ func (s *StoreSuite) checkAddCharm(c *gc.C, ch charm.Charm, addToES bool) {
 	blobName := doc.BlobName
 	c.Assert(blobName, gc.Matches, "[0-9a-z]+")
 	doc.BlobName = ""
+
+	// The manifest is checked against the archive below.
+	manifest := doc.Manifest
+	doc.Manifest = nil
 	c.Assert(doc, jc.DeepEquals, mongodoc.Entity{
 		URL:                     url,
 		BaseURL:                 charm.MustParseReference("cs:wordpress"),
@@ -93,6 +98,10 @@ func (s *StoreSuite) checkAddCharm(c *gc.C, ch charm.Charm, addToES bool) {
 		CharmConfig:             ch.Config(),
 		CharmProvidedInterfaces: []string{"http", "logging", "monitoring"},
 		CharmRequiredInterfaces: []string{"mysql", "varnish"},
+		Contents: map[mongodoc.FileId]mongodoc.ZipFile{
+			mongodoc.FileReadMe: {},
+			mongodoc.FileIcon:   {},
+		},
 	})
 
 	// The charm archive has been properly added to the blob store.
@@ -101,6 +110,7 @@ func (s *StoreSuite) checkAddCharm(c *gc.C, ch charm.Charm, addToES bool) {
 	c.Assert(obtainedSize, gc.Equals, size)
 	data, err := ioutil.ReadAll(r)
 	c.Assert(err, gc.IsNil)
+	assertManifest(c, manifest, data)
 	charmArchive, err := charm.ReadCharmArchiveBytes(data)
 	c.Assert(err, gc.IsNil)
 	c.Assert(charmArchive.Meta(), jc.DeepEquals, ch.Meta())
@@ -156,6 +166,13 @@ func (s *StoreSuite) checkAddBundle(c *gc.C, bundle charm.Bundle, addToES bool)
 	c.Assert(blobName, gc.Matches, "[0-9a-z]+")
 	doc.BlobName = ""
 
+	// The manifest and the README location are
+	// checked against the archive below.
+	manifest := doc.Manifest
+	doc.Manifest = nil
+	readme := doc.Contents[mongodoc.FileReadMe]
+	delete(doc.Contents, mongodoc.FileReadMe)
+
 	// The entity doc has been correctly added to the mongo collection.
 	size, hash := mustGetSizeAndHash(bundle)
 	c.Assert(doc, jc.DeepEquals, mongodoc.Entity{
@@ -175,6 +192,9 @@ func (s *StoreSuite) checkAddBundle(c *gc.C, bundle charm.Bundle, addToES bool)
 		},
 		BundleMachineCount: newInt(2),
 		BundleUnitCount:    newInt(2),
+		Contents: map[mongodoc.FileId]mongodoc.ZipFile{
+			mongodoc.FileIcon: {},
+		},
 	})
 
 	// The bundle archive has been properly added to the blob store.
@@ -183,6 +203,13 @@ func (s *StoreSuite) checkAddBundle(c *gc.C, bundle charm.Bundle, addToES bool)
 	c.Assert(obtainedSize, gc.Equals, size)
 	data, err := ioutil.ReadAll(r)
 	c.Assert(err, gc.IsNil)
+	assertManifest(c, manifest, data)
+	c.Assert(readme.Name, gc.Equals, "README.md")
+	readmeData, err := ZipFileReader(bytes.NewReader(data), readme)
+	c.Assert(err, gc.IsNil)
+	readmeContent, err := ioutil.ReadAll(readmeData)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(readmeContent), gc.Equals, bundle.ReadMe())
 	bundleArchive, err := charm.ReadBundleArchiveBytes(data)
 	c.Assert(err, gc.IsNil)
 	c.Assert(bundleArchive.Data(), jc.DeepEquals, bundle.Data())
@@ -287,6 +314,67 @@ func (s *StoreSuite) TestExpandURL(c *gc.C) {
 	})
 }
 
+func (s *StoreSuite) TestExpandVisibleURL(c *gc.C) {
+	store, err := NewStore(s.Session.DB("foo"), nil, nil)
+	c.Assert(err, gc.IsNil)
+	wordpress := storetesting.Charms.CharmDir("wordpress")
+	for _, id := range []string{"cs:precise/wordpress-1", "cs:precise/wordpress-2"} {
+		err := store.AddCharmWithArchive(charm.MustParseReference(id), wordpress)
+		c.Assert(err, gc.IsNil)
+	}
+	err = store.DB.Entities().UpdateId(
+		charm.MustParseReference("cs:precise/wordpress-2"),
+		bson.D{{"$set", bson.D{{"hidden", true}}}},
+	)
+	c.Assert(err, gc.IsNil)
+
+	calls := 0
+	canSeeHidden := func(result bool) func() bool {
+		return func() bool {
+			calls++
+			return result
+		}
+	}
+	urls, err := store.ExpandVisibleURL(charm.MustParseReference("wordpress"), canSeeHidden(false))
+	c.Assert(err, gc.IsNil)
+	c.Assert(urls, jc.DeepEquals, mustParseReferences([]string{"cs:precise/wordpress-1"}))
+	c.Assert(calls, gc.Equals, 1)
+
+	urls, err = store.ExpandVisibleURL(charm.MustParseReference("wordpress"), canSeeHidden(true))
+	c.Assert(err, gc.IsNil)
+	sort.Sort(orderedURLs(urls))
+	c.Assert(urls, jc.DeepEquals, mustParseReferences([]string{"cs:precise/wordpress-1", "cs:precise/wordpress-2"}))
+	c.Assert(calls, gc.Equals, 2)
+
+	// The callback is not called when no hidden revision matches.
+	urls, err = store.ExpandVisibleURL(charm.MustParseReference("precise/wordpress-1"), canSeeHidden(false))
+	c.Assert(err, gc.IsNil)
+	c.Assert(urls, jc.DeepEquals, mustParseReferences([]string{"cs:precise/wordpress-1"}))
+	c.Assert(calls, gc.Equals, 2)
+}
+
+func (s *StoreSuite) TestAddCharmPrivate(c *gc.C) {
+	store, err := NewStore(s.Session.DB("foo"), nil, nil)
+	c.Assert(err, gc.IsNil)
+	url := charm.MustParseReference("cs:~bob/precise/wordpress-23")
+	err = store.AddCharm(storetesting.Charms.CharmDir("wordpress"), AddParams{
+		URL:                 url,
+		BlobName:            "blobName",
+		BlobHash:            fakeBlobHash,
+		BlobSize:            fakeBlobSize,
+		PromulgatedRevision: -1,
+		Private:             true,
+	})
+	c.Assert(err, gc.IsNil)
+	baseEntity, err := store.FindBaseEntity(url)
+	c.Assert(err, gc.IsNil)
+	c.Assert(baseEntity.Public, jc.IsFalse)
+	c.Assert(baseEntity.ACLs, jc.DeepEquals, mongodoc.ACL{
+		Read:  []string{"bob"},
+		Write: []string{"bob"},
+	})
+}
+
 func (s *StoreSuite) testURLFinding(c *gc.C, check func(store *Store, expand *charm.Reference, expect []*charm.Reference)) {
 	wordpress := storetesting.Charms.CharmDir("wordpress")
 	for i, test := range urlFindingTests {
@@ -1203,6 +1291,8 @@ func (s *StoreSuite) TestCollections(c *gc.C) {
 	createdOnUse := map[string]bool{
 		"migrations": true,
 		"macaroons":  true,
+		"readmes":    true,
+		"changes":    true,
 	}
 	// Check that all collections mentioned by Collections are actually created.
 	for _, coll := range colls {
@@ -1258,6 +1348,7 @@ func (s *StoreSuite) TestOpenCachedBlobFileWithFoundContent(c *gc.C) {
 	url := charm.MustParseReference("cs:precise/wordpress-23")
 	err = store.AddCharmWithArchive(url, wordpress)
 	c.Assert(err, gc.IsNil)
+	removeContents(c, store, url)
 
 	// Get our expected content.
 	data, err := ioutil.ReadFile(filepath.Join(wordpress.Path, "metadata.yaml"))
@@ -1314,6 +1405,7 @@ func (s *StoreSuite) TestOpenCachedBlobFileWithNotFoundContent(c *gc.C) {
 	url := charm.MustParseReference("cs:precise/wordpress-23")
 	err = store.AddCharmWithArchive(url, wordpress)
 	c.Assert(err, gc.IsNil)
+	removeContents(c, store, url)
 
 	entity, err := store.FindEntity(url, "blobname", "contents")
 	c.Assert(err, gc.IsNil)
@@ -1346,6 +1438,87 @@ func (s *StoreSuite) TestOpenCachedBlobFileWithNotFoundContent(c *gc.C) {
 	c.Assert(r, gc.Equals, nil)
 }
 
+func (s *StoreSuite) TestOpenCachedBlobFileWithContentsFromUpload(c *gc.C) {
+	store, err := NewStore(s.Session.DB("foo"), nil, nil)
+	c.Assert(err, gc.IsNil)
+
+	bundle := storetesting.Charms.BundleDir("wordpress-simple")
+	url := charm.MustParseReference("cs:bundle/wordpress-simple-42")
+	err = store.AddBundleWithArchive(url, bundle)
+	c.Assert(err, gc.IsNil)
+
+	data, err := ioutil.ReadFile(filepath.Join(bundle.Path, "README.md"))
+	c.Assert(err, gc.IsNil)
+
+	// The README location was recorded when the bundle was added,
+	// so the archive is not searched for it.
+	entity, err := store.FindEntity(url, "blobname", "contents")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Contents[mongodoc.FileReadMe].Name, gc.Equals, "README.md")
+	r, err := store.OpenCachedBlobFile(entity, mongodoc.FileReadMe, func(f *zip.File) bool {
+		c.Errorf("isFile called unexpectedly")
+		return false
+	})
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	obtained, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(obtained), gc.Equals, string(data))
+}
+
+// removeContents removes the Contents field from the entity with the
+// given id, as for entities added before the field was populated on
+// upload.
+func removeContents(c *gc.C, store *Store, url *charm.Reference) {
+	err := store.DB.Entities().UpdateId(url, bson.D{{"$unset", bson.D{{"contents", ""}}}})
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *StoreSuite) TestRenderedReadMe(c *gc.C) {
+	store, err := NewStore(s.Session.DB("foo"), nil, nil)
+	c.Assert(err, gc.IsNil)
+
+	_, err = store.RenderedReadMe("hash", 1)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	err = store.SetRenderedReadMe("hash", 1, []byte("<p>hello</p>"))
+	c.Assert(err, gc.IsNil)
+	html, err := store.RenderedReadMe("hash", 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(html), gc.Equals, "<p>hello</p>")
+
+	// Renderings produced by other renderer versions are ignored.
+	_, err = store.RenderedReadMe("hash", 2)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	// A new rendering replaces the old one.
+	err = store.SetRenderedReadMe("hash", 2, []byte("<p>hello again</p>"))
+	c.Assert(err, gc.IsNil)
+	html, err = store.RenderedReadMe("hash", 2)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(html), gc.Equals, "<p>hello again</p>")
+	_, err = store.RenderedReadMe("hash", 1)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+// assertManifest checks that the given manifest,
+// as read from the database, describes the archive
+// with the given contents.
+func assertManifest(c *gc.C, manifest []mongodoc.ManifestFile, data []byte) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	c.Assert(err, gc.IsNil)
+	expect, err := NewManifest(zr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(manifest, gc.HasLen, len(expect))
+	for i, f := range manifest {
+		// Times read from the database are in a
+		// different location, so compare them separately.
+		c.Assert(f.ModTime.Equal(expect[i].ModTime), gc.Equals, true, gc.Commentf("file %q", f.Name))
+		f.ModTime, expect[i].ModTime = time.Time{}, time.Time{}
+		c.Assert(f, jc.DeepEquals, expect[i])
+	}
+}
+
 func hashOfReader(c *gc.C, r io.Reader) string {
 	hash := sha512.New384()
 	_, err := io.Copy(hash, r)
@@ -0,0 +1,216 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/params"
+)
+
+var (
+	// uploadExpiry holds how long an upload is kept
+	// after its last part has been uploaded.
+	uploadExpiry = 24 * time.Hour
+
+	// maxUploadParts holds the maximum number
+	// of parts in an upload.
+	maxUploadParts = 10000
+
+	// maxUserUploads holds the maximum number of
+	// unexpired uploads to the charms and bundles
+	// owned by a user.
+	maxUserUploads = 20
+)
+
+// Uploads returns the Mongo collection where
+// archive uploads made in parts are stored.
+func (s StoreDatabase) Uploads() *mgo.Collection {
+	return s.C("uploads")
+}
+
+// NewUpload starts a new upload of the archive of the
+// charm or bundle with the given id. Expired uploads are
+// discarded at the same time. If the owner of the charm or
+// bundle already has too many uploads in progress, an error
+// with a params.ErrQuotaExceeded cause is returned.
+func (s *Store) NewUpload(id *charm.Reference) (*mongodoc.Upload, error) {
+	if err := s.removeExpiredUploads(); err != nil {
+		logger.Errorf("cannot remove expired uploads: %v", err)
+	}
+	n, err := s.DB.Uploads().Find(bson.D{
+		{"user", id.User},
+		{"expires", bson.D{{"$gte", time.Now()}}},
+	}).Count()
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot count uploads")
+	}
+	if n >= maxUserUploads {
+		return nil, errgo.WithCausef(nil, params.ErrQuotaExceeded, "maximum of %d uploads in progress reached", maxUserUploads)
+	}
+	url := *id
+	url.Revision = -1
+	upload := &mongodoc.Upload{
+		Id:      bson.NewObjectId().Hex(),
+		URL:     &url,
+		User:    id.User,
+		Parts:   make(map[string]mongodoc.UploadPart),
+		Expires: time.Now().Add(uploadExpiry),
+	}
+	if err := s.DB.Uploads().Insert(upload); err != nil {
+		return nil, errgo.Notef(err, "cannot add upload")
+	}
+	return upload, nil
+}
+
+// FindUpload returns the upload with the given id.
+// If it does not exist or has expired, an error with
+// a params.ErrNotFound cause is returned.
+func (s *Store) FindUpload(uploadId string) (*mongodoc.Upload, error) {
+	var upload mongodoc.Upload
+	if err := s.DB.Uploads().FindId(uploadId).One(&upload); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "upload %q not found", uploadId)
+		}
+		return nil, errgo.Notef(err, "cannot get upload %q", uploadId)
+	}
+	if time.Now().After(upload.Expires) {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "upload %q not found", uploadId)
+	}
+	return &upload, nil
+}
+
+// PutUploadPart stores the contents of the given reader as the part
+// with the given number of the given upload. The contents should have
+// the given size and hash. If the part has already been uploaded, it
+// is replaced.
+func (s *Store) PutUploadPart(upload *mongodoc.Upload, part int, r io.Reader, size int64, hash string) error {
+	if part < 0 || part >= maxUploadParts {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "part number %d out of range [0, %d)", part, maxUploadParts)
+	}
+	name := bson.NewObjectId().Hex()
+	if err := s.BlobStore.PutUnchallenged(r, name, size, hash); err != nil {
+		return errgo.Notef(err, "cannot put upload part")
+	}
+	key := strconv.Itoa(part)
+	err := s.DB.Uploads().UpdateId(upload.Id, bson.D{{
+		"$set", bson.D{
+			{"parts." + key, mongodoc.UploadPart{
+				BlobName: name,
+				Hash:     hash,
+				Size:     size,
+			}},
+			{"expires", time.Now().Add(uploadExpiry)},
+		},
+	}})
+	if err != nil {
+		s.BlobStore.Remove(name)
+		if err == mgo.ErrNotFound {
+			return errgo.WithCausef(nil, params.ErrNotFound, "upload %q not found", upload.Id)
+		}
+		return errgo.Notef(err, "cannot update upload")
+	}
+	if old, ok := upload.Parts[key]; ok {
+		if err := s.BlobStore.Remove(old.BlobName); err != nil {
+			logger.Errorf("cannot remove replaced upload part blob %s: %v", old.BlobName, err)
+		}
+	}
+	return nil
+}
+
+// OpenUpload returns a reader for the concatenated parts of the
+// given upload, and its total size. All the parts from zero to the
+// highest numbered one must have been uploaded, otherwise an error
+// with a params.ErrBadRequest cause is returned.
+func (s *Store) OpenUpload(upload *mongodoc.Upload) (io.ReadCloser, int64, error) {
+	if len(upload.Parts) == 0 {
+		return nil, 0, errgo.WithCausef(nil, params.ErrBadRequest, "upload %q has no parts", upload.Id)
+	}
+	r := &multiReadCloser{}
+	var size int64
+	for i := 0; i < len(upload.Parts); i++ {
+		part, ok := upload.Parts[strconv.Itoa(i)]
+		if !ok {
+			r.Close()
+			return nil, 0, errgo.WithCausef(nil, params.ErrBadRequest, "upload %q has no part %d", upload.Id, i)
+		}
+		blob, _, err := s.BlobStore.Open(part.BlobName)
+		if err != nil {
+			r.Close()
+			return nil, 0, errgo.Notef(err, "cannot open upload part %d", i)
+		}
+		r.readers = append(r.readers, blob)
+		size += part.Size
+	}
+	return r, size, nil
+}
+
+// RemoveUpload removes the given upload and its parts.
+func (s *Store) RemoveUpload(upload *mongodoc.Upload) error {
+	if err := s.DB.Uploads().RemoveId(upload.Id); err != nil {
+		if err == mgo.ErrNotFound {
+			return errgo.WithCausef(nil, params.ErrNotFound, "upload %q not found", upload.Id)
+		}
+		return errgo.Notef(err, "cannot remove upload %q", upload.Id)
+	}
+	for _, part := range upload.Parts {
+		if err := s.BlobStore.Remove(part.BlobName); err != nil {
+			logger.Errorf("cannot remove upload part blob %s: %v", part.BlobName, err)
+		}
+	}
+	return nil
+}
+
+// removeExpiredUploads removes all the uploads that have expired.
+func (s *Store) removeExpiredUploads() error {
+	var upload mongodoc.Upload
+	iter := s.DB.Uploads().Find(bson.D{{"expires", bson.D{{"$lt", time.Now()}}}}).Iter()
+	for iter.Next(&upload) {
+		if err := s.RemoveUpload(&upload); err != nil && errgo.Cause(err) != params.ErrNotFound {
+			iter.Close()
+			return errgo.Mask(err)
+		}
+		upload = mongodoc.Upload{}
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot get expired uploads")
+	}
+	return nil
+}
+
+// multiReadCloser reads from each of its readers in turn,
+// and closes them all when it is closed.
+type multiReadCloser struct {
+	readers []io.ReadCloser
+	current int
+}
+
+func (r *multiReadCloser) Read(buf []byte) (int, error) {
+	for r.current < len(r.readers) {
+		n, err := r.readers[r.current].Read(buf)
+		if err == io.EOF {
+			r.current++
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, io.EOF
+}
+
+func (r *multiReadCloser) Close() error {
+	for _, rc := range r.readers {
+		rc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/blobstore"
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+type uploadsSuite struct {
+	storetesting.IsolatedMgoSuite
+	store *Store
+}
+
+var _ = gc.Suite(&uploadsSuite{})
+
+func (s *uploadsSuite) SetUpTest(c *gc.C) {
+	s.IsolatedMgoSuite.SetUpTest(c)
+	store, err := NewStore(s.Session.DB("juju_test"), nil, nil)
+	c.Assert(err, gc.IsNil)
+	s.store = store
+}
+
+func (s *uploadsSuite) putPart(c *gc.C, uploadId string, part int, data string) {
+	upload, err := s.store.FindUpload(uploadId)
+	c.Assert(err, gc.IsNil)
+	hash := blobstore.NewHash()
+	hash.Write([]byte(data))
+	err = s.store.PutUploadPart(upload, part, bytes.NewReader([]byte(data)), int64(len(data)), fmt.Sprintf("%x", hash.Sum(nil)))
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *uploadsSuite) TestNewUpload(c *gc.C) {
+	upload, err := s.store.NewUpload(charm.MustParseReference("cs:~bob/trusty/wordpress-3"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(upload.URL, jc.DeepEquals, charm.MustParseReference("cs:~bob/trusty/wordpress"))
+	c.Assert(upload.Expires.After(time.Now()), gc.Equals, true)
+
+	found, err := s.store.FindUpload(upload.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.Id, gc.Equals, upload.Id)
+	c.Assert(found.URL, jc.DeepEquals, upload.URL)
+}
+
+func (s *uploadsSuite) TestOpenUpload(c *gc.C) {
+	upload, err := s.store.NewUpload(charm.MustParseReference("cs:~bob/trusty/wordpress"))
+	c.Assert(err, gc.IsNil)
+
+	_, _, err = s.store.OpenUpload(upload)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	s.putPart(c, upload.Id, 1, "world")
+	s.putPart(c, upload.Id, 0, "bad")
+	// Replacing a part removes the blob of the old one.
+	old, err := s.store.FindUpload(upload.Id)
+	c.Assert(err, gc.IsNil)
+	s.putPart(c, upload.Id, 0, "hello ")
+	_, _, err = s.store.BlobStore.Open(old.Parts["0"].BlobName)
+	c.Assert(err, gc.NotNil)
+
+	upload, err = s.store.FindUpload(upload.Id)
+	c.Assert(err, gc.IsNil)
+	r, size, err := s.store.OpenUpload(upload)
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "hello world")
+	c.Assert(size, gc.Equals, int64(len(data)))
+}
+
+func (s *uploadsSuite) TestOpenUploadMissingPart(c *gc.C) {
+	upload, err := s.store.NewUpload(charm.MustParseReference("cs:~bob/trusty/wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.putPart(c, upload.Id, 1, "world")
+	upload, err = s.store.FindUpload(upload.Id)
+	c.Assert(err, gc.IsNil)
+	_, _, err = s.store.OpenUpload(upload)
+	c.Assert(err, gc.ErrorMatches, `upload ".*" has no part 0`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+}
+
+func (s *uploadsSuite) TestRemoveUpload(c *gc.C) {
+	upload, err := s.store.NewUpload(charm.MustParseReference("cs:~bob/trusty/wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.putPart(c, upload.Id, 0, "hello")
+	upload, err = s.store.FindUpload(upload.Id)
+	c.Assert(err, gc.IsNil)
+
+	err = s.store.RemoveUpload(upload)
+	c.Assert(err, gc.IsNil)
+	_, err = s.store.FindUpload(upload.Id)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	_, _, err = s.store.BlobStore.Open(upload.Parts["0"].BlobName)
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *uploadsSuite) TestExpiredUploads(c *gc.C) {
+	s.PatchValue(&uploadExpiry, -time.Second)
+	expired, err := s.store.NewUpload(charm.MustParseReference("cs:~bob/trusty/wordpress"))
+	c.Assert(err, gc.IsNil)
+	_, err = s.store.FindUpload(expired.Id)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	// Expired uploads are removed when a new upload is started.
+	s.PatchValue(&uploadExpiry, time.Hour)
+	_, err = s.store.NewUpload(charm.MustParseReference("cs:~bob/trusty/wordpress"))
+	c.Assert(err, gc.IsNil)
+	n, err := s.store.DB.Uploads().FindId(expired.Id).Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *uploadsSuite) TestNewUploadLimit(c *gc.C) {
+	s.PatchValue(&maxUserUploads, 2)
+	id := charm.MustParseReference("cs:~bob/trusty/wordpress")
+	first, err := s.store.NewUpload(id)
+	c.Assert(err, gc.IsNil)
+	_, err = s.store.NewUpload(charm.MustParseReference("cs:~bob/precise/mysql"))
+	c.Assert(err, gc.IsNil)
+	_, err = s.store.NewUpload(id)
+	c.Assert(err, gc.ErrorMatches, "maximum of 2 uploads in progress reached")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrQuotaExceeded)
+
+	// Uploads to the charms of other users are not affected.
+	_, err = s.store.NewUpload(charm.MustParseReference("cs:~alice/trusty/wordpress"))
+	c.Assert(err, gc.IsNil)
+
+	// Another upload can be started once one has been removed.
+	err = s.store.RemoveUpload(first)
+	c.Assert(err, gc.IsNil)
+	_, err = s.store.NewUpload(id)
+	c.Assert(err, gc.IsNil)
+}
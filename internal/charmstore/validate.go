@@ -0,0 +1,302 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/mongodoc"
+)
+
+// UploadedEntity holds a charm or bundle that is
+// being uploaded, as passed to a Validator.
+type UploadedEntity struct {
+	// URL holds the id of the uploaded entity.
+	URL *charm.Reference
+
+	// Charm holds the uploaded charm.
+	// It is nil if a bundle is being uploaded.
+	Charm charm.Charm
+
+	// Bundle holds the uploaded bundle.
+	// It is nil if a charm is being uploaded.
+	Bundle charm.Bundle
+
+	// Archive holds the contents of the uploaded archive.
+	Archive *zip.Reader
+}
+
+// file returns the archive entry with the given name,
+// or nil if there is none.
+func (u *UploadedEntity) file(name string) *zip.File {
+	for _, f := range u.Archive.File {
+		if path.Clean(f.Name) == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Problem holds a problem found by a Validator.
+type Problem struct {
+	// Error holds whether the problem prevents the
+	// entity from being stored. If it is false, the
+	// problem is recorded as a lint warning.
+	Error bool
+
+	// Message holds a description of the problem.
+	Message string
+}
+
+// Errorf returns an error Problem with the given formatted message.
+func Errorf(f string, a ...interface{}) Problem {
+	return Problem{
+		Error:   true,
+		Message: fmt.Sprintf(f, a...),
+	}
+}
+
+// Warningf returns a warning Problem with the given formatted message.
+func Warningf(f string, a ...interface{}) Problem {
+	return Problem{
+		Message: fmt.Sprintf(f, a...),
+	}
+}
+
+// Validator checks an uploaded charm or bundle
+// and returns any problems found.
+type Validator func(u *UploadedEntity) []Problem
+
+var (
+	validatorsMu sync.Mutex
+	validators   = make(map[string]Validator)
+)
+
+// DefaultValidators holds the names of the validators that
+// are used when none are explicitly configured.
+var DefaultValidators = []string{
+	"config-descriptions",
+	"hook-permissions",
+	"icon",
+	"metadata",
+	"readme",
+	"relation-names",
+}
+
+func init() {
+	RegisterValidator("config-descriptions", validateConfigDescriptions)
+	RegisterValidator("hook-permissions", validateHookPermissions)
+	RegisterValidator("icon", validateIcon)
+	RegisterValidator("metadata", validateMetadata)
+	RegisterValidator("readme", validateReadMe)
+	RegisterValidator("relation-names", validateRelationNames)
+}
+
+// RegisterValidator registers a validator with the given name, so
+// that it can be enabled in the server configuration. It can be
+// used to add custom policies. It panics if a validator with the
+// same name is already registered.
+func RegisterValidator(name string, v Validator) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	if _, ok := validators[name]; ok {
+		panic(fmt.Sprintf("validator %q already registered", name))
+	}
+	validators[name] = v
+}
+
+// CheckValidators checks that all the given names
+// refer to registered validators.
+func CheckValidators(names []string) error {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	for _, name := range names {
+		if validators[name] == nil {
+			return errgo.Newf("unknown validator %q", name)
+		}
+	}
+	return nil
+}
+
+// Validate runs the validators with the given names against the
+// given entity. It returns the warnings found, or an error holding
+// the messages of all the error problems if there are any.
+func Validate(u *UploadedEntity, names []string) ([]mongodoc.LintWarning, error) {
+	var warnings []mongodoc.LintWarning
+	var errors []string
+	for _, name := range names {
+		validatorsMu.Lock()
+		v := validators[name]
+		validatorsMu.Unlock()
+		if v == nil {
+			return nil, errgo.Newf("unknown validator %q", name)
+		}
+		for _, p := range v(u) {
+			if p.Error {
+				errors = append(errors, p.Message)
+				continue
+			}
+			warnings = append(warnings, mongodoc.LintWarning{
+				Validator: name,
+				Message:   p.Message,
+			})
+		}
+	}
+	if len(errors) > 0 {
+		return nil, errgo.New(strings.Join(errors, "; "))
+	}
+	return warnings, nil
+}
+
+// validateRelationNames checks that the charm's relations
+// have been changed from the charm template.
+func validateRelationNames(u *UploadedEntity) []Problem {
+	if u.Charm == nil {
+		return nil
+	}
+	m := u.Charm.Meta()
+	var problems []Problem
+	for _, rels := range []map[string]charm.Relation{m.Provides, m.Requires, m.Peers} {
+		for _, name := range sortedRelationNames(rels) {
+			rel := rels[name]
+			if rel.Name == "relation-name" {
+				problems = append(problems, Errorf("relation %s has almost certainly not been changed from the template", rel.Name))
+			}
+			if rel.Interface == "interface-name" {
+				problems = append(problems, Errorf("interface %s in relation %s has almost certainly not been changed from the template", rel.Interface, rel.Name))
+			}
+		}
+	}
+	return problems
+}
+
+func sortedRelationNames(rels map[string]charm.Relation) []string {
+	names := make([]string, 0, len(rels))
+	for name := range rels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// maxSummaryLength holds the maximum recommended
+// length of a charm summary.
+const maxSummaryLength = 80
+
+// validateMetadata checks the charm metadata for
+// common mistakes.
+func validateMetadata(u *UploadedEntity) []Problem {
+	if u.Charm == nil {
+		return nil
+	}
+	m := u.Charm.Meta()
+	var problems []Problem
+	if len(m.Summary) > maxSummaryLength {
+		problems = append(problems, Warningf("summary is longer than %d characters", maxSummaryLength))
+	}
+	if strings.TrimSpace(m.Description) == strings.TrimSpace(m.Summary) {
+		problems = append(problems, Warningf("description is the same as the summary"))
+	}
+	if len(m.Tags) == 0 && len(m.Categories) == 0 {
+		problems = append(problems, Warningf("no tags specified"))
+	}
+	return problems
+}
+
+// validateReadMe checks that the archive contains a README file.
+func validateReadMe(u *UploadedEntity) []Problem {
+	for _, f := range u.Archive.File {
+		if isReadMe(path.Clean(f.Name)) {
+			return nil
+		}
+	}
+	return []Problem{Warningf("no README file found")}
+}
+
+// isReadMe reports whether the given archive
+// entry name is a README file.
+func isReadMe(name string) bool {
+	if strings.Contains(name, "/") {
+		return false
+	}
+	name = strings.ToLower(name)
+	return name == "readme" || strings.HasPrefix(name, "readme.")
+}
+
+// validateIcon checks that a charm has a valid SVG icon
+// that does not need to be changed by sanitization.
+func validateIcon(u *UploadedEntity) []Problem {
+	if u.Charm == nil {
+		return nil
+	}
+	f := u.file("icon.svg")
+	if f == nil {
+		return []Problem{Warningf("no icon.svg file found")}
+	}
+	if f.UncompressedSize64 > MaxIconSize {
+		return []Problem{Warningf("icon.svg is larger than %d bytes", MaxIconSize)}
+	}
+	r, err := f.Open()
+	if err != nil {
+		return []Problem{Warningf("cannot open icon.svg: %v", err)}
+	}
+	defer r.Close()
+	_, removed, err := ReadIcon(r)
+	if err != nil {
+		return []Problem{Warningf("icon.svg is not a valid SVG document: %v", err)}
+	}
+	problems := make([]Problem, len(removed))
+	for i, what := range removed {
+		problems[i] = Warningf("icon.svg contains unsafe content: %s", what)
+	}
+	return problems
+}
+
+// validateHookPermissions checks that no
+// hook can be modified by other users.
+func validateHookPermissions(u *UploadedEntity) []Problem {
+	if u.Charm == nil {
+		return nil
+	}
+	var problems []Problem
+	for _, f := range u.Archive.File {
+		name := path.Clean(f.Name)
+		if path.Dir(name) != "hooks" {
+			continue
+		}
+		if mode := f.Mode(); mode.IsRegular() && mode&0002 != 0 {
+			problems = append(problems, Warningf("hook file %q is world-writable", path.Base(name)))
+		}
+	}
+	return problems
+}
+
+// validateConfigDescriptions checks that all
+// the charm's config options are described.
+func validateConfigDescriptions(u *UploadedEntity) []Problem {
+	if u.Charm == nil || u.Charm.Config() == nil {
+		return nil
+	}
+	options := u.Charm.Config().Options
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var problems []Problem
+	for _, name := range names {
+		if strings.TrimSpace(options[name].Description) == "" {
+			problems = append(problems, Warningf("config option %q has no description", name))
+		}
+	}
+	return problems
+}
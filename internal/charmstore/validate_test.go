@@ -0,0 +1,177 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore_test
+
+import (
+	"archive/zip"
+
+	jujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/charmstore"
+	"github.com/juju/charmstore/internal/mongodoc"
+)
+
+type validateSuite struct {
+	jujutesting.IsolationSuite
+}
+
+var _ = gc.Suite(&validateSuite{})
+
+// testCharm implements charm.Charm for validator tests.
+type testCharm struct {
+	meta   *charm.Meta
+	config *charm.Config
+}
+
+func (c *testCharm) Meta() *charm.Meta       { return c.meta }
+func (c *testCharm) Config() *charm.Config   { return c.config }
+func (c *testCharm) Actions() *charm.Actions { return nil }
+func (c *testCharm) Revision() int           { return 0 }
+
+var validateTests = []struct {
+	about          string
+	meta           charm.Meta
+	config         map[string]charm.Option
+	entries        []archiveEntry
+	validators     []string
+	expectWarnings []mongodoc.LintWarning
+	expectError    string
+}{{
+	about: "no problems",
+	meta: charm.Meta{
+		Name:        "foo",
+		Summary:     "A foo.",
+		Description: "A foo that does bar.",
+		Tags:        []string{"misc"},
+	},
+	config: map[string]charm.Option{
+		"port": {Type: "int", Description: "The port to listen on."},
+	},
+	entries: []archiveEntry{
+		{name: "README.md", content: "foo"},
+		{name: "icon.svg", content: `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"/>`},
+		{name: "hooks/install", content: "#!/bin/sh", mode: 0755},
+	},
+	validators: charmstore.DefaultValidators,
+}, {
+	about: "all warnings",
+	meta: charm.Meta{
+		Name:        "foo",
+		Summary:     "foo",
+		Description: "foo",
+	},
+	config: map[string]charm.Option{
+		"port": {Type: "int"},
+	},
+	entries: []archiveEntry{
+		{name: "icon.svg", content: `<html></html>`},
+		{name: "hooks/install", content: "#!/bin/sh", mode: 0777},
+	},
+	validators: charmstore.DefaultValidators,
+	expectWarnings: []mongodoc.LintWarning{{
+		Validator: "config-descriptions",
+		Message:   `config option "port" has no description`,
+	}, {
+		Validator: "hook-permissions",
+		Message:   `hook file "install" is world-writable`,
+	}, {
+		Validator: "icon",
+		Message:   `icon.svg is not a valid SVG document: root element is "html"`,
+	}, {
+		Validator: "metadata",
+		Message:   "description is the same as the summary",
+	}, {
+		Validator: "metadata",
+		Message:   "no tags specified",
+	}, {
+		Validator: "readme",
+		Message:   "no README file found",
+	}},
+}, {
+	about: "only selected validators are run",
+	meta: charm.Meta{
+		Name:        "foo",
+		Summary:     "foo",
+		Description: "foo",
+		Tags:        []string{"misc"},
+	},
+	validators: []string{"readme"},
+	expectWarnings: []mongodoc.LintWarning{{
+		Validator: "readme",
+		Message:   "no README file found",
+	}},
+}, {
+	about: "errors",
+	meta: charm.Meta{
+		Name: "foo",
+		Provides: map[string]charm.Relation{
+			"relation-name": {Name: "relation-name", Interface: "interface-name"},
+		},
+	},
+	validators:  []string{"relation-names", "readme"},
+	expectError: "relation relation-name has almost certainly not been changed from the template; interface interface-name in relation relation-name has almost certainly not been changed from the template",
+}, {
+	about:       "unknown validator",
+	validators:  []string{"no-such-validator"},
+	expectError: `unknown validator "no-such-validator"`,
+}}
+
+func (s *validateSuite) TestValidate(c *gc.C) {
+	for i, test := range validateTests {
+		c.Logf("test %d: %s", i, test.about)
+		meta := test.meta
+		r := makeArchive(c, test.entries)
+		zr, err := zip.NewReader(r, r.Size())
+		c.Assert(err, gc.IsNil)
+		warnings, err := charmstore.Validate(&charmstore.UploadedEntity{
+			URL: charm.MustParseReference("cs:trusty/foo-0"),
+			Charm: &testCharm{
+				meta:   &meta,
+				config: &charm.Config{Options: test.config},
+			},
+			Archive: zr,
+		}, test.validators)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(warnings, jc.DeepEquals, test.expectWarnings)
+	}
+}
+
+func (s *validateSuite) TestRegisterValidator(c *gc.C) {
+	charmstore.RegisterValidator("test-no-foo", func(u *charmstore.UploadedEntity) []charmstore.Problem {
+		if u.URL.Name == "foo" {
+			return []charmstore.Problem{charmstore.Errorf("charms may not be called foo")}
+		}
+		return []charmstore.Problem{charmstore.Warningf("%s is a fine name", u.URL.Name)}
+	})
+	c.Assert(func() {
+		charmstore.RegisterValidator("test-no-foo", nil)
+	}, gc.PanicMatches, `validator "test-no-foo" already registered`)
+	c.Assert(charmstore.CheckValidators([]string{"readme", "test-no-foo"}), gc.IsNil)
+	c.Assert(charmstore.CheckValidators([]string{"readme", "bad"}), gc.ErrorMatches, `unknown validator "bad"`)
+
+	r := makeArchive(c, []archiveEntry{{name: "README", content: "bar"}})
+	zr, err := zip.NewReader(r, r.Size())
+	c.Assert(err, gc.IsNil)
+	u := &charmstore.UploadedEntity{
+		URL:     charm.MustParseReference("cs:bundle/bar-0"),
+		Archive: zr,
+	}
+	warnings, err := charmstore.Validate(u, []string{"readme", "test-no-foo"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(warnings, jc.DeepEquals, []mongodoc.LintWarning{{
+		Validator: "test-no-foo",
+		Message:   "bar is a fine name",
+	}})
+
+	u.URL = charm.MustParseReference("cs:bundle/foo-0")
+	_, err = charmstore.Validate(u, []string{"test-no-foo"})
+	c.Assert(err, gc.ErrorMatches, "charms may not be called foo")
+}
@@ -0,0 +1,424 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/params"
+)
+
+var (
+	// maxWebhookAttempts holds the number of times the delivery
+	// of a payload is attempted before it is marked as failed.
+	maxWebhookAttempts = 8
+
+	// webhookRetryDelay holds the delay before the first retry of
+	// a failed delivery. The delay doubles after each attempt.
+	webhookRetryDelay = time.Minute
+
+	// maxWebhookRetryDelay holds the maximum delay between
+	// delivery attempts.
+	maxWebhookRetryDelay = 6 * time.Hour
+
+	// webhookTimeout holds the time after which a delivery attempt
+	// is abandoned. A delivery being attempted is not attempted
+	// again by another server until this time has passed.
+	webhookTimeout = 30 * time.Second
+
+	// lookupIP is used to resolve the host names of webhooks.
+	lookupIP = net.LookupIP
+)
+
+// reservedNetworks holds the networks that webhook payloads
+// are not delivered to unless private webhooks are allowed:
+// loopback, private, shared, link-local (which includes cloud
+// metadata services), multicast and other special purpose
+// addresses, including the IPv6 ranges that embed them.
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"100::/64",
+	"2001:db8::/32",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// Webhooks returns the Mongo collection where
+// webhook subscriptions are stored.
+func (s StoreDatabase) Webhooks() *mgo.Collection {
+	return s.C("webhooks")
+}
+
+// WebhookDeliveries returns the Mongo collection where
+// webhook payloads and their delivery history are stored.
+func (s StoreDatabase) WebhookDeliveries() *mgo.Collection {
+	return s.C("webhook_deliveries")
+}
+
+// AddWebhook adds the given webhook subscription to the store,
+// setting its id and creation time.
+func (s *Store) AddWebhook(hook *mongodoc.Webhook) error {
+	hook.Id = bson.NewObjectId().Hex()
+	hook.CreatedTime = time.Now()
+	if err := s.DB.Webhooks().Insert(hook); err != nil {
+		return errgo.Notef(err, "cannot insert webhook")
+	}
+	return nil
+}
+
+// FindWebhook returns the webhook with the given id.
+// If the webhook is not found, it returns an error
+// with a params.ErrNotFound cause.
+func (s *Store) FindWebhook(id string) (*mongodoc.Webhook, error) {
+	var hook mongodoc.Webhook
+	if err := s.DB.Webhooks().FindId(id).One(&hook); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "webhook %q not found", id)
+		}
+		return nil, errgo.Notef(err, "cannot get webhook %q", id)
+	}
+	return &hook, nil
+}
+
+// RemoveWebhook removes the webhook with the given id,
+// along with its delivery history.
+func (s *Store) RemoveWebhook(id string) error {
+	if err := s.DB.Webhooks().RemoveId(id); err != nil {
+		if err == mgo.ErrNotFound {
+			return errgo.WithCausef(nil, params.ErrNotFound, "webhook %q not found", id)
+		}
+		return errgo.Notef(err, "cannot remove webhook %q", id)
+	}
+	if _, err := s.DB.WebhookDeliveries().RemoveAll(bson.D{{"webhookid", id}}); err != nil {
+		return errgo.Notef(err, "cannot remove deliveries for webhook %q", id)
+	}
+	return nil
+}
+
+// NotifyWebhooks queues the given payload for delivery to all the
+// webhooks subscribed to its event, either globally or in the
+// namespace of the payload id.
+func (s *Store) NotifyWebhooks(payload *params.WebhookPayload) error {
+	if payload.Time.IsZero() {
+		payload.Time = time.Now()
+	}
+	payload.Time = payload.Time.UTC()
+	var hooks []mongodoc.Webhook
+	if err := s.DB.Webhooks().Find(bson.D{
+		{"namespace", bson.D{{"$in", []string{"", payload.Id.User}}}},
+	}).All(&hooks); err != nil {
+		return errgo.Notef(err, "cannot get webhooks")
+	}
+	var data []byte
+	for _, hook := range hooks {
+		if !webhookWants(&hook, payload.Event) {
+			continue
+		}
+		if data == nil {
+			var err error
+			data, err = json.Marshal(payload)
+			if err != nil {
+				return errgo.Notef(err, "cannot marshal webhook payload")
+			}
+		}
+		if err := s.DB.WebhookDeliveries().Insert(&mongodoc.WebhookDelivery{
+			Id:          bson.NewObjectId(),
+			WebhookId:   hook.Id,
+			Event:       string(payload.Event),
+			Payload:     data,
+			CreatedTime: payload.Time,
+			Status:      mongodoc.DeliveryPending,
+			NextAttempt: payload.Time,
+		}); err != nil {
+			return errgo.Notef(err, "cannot queue webhook delivery")
+		}
+	}
+	return nil
+}
+
+// webhookWants reports whether the given webhook
+// is subscribed to the given event.
+func webhookWants(hook *mongodoc.Webhook, event params.WebhookEvent) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == string(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliverWebhooks attempts to deliver all the webhook payloads that
+// are due, using the given HTTP client. It returns the number of
+// delivery attempts made.
+func (s *Store) DeliverWebhooks(client *http.Client) (int, error) {
+	db := s.DB.Copy()
+	defer db.Close()
+
+	n := 0
+	for {
+		now := time.Now()
+		// Claim the delivery so that no other server
+		// attempts it at the same time.
+		var delivery mongodoc.WebhookDelivery
+		_, err := db.WebhookDeliveries().Find(bson.D{
+			{"status", mongodoc.DeliveryPending},
+			{"nextattempt", bson.D{{"$lte", now}}},
+		}).Sort("nextattempt").Apply(mgo.Change{
+			Update: bson.D{{"$set", bson.D{
+				{"nextattempt", now.Add(webhookTimeout)},
+			}}},
+			ReturnNew: true,
+		}, &delivery)
+		if err == mgo.ErrNotFound {
+			return n, nil
+		}
+		if err != nil {
+			return n, errgo.Notef(err, "cannot get pending webhook delivery")
+		}
+		var hook mongodoc.Webhook
+		err = db.Webhooks().FindId(delivery.WebhookId).One(&hook)
+		if err == mgo.ErrNotFound {
+			// The webhook has been removed since the payload was queued.
+			if err := db.WebhookDeliveries().RemoveId(delivery.Id); err != nil && err != mgo.ErrNotFound {
+				return n, errgo.Notef(err, "cannot remove webhook delivery")
+			}
+			continue
+		}
+		if err != nil {
+			return n, errgo.Notef(err, "cannot get webhook %q", delivery.WebhookId)
+		}
+		attempt := postWebhook(client, &hook, &delivery)
+		n++
+		status := mongodoc.DeliveryDelivered
+		var next time.Time
+		if attempt.Error != "" {
+			status = mongodoc.DeliveryPending
+			next = attempt.Time.Add(webhookBackoff(len(delivery.Attempts) + 1))
+			if len(delivery.Attempts)+1 >= maxWebhookAttempts {
+				status = mongodoc.DeliveryFailed
+				next = time.Time{}
+			}
+			logger.Infof("cannot deliver %s event to webhook %s: %s", delivery.Event, hook.Id, attempt.Error)
+		}
+		if err := db.WebhookDeliveries().UpdateId(delivery.Id, bson.D{
+			{"$set", bson.D{
+				{"status", status},
+				{"nextattempt", next},
+			}},
+			{"$push", bson.D{{"attempts", attempt}}},
+		}); err != nil {
+			return n, errgo.Notef(err, "cannot update webhook delivery")
+		}
+	}
+}
+
+// webhookBackoff returns the delay before the next
+// attempt after the given number of failed attempts.
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookRetryDelay
+	for i := 1; i < attempts && delay < maxWebhookRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxWebhookRetryDelay {
+		delay = maxWebhookRetryDelay
+	}
+	return delay
+}
+
+// CheckWebhookURL checks that webhook payloads can be delivered
+// to the given URL: its host must resolve only to public addresses.
+// The addresses are checked again when payloads are delivered by a
+// client returned by newWebhookClient, as the host may resolve to
+// different addresses by then.
+func CheckWebhookURL(u *url.URL) error {
+	_, err := webhookAddrs(urlHost(u))
+	return errgo.Mask(err)
+}
+
+// newWebhookClient returns the HTTP client used to deliver webhook
+// payloads. Unless allowPrivate is true, the client refuses to
+// connect to hosts that resolve to reserved addresses.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	if allowPrivate {
+		return &http.Client{
+			Timeout: webhookTimeout,
+		}
+	}
+	// Proxies are not used, as the proxy address rather
+	// than the webhook address would be checked.
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial:                webhookDial,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		Timeout: webhookTimeout,
+	}
+}
+
+// webhookDial dials the given webhook address after checking that its
+// host does not resolve to reserved addresses. The checked addresses
+// are dialed directly, so that the host cannot be made to resolve to
+// another address between the check and the connection.
+func webhookDial(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	ips, err := webhookAddrs(host)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = net.DialTimeout(network, net.JoinHostPort(ip.String(), port), webhookTimeout)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// webhookAddrs returns the addresses of the given webhook host.
+// It returns an error if the host cannot be resolved or if any of
+// its addresses is reserved.
+func webhookAddrs(host string) ([]net.IP, error) {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		ips, err = lookupIP(host)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot resolve webhook host %q", host)
+		}
+	}
+	for _, ip := range ips {
+		if isReservedIP(ip) {
+			return nil, errgo.Newf("webhook host %q has reserved address %s", host, ip)
+		}
+	}
+	return ips, nil
+}
+
+// isReservedIP reports whether the given address
+// is in one of the reserved networks.
+func isReservedIP(ip net.IP) bool {
+	for _, n := range reservedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlHost returns the host of the given URL, without the port.
+func urlHost(u *url.URL) string {
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// postWebhook posts the payload of the given delivery
+// to the given webhook and returns the outcome.
+func postWebhook(client *http.Client, hook *mongodoc.Webhook, delivery *mongodoc.WebhookDelivery) mongodoc.WebhookAttempt {
+	attempt := mongodoc.WebhookAttempt{
+		Time: time.Now(),
+	}
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = fmt.Sprintf("cannot make request: %v", err)
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(params.WebhookEventHeader, delivery.Event)
+	req.Header.Set(params.WebhookDeliveryHeader, delivery.Id.Hex())
+	req.Header.Set(params.WebhookSignatureHeader, WebhookSignature(hook.Secret, delivery.Payload))
+	resp, err := client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	// Read some of the body so that the connection can be reused.
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("unexpected response status %q", resp.Status)
+	}
+	return attempt
+}
+
+// WebhookSignature returns the value of the
+// params.WebhookSignatureHeader header sent with
+// the given payload to a webhook with the given secret.
+func WebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// runWebhookDeliveries delivers webhook payloads
+// every interval, until the stop channel is closed.
+func (s *Store) runWebhookDeliveries(client *http.Client, interval time.Duration, stop <-chan struct{}) {
+	for {
+		if _, err := s.DeliverWebhooks(client); err != nil {
+			logger.Errorf("cannot deliver webhooks: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,454 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+type webhooksSuite struct {
+	storetesting.IsolatedMgoSuite
+	store *Store
+}
+
+var _ = gc.Suite(&webhooksSuite{})
+
+func (s *webhooksSuite) SetUpTest(c *gc.C) {
+	s.IsolatedMgoSuite.SetUpTest(c)
+	store, err := NewStore(s.Session.DB("juju_test"), nil, nil)
+	c.Assert(err, gc.IsNil)
+	s.store = store
+}
+
+// webhookRecorder records the requests made to a webhook
+// and responds with the next status code in statuses.
+type webhookRecorder struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []recordedRequest
+}
+
+type recordedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, recordedRequest{
+		header: req.Header,
+		body:   body,
+	})
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// count returns the number of requests recorded.
+func (r *webhookRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func (s *webhooksSuite) addWebhook(c *gc.C, namespace, url string, events ...string) *mongodoc.Webhook {
+	hook := &mongodoc.Webhook{
+		Namespace: namespace,
+		URL:       url,
+		Secret:    "secret-" + namespace,
+		Events:    events,
+	}
+	err := s.store.AddWebhook(hook)
+	c.Assert(err, gc.IsNil)
+	return hook
+}
+
+func (s *webhooksSuite) deliveries(c *gc.C, hook *mongodoc.Webhook) []mongodoc.WebhookDelivery {
+	var deliveries []mongodoc.WebhookDelivery
+	err := s.store.DB.WebhookDeliveries().Find(bson.D{{"webhookid", hook.Id}}).Sort("_id").All(&deliveries)
+	c.Assert(err, gc.IsNil)
+	return deliveries
+}
+
+func (s *webhooksSuite) TestNotifyWebhooks(c *gc.C) {
+	global := s.addWebhook(c, "", "http://0.1.2.3/global")
+	joe := s.addWebhook(c, "joe", "http://0.1.2.3/joe", "upload", "delete")
+	bob := s.addWebhook(c, "bob", "http://0.1.2.3/bob")
+
+	uploadTime := time.Date(2015, 3, 4, 5, 6, 7, 0, time.UTC)
+	err := s.store.NotifyWebhooks(&params.WebhookPayload{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:~joe/precise/wordpress-0"),
+		Time:  uploadTime,
+	})
+	c.Assert(err, gc.IsNil)
+	err = s.store.NotifyWebhooks(&params.WebhookPayload{
+		Event: params.PermEvent,
+		Id:    charm.MustParseReference("cs:~joe/wordpress"),
+		Perm: &params.PermResponse{
+			Read: []string{"joe"},
+		},
+	})
+	c.Assert(err, gc.IsNil)
+
+	// The global webhook is notified about both events.
+	deliveries := s.deliveries(c, global)
+	c.Assert(deliveries, gc.HasLen, 2)
+	c.Assert(deliveries[0].Event, gc.Equals, "upload")
+	c.Assert(deliveries[0].Status, gc.Equals, mongodoc.DeliveryPending)
+	c.Assert(deliveries[0].CreatedTime.UTC(), gc.DeepEquals, uploadTime)
+	var payload params.WebhookPayload
+	err = json.Unmarshal(deliveries[0].Payload, &payload)
+	c.Assert(err, gc.IsNil)
+	c.Assert(payload, jc.DeepEquals, params.WebhookPayload{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:~joe/precise/wordpress-0"),
+		Time:  uploadTime,
+	})
+	c.Assert(deliveries[1].Event, gc.Equals, "perm")
+	c.Assert(deliveries[1].Attempts, gc.HasLen, 0)
+
+	// The namespace webhook is only notified about the events
+	// it subscribed to.
+	deliveries = s.deliveries(c, joe)
+	c.Assert(deliveries, gc.HasLen, 1)
+	c.Assert(deliveries[0].Event, gc.Equals, "upload")
+
+	// Webhooks in other namespaces are not notified.
+	c.Assert(s.deliveries(c, bob), gc.HasLen, 0)
+}
+
+func (s *webhooksSuite) TestDeliverWebhooks(c *gc.C) {
+	recorder := &webhookRecorder{}
+	srv := httptest.NewServer(recorder)
+	defer srv.Close()
+	hook := s.addWebhook(c, "joe", srv.URL)
+	err := s.store.NotifyWebhooks(&params.WebhookPayload{
+		Event: params.DeleteEvent,
+		Id:    charm.MustParseReference("cs:~joe/precise/wordpress-0"),
+	})
+	c.Assert(err, gc.IsNil)
+
+	n, err := s.store.DeliverWebhooks(http.DefaultClient)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+
+	deliveries := s.deliveries(c, hook)
+	c.Assert(deliveries, gc.HasLen, 1)
+	c.Assert(deliveries[0].Status, gc.Equals, mongodoc.DeliveryDelivered)
+	c.Assert(deliveries[0].Attempts, gc.HasLen, 1)
+	c.Assert(deliveries[0].Attempts[0].StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(deliveries[0].Attempts[0].Error, gc.Equals, "")
+
+	c.Assert(recorder.requests, gc.HasLen, 1)
+	req := recorder.requests[0]
+	c.Assert(string(req.body), gc.Equals, string(deliveries[0].Payload))
+	c.Assert(req.header.Get("Content-Type"), gc.Equals, "application/json")
+	c.Assert(req.header.Get(params.WebhookEventHeader), gc.Equals, "delete")
+	c.Assert(req.header.Get(params.WebhookDeliveryHeader), gc.Equals, deliveries[0].Id.Hex())
+	c.Assert(req.header.Get(params.WebhookSignatureHeader), gc.Equals, WebhookSignature("secret-joe", req.body))
+	var payload params.WebhookPayload
+	err = json.Unmarshal(req.body, &payload)
+	c.Assert(err, gc.IsNil)
+	c.Assert(payload.Event, gc.Equals, params.DeleteEvent)
+	c.Assert(payload.Id.String(), gc.Equals, "cs:~joe/precise/wordpress-0")
+
+	// Delivered payloads are not sent again.
+	n, err = s.store.DeliverWebhooks(http.DefaultClient)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+	c.Assert(recorder.requests, gc.HasLen, 1)
+}
+
+func (s *webhooksSuite) TestDeliverWebhooksRetries(c *gc.C) {
+	s.PatchValue(&maxWebhookAttempts, 3)
+	s.PatchValue(&webhookRetryDelay, time.Duration(0))
+	recorder := &webhookRecorder{
+		statuses: []int{http.StatusInternalServerError, http.StatusOK},
+	}
+	srv := httptest.NewServer(recorder)
+	defer srv.Close()
+	hook := s.addWebhook(c, "", srv.URL)
+	err := s.store.NotifyWebhooks(&params.WebhookPayload{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:precise/wordpress-0"),
+	})
+	c.Assert(err, gc.IsNil)
+
+	// The first attempt fails and the delivery is retried
+	// immediately because the retry delay is zero.
+	n, err := s.store.DeliverWebhooks(http.DefaultClient)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 2)
+
+	deliveries := s.deliveries(c, hook)
+	c.Assert(deliveries, gc.HasLen, 1)
+	c.Assert(deliveries[0].Status, gc.Equals, mongodoc.DeliveryDelivered)
+	c.Assert(deliveries[0].Attempts, gc.HasLen, 2)
+	c.Assert(deliveries[0].Attempts[0].StatusCode, gc.Equals, http.StatusInternalServerError)
+	c.Assert(deliveries[0].Attempts[0].Error, gc.Equals, `unexpected response status "500 Internal Server Error"`)
+	c.Assert(deliveries[0].Attempts[1].StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(recorder.requests, gc.HasLen, 2)
+	c.Assert(recorder.requests[1].header.Get(params.WebhookDeliveryHeader), gc.Equals, recorder.requests[0].header.Get(params.WebhookDeliveryHeader))
+}
+
+func (s *webhooksSuite) TestDeliverWebhooksFails(c *gc.C) {
+	s.PatchValue(&maxWebhookAttempts, 3)
+	s.PatchValue(&webhookRetryDelay, time.Duration(0))
+	recorder := &webhookRecorder{
+		statuses: []int{http.StatusNotFound, http.StatusNotFound, http.StatusNotFound, http.StatusOK},
+	}
+	srv := httptest.NewServer(recorder)
+	defer srv.Close()
+	hook := s.addWebhook(c, "", srv.URL)
+	err := s.store.NotifyWebhooks(&params.WebhookPayload{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:precise/wordpress-0"),
+	})
+	c.Assert(err, gc.IsNil)
+
+	n, err := s.store.DeliverWebhooks(http.DefaultClient)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 3)
+
+	deliveries := s.deliveries(c, hook)
+	c.Assert(deliveries, gc.HasLen, 1)
+	c.Assert(deliveries[0].Status, gc.Equals, mongodoc.DeliveryFailed)
+	c.Assert(deliveries[0].Attempts, gc.HasLen, 3)
+
+	// Failed deliveries are not attempted again.
+	n, err = s.store.DeliverWebhooks(http.DefaultClient)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *webhooksSuite) TestDeliverWebhooksBackoff(c *gc.C) {
+	srv := httptest.NewServer(&webhookRecorder{
+		statuses: []int{http.StatusServiceUnavailable},
+	})
+	defer srv.Close()
+	hook := s.addWebhook(c, "", srv.URL)
+	err := s.store.NotifyWebhooks(&params.WebhookPayload{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:precise/wordpress-0"),
+	})
+	c.Assert(err, gc.IsNil)
+
+	n, err := s.store.DeliverWebhooks(http.DefaultClient)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+
+	// The delivery is not attempted again until the retry delay has passed.
+	deliveries := s.deliveries(c, hook)
+	c.Assert(deliveries, gc.HasLen, 1)
+	c.Assert(deliveries[0].Status, gc.Equals, mongodoc.DeliveryPending)
+	c.Assert(deliveries[0].NextAttempt, jc.TimeBetween(
+		deliveries[0].Attempts[0].Time.Add(webhookRetryDelay-time.Second),
+		deliveries[0].Attempts[0].Time.Add(webhookRetryDelay+time.Second),
+	))
+	n, err = s.store.DeliverWebhooks(http.DefaultClient)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *webhooksSuite) TestDeliverWebhooksRemovedWebhook(c *gc.C) {
+	hook := s.addWebhook(c, "", "http://0.1.2.3/")
+	err := s.store.NotifyWebhooks(&params.WebhookPayload{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:precise/wordpress-0"),
+	})
+	c.Assert(err, gc.IsNil)
+	err = s.store.DB.Webhooks().RemoveId(hook.Id)
+	c.Assert(err, gc.IsNil)
+
+	n, err := s.store.DeliverWebhooks(http.DefaultClient)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+	c.Assert(s.deliveries(c, hook), gc.HasLen, 0)
+}
+
+func (s *webhooksSuite) TestRemoveWebhook(c *gc.C) {
+	hook := s.addWebhook(c, "joe", "http://0.1.2.3/")
+	err := s.store.NotifyWebhooks(&params.WebhookPayload{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:~joe/precise/wordpress-0"),
+	})
+	c.Assert(err, gc.IsNil)
+
+	err = s.store.RemoveWebhook(hook.Id)
+	c.Assert(err, gc.IsNil)
+	_, err = s.store.FindWebhook(hook.Id)
+	c.Assert(err, gc.ErrorMatches, `webhook ".*" not found`)
+	c.Assert(s.deliveries(c, hook), gc.HasLen, 0)
+
+	err = s.store.RemoveWebhook(hook.Id)
+	c.Assert(err, gc.ErrorMatches, `webhook ".*" not found`)
+}
+
+// fakeLookupIP resolves the host names used in the webhook tests.
+func fakeLookupIP(host string) ([]net.IP, error) {
+	switch host {
+	case "public.example.com":
+		return []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("2001:4860:4860::8888")}, nil
+	case "internal.example.com":
+		return []net.IP{net.ParseIP("192.168.1.1")}, nil
+	case "mixed.example.com":
+		return []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("10.0.0.1")}, nil
+	case "rebind.example.com":
+		return []net.IP{net.ParseIP("127.0.0.1")}, nil
+	}
+	return nil, errgo.New("no such host")
+}
+
+var checkWebhookURLTests = []struct {
+	url         string
+	expectError string
+}{{
+	url: "http://8.8.8.8/hook",
+}, {
+	url: "https://public.example.com:8443/hook",
+}, {
+	url: "http://[2001:4860:4860::8888]/hook",
+}, {
+	url:         "http://127.0.0.1:8080/hook",
+	expectError: `webhook host "127.0.0.1" has reserved address 127.0.0.1`,
+}, {
+	url:         "http://169.254.169.254/latest/meta-data",
+	expectError: `webhook host "169.254.169.254" has reserved address 169.254.169.254`,
+}, {
+	url:         "http://172.20.0.1/hook",
+	expectError: `webhook host "172.20.0.1" has reserved address 172.20.0.1`,
+}, {
+	url:         "http://100.64.0.1/hook",
+	expectError: `webhook host "100.64.0.1" has reserved address 100.64.0.1`,
+}, {
+	url:         "http://[::1]/hook",
+	expectError: `webhook host "::1" has reserved address ::1`,
+}, {
+	url:         "http://[fe80::1]:80/hook",
+	expectError: `webhook host "fe80::1" has reserved address fe80::1`,
+}, {
+	url:         "http://[::ffff:10.0.0.1]/hook",
+	expectError: `webhook host "::ffff:10.0.0.1" has reserved address 10.0.0.1`,
+}, {
+	url:         "http://internal.example.com/hook",
+	expectError: `webhook host "internal.example.com" has reserved address 192.168.1.1`,
+}, {
+	url:         "http://mixed.example.com/hook",
+	expectError: `webhook host "mixed.example.com" has reserved address 10.0.0.1`,
+}, {
+	url:         "http://unknown.example.com/hook",
+	expectError: `cannot resolve webhook host "unknown.example.com": no such host`,
+}}
+
+func (s *webhooksSuite) TestCheckWebhookURL(c *gc.C) {
+	s.PatchValue(&lookupIP, fakeLookupIP)
+	for i, test := range checkWebhookURLTests {
+		c.Logf("test %d: %s", i, test.url)
+		u, err := url.Parse(test.url)
+		c.Assert(err, gc.IsNil)
+		err = CheckWebhookURL(u)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, regexp.QuoteMeta(test.expectError))
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+	}
+}
+
+func (s *webhooksSuite) TestDeliverWebhooksRejectsReservedAddresses(c *gc.C) {
+	s.PatchValue(&lookupIP, fakeLookupIP)
+	recorder := &webhookRecorder{}
+	srv := httptest.NewServer(recorder)
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	c.Assert(err, gc.IsNil)
+	_, port, err := net.SplitHostPort(srvURL.Host)
+	c.Assert(err, gc.IsNil)
+
+	// The host of the second webhook resolves to a loopback
+	// address after the webhook has been registered.
+	local := s.addWebhook(c, "", srv.URL)
+	rebind := s.addWebhook(c, "", "http://rebind.example.com:"+port+"/hook")
+	err = s.store.NotifyWebhooks(&params.WebhookPayload{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:precise/wordpress-0"),
+	})
+	c.Assert(err, gc.IsNil)
+
+	n, err := s.store.DeliverWebhooks(newWebhookClient(false))
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 2)
+	c.Assert(recorder.requests, gc.HasLen, 0)
+	for _, hook := range []*mongodoc.Webhook{local, rebind} {
+		deliveries := s.deliveries(c, hook)
+		c.Assert(deliveries, gc.HasLen, 1)
+		c.Assert(deliveries[0].Status, gc.Equals, mongodoc.DeliveryPending)
+		c.Assert(deliveries[0].Attempts, gc.HasLen, 1)
+		c.Assert(deliveries[0].Attempts[0].StatusCode, gc.Equals, 0)
+		c.Assert(deliveries[0].Attempts[0].Error, gc.Matches, `.*webhook host ".*" has reserved address 127\.0\.0\.1`)
+	}
+
+	// Private webhooks can be allowed.
+	err = s.store.DB.WebhookDeliveries().Update(
+		bson.D{{"webhookid", local.Id}},
+		bson.D{{"$set", bson.D{{"nextattempt", time.Time{}}}}},
+	)
+	c.Assert(err, gc.IsNil)
+	n, err = s.store.DeliverWebhooks(newWebhookClient(true))
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+	c.Assert(recorder.requests, gc.HasLen, 1)
+}
+
+var webhookBackoffTests = []struct {
+	attempts int
+	expect   time.Duration
+}{
+	{1, time.Minute},
+	{2, 2 * time.Minute},
+	{4, 8 * time.Minute},
+	{9, 256 * time.Minute},
+	{10, 6 * time.Hour},
+	{100, 6 * time.Hour},
+}
+
+func (s *webhooksSuite) TestWebhookBackoff(c *gc.C) {
+	for i, test := range webhookBackoffTests {
+		c.Logf("test %d: %d attempts", i, test.attempts)
+		c.Assert(webhookBackoff(test.attempts), gc.Equals, test.expect)
+	}
+}
+
+func (s *webhooksSuite) TestWebhookSignature(c *gc.C) {
+	// Computed with:
+	// echo -n '{"Event":"upload"}' | openssl dgst -sha256 -hmac secret
+	c.Assert(
+		WebhookSignature("secret", []byte(`{"Event":"upload"}`)),
+		gc.Equals,
+		"sha256=895a812a9b923b3366a901c6eaf2019bb3d8ad4c6ed8a12eb3bc3b321d7c6042",
+	)
+}
@@ -7,6 +7,8 @@ import (
 	"archive/zip"
 	"compress/flate"
 	"io"
+	"path"
+	"strings"
 
 	"gopkg.in/errgo.v1"
 
@@ -35,6 +37,7 @@ func NewZipFile(f *zip.File) (mongodoc.ZipFile, error) {
 		return mongodoc.ZipFile{}, errgo.Notef(err, "cannot determine data offset for %q", f.Name)
 	}
 	zf := mongodoc.ZipFile{
+		Name:   f.Name,
 		Offset: offset,
 		Size:   int64(f.CompressedSize64),
 	}
@@ -47,3 +50,75 @@ func NewZipFile(f *zip.File) (mongodoc.ZipFile, error) {
 	}
 	return zf, nil
 }
+
+// NewManifest returns the manifest of the given archive,
+// holding an entry for each of its files and directories.
+func NewManifest(zr *zip.Reader) ([]mongodoc.ManifestFile, error) {
+	manifest := make([]mongodoc.ManifestFile, len(zr.File))
+	for i, f := range zr.File {
+		zf, err := NewZipFile(f)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		manifest[i] = mongodoc.ManifestFile{
+			Name:           f.Name,
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: zf.Size,
+			Offset:         zf.Offset,
+			Compressed:     zf.Compressed,
+			CRC32:          f.CRC32,
+			Mode:           f.Mode(),
+			ModTime:        f.ModTime(),
+		}
+	}
+	return manifest, nil
+}
+
+// ArchiveContents returns the entries for the frequently accessed
+// files held in the given archive, suitable for storing in the
+// entity's Contents field. There is an entry for each file id;
+// the entry is invalid when the archive does not hold the file.
+func ArchiveContents(zr *zip.Reader) (map[mongodoc.FileId]mongodoc.ZipFile, error) {
+	readme, err := ReadMeContents(zr)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	icon, err := IconContents(zr)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return map[mongodoc.FileId]mongodoc.ZipFile{
+		mongodoc.FileReadMe: readme,
+		mongodoc.FileIcon:   icon,
+	}, nil
+}
+
+// These are all forms of README files
+// actually observed in charms in the wild.
+var allowedReadMe = map[string]bool{
+	"readme":          true,
+	"readme.md":       true,
+	"readme.rst":      true,
+	"readme.ex":       true,
+	"readme.markdown": true,
+	"readme.txt":      true,
+}
+
+// IsReadMeFile reports whether the archive entry with the given
+// name is served as the README file of a charm or bundle.
+func IsReadMeFile(name string) bool {
+	// This is the same condition currently used by the GUI.
+	return allowedReadMe[strings.ToLower(path.Clean(name))]
+}
+
+// ReadMeContents returns the entry for the README file held in
+// the given archive, suitable for storing in the entity's Contents
+// field. If there is no README file, an invalid ZipFile is returned.
+func ReadMeContents(zr *zip.Reader) (mongodoc.ZipFile, error) {
+	for _, f := range zr.File {
+		if IsReadMeFile(f.Name) {
+			return NewZipFile(f)
+		}
+	}
+	return mongodoc.ZipFile{}, nil
+}
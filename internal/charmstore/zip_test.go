@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"os"
 	"strings"
 
 	jujutesting "github.com/juju/testing"
@@ -121,3 +122,53 @@ func (s *zipSuite) TestNewZipFileWithCompressionMethodError(c *gc.C) {
 	_, err := charmstore.NewZipFile(f)
 	c.Assert(err, gc.ErrorMatches, `unknown zip compression method for "foo"`)
 }
+
+func (s *zipSuite) TestNewManifest(c *gc.C) {
+	r := makeArchive(c, []archiveEntry{
+		{name: "hooks/", mode: os.ModeDir | 0755},
+		{name: "hooks/install", content: "#!/bin/sh\n", mode: 0755},
+		{name: "README.md", content: "readme contents"},
+	})
+	zr, err := zip.NewReader(r, r.Size())
+	c.Assert(err, gc.IsNil)
+	manifest, err := charmstore.NewManifest(zr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(manifest, gc.HasLen, 3)
+	for i, f := range zr.File {
+		c.Logf("test %d: %s", i, f.Name)
+		mf := manifest[i]
+		c.Assert(mf.Name, gc.Equals, f.Name)
+		c.Assert(mf.Size, gc.Equals, int64(f.UncompressedSize64))
+		c.Assert(mf.CRC32, gc.Equals, f.CRC32)
+		c.Assert(mf.Mode, gc.Equals, f.Mode())
+		c.Assert(mf.IsDir(), gc.Equals, f.Name == "hooks/")
+
+		// The file data can be read using the manifest entry.
+		zfr, err := charmstore.ZipFileReader(r, mf.ZipFile())
+		c.Assert(err, gc.IsNil)
+		data, err := ioutil.ReadAll(zfr)
+		c.Assert(err, gc.IsNil)
+		c.Assert(int64(len(data)), gc.Equals, mf.Size)
+	}
+}
+
+func (s *zipSuite) TestArchiveContents(c *gc.C) {
+	r := makeArchive(c, []archiveEntry{
+		{name: "metadata.yaml", content: "name: foo"},
+		{name: "docs/README.md", content: "not the readme"},
+		{name: "./Readme.md", content: "readme contents"},
+	})
+	zr, err := zip.NewReader(r, r.Size())
+	c.Assert(err, gc.IsNil)
+	contents, err := charmstore.ArchiveContents(zr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(contents, gc.HasLen, 2)
+	c.Assert(contents[mongodoc.FileIcon].IsValid(), gc.Equals, false)
+	readme := contents[mongodoc.FileReadMe]
+	c.Assert(readme.Name, gc.Equals, "./Readme.md")
+	zfr, err := charmstore.ZipFileReader(r, readme)
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadAll(zfr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "readme contents")
+}
@@ -0,0 +1,340 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The mirror package copies charms and bundles from an upstream
+// charm store into a local one.
+package mirror
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/csclient"
+	"github.com/juju/charmstore/params"
+)
+
+var logger = loggo.GetLogger("charmstore.internal.mirror")
+
+// pageSize holds the number of results requested
+// at a time from the upstream charm store.
+const pageSize = 100
+
+// Params holds the parameters for creating a Mirror.
+type Params struct {
+	// Upstream holds the client used to read from
+	// the charm store being mirrored.
+	Upstream *csclient.Client
+
+	// Local holds the client used to write to the local
+	// charm store. It must have administrator credentials.
+	Local *csclient.Client
+
+	// Namespaces holds the users or teams whose charms and
+	// bundles are mirrored. If it is empty, charms and bundles
+	// are mirrored regardless of their owner.
+	Namespaces []string
+
+	// Series holds the series of the charms and bundles that
+	// are mirrored. If it is empty, all series are mirrored.
+	Series []string
+
+	// Queries holds search queries selecting the charms and
+	// bundles to mirror. If it is not empty, only the search
+	// results are mirrored, still restricted by Namespaces and
+	// Series, rather than the charms and bundles published since
+	// the last synchronisation.
+	Queries []string
+
+	// PreserveACLs holds whether the read and write permissions
+	// of the charms and bundles are copied. If it is false, the
+	// local charm store uses its default permissions, except that
+	// charms and bundles that are not readable by everyone upstream
+	// are only readable by their owner.
+	PreserveACLs bool
+}
+
+// State holds the state of the synchronisation, so that a
+// mirror can resume from where it left off.
+type State struct {
+	// LastPublished holds the publish time of the most recently
+	// published upstream charm or bundle that has been mirrored.
+	LastPublished time.Time
+}
+
+// Mirror copies charms and bundles from an upstream
+// charm store into a local one.
+type Mirror struct {
+	p Params
+}
+
+// New returns a new Mirror using the given parameters.
+func New(p Params) *Mirror {
+	return &Mirror{
+		p: p,
+	}
+}
+
+// Sync copies the selected charms and bundles published upstream since
+// the time recorded in the given state, oldest first, and updates the
+// state as it goes. Each revision keeps its upstream revision number,
+// and its extra-info, promulgation status and, if requested, permissions
+// are copied too. Revisions already present in the local charm store are
+// left alone, and those that cannot be read upstream, such as private
+// revisions, are skipped. Sync returns the ids of the revisions copied.
+//
+// If Sync fails, the state still records the revisions copied so
+// far, so that the next call resumes from the failed revision.
+func (m *Mirror) Sync(state *State) ([]*charm.Reference, error) {
+	if len(m.p.Queries) > 0 {
+		ids, err := m.searchIds()
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return m.syncIds(ids)
+	}
+	published, err := m.publishedSince(state.LastPublished)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var synced []*charm.Reference
+	for i := len(published) - 1; i >= 0; i-- {
+		p := published[i]
+		copied, err := m.syncEntity(p.Id)
+		if err != nil {
+			return synced, errgo.Mask(err)
+		}
+		if copied {
+			synced = append(synced, p.Id)
+		}
+		state.LastPublished = p.PublishTime
+	}
+	return synced, nil
+}
+
+// syncIds copies the entities with the given ids that are
+// not already present in the local charm store.
+func (m *Mirror) syncIds(ids []*charm.Reference) ([]*charm.Reference, error) {
+	var synced []*charm.Reference
+	for _, id := range ids {
+		copied, err := m.syncEntity(id)
+		if err != nil {
+			return synced, errgo.Mask(err)
+		}
+		if copied {
+			synced = append(synced, id)
+		}
+	}
+	return synced, nil
+}
+
+// publishedSince returns the selected upstream charms and bundles
+// published at or after the given time, most recently published first.
+// Entities published exactly at that time are included because more
+// than one entity can be published at the same time.
+func (m *Mirror) publishedSince(since time.Time) ([]params.Published, error) {
+	query := m.filters()
+	query.Set("limit", strconv.Itoa(pageSize))
+	var results []params.Published
+	for {
+		page, cursor, err := m.publishedPage(query)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		for _, p := range page {
+			if p.PublishTime.Before(since) {
+				return results, nil
+			}
+			results = append(results, p)
+		}
+		if cursor == "" {
+			return results, nil
+		}
+		query.Set("cursor", cursor)
+	}
+}
+
+// publishedPage returns a page of changes/published results for
+// the given query, and the cursor for the next page, if any.
+func (m *Mirror) publishedPage(query url.Values) ([]params.Published, string, error) {
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		return nil, "", errgo.Notef(err, "cannot make new request")
+	}
+	resp, err := m.p.Upstream.Do(req, "/changes/published?"+query.Encode())
+	if err != nil {
+		return nil, "", errgo.Notef(err, "cannot get published charms and bundles")
+	}
+	defer resp.Body.Close()
+	var page []params.Published
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, "", errgo.Notef(err, "cannot unmarshal published charms and bundles")
+	}
+	return page, resp.Header.Get(params.NextCursorHeader), nil
+}
+
+// searchIds returns the ids of the upstream charms and
+// bundles matching the search queries.
+func (m *Mirror) searchIds() ([]*charm.Reference, error) {
+	var ids []*charm.Reference
+	seen := make(map[string]bool)
+	for _, text := range m.p.Queries {
+		query := m.filters()
+		query.Set("text", text)
+		query.Set("limit", strconv.Itoa(pageSize))
+		for skip := 0; ; {
+			query.Set("skip", strconv.Itoa(skip))
+			var resp params.SearchResponse
+			if err := m.p.Upstream.Get("/search?"+query.Encode(), &resp); err != nil {
+				return nil, errgo.Notef(err, "cannot search for %q", text)
+			}
+			for _, result := range resp.Results {
+				if !seen[result.Id.String()] {
+					seen[result.Id.String()] = true
+					ids = append(ids, result.Id)
+				}
+			}
+			skip += len(resp.Results)
+			if len(resp.Results) < pageSize || skip >= resp.Total {
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// filters returns the query parameters restricting
+// results to the selected namespaces and series.
+func (m *Mirror) filters() url.Values {
+	query := make(url.Values)
+	for _, ns := range m.p.Namespaces {
+		query.Add("owner", ns)
+	}
+	for _, series := range m.p.Series {
+		query.Add("series", series)
+	}
+	return query
+}
+
+// syncEntity copies the entity with the given id from the upstream
+// charm store unless it already exists in the local one. It reports
+// whether the entity was copied.
+func (m *Mirror) syncEntity(id *charm.Reference) (bool, error) {
+	err := m.p.Local.Get("/"+id.Path()+"/meta/id-revision", nil)
+	if err == nil {
+		return false, nil
+	}
+	if errgo.Cause(err) != params.ErrNotFound {
+		return false, errgo.Notef(err, "cannot check for %q in local charm store", id)
+	}
+	logger.Infof("mirroring %s", id)
+	var meta entityMeta
+	_, err = m.p.Upstream.Meta(id, &meta)
+	if err == nil {
+		err = m.copyArchive(id, csclient.UploadFlags{
+			Private: !isPublic(meta.Perm.Read),
+			Hidden:  meta.Hidden.Hidden,
+		})
+	}
+	if cause := errgo.Cause(err); cause == params.ErrUnauthorized || cause == params.ErrNotFound {
+		// Skip the entity rather than failing every
+		// synchronisation from now on.
+		logger.Warningf("skipping %s: %v", id, err)
+		return false, nil
+	}
+	if err != nil {
+		return false, errgo.Notef(err, "cannot copy %q", id)
+	}
+	if err := m.copyMeta(id, &meta); err != nil {
+		return false, errgo.Notef(err, "cannot copy metadata of %q", id)
+	}
+	return true, nil
+}
+
+// entityMeta holds the upstream metadata of a mirrored entity.
+type entityMeta struct {
+	ExtraInfo   map[string]json.RawMessage
+	Perm        params.PermResponse
+	Promulgated params.PromulgatedResponse
+	Hidden      params.HiddenResponse
+}
+
+// copyArchive copies the archive of the entity with the given id
+// from the upstream charm store to the local one, uploading it with
+// the given flags. The cause of the returned error is
+// params.ErrUnauthorized or params.ErrNotFound when the archive
+// cannot be read upstream.
+func (m *Mirror) copyArchive(id *charm.Reference, flags csclient.UploadFlags) error {
+	dir, err := ioutil.TempDir("", "csmirror")
+	if err != nil {
+		return errgo.Notef(err, "cannot make temporary directory")
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "archive.zip")
+	eid, err := m.p.Upstream.DownloadTo(id, path)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrUnauthorized), errgo.Is(params.ErrNotFound))
+	}
+	if eid.Series == "bundle" {
+		b, err := charm.ReadBundleArchive(path)
+		if err != nil {
+			return errgo.Notef(err, "cannot read bundle archive")
+		}
+		err = m.p.Local.UploadBundleWithRevisionAndFlags(eid, b, flags)
+		return errgo.Mask(err)
+	}
+	ch, err := charm.ReadCharmArchive(path)
+	if err != nil {
+		return errgo.Notef(err, "cannot read charm archive")
+	}
+	err = m.p.Local.UploadCharmWithRevisionAndFlags(eid, ch, flags)
+	return errgo.Mask(err)
+}
+
+// copyMeta copies the given extra-info, promulgation status and,
+// if requested, permissions of the entity with the given id to
+// the local charm store.
+func (m *Mirror) copyMeta(id *charm.Reference, meta *entityMeta) error {
+	if len(meta.ExtraInfo) > 0 {
+		info := make(map[string]interface{}, len(meta.ExtraInfo))
+		for key, val := range meta.ExtraInfo {
+			info[key] = val
+		}
+		if err := m.p.Local.PutExtraInfo(id, info); err != nil {
+			return errgo.Notef(err, "cannot put extra-info")
+		}
+	}
+	if m.p.PreserveACLs {
+		if err := m.p.Local.Put("/"+id.Path()+"/meta/perm/read", meta.Perm.Read); err != nil {
+			return errgo.Notef(err, "cannot put read permissions")
+		}
+		if err := m.p.Local.Put("/"+id.Path()+"/meta/perm/write", meta.Perm.Write); err != nil {
+			return errgo.Notef(err, "cannot put write permissions")
+		}
+	}
+	if err := m.p.Local.Put("/"+id.Path()+"/promulgate", params.PromulgateRequest{
+		Promulgated: meta.Promulgated.Promulgated,
+	}); err != nil {
+		return errgo.Notef(err, "cannot put promulgation status")
+	}
+	return nil
+}
+
+// isPublic reports whether the given read ACL allows everyone.
+func isPublic(acl []string) bool {
+	for _, name := range acl {
+		if name == params.Everyone {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,340 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mirror_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+	"gopkg.in/mgo.v2"
+
+	"github.com/juju/charmstore"
+	"github.com/juju/charmstore/csclient"
+	"github.com/juju/charmstore/internal/mirror"
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+var serverParams = charmstore.ServerParams{
+	AuthUsername: "test-user",
+	AuthPassword: "test-password",
+}
+
+type mirrorSuite struct {
+	storetesting.IsolatedMgoSuite
+	upstreamSrv *httptest.Server
+	localSrv    *httptest.Server
+	upstream    *csclient.Client
+	local       *csclient.Client
+}
+
+var _ = gc.Suite(&mirrorSuite{})
+
+func (s *mirrorSuite) SetUpTest(c *gc.C) {
+	s.IsolatedMgoSuite.SetUpTest(c)
+	s.upstreamSrv, s.upstream = newServer(c, s.Session.DB("upstream"))
+	s.localSrv, s.local = newServer(c, s.Session.DB("local"))
+}
+
+func (s *mirrorSuite) TearDownTest(c *gc.C) {
+	s.upstreamSrv.Close()
+	s.localSrv.Close()
+	s.IsolatedMgoSuite.TearDownTest(c)
+}
+
+// newServer starts an in-process charm store using the given
+// database, and returns a client with administrator credentials.
+func newServer(c *gc.C, db *mgo.Database) (*httptest.Server, *csclient.Client) {
+	handler, err := charmstore.NewServer(db, nil, "", serverParams, charmstore.V4)
+	c.Assert(err, gc.IsNil)
+	srv := httptest.NewServer(handler)
+	client := csclient.New(csclient.Params{
+		URL:      srv.URL,
+		User:     serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	return srv, client
+}
+
+// uploadCharm uploads the charm with the given name
+// to the upstream charm store with the given id.
+func (s *mirrorSuite) uploadCharm(c *gc.C, name, id string) *charm.Reference {
+	url := charm.MustParseReference(id)
+	err := s.upstream.UploadCharmWithRevision(url, storetesting.Charms.CharmDir(name))
+	c.Assert(err, gc.IsNil)
+	// Make sure that publish times differ.
+	time.Sleep(10 * time.Millisecond)
+	return url
+}
+
+func (s *mirrorSuite) assertMirrored(c *gc.C, id *charm.Reference) {
+	var meta struct {
+		ArchiveSize params.ArchiveSizeResponse
+	}
+	eid, err := s.local.Meta(id, &meta)
+	c.Assert(err, gc.IsNil)
+	c.Assert(eid, jc.DeepEquals, id)
+}
+
+func (s *mirrorSuite) assertNotMirrored(c *gc.C, id *charm.Reference) {
+	err := s.local.Get("/"+id.Path()+"/meta/id-revision", nil)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *mirrorSuite) TestSync(c *gc.C) {
+	wordpress := s.uploadCharm(c, "wordpress", "~bob/trusty/wordpress-3")
+	mysql := s.uploadCharm(c, "mysql", "~bob/precise/mysql-7")
+	err := s.upstream.PutExtraInfo(wordpress, map[string]interface{}{
+		"vcs": "https://example.com/wordpress",
+	})
+	c.Assert(err, gc.IsNil)
+	err = s.upstream.Put("/~bob/trusty/wordpress-3/promulgate", params.PromulgateRequest{
+		Promulgated: true,
+	})
+	c.Assert(err, gc.IsNil)
+	err = s.upstream.Put("/~bob/trusty/wordpress-3/meta/perm/read", []string{"bob", "friends"})
+	c.Assert(err, gc.IsNil)
+
+	m := mirror.New(mirror.Params{
+		Upstream: s.upstream,
+		Local:    s.local,
+	})
+	var state mirror.State
+	ids, err := m.Sync(&state)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, jc.DeepEquals, []*charm.Reference{wordpress, mysql})
+	c.Assert(state.LastPublished.IsZero(), gc.Equals, false)
+	s.assertMirrored(c, wordpress)
+	s.assertMirrored(c, mysql)
+
+	var meta struct {
+		ExtraInfo   map[string]interface{}
+		Perm        params.PermResponse
+		Promulgated params.PromulgatedResponse
+	}
+	_, err = s.local.Meta(wordpress, &meta)
+	c.Assert(err, gc.IsNil)
+	c.Assert(meta.ExtraInfo, jc.DeepEquals, map[string]interface{}{
+		"vcs": "https://example.com/wordpress",
+	})
+	c.Assert(meta.Promulgated.Promulgated, gc.Equals, true)
+	// Permissions are not copied by default.
+	c.Assert(meta.Perm.Read, jc.DeepEquals, []string{params.Everyone, "bob"})
+
+	// Syncing again resumes from the last synced change.
+	ids, err = m.Sync(&state)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.HasLen, 0)
+
+	wordpress4 := s.uploadCharm(c, "wordpress", "~bob/trusty/wordpress-4")
+	ids, err = m.Sync(&state)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, jc.DeepEquals, []*charm.Reference{wordpress4})
+	s.assertMirrored(c, wordpress4)
+}
+
+func (s *mirrorSuite) TestSyncPreservesACLs(c *gc.C) {
+	wordpress := s.uploadCharm(c, "wordpress", "~bob/trusty/wordpress-0")
+	err := s.upstream.Put("/~bob/trusty/wordpress-0/meta/perm/read", []string{"bob", "friends"})
+	c.Assert(err, gc.IsNil)
+	err = s.upstream.Put("/~bob/trusty/wordpress-0/meta/perm/write", []string{"bob", "editors"})
+	c.Assert(err, gc.IsNil)
+
+	m := mirror.New(mirror.Params{
+		Upstream:     s.upstream,
+		Local:        s.local,
+		PreserveACLs: true,
+	})
+	_, err = m.Sync(new(mirror.State))
+	c.Assert(err, gc.IsNil)
+	var meta struct {
+		Perm params.PermResponse
+	}
+	_, err = s.local.Meta(wordpress, &meta)
+	c.Assert(err, gc.IsNil)
+	c.Assert(meta.Perm, jc.DeepEquals, params.PermResponse{
+		Read:  []string{"bob", "friends"},
+		Write: []string{"bob", "editors"},
+	})
+}
+
+func (s *mirrorSuite) TestSyncKeepsPrivateAndHidden(c *gc.C) {
+	private := s.uploadCharm(c, "wordpress", "~bob/trusty/private-0")
+	err := s.upstream.Put("/~bob/trusty/private-0/meta/perm/read", []string{"bob"})
+	c.Assert(err, gc.IsNil)
+	hidden := s.uploadCharm(c, "mysql", "~bob/trusty/mysql-0")
+
+	// Hide the revision once it has been listed, as hidden
+	// revisions are not listed in changes/published.
+	upstreamHandler := s.upstreamSrv.Config.Handler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstreamHandler.ServeHTTP(w, req)
+		if strings.HasSuffix(req.URL.Path, "/changes/published") {
+			err := s.upstream.Put("/~bob/trusty/mysql-0/meta/hidden", params.HiddenResponse{
+				Hidden: true,
+			})
+			c.Check(err, gc.IsNil)
+		}
+	}))
+	defer srv.Close()
+
+	m := mirror.New(mirror.Params{
+		Upstream: csclient.New(csclient.Params{
+			URL:      srv.URL,
+			User:     serverParams.AuthUsername,
+			Password: serverParams.AuthPassword,
+		}),
+		Local: s.local,
+	})
+	ids, err := m.Sync(new(mirror.State))
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, jc.DeepEquals, []*charm.Reference{private, hidden})
+
+	for _, test := range []struct {
+		id     *charm.Reference
+		read   []string
+		hidden bool
+	}{{
+		id:   private,
+		read: []string{"bob"},
+	}, {
+		id:     hidden,
+		read:   []string{params.Everyone, "bob"},
+		hidden: true,
+	}} {
+		var meta struct {
+			Perm   params.PermResponse
+			Hidden params.HiddenResponse
+		}
+		_, err := s.local.Meta(test.id, &meta)
+		c.Assert(err, gc.IsNil)
+		c.Assert(meta.Perm.Read, jc.DeepEquals, test.read, gc.Commentf("%s", test.id))
+		c.Assert(meta.Hidden.Hidden, gc.Equals, test.hidden, gc.Commentf("%s", test.id))
+	}
+}
+
+func (s *mirrorSuite) TestSyncFilters(c *gc.C) {
+	bobWordpress := s.uploadCharm(c, "wordpress", "~bob/trusty/wordpress-0")
+	bobMysql := s.uploadCharm(c, "mysql", "~bob/precise/mysql-0")
+	aliceWordpress := s.uploadCharm(c, "wordpress", "~alice/trusty/wordpress-1")
+	charlieWordpress := s.uploadCharm(c, "wordpress", "~charlie/trusty/wordpress-2")
+
+	m := mirror.New(mirror.Params{
+		Upstream:   s.upstream,
+		Local:      s.local,
+		Namespaces: []string{"bob", "alice"},
+		Series:     []string{"trusty"},
+	})
+	ids, err := m.Sync(new(mirror.State))
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, jc.DeepEquals, []*charm.Reference{bobWordpress, aliceWordpress})
+	s.assertNotMirrored(c, bobMysql)
+	s.assertNotMirrored(c, charlieWordpress)
+}
+
+func (s *mirrorSuite) TestSyncSkipsExisting(c *gc.C) {
+	wordpress := s.uploadCharm(c, "wordpress", "~bob/trusty/wordpress-0")
+	err := s.local.UploadCharmWithRevision(wordpress, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+
+	m := mirror.New(mirror.Params{
+		Upstream: s.upstream,
+		Local:    s.local,
+	})
+	ids, err := m.Sync(new(mirror.State))
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.HasLen, 0)
+}
+
+func (s *mirrorSuite) TestSyncErrorResumes(c *gc.C) {
+	wordpress := s.uploadCharm(c, "wordpress", "~bob/trusty/wordpress-0")
+	s.uploadCharm(c, "mysql", "~bob/trusty/mysql-0")
+
+	// A local client without credentials cannot upload.
+	anonymous := csclient.New(csclient.Params{
+		URL: s.localSrv.URL,
+	})
+	m := mirror.New(mirror.Params{
+		Upstream: s.upstream,
+		Local:    anonymous,
+	})
+	var state mirror.State
+	ids, err := m.Sync(&state)
+	c.Assert(err, gc.ErrorMatches, `cannot copy "cs:~bob/trusty/wordpress-0": .*`)
+	c.Assert(ids, gc.HasLen, 0)
+	c.Assert(state.LastPublished.IsZero(), gc.Equals, true)
+
+	m = mirror.New(mirror.Params{
+		Upstream: s.upstream,
+		Local:    s.local,
+	})
+	ids, err = m.Sync(&state)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.HasLen, 2)
+	c.Assert(ids[0], jc.DeepEquals, wordpress)
+}
+
+func (s *mirrorSuite) TestSyncSkipsUnreadable(c *gc.C) {
+	wordpress := s.uploadCharm(c, "wordpress", "~bob/trusty/wordpress-0")
+	private := s.uploadCharm(c, "mysql", "~bob/trusty/private-0")
+	removed := s.uploadCharm(c, "mysql", "~bob/trusty/removed-0")
+	mysql := s.uploadCharm(c, "mysql", "~bob/trusty/mysql-0")
+
+	// Serve the upstream charm store through a server that
+	// cannot read the archives of some of the listed charms.
+	upstreamHandler := s.upstreamSrv.Config.Handler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var perr *params.Error
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/"+private.Path()+"/archive"):
+			perr = &params.Error{
+				Code:    params.ErrUnauthorized,
+				Message: "unauthorized",
+			}
+		case strings.HasSuffix(req.URL.Path, "/"+removed.Path()+"/archive"):
+			perr = &params.Error{
+				Code:    params.ErrNotFound,
+				Message: "not found",
+			}
+		default:
+			upstreamHandler.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if perr.Code == params.ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		json.NewEncoder(w).Encode(perr)
+	}))
+	defer srv.Close()
+
+	m := mirror.New(mirror.Params{
+		Upstream: csclient.New(csclient.Params{
+			URL:      srv.URL,
+			User:     serverParams.AuthUsername,
+			Password: serverParams.AuthPassword,
+		}),
+		Local: s.local,
+	})
+	var state mirror.State
+	ids, err := m.Sync(&state)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, jc.DeepEquals, []*charm.Reference{wordpress, mysql})
+	s.assertNotMirrored(c, private)
+	s.assertNotMirrored(c, removed)
+
+	// The next synchronisation starts after the skipped charms.
+	ids, err = m.Sync(&state)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.HasLen, 0)
+}
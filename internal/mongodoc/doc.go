@@ -4,9 +4,11 @@
 package mongodoc
 
 import (
+	"os"
 	"time"
 
 	"gopkg.in/juju/charm.v4"
+	"gopkg.in/mgo.v2/bson"
 )
 
 // Entity holds the in-database representation of charm or bundle's
@@ -99,6 +101,12 @@ type Entity struct {
 	// every time we access one of these files.
 	Contents map[FileId]ZipFile `json:",omitempty" bson:",omitempty"`
 
+	// Manifest holds an entry for every file and directory in the
+	// entity's archive blob, in archive order. It is computed when
+	// the entity is uploaded so that the archive's central directory
+	// need not be read again. It is not included in search documents.
+	Manifest []ManifestFile `json:"-" bson:",omitempty"`
+
 	// PromulgatedURL holds the promulgated URL of the entity. If the entity
 	// is not promulgated this should be set to nil.
 	PromulgatedURL *charm.Reference `bson:"promulgated-url,omitempty"`
@@ -106,6 +114,31 @@ type Entity struct {
 	// PromulgatedRevision holds the revision number from the promulgated URL.
 	// If the entity is not promulgated this should be set to -1.
 	PromulgatedRevision int `bson:"promulgated-revision"`
+
+	// Hidden specifies whether this revision is hidden from users
+	// who do not have write access to the charm or bundle.
+	// Hidden revisions are never chosen when resolving
+	// partially specified ids, and are not indexed for search.
+	Hidden bool `bson:",omitempty" json:",omitempty"`
+
+	// LintWarnings holds the warnings produced by the
+	// upload validators when the entity was uploaded.
+	LintWarnings []LintWarning `bson:",omitempty" json:",omitempty"`
+
+	// ReleaseNotes holds the release notes for this revision. They
+	// are extracted from the CHANGELOG file in the archive when the
+	// entity is uploaded, and may be changed afterwards.
+	ReleaseNotes string `bson:",omitempty" json:",omitempty"`
+}
+
+// LintWarning holds a warning produced by an upload validator.
+type LintWarning struct {
+	// Validator holds the name of the validator
+	// that produced the warning.
+	Validator string
+
+	// Message holds the warning message.
+	Message string
 }
 
 // BaseEntity holds metadata for a charm or bundle
@@ -158,6 +191,9 @@ const (
 
 // ZipFile refers to a specific file in the uploaded archive blob.
 type ZipFile struct {
+	// Name holds the name of the file in the archive.
+	Name string `bson:",omitempty"`
+
 	// Compressed specifies whether the file is compressed or not.
 	Compressed bool
 
@@ -167,6 +203,12 @@ type ZipFile struct {
 
 	// Size holds the size of the file before decompression.
 	Size int64
+
+	// Data holds the processed form of the file, if it
+	// needs to be changed before being served (for instance
+	// a sanitized icon). If it is nil, the file is served
+	// from the archive as is.
+	Data []byte `bson:",omitempty"`
 }
 
 // Valid reports whether f is a valid (non-zero) reference to
@@ -174,7 +216,67 @@ type ZipFile struct {
 func (f ZipFile) IsValid() bool {
 	// Note that no valid zip files can start at offset zero,
 	// because that's where the zip header lives.
-	return f != ZipFile{}
+	return f.Offset != 0 || f.Size != 0 || f.Compressed
+}
+
+// ManifestFile holds information about a file in an
+// uploaded archive blob.
+type ManifestFile struct {
+	// Name holds the name of the file in the archive.
+	Name string
+
+	// Size holds the size of the file after decompression.
+	Size int64
+
+	// CompressedSize holds the size of the file data
+	// as stored in the archive.
+	CompressedSize int64
+
+	// Offset holds the offset into the zip archive of the
+	// start of the file's data.
+	Offset int64
+
+	// Compressed specifies whether the file is compressed or not.
+	Compressed bool
+
+	// CRC32 holds the CRC-32 checksum of the uncompressed file data.
+	CRC32 uint32
+
+	// Mode holds the file's permission and mode bits.
+	Mode os.FileMode
+
+	// ModTime holds the file's modification time.
+	ModTime time.Time
+}
+
+// IsDir reports whether f refers to a directory.
+func (f ManifestFile) IsDir() bool {
+	return f.Mode.IsDir()
+}
+
+// ZipFile returns a reference to the data of f
+// suitable for reading with charmstore.ZipFileReader.
+func (f ManifestFile) ZipFile() ZipFile {
+	return ZipFile{
+		Name:       f.Name,
+		Compressed: f.Compressed,
+		Offset:     f.Offset,
+		Size:       f.CompressedSize,
+	}
+}
+
+// RenderedReadMe holds a README file rendered as HTML.
+type RenderedReadMe struct {
+	// BlobHash holds the hash of the archive blob
+	// holding the README file.
+	BlobHash string `bson:"_id"`
+
+	// Version holds the version of the renderer
+	// that produced HTML.
+	Version int
+
+	// HTML holds the rendered README file.
+	HTML []byte
 }
 
 // Log holds the in-database representation of a log message sent to the charm
@@ -230,3 +332,152 @@ type Migration struct {
 	// Executed holds the migration names for migrations already executed.
 	Executed []string
 }
+
+// Webhook holds a subscription to charm store events.
+type Webhook struct {
+	// Id holds the unique identifier of the webhook.
+	Id string `bson:"_id"`
+
+	// Namespace holds the user or team whose charms and bundles
+	// the webhook is notified about. If it is empty, the webhook
+	// is notified about all charms and bundles.
+	Namespace string
+
+	// URL holds the URL that event payloads are posted to.
+	URL string
+
+	// Secret holds the key used to sign the event payloads.
+	Secret string
+
+	// Events holds the events the webhook is notified about.
+	// If it is empty, the webhook is notified about all events.
+	Events []string
+
+	// CreatedTime holds the time the webhook was created.
+	CreatedTime time.Time
+}
+
+// WebhookDeliveryStatus holds the status of a webhook delivery.
+type WebhookDeliveryStatus string
+
+const (
+	// DeliveryPending is the status of a delivery that has not
+	// yet succeeded but will be attempted again.
+	DeliveryPending WebhookDeliveryStatus = "pending"
+
+	// DeliveryDelivered is the status of a delivery that
+	// has succeeded.
+	DeliveryDelivered WebhookDeliveryStatus = "delivered"
+
+	// DeliveryFailed is the status of a delivery that has
+	// failed too many times to be attempted again.
+	DeliveryFailed WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery holds the in-database representation of an event
+// payload sent, or to be sent, to a webhook.
+type WebhookDelivery struct {
+	Id bson.ObjectId `bson:"_id"`
+
+	// WebhookId holds the id of the webhook the payload is sent to.
+	WebhookId string
+
+	// Event holds the name of the event.
+	Event string
+
+	// Payload holds the JSON-encoded event payload.
+	Payload []byte
+
+	// CreatedTime holds the time the event happened.
+	CreatedTime time.Time
+
+	// Status holds the status of the delivery.
+	Status WebhookDeliveryStatus
+
+	// Attempts holds the delivery attempts made so far,
+	// oldest first.
+	Attempts []WebhookAttempt
+
+	// NextAttempt holds the earliest time the next delivery
+	// attempt can be made. While an attempt is in progress,
+	// it holds the time the attempt is abandoned so that
+	// the delivery can be attempted again.
+	NextAttempt time.Time
+}
+
+// WebhookAttempt holds the outcome of an attempt to deliver
+// an event payload to a webhook.
+type WebhookAttempt struct {
+	// Time holds the time the attempt was made.
+	Time time.Time
+
+	// StatusCode holds the HTTP status code of the response,
+	// or zero if no response was received.
+	StatusCode int `bson:",omitempty"`
+
+	// Error holds a description of why the attempt failed.
+	// It is empty if the attempt succeeded.
+	Error string `bson:",omitempty"`
+}
+
+// Change holds an entry in the log of changes made to
+// the charm store.
+type Change struct {
+	// Id holds the sequence number of the change. Changes
+	// are numbered from 1 in the order they are made.
+	Id int64 `bson:"_id"`
+
+	// Event holds the kind of change, as one of the
+	// params.WebhookEvent values.
+	Event string
+
+	// ReadACL holds the users and groups allowed to see the change:
+	// those allowed to read the charm or bundle when the change was
+	// made, or to write it for changes to hidden revisions. The
+	// administrator can see all changes.
+	ReadACL []string `bson:",omitempty"`
+
+	// Payload holds the JSON-encoded params.WebhookPayload
+	// describing the change.
+	Payload []byte
+
+	// Time holds the time the change was made.
+	Time time.Time
+}
+
+// Upload holds an archive upload made in parts.
+type Upload struct {
+	// Id holds the unique id of the upload.
+	Id string `bson:"_id"`
+
+	// URL holds the id of the charm or bundle being
+	// uploaded, with no revision.
+	URL *charm.Reference
+
+	// User holds the user part of URL, so that
+	// the uploads to a user's charms and bundles
+	// can be counted.
+	User string
+
+	// Parts holds the parts uploaded so far,
+	// keyed by decimal part number.
+	Parts map[string]UploadPart
+
+	// Expires holds the time after which the upload
+	// is discarded. It is extended each time a part
+	// is uploaded.
+	Expires time.Time
+}
+
+// UploadPart holds a part of an archive upload.
+type UploadPart struct {
+	// BlobName holds the name of the blob holding
+	// the part contents.
+	BlobName string
+
+	// Hash holds the SHA384 hash of the part contents.
+	Hash string
+
+	// Size holds the size of the part contents.
+	Size int64
+}
@@ -0,0 +1,494 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package readme
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// renderMarkdown renders the given Markdown lines, nested inside
+// depth lists or block quotes, as HTML. If tight is true, paragraphs
+// are written without enclosing <p> tags, as used in the items of
+// tight lists.
+func renderMarkdown(buf *bytes.Buffer, lines []string, tight bool, depth int) {
+	if depth > maxBlockDepth {
+		writePre(buf, lines)
+		return
+	}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			i++
+		case isFence(trimmed):
+			fence := trimmed[:3]
+			j := i + 1
+			for j < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[j]), fence) {
+				j++
+			}
+			writePre(buf, dedent(lines[i+1:j], indentation(line)))
+			i = j + 1
+		case indentation(line) >= 4:
+			j := i
+			for j < len(lines) && (indentation(lines[j]) >= 4 || isBlank(lines[j])) {
+				j++
+			}
+			writePre(buf, dedent(trimBlankLines(lines[i:j]), 4))
+			i = j
+		case atxLevel(trimmed) > 0:
+			level := atxLevel(trimmed)
+			text := strings.TrimSpace(strings.TrimRight(trimmed[level:], "#"))
+			writeMarkdownHeading(buf, level, text)
+			i++
+		case isMarkdownRule(trimmed):
+			buf.WriteString("<hr>\n")
+			i++
+		case strings.HasPrefix(trimmed, ">"):
+			var quoted []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				l := strings.TrimSpace(lines[i])
+				l = strings.TrimPrefix(l, ">")
+				l = strings.TrimPrefix(l, " ")
+				quoted = append(quoted, l)
+			}
+			buf.WriteString("<blockquote>\n")
+			renderMarkdown(buf, quoted, false, depth+1)
+			buf.WriteString("</blockquote>\n")
+		case isListItem(line):
+			i = renderMarkdownList(buf, lines, i, depth)
+		default:
+			i = renderMarkdownParagraph(buf, lines, i, tight)
+		}
+	}
+}
+
+// renderMarkdownParagraph renders the paragraph (or setext heading)
+// starting at lines[i] and returns the index of the first line
+// after it.
+func renderMarkdownParagraph(buf *bytes.Buffer, lines []string, i int, tight bool) int {
+	var para []string
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if len(para) > 0 {
+			if level := setextLevel(trimmed); level > 0 {
+				writeMarkdownHeading(buf, level, strings.Join(para, "\n"))
+				return i + 1
+			}
+			if startsMarkdownBlock(lines[i]) {
+				break
+			}
+		}
+		if trimmed == "" {
+			break
+		}
+		para = append(para, trimmed)
+	}
+	if !tight {
+		buf.WriteString("<p>")
+	}
+	buf.WriteString(markdownInline(strings.Join(para, "\n")))
+	if !tight {
+		buf.WriteString("</p>")
+	}
+	buf.WriteString("\n")
+	return i
+}
+
+// renderMarkdownList renders the list starting at lines[i],
+// nested inside depth lists or block quotes, and returns the
+// index of the first line after it.
+func renderMarkdownList(buf *bytes.Buffer, lines []string, i int, depth int) int {
+	ordered, _ := listMarker(lines[i])
+	var items [][]string
+	loose := false
+	for i < len(lines) {
+		itemOrdered, width := listMarker(lines[i])
+		if width == 0 || itemOrdered != ordered {
+			break
+		}
+		markerIndent := indentation(lines[i])
+		body := []string{lines[i][width:]}
+		i++
+	item:
+		for i < len(lines) {
+			line := lines[i]
+			switch {
+			case isBlank(line):
+				// A blank line continues the item only if it
+				// is followed by an indented line.
+				j := i
+				for j < len(lines) && isBlank(lines[j]) {
+					j++
+				}
+				if j == len(lines) || indentation(lines[j]) <= markerIndent {
+					break item
+				}
+				loose = true
+				body = append(body, lines[i:j]...)
+				i = j
+			case indentation(line) > markerIndent:
+				body = append(body, dedent([]string{line}, width)[0])
+				i++
+			case startsMarkdownBlock(line):
+				break item
+			default:
+				// Lazy continuation line.
+				body = append(body, strings.TrimSpace(line))
+				i++
+			}
+		}
+		items = append(items, body)
+		// Blank lines between items make the list loose.
+		j := i
+		for j < len(lines) && isBlank(lines[j]) {
+			j++
+		}
+		if j == i || j == len(lines) {
+			continue
+		}
+		if o, w := listMarker(lines[j]); w == 0 || o != ordered {
+			break
+		}
+		loose = true
+		i = j
+	}
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	fmt.Fprintf(buf, "<%s>\n", tag)
+	for _, body := range items {
+		buf.WriteString("<li>")
+		var item bytes.Buffer
+		renderMarkdown(&item, body, !loose, depth+1)
+		buf.WriteString(strings.TrimSuffix(item.String(), "\n"))
+		buf.WriteString("</li>\n")
+	}
+	fmt.Fprintf(buf, "</%s>\n", tag)
+	return i
+}
+
+func writeMarkdownHeading(buf *bytes.Buffer, level int, text string) {
+	fmt.Fprintf(buf, "<h%d>%s</h%d>\n", level, markdownInline(text), level)
+}
+
+// startsMarkdownBlock reports whether the given line
+// interrupts a paragraph by starting another block.
+func startsMarkdownBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return isFence(trimmed) ||
+		atxLevel(trimmed) > 0 ||
+		isMarkdownRule(trimmed) ||
+		strings.HasPrefix(trimmed, ">") ||
+		isListItem(line)
+}
+
+func isFence(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+}
+
+// atxLevel returns the level of the ATX heading
+// ("# Heading") in the given trimmed line, or zero
+// if it is not a heading.
+func atxLevel(trimmed string) int {
+	level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+	if level == 0 || level > 6 {
+		return 0
+	}
+	if len(trimmed) > level && trimmed[level] != ' ' {
+		return 0
+	}
+	return level
+}
+
+// setextLevel returns the level of the heading underlined
+// by the given trimmed line, or zero if it is not
+// a heading underline.
+func setextLevel(trimmed string) int {
+	switch {
+	case trimmed == "":
+		return 0
+	case strings.Trim(trimmed, "=") == "":
+		return 1
+	case strings.Trim(trimmed, "-") == "":
+		return 2
+	}
+	return 0
+}
+
+// isMarkdownRule reports whether the given trimmed
+// line is a horizontal rule.
+func isMarkdownRule(trimmed string) bool {
+	s := strings.Replace(trimmed, " ", "", -1)
+	if len(s) < 3 {
+		return false
+	}
+	for _, c := range "-*_" {
+		if strings.Trim(s, string(c)) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isListItem(line string) bool {
+	_, width := listMarker(line)
+	return width > 0 && !isMarkdownRule(strings.TrimSpace(line))
+}
+
+// listMarker returns whether the given line starts an item
+// of an ordered list, and the width of the item marker,
+// including its indentation and the following space.
+// The width is zero if the line does not start a list item.
+func listMarker(line string) (ordered bool, width int) {
+	indent := indentation(line)
+	if indent > 3 {
+		return false, 0
+	}
+	s := line[indent:]
+	n := 0
+	if len(s) > 0 && strings.IndexByte("-*+", s[0]) >= 0 {
+		n = 1
+	} else {
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		if n == 0 || n > 9 || n == len(s) || (s[n] != '.' && s[n] != ')') {
+			return false, 0
+		}
+		n++
+		ordered = true
+	}
+	switch {
+	case n == len(s):
+		return ordered, indent + n
+	case s[n] == ' ':
+		return ordered, indent + n + 1
+	}
+	return false, 0
+}
+
+// maxInlineDepth holds the maximum nesting of the emphasis and
+// links rendered by markdownInline. More deeply nested markup is
+// rendered as text, so that the rendering time is bounded.
+const maxInlineDepth = 8
+
+// markdownInline renders the inline Markdown
+// markup in the given text as HTML.
+func markdownInline(text string) string {
+	return newInlineRenderer(text, 0, true).render()
+}
+
+// inlineRenderer renders the inline Markdown markup in a text.
+// The closing delimiters are looked up in tables computed once
+// for the whole text, and delimiters found not to be closed are
+// remembered, so that each level of nesting is rendered in time
+// linear in the length of the text.
+type inlineRenderer struct {
+	text  string
+	depth int
+
+	// links holds whether links are rendered. Links are
+	// not rendered inside link labels.
+	links bool
+
+	// closers maps the index of each '[' and '(' in text
+	// to the index of the matching ']' or ')', or -1 if
+	// there is none.
+	closers map[int]int
+
+	// unclosed holds the delimiters of code spans and emphasis
+	// that are known not to be closed after the current position.
+	unclosed map[string]bool
+}
+
+func newInlineRenderer(text string, depth int, links bool) *inlineRenderer {
+	r := &inlineRenderer{
+		text:     text,
+		depth:    depth,
+		links:    links,
+		closers:  make(map[int]int),
+		unclosed: make(map[string]bool),
+	}
+	var brackets, parens []int
+	match := func(open []int, i int) []int {
+		if n := len(open); n > 0 {
+			r.closers[open[n-1]] = i
+			return open[:n-1]
+		}
+		return open
+	}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '[':
+			brackets = append(brackets, i)
+			r.closers[i] = -1
+		case '(':
+			parens = append(parens, i)
+			r.closers[i] = -1
+		case ']':
+			brackets = match(brackets, i)
+		case ')':
+			parens = match(parens, i)
+		}
+	}
+	return r
+}
+
+// inline renders the given text, nested inside the text being
+// rendered by r, with links rendered only if links is true.
+func (r *inlineRenderer) inline(text string, links bool) string {
+	if r.depth+1 >= maxInlineDepth {
+		return html.EscapeString(text)
+	}
+	return newInlineRenderer(text, r.depth+1, r.links && links).render()
+}
+
+func (r *inlineRenderer) render() string {
+	text := r.text
+	var buf bytes.Buffer
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && isPunct(text[i+1]):
+			buf.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			n := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			ticks := text[i : i+n]
+			if end := r.index(i+n, ticks); end >= 0 {
+				code := strings.TrimSpace(text[i+n : end])
+				buf.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i = end + n
+				continue
+			}
+			buf.WriteString(ticks)
+			i += n
+			continue
+		case c == '*' || c == '_':
+			if strings.HasPrefix(text[i:], "**") || strings.HasPrefix(text[i:], "__") {
+				delim := text[i : i+2]
+				if end := r.emphasisEnd(i+2, delim); end >= 0 {
+					buf.WriteString("<strong>" + r.inline(text[i+2:end], true) + "</strong>")
+					i = end + 2
+					continue
+				}
+			} else if c == '*' || i == 0 || !isWordChar(text[i-1]) {
+				if end := r.emphasisEnd(i+1, text[i:i+1]); end >= 0 {
+					buf.WriteString("<em>" + r.inline(text[i+1:end], true) + "</em>")
+					i = end + 1
+					continue
+				}
+			}
+		case c == '!' && strings.HasPrefix(text[i+1:], "["):
+			if label, target, end := r.link(i + 1); end > 0 {
+				if src, ok := safeURL(target); ok {
+					fmt.Fprintf(&buf, `<img src="%s" alt="%s">`, html.EscapeString(src), html.EscapeString(label))
+				} else {
+					buf.WriteString(html.EscapeString(label))
+				}
+				i = end
+				continue
+			}
+		case c == '[' && r.links:
+			if label, target, end := r.link(i); end > 0 {
+				writeLink(&buf, target, r.inline(label, false))
+				i = end
+				continue
+			}
+		case c == '<':
+			// Autolinks cannot hold spaces or '<', so
+			// the search for their end stops there.
+			end := strings.IndexAny(text[i+1:], " \t\n<>")
+			if end >= 0 && text[i+1+end] == '>' {
+				target := text[i+1 : i+1+end]
+				if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+					writeLink(&buf, target, html.EscapeString(target))
+					i += end + 2
+					continue
+				}
+			}
+		}
+		buf.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+	return buf.String()
+}
+
+// index returns the index of the first occurrence
+// of delim in the text at or after start, or -1.
+func (r *inlineRenderer) index(start int, delim string) int {
+	if r.unclosed[delim] {
+		return -1
+	}
+	i := strings.Index(r.text[start:], delim)
+	if i == -1 {
+		r.unclosed[delim] = true
+		return -1
+	}
+	return start + i
+}
+
+// emphasisEnd returns the index of the delimiter closing
+// the emphasis that starts at text[start], or -1 if there is none.
+func (r *inlineRenderer) emphasisEnd(start int, delim string) int {
+	text := r.text
+	if start >= len(text) || text[start] == ' ' || text[start] == '\n' {
+		return -1
+	}
+	if r.unclosed[delim] {
+		return -1
+	}
+	for i := start + 1; i <= len(text)-len(delim); i++ {
+		if text[i] == '\\' {
+			i++
+			continue
+		}
+		if !strings.HasPrefix(text[i:], delim) || text[i-1] == ' ' {
+			continue
+		}
+		if delim[0] == '_' && i+len(delim) < len(text) && isWordChar(text[i+len(delim)]) {
+			continue
+		}
+		return i
+	}
+	r.unclosed[delim] = true
+	return -1
+}
+
+// link parses a link of the form [label](target) starting at
+// text[start] and returns its label and target, and the index
+// of the end of the link markup. The end is zero if there is
+// no link at start.
+func (r *inlineRenderer) link(start int) (label, target string, end int) {
+	text := r.text
+	i := r.closers[start]
+	if i < 0 || !strings.HasPrefix(text[i+1:], "(") {
+		return "", "", 0
+	}
+	j := r.closers[i+1]
+	if j < 0 {
+		return "", "", 0
+	}
+	target = strings.TrimSpace(text[i+2 : j])
+	// Remove any title.
+	if k := strings.IndexAny(target, " \t\n"); k >= 0 {
+		target = target[:k]
+	}
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	return text[start+1 : i], target, j + 1
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("\\`*_{}[]()#+-.!<>", c) >= 0
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
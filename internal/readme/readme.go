@@ -0,0 +1,211 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The readme package renders the README files found in charms
+// and bundles as HTML.
+//
+// Only a commonly used subset of Markdown and reStructuredText is
+// understood. The produced HTML is safe to serve from the charm store
+// origin: all text in the source is escaped, so raw HTML is never
+// passed through, and only links to http, https and mailto URLs, or
+// URLs relative to the README, are retained.
+package readme
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Version holds the version of the renderer. It must be incremented
+// whenever the rendered output changes, so that cached renderings
+// can be invalidated.
+const Version = 2
+
+// MaxSize holds the maximum size in bytes of the README
+// contents rendered by Render. Any content after that
+// is not rendered.
+const MaxSize = 512 * 1024
+
+// maxBlockDepth holds the maximum nesting of lists and block
+// quotes. More deeply nested blocks are rendered as preformatted
+// text, so that the rendering time is bounded.
+const maxBlockDepth = 16
+
+// HTMLContentType holds the content type of rendered README files.
+const HTMLContentType = "text/html; charset=utf-8"
+
+// Format holds the markup format of a README file.
+type Format string
+
+const (
+	Markdown         Format = "markdown"
+	ReStructuredText Format = "rst"
+	PlainText        Format = "text"
+)
+
+// FormatOf returns the format of the README file
+// with the given name, as determined by its extension.
+func FormatOf(name string) Format {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return Markdown
+	case ".rst":
+		return ReStructuredText
+	}
+	return PlainText
+}
+
+// ContentType returns the content type of
+// README files with the format f.
+func (f Format) ContentType() string {
+	switch f {
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	case ReStructuredText:
+		return "text/x-rst; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Render renders the given README contents, held in the format f,
+// as an HTML fragment. Only the lines held in the first MaxSize
+// bytes of data are rendered.
+func Render(data []byte, f Format) []byte {
+	if len(data) > MaxSize {
+		data = data[:MaxSize]
+		if i := bytes.LastIndex(data, []byte("\n")); i >= 0 {
+			data = data[:i]
+		}
+	}
+	var buf bytes.Buffer
+	lines := splitLines(string(data))
+	switch f {
+	case Markdown:
+		renderMarkdown(&buf, lines, false, 0)
+	case ReStructuredText:
+		var r rstRenderer
+		r.render(&buf, lines)
+	default:
+		writePre(&buf, lines)
+	}
+	return buf.Bytes()
+}
+
+// splitLines splits the given text into lines,
+// expanding any tabs in their indentation.
+func splitLines(text string) []string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = expandIndentTabs(line)
+	}
+	return lines
+}
+
+// expandIndentTabs replaces the tabs in the indentation
+// of the given line with spaces, with tab stops every
+// four columns.
+func expandIndentTabs(line string) string {
+	if !strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+		return line
+	}
+	col := 0
+	for i, r := range line {
+		switch r {
+		case ' ':
+			col++
+		case '\t':
+			col += 4 - col%4
+		default:
+			return strings.Repeat(" ", col) + line[i:]
+		}
+	}
+	return ""
+}
+
+// indentation returns the number of spaces
+// at the start of the given line.
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// dedent removes up to n spaces from the
+// start of each of the given lines.
+func dedent(lines []string, n int) []string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		if indent := indentation(line); indent < n {
+			result[i] = line[indent:]
+		} else {
+			result[i] = line[n:]
+		}
+	}
+	return result
+}
+
+// isBlank reports whether the given line is empty.
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// trimBlankLines removes blank lines from the
+// start and the end of the given lines.
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && isBlank(lines[0]) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && isBlank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// writePre writes the given lines as a preformatted block.
+func writePre(buf *bytes.Buffer, lines []string) {
+	buf.WriteString("<pre>")
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(html.EscapeString(line))
+	}
+	buf.WriteString("</pre>\n")
+}
+
+// safeURL returns the given link target if it is safe to include
+// in the rendered HTML, and reports whether it is.
+func safeURL(target string) (string, bool) {
+	target = strings.TrimSpace(target)
+	u, err := url.Parse(target)
+	if err != nil || target == "" {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return target, true
+	}
+	return "", false
+}
+
+// writeLink writes a link to the given target with the given
+// (already rendered) text. If the target is not safe, only
+// the text is written.
+func writeLink(buf *bytes.Buffer, target, text string) {
+	target, ok := safeURL(target)
+	if !ok {
+		buf.WriteString(text)
+		return
+	}
+	buf.WriteString(`<a href="`)
+	buf.WriteString(html.EscapeString(target))
+	buf.WriteString(`">`)
+	buf.WriteString(text)
+	buf.WriteString("</a>")
+}
@@ -0,0 +1,233 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package readme_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/charmstore/internal/readme"
+)
+
+func TestPackage(t *testing.T) {
+	gc.TestingT(t)
+}
+
+type readmeSuite struct{}
+
+var _ = gc.Suite(&readmeSuite{})
+
+var formatOfTests = []struct {
+	name              string
+	expectFormat      readme.Format
+	expectContentType string
+}{{
+	name:              "README.md",
+	expectFormat:      readme.Markdown,
+	expectContentType: "text/markdown; charset=utf-8",
+}, {
+	name:              "readme.markdown",
+	expectFormat:      readme.Markdown,
+	expectContentType: "text/markdown; charset=utf-8",
+}, {
+	name:              "README.rst",
+	expectFormat:      readme.ReStructuredText,
+	expectContentType: "text/x-rst; charset=utf-8",
+}, {
+	name:              "README",
+	expectFormat:      readme.PlainText,
+	expectContentType: "text/plain; charset=utf-8",
+}, {
+	name:              "readme.txt",
+	expectFormat:      readme.PlainText,
+	expectContentType: "text/plain; charset=utf-8",
+}}
+
+func (s *readmeSuite) TestFormatOf(c *gc.C) {
+	for i, test := range formatOfTests {
+		c.Logf("test %d: %s", i, test.name)
+		f := readme.FormatOf(test.name)
+		c.Assert(f, gc.Equals, test.expectFormat)
+		c.Assert(f.ContentType(), gc.Equals, test.expectContentType)
+	}
+}
+
+var renderTests = []struct {
+	about  string
+	format readme.Format
+	text   string
+	expect string
+}{{
+	about:  "plain text",
+	format: readme.PlainText,
+	text:   "Hello <world>\n  & all\n",
+	expect: "<pre>Hello &lt;world&gt;\n  &amp; all</pre>\n",
+}, {
+	about:  "empty",
+	format: readme.Markdown,
+	text:   "",
+	expect: "",
+}, {
+	about:  "markdown paragraphs",
+	format: readme.Markdown,
+	text:   "Hello\nworld.\n\nAnother paragraph.\n",
+	expect: "<p>Hello\nworld.</p>\n<p>Another paragraph.</p>\n",
+}, {
+	about:  "markdown headings",
+	format: readme.Markdown,
+	text:   "# Title #\n\nIntro\n=====\n\nUsage\n-----\n\n### Details\n",
+	expect: "<h1>Title</h1>\n<h1>Intro</h1>\n<h2>Usage</h2>\n<h3>Details</h3>\n",
+}, {
+	about:  "markdown inline markup",
+	format: readme.Markdown,
+	text:   "Some *emphasis*, **strong** text, `code <b>` and snake_case_names \\*not emphasis\\*.",
+	expect: "<p>Some <em>emphasis</em>, <strong>strong</strong> text, <code>code &lt;b&gt;</code> and snake_case_names *not emphasis*.</p>\n",
+}, {
+	about:  "markdown links",
+	format: readme.Markdown,
+	text:   "See [the *docs*](https://example.com/docs \"Docs\"), [config](config.yaml), <http://example.com> and ![logo](http://example.com/logo.png).",
+	expect: `<p>See <a href="https://example.com/docs">the <em>docs</em></a>, <a href="config.yaml">config</a>, <a href="http://example.com">http://example.com</a> and <img src="http://example.com/logo.png" alt="logo">.</p>` + "\n",
+}, {
+	about:  "markdown links do not nest",
+	format: readme.Markdown,
+	text:   "[a [b](c) d](e) [unclosed [link](f)",
+	expect: `<p><a href="e">a [b](c) d</a> [unclosed <a href="f">link</a></p>` + "\n",
+}, {
+	about:  "markdown unsafe links",
+	format: readme.Markdown,
+	text:   "[click](javascript:alert(1)) ![x](data:image/svg+xml,foo)",
+	expect: "<p>click x</p>\n",
+}, {
+	about:  "markdown raw html is escaped",
+	format: readme.Markdown,
+	text:   "<script>alert(\"hello\")</script>\n\n<img src=x onerror=\"evil()\">",
+	expect: "<p>&lt;script&gt;alert(&#34;hello&#34;)&lt;/script&gt;</p>\n<p>&lt;img src=x onerror=&#34;evil()&#34;&gt;</p>\n",
+}, {
+	about:  "markdown code blocks",
+	format: readme.Markdown,
+	text:   "Run:\n\n    juju deploy foo\n    juju expose <foo>\n\n```bash\njuju status\n```\n",
+	expect: "<p>Run:</p>\n<pre>juju deploy foo\njuju expose &lt;foo&gt;</pre>\n<pre>juju status</pre>\n",
+}, {
+	about:  "markdown tight lists",
+	format: readme.Markdown,
+	text:   "- one\n- two\n  continued\n  - nested\n\n1. first\n2. second\n",
+	expect: "<ul>\n<li>one</li>\n<li>two\ncontinued\n<ul>\n<li>nested</li>\n</ul></li>\n</ul>\n<ol>\n<li>first</li>\n<li>second</li>\n</ol>\n",
+}, {
+	about:  "markdown loose list",
+	format: readme.Markdown,
+	text:   "* one\n\n* two\n\n  more about two\n",
+	expect: "<ul>\n<li><p>one</p></li>\n<li><p>two</p>\n<p>more about two</p></li>\n</ul>\n",
+}, {
+	about:  "markdown blockquote and rule",
+	format: readme.Markdown,
+	text:   "> quoted\n> *text*\n\n---\n",
+	expect: "<blockquote>\n<p>quoted\n<em>text</em></p>\n</blockquote>\n<hr>\n",
+}, {
+	about:  "rst sections",
+	format: readme.ReStructuredText,
+	text:   "=====\nTitle\n=====\n\nOverview\n========\n\nText.\n\nDetails\n-------\n\nMore\n========\n",
+	expect: "<h1>Title</h1>\n<h2>Overview</h2>\n<p>Text.</p>\n<h3>Details</h3>\n<h2>More</h2>\n",
+}, {
+	about:  "rst inline markup",
+	format: readme.ReStructuredText,
+	text:   "Some *emphasis*, **strong**, ``code <b>``, `a link <http://example.com/>`_, `bad <javascript:alert(1)>`_ and `interpreted`.",
+	expect: `<p>Some <em>emphasis</em>, <strong>strong</strong>, <code>code &lt;b&gt;</code>, <a href="http://example.com/">a link</a>, bad and <em>interpreted</em>.</p>` + "\n",
+}, {
+	about:  "rst literal blocks",
+	format: readme.ReStructuredText,
+	text:   "Deploy it::\n\n    juju deploy foo\n      --to 0\n\nThen:\n\n::\n\n    juju status\n\n.. code-block:: bash\n\n   juju expose foo\n",
+	expect: "<p>Deploy it:</p>\n<pre>juju deploy foo\n  --to 0</pre>\n<p>Then:</p>\n<pre>juju status</pre>\n<pre>juju expose foo</pre>\n",
+}, {
+	about:  "rst lists",
+	format: readme.ReStructuredText,
+	text:   "- one\n- two\n  continued\n\n1. first\n2. second\n\n#. auto\n",
+	expect: "<ul>\n<li>one</li>\n<li><p>two\ncontinued</p></li>\n</ul>\n<ol>\n<li>first</li>\n<li>second</li>\n<li>auto</li>\n</ol>\n",
+}, {
+	about:  "rst comments and directives are not rendered",
+	format: readme.ReStructuredText,
+	text:   ".. comment\n   more comment\n\n.. raw:: html\n\n   <script>evil()</script>\n\nText\n",
+	expect: "<p>Text</p>\n",
+}, {
+	about:  "rst block quotes and transitions",
+	format: readme.ReStructuredText,
+	text:   "Text\n\n   Quoted <text>\n\n----\n\nEnd\n",
+	expect: "<p>Text</p>\n<blockquote>\n<p>Quoted &lt;text&gt;</p>\n</blockquote>\n<hr>\n<p>End</p>\n",
+}}
+
+func (s *readmeSuite) TestRender(c *gc.C) {
+	for i, test := range renderTests {
+		c.Logf("test %d: %s", i, test.about)
+		c.Assert(string(readme.Render([]byte(test.text), test.format)), gc.Equals, test.expect)
+	}
+}
+
+func (s *readmeSuite) TestRenderTruncatesLargeContents(c *gc.C) {
+	text := "first line\n" + strings.Repeat("x", readme.MaxSize) + "\n"
+	c.Assert(string(readme.Render([]byte(text), readme.PlainText)), gc.Equals, "<pre>first line</pre>\n")
+}
+
+var renderTimeTests = []struct {
+	about  string
+	format readme.Format
+	text   string
+}{{
+	about:  "markdown unclosed links",
+	format: readme.Markdown,
+	text:   strings.Repeat("[", 100000),
+}, {
+	about:  "markdown unclosed link targets",
+	format: readme.Markdown,
+	text:   strings.Repeat("[a](", 50000),
+}, {
+	about:  "markdown unclosed emphasis",
+	format: readme.Markdown,
+	text:   strings.Repeat("*a _b **c ", 20000),
+}, {
+	about:  "markdown unclosed code spans",
+	format: readme.Markdown,
+	text:   strings.Repeat("`a ``b ", 20000),
+}, {
+	about:  "markdown unclosed autolinks",
+	format: readme.Markdown,
+	text:   strings.Repeat("<http://", 20000),
+}, {
+	about:  "markdown nested emphasis",
+	format: readme.Markdown,
+	text:   strings.Repeat("*a ", 20000) + strings.Repeat("a*", 20000),
+}, {
+	about:  "markdown nested block quotes",
+	format: readme.Markdown,
+	text:   strings.Repeat(">", 20000) + " a\n",
+}, {
+	about:  "markdown nested lists",
+	format: readme.Markdown,
+	text:   strings.Repeat("- ", 20000) + "a\n",
+}, {
+	about:  "rst nested block quotes",
+	format: readme.ReStructuredText,
+	text:   nestedRSTBlockQuotes(1000),
+}}
+
+// nestedRSTBlockQuotes returns reStructuredText
+// holding n nested block quotes.
+func nestedRSTBlockQuotes(n int) string {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		buf.WriteString(strings.Repeat(" ", i) + "a\n\n")
+	}
+	return buf.String()
+}
+
+func (s *readmeSuite) TestRenderTime(c *gc.C) {
+	for i, test := range renderTimeTests {
+		c.Logf("test %d: %s", i, test.about)
+		start := time.Now()
+		readme.Render([]byte(test.text), test.format)
+		c.Assert(time.Since(start) < 5*time.Second, gc.Equals, true)
+	}
+}
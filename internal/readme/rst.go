@@ -0,0 +1,246 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package readme
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// rstRenderer renders reStructuredText as HTML.
+type rstRenderer struct {
+	// headingStyles holds the section title adornment styles
+	// in the order in which they were first found. The level
+	// of a title is determined by the position of its style.
+	headingStyles []string
+
+	// depth holds the number of block quotes and lists
+	// enclosing the lines being rendered.
+	depth int
+}
+
+// rstAdornmentChars holds the characters that can be
+// used to adorn section titles.
+const rstAdornmentChars = "=-`:'\"~^_*+#<>."
+
+var (
+	rstBulletPattern = regexp.MustCompile(`^[-*+] +`)
+	rstEnumPattern   = regexp.MustCompile(`^([0-9]+|#)[.)] +`)
+	rstCodeDirective = regexp.MustCompile(`^\.\. +(code|code-block|sourcecode)::`)
+)
+
+// render renders the given lines, which must not be indented.
+func (r *rstRenderer) render(buf *bytes.Buffer, lines []string) {
+	if r.depth > maxBlockDepth {
+		writePre(buf, lines)
+		return
+	}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case indentation(line) > 0:
+			j := endOfIndentedBlock(lines, i)
+			buf.WriteString("<blockquote>\n")
+			r.renderNested(buf, dedent(lines[i:j], indentation(line)))
+			buf.WriteString("</blockquote>\n")
+			i = j
+		case rstCodeDirective.MatchString(line):
+			j := endOfIndentedBlock(lines, i+1)
+			block := trimBlankLines(lines[i+1 : j])
+			if len(block) > 0 {
+				writePre(buf, dedent(block, indentation(block[0])))
+			}
+			i = j
+		case strings.HasPrefix(line, ".."):
+			// Comments, hyperlink targets and other directives
+			// are not rendered.
+			i = endOfIndentedBlock(lines, i+1)
+		case i+2 < len(lines) && isRSTAdornment(line) && isRSTAdornment(lines[i+2]) && lines[i+2] == line:
+			r.writeHeading(buf, "over"+line[:1], strings.TrimSpace(lines[i+1]))
+			i += 3
+		case i+1 < len(lines) && isRSTAdornment(lines[i+1]) && len(lines[i+1]) >= len(strings.TrimSpace(line)):
+			r.writeHeading(buf, lines[i+1][:1], strings.TrimSpace(line))
+			i += 2
+		case isRSTAdornment(line) && len(line) >= 4:
+			buf.WriteString("<hr>\n")
+			i++
+		case rstBulletPattern.MatchString(line):
+			i = r.renderList(buf, lines, i, rstBulletPattern, "ul")
+		case rstEnumPattern.MatchString(line):
+			i = r.renderList(buf, lines, i, rstEnumPattern, "ol")
+		default:
+			i = r.renderParagraph(buf, lines, i)
+		}
+	}
+}
+
+// renderNested renders the given lines, nested
+// inside a block quote or a list item.
+func (r *rstRenderer) renderNested(buf *bytes.Buffer, lines []string) {
+	r.depth++
+	r.render(buf, lines)
+	r.depth--
+}
+
+// renderParagraph renders the paragraph starting at lines[i]
+// and returns the index of the first line after it. If the
+// paragraph ends with "::", the following indented block is
+// rendered as a literal block.
+func (r *rstRenderer) renderParagraph(buf *bytes.Buffer, lines []string, i int) int {
+	j := i
+	for j < len(lines) && !isBlank(lines[j]) && indentation(lines[j]) == 0 {
+		j++
+	}
+	text := strings.Join(lines[i:j], "\n")
+	literal := strings.HasSuffix(text, "::")
+	if literal {
+		switch {
+		case text == "::":
+			text = ""
+		case strings.HasSuffix(text, " ::"):
+			text = strings.TrimSuffix(text, " ::")
+		default:
+			text = strings.TrimSuffix(text, ":")
+		}
+	}
+	if text != "" {
+		buf.WriteString("<p>" + rstInline(text) + "</p>\n")
+	}
+	if !literal {
+		return j
+	}
+	k := j
+	for k < len(lines) && isBlank(lines[k]) {
+		k++
+	}
+	if k == len(lines) || indentation(lines[k]) == 0 {
+		return k
+	}
+	end := endOfIndentedBlock(lines, k)
+	block := trimBlankLines(lines[k:end])
+	writePre(buf, dedent(block, indentation(block[0])))
+	return end
+}
+
+// renderList renders the list whose items start with
+// the given marker pattern and returns the index of the
+// first line after it.
+func (r *rstRenderer) renderList(buf *bytes.Buffer, lines []string, i int, marker *regexp.Regexp, tag string) int {
+	fmt.Fprintf(buf, "<%s>\n", tag)
+	for i < len(lines) {
+		m := marker.FindString(lines[i])
+		if m == "" {
+			break
+		}
+		end := endOfIndentedBlock(lines, i+1)
+		body := append([]string{lines[i][len(m):]}, dedent(lines[i+1:end], len(m))...)
+		body = trimBlankLines(body)
+		buf.WriteString("<li>")
+		if len(body) == 1 {
+			buf.WriteString(rstInline(body[0]))
+		} else {
+			var item bytes.Buffer
+			r.renderNested(&item, body)
+			buf.WriteString(strings.TrimSuffix(item.String(), "\n"))
+		}
+		buf.WriteString("</li>\n")
+		i = end
+		for i < len(lines) && isBlank(lines[i]) {
+			i++
+		}
+	}
+	fmt.Fprintf(buf, "</%s>\n", tag)
+	return i
+}
+
+func (r *rstRenderer) writeHeading(buf *bytes.Buffer, style, text string) {
+	level := 0
+	for i, s := range r.headingStyles {
+		if s == style {
+			level = i + 1
+			break
+		}
+	}
+	if level == 0 {
+		r.headingStyles = append(r.headingStyles, style)
+		level = len(r.headingStyles)
+	}
+	if level > 6 {
+		level = 6
+	}
+	fmt.Fprintf(buf, "<h%d>%s</h%d>\n", level, rstInline(text), level)
+}
+
+// endOfIndentedBlock returns the index of the first line,
+// starting at lines[i], that is not indented and follows
+// any indented and blank lines.
+func endOfIndentedBlock(lines []string, i int) int {
+	end := i
+	for j := i; j < len(lines); j++ {
+		if isBlank(lines[j]) {
+			continue
+		}
+		if indentation(lines[j]) == 0 {
+			break
+		}
+		end = j + 1
+	}
+	return end
+}
+
+// isRSTAdornment reports whether the given line is a section
+// title adornment: a line made of at least two repeated
+// punctuation characters.
+func isRSTAdornment(line string) bool {
+	line = strings.TrimRight(line, " ")
+	if len(line) < 2 || strings.IndexByte(rstAdornmentChars, line[0]) == -1 {
+		return false
+	}
+	return strings.Trim(line, line[:1]) == ""
+}
+
+var rstInlinePattern = regexp.MustCompile("``(.+?)``" +
+	"|\\*\\*(.+?)\\*\\*" +
+	"|\\*([^*\\s](?:.*?[^*\\s])?)\\*" +
+	"|`([^`<]*?)\\s*<([^`>]+)>`__?" +
+	"|`([^`]+)`_{0,2}")
+
+// rstInline renders the inline reStructuredText
+// markup in the given text as HTML.
+func rstInline(text string) string {
+	var buf bytes.Buffer
+	for {
+		m := rstInlinePattern.FindStringSubmatchIndex(text)
+		if m == nil {
+			buf.WriteString(html.EscapeString(text))
+			return buf.String()
+		}
+		buf.WriteString(html.EscapeString(text[:m[0]]))
+		group := func(n int) string {
+			return text[m[2*n]:m[2*n+1]]
+		}
+		switch {
+		case m[2] >= 0:
+			buf.WriteString("<code>" + html.EscapeString(group(1)) + "</code>")
+		case m[4] >= 0:
+			buf.WriteString("<strong>" + html.EscapeString(group(2)) + "</strong>")
+		case m[6] >= 0:
+			buf.WriteString("<em>" + html.EscapeString(group(3)) + "</em>")
+		case m[8] >= 0:
+			label := group(4)
+			if label == "" {
+				label = group(5)
+			}
+			writeLink(&buf, group(5), html.EscapeString(label))
+		default:
+			buf.WriteString("<em>" + html.EscapeString(group(6)) + "</em>")
+		}
+		text = text[m[1]:]
+	}
+}
@@ -130,7 +130,7 @@ type Handlers struct {
 type Router struct {
 	handlers   *Handlers
 	handler    http.Handler
-	resolveURL func(id *charm.Reference) error
+	resolveURL func(id *charm.Reference, req *http.Request) error
 	authorize  func(id *charm.Reference, req *http.Request) error
 	exists     func(id *charm.Reference, req *http.Request) (bool, error)
 }
@@ -141,6 +141,8 @@ type Router struct {
 // The resolveURL function will be called to resolve ids in
 // router paths - it should fill in the Series and Revision
 // fields of its argument URL if they are not specified.
+// The request is provided so that the result may depend
+// on the privileges of the client making it.
 // The Cause of the resolveURL error will be left unchanged,
 // as for the handlers.
 //
@@ -153,7 +155,7 @@ type Router struct {
 // but has no appropriate handler to call.
 func New(
 	handlers *Handlers,
-	resolveURL func(id *charm.Reference) error,
+	resolveURL func(id *charm.Reference, req *http.Request) error,
 	authorize func(id *charm.Reference, req *http.Request) error,
 	exists func(id *charm.Reference, req *http.Request) (bool, error),
 ) *Router {
@@ -234,7 +236,7 @@ func (r *Router) serveIds(w http.ResponseWriter, req *http.Request) error {
 		// we always want a resolved URL. Otherwise we leave the
 		// URL unresolved for cases where the id may validly not
 		// exist (for example when uploading a new charm).
-		if err := r.resolveURL(url); err != nil {
+		if err := r.resolveURL(url, req); err != nil {
 			// Note: preserve error cause from resolveURL.
 			return errgo.Mask(err, errgo.Any)
 		}
@@ -485,7 +487,7 @@ func (r *Router) serveBulkMetaGet(req *http.Request) (interface{}, error) {
 		if err != nil {
 			return nil, errgo.Mask(err)
 		}
-		if err := r.resolveURL(url); err != nil {
+		if err := r.resolveURL(url, req); err != nil {
 			if errgo.Cause(err) == params.ErrNotFound {
 				// URLs not found will be omitted from the result.
 				// http://tinyurl.com/o5ptfkk
@@ -501,7 +503,10 @@ func (r *Router) serveBulkMetaGet(req *http.Request) (interface{}, error) {
 			continue
 		}
 		if err != nil {
-			return nil, errgo.Mask(err)
+			// Note: preserve error cause from serveMetaGet, so that
+			// an id that cannot be read results in an authorization
+			// error rather than an internal server error.
+			return nil, errgo.Mask(err, errgo.Any)
 		}
 		result[id] = meta
 	}
@@ -541,7 +546,7 @@ func (r *Router) serveBulkMetaPutOne(req *http.Request, id string, val *json.Raw
 	if err != nil {
 		return errgo.Mask(err)
 	}
-	if err := r.resolveURL(url); err != nil {
+	if err := r.resolveURL(url, req); err != nil {
 		// Note: preserve error cause from resolveURL.
 		return errgo.Mask(err, errgo.Any)
 	}
@@ -39,7 +39,7 @@ var routerGetTests = []struct {
 	expectStatus     int
 	expectBody       interface{}
 	expectQueryCount int32
-	resolveURL       func(*charm.Reference) error
+	resolveURL       func(*charm.Reference, *http.Request) error
 	authorize        func(id *charm.Reference, req *http.Request) error
 	exists           func(*charm.Reference, *http.Request) (bool, error)
 }{{
@@ -468,6 +468,20 @@ var routerGetTests = []struct {
 		Code:    params.ErrUnauthorized,
 		Message: "bad wolf",
 	},
+}, {
+	about:  "unauthorized bulk meta handler",
+	urlStr: "/meta/foo?id=precise/wordpress-42&id=utopic/foo-32",
+	handlers: Handlers{
+		Meta: map[string]BulkIncludeHandler{
+			"foo": testMetaHandler(0),
+		},
+	},
+	authorize:    neverAuthorize,
+	expectStatus: http.StatusUnauthorized,
+	expectBody: params.Error{
+		Code:    params.ErrUnauthorized,
+		Message: "bad wolf",
+	},
 }, {
 	about:  "meta/any, no includes, id exists",
 	urlStr: "/precise/wordpress-42/meta/any",
@@ -723,7 +737,7 @@ var routerGetTests = []struct {
 }, {
 	about:  "bulk meta handler with unresolvable id",
 	urlStr: "/meta/foo?id=unresolved&id=precise/wordpress-23",
-	resolveURL: func(url *charm.Reference) error {
+	resolveURL: func(url *charm.Reference, req *http.Request) error {
 		if url.Name == "unresolved" {
 			return params.ErrNotFound
 		}
@@ -743,7 +757,7 @@ var routerGetTests = []struct {
 }, {
 	about:  "bulk meta handler with id resolution error",
 	urlStr: "/meta/foo?id=resolveerror&id=precise/wordpress-23",
-	resolveURL: func(url *charm.Reference) error {
+	resolveURL: func(url *charm.Reference, req *http.Request) error {
 		if url.Name == "resolveerror" {
 			return errgo.Newf("an error")
 		}
@@ -794,8 +808,8 @@ var routerGetTests = []struct {
 // newResolveURL returns a URL resolver that resolves
 // unspecified series and revision to the given series
 // and revision.
-func newResolveURL(series string, revision int) func(*charm.Reference) error {
-	return func(url *charm.Reference) error {
+func newResolveURL(series string, revision int) func(*charm.Reference, *http.Request) error {
+	return func(url *charm.Reference, req *http.Request) error {
 		if url.Series == "" {
 			url.Series = series
 		}
@@ -806,13 +820,13 @@ func newResolveURL(series string, revision int) func(*charm.Reference) error {
 	}
 }
 
-func resolveURLError(err error) func(*charm.Reference) error {
-	return func(*charm.Reference) error {
+func resolveURLError(err error) func(*charm.Reference, *http.Request) error {
+	return func(*charm.Reference, *http.Request) error {
 		return err
 	}
 }
 
-func noResolveURL(*charm.Reference) error {
+func noResolveURL(*charm.Reference, *http.Request) error {
 	return nil
 }
 
@@ -939,7 +953,7 @@ var routerPutTests = []struct {
 	expectCode          int
 	expectBody          interface{}
 	expectRecordedCalls []interface{}
-	resolveURL          func(*charm.Reference) error
+	resolveURL          func(*charm.Reference, *http.Request) error
 }{{
 	about: "global handler",
 	handlers: Handlers{
@@ -1351,7 +1365,7 @@ var routerPutTests = []struct {
 			}),
 		},
 	},
-	resolveURL: func(id *charm.Reference) error {
+	resolveURL: func(id *charm.Reference, req *http.Request) error {
 		if id.Name == "bad" {
 			return params.ErrBadRequest
 		}
@@ -32,12 +32,16 @@ func errorToResp(err error) (int, interface{}) {
 	switch errorBody.Code {
 	case params.ErrNotFound, params.ErrMetadataNotFound:
 		status = http.StatusNotFound
-	case params.ErrBadRequest:
+	case params.ErrBadRequest, params.ErrUnsafeArchive, params.ErrInvalidArchive:
 		status = http.StatusBadRequest
-	case params.ErrForbidden:
+	case params.ErrArchiveTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case params.ErrForbidden, params.ErrQuotaExceeded:
 		status = http.StatusForbidden
 	case params.ErrUnauthorized:
 		status = http.StatusUnauthorized
+	case params.ErrContentRequired:
+		status = http.StatusPreconditionFailed
 	case params.ErrMethodNotAllowed:
 		// TODO(rog) from RFC 2616, section 4.7: An Allow header
 		// field MUST be present in a 405 (Method Not Allowed)
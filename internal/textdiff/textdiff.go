@@ -0,0 +1,202 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The textdiff package produces unified diffs of text files.
+package textdiff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxCells holds the maximum size of the table used to find
+// the longest common subsequence of the changed lines.
+const maxCells = 1 << 22
+
+// IsText reports whether the given data looks like the
+// contents of a text file: valid UTF-8 without NUL bytes.
+func IsText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) == -1
+}
+
+// Unified returns a unified diff, in the format produced by "diff -u",
+// between the old and new texts, using the given names in the header
+// and showing the given number of context lines around each change.
+// It returns the empty string if the texts are the same.
+//
+// It reports false if the texts have too many differing lines
+// to be compared.
+func Unified(oldName, newName, old, new string, context int) (string, bool) {
+	ops, ok := editScript(splitLines(old), splitLines(new))
+	if !ok {
+		return "", false
+	}
+	// oldBefore[i] and newBefore[i] hold the number of old and new
+	// lines that precede ops[i].
+	oldBefore := make([]int, len(ops)+1)
+	newBefore := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldBefore[i+1], newBefore[i+1] = oldBefore[i], newBefore[i]
+		if op.kind != '+' {
+			oldBefore[i+1]++
+		}
+		if op.kind != '-' {
+			newBefore[i+1]++
+		}
+	}
+	var buf bytes.Buffer
+	for start := 0; ; {
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		// Find the end of the changes, joining changes
+		// whose context would overlap.
+		end := first
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		hunkStart := max(first-context, start)
+		hunkEnd := min(end+context, len(ops))
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(oldBefore[hunkStart], oldBefore[hunkEnd]),
+			hunkRange(newBefore[hunkStart], newBefore[hunkEnd]),
+		)
+		for _, op := range ops[hunkStart:hunkEnd] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = hunkEnd
+	}
+	return buf.String(), true
+}
+
+// hunkRange returns the range of lines from the given start
+// (exclusive) to end (inclusive) in the form used in unified
+// diff hunk headers.
+func hunkRange(start, end int) string {
+	switch end - start {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprint(end)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+// op holds a line in an edit script. Its kind is ' ' for an
+// unchanged line, '-' for a removed line and '+' for an added line.
+type op struct {
+	kind byte
+	line string
+}
+
+// editScript returns the edit script that changes the lines in a
+// into the lines in b. Removals are placed before additions. It
+// reports false if there are too many changed lines to compare.
+func editScript(a, b []string) ([]op, bool) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(ma), len(mb)
+	if n*m > maxCells {
+		return nil, false
+	}
+	// lcs[i*(m+1)+j] holds the length of the longest
+	// common subsequence of ma[i:] and mb[j:].
+	lcs := make([]int32, (n+1)*(m+1))
+	at := func(i, j int) int32 {
+		return lcs[i*(m+1)+j]
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case ma[i] == mb[j]:
+				lcs[i*(m+1)+j] = at(i+1, j+1) + 1
+			case at(i+1, j) >= at(i, j+1):
+				lcs[i*(m+1)+j] = at(i+1, j)
+			default:
+				lcs[i*(m+1)+j] = at(i, j+1)
+			}
+		}
+	}
+	ops := make([]op, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+	for i, j := 0, 0; i < n || j < m; {
+		switch {
+		case i < n && j < m && ma[i] == mb[j]:
+			ops = append(ops, op{' ', ma[i]})
+			i++
+			j++
+		case j < m && (i == n || at(i, j+1) > at(i+1, j)):
+			ops = append(ops, op{'+', mb[j]})
+			j++
+		default:
+			ops = append(ops, op{'-', ma[i]})
+			i++
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+	return ops, true
+}
+
+// splitLines splits the given text into lines, each
+// including its terminating newline, if any.
+func splitLines(text string) []string {
+	var lines []string
+	for text != "" {
+		i := strings.IndexByte(text, '\n')
+		if i == -1 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:i+1])
+		text = text[i+1:]
+	}
+	return lines
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
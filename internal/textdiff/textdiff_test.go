@@ -0,0 +1,129 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package textdiff_test
+
+import (
+	"strings"
+	"testing"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/charmstore/internal/textdiff"
+)
+
+func TestPackage(t *testing.T) {
+	gc.TestingT(t)
+}
+
+type textdiffSuite struct{}
+
+var _ = gc.Suite(&textdiffSuite{})
+
+var unifiedTests = []struct {
+	about  string
+	old    string
+	new    string
+	expect string
+}{{
+	about:  "same text",
+	old:    "a\nb\n",
+	new:    "a\nb\n",
+	expect: "",
+}, {
+	about: "separate hunks",
+	old:   "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n",
+	new:   "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk",
+	expect: `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+\ No newline at end of file
+`,
+}, {
+	about: "joined hunks",
+	old:   "a\nb\nc\nd\ne\nf\ng\nh\n",
+	new:   "a\nx\nc\nd\ne\nf\ny\nh",
+	expect: `--- old
++++ new
+@@ -1,8 +1,8 @@
+ a
+-b
++x
+ c
+ d
+ e
+ f
+-g
+-h
++y
++h
+\ No newline at end of file
+`,
+}, {
+	about: "removal and addition",
+	old:   "1\n2\n3\n",
+	new:   "1\n3\n4\n",
+	expect: `--- old
++++ new
+@@ -1,3 +1,3 @@
+ 1
+-2
+ 3
++4
+`,
+}, {
+	about: "added file",
+	old:   "",
+	new:   "one\ntwo\n",
+	expect: `--- old
++++ new
+@@ -0,0 +1,2 @@
++one
++two
+`,
+}, {
+	about: "removed file",
+	old:   "one\ntwo\n",
+	new:   "",
+	expect: `--- old
++++ new
+@@ -1,2 +0,0 @@
+-one
+-two
+`,
+}}
+
+func (s *textdiffSuite) TestUnified(c *gc.C) {
+	for i, test := range unifiedTests {
+		c.Logf("test %d: %s", i, test.about)
+		diff, ok := textdiff.Unified("old", "new", test.old, test.new, 3)
+		c.Assert(ok, gc.Equals, true)
+		c.Assert(diff, gc.Equals, test.expect)
+	}
+}
+
+func (s *textdiffSuite) TestUnifiedTooManyChanges(c *gc.C) {
+	old := strings.Repeat("a\n", 3000)
+	new := strings.Repeat("b\n", 3000)
+	diff, ok := textdiff.Unified("old", "new", old, new, 3)
+	c.Assert(ok, gc.Equals, false)
+	c.Assert(diff, gc.Equals, "")
+}
+
+func (s *textdiffSuite) TestIsText(c *gc.C) {
+	c.Assert(textdiff.IsText([]byte("hello\nworld\n")), gc.Equals, true)
+	c.Assert(textdiff.IsText([]byte("héllo")), gc.Equals, true)
+	c.Assert(textdiff.IsText([]byte("hello\x00")), gc.Equals, false)
+	c.Assert(textdiff.IsText([]byte("\xff\xfe")), gc.Equals, false)
+}
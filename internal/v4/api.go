@@ -4,7 +4,7 @@
 package v4
 
 import (
-	"archive/zip"
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"net/url"
@@ -14,6 +14,7 @@ import (
 
 	"github.com/juju/loggo"
 	"github.com/juju/utils/jsonhttp"
+	"github.com/juju/utils/parallel"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/juju/charm.v4"
 	"gopkg.in/macaroon-bakery.v0/bakery"
@@ -46,6 +47,7 @@ func New(store *charmstore.Store, config charmstore.ServerParams) *Handler {
 	h.Router = router.New(&router.Handlers{
 		Global: map[string]http.Handler{
 			"changes/published":  router.HandleJSON(h.serveChangesPublished),
+			"changes/stream":     http.HandlerFunc(h.serveChangesStream),
 			"debug":              http.HandlerFunc(h.serveDebug),
 			"debug/pprof/":       newPprofHandler(h),
 			"debug/status":       router.HandleJSON(h.serveDebugStatus),
@@ -55,16 +57,23 @@ func New(store *charmstore.Store, config charmstore.ServerParams) *Handler {
 			"search/interesting": http.HandlerFunc(h.serveSearchInteresting),
 			"stats/":             router.NotFoundHandler(),
 			"stats/counter/":     router.HandleJSON(h.serveStatsCounter),
+			"upload/":            router.HandleErrors(h.serveUploads),
 			"macaroon":           router.HandleJSON(h.serveMacaroon),
+			"webhooks":           router.HandleErrors(h.serveWebhooks),
+			"webhooks/":          router.HandleErrors(h.serveWebhook),
 		},
 		Id: map[string]router.IdHandler{
 			"archive":     h.serveArchive,
 			"archive/":    h.serveArchiveFile,
 			"diagram.svg": h.serveDiagram,
+			"diff":        h.serveDiff,
 			"expand-id":   h.serveExpandId,
+			"export":      h.serveExport,
 			"icon.svg":    h.serveIcon,
+			"promulgate":  h.servePromulgate,
 			"readme":      h.serveReadMe,
 			"resources":   h.serveResources,
+			"upload":      h.serveUpload,
 		},
 		Meta: map[string]router.BulkIncludeHandler{
 			"archive-size":         h.entityHandler(h.metaArchiveSize, "size"),
@@ -87,17 +96,24 @@ func New(store *charmstore.Store, config charmstore.ServerParams) *Handler {
 				h.putMetaExtraInfoWithKey,
 				"extrainfo",
 			),
+			"hash":          h.entityHandler(h.metaHash, "blobhash"),
+			"hidden":        h.puttableEntityHandler(h.metaHidden, h.putMetaHidden, "hidden"),
 			"id":            h.entityHandler(h.metaId, "_id"),
 			"id-name":       h.entityHandler(h.metaIdName, "_id"),
 			"id-user":       h.entityHandler(h.metaIdUser, "_id"),
 			"id-revision":   h.entityHandler(h.metaIdRevision, "_id"),
 			"id-series":     h.entityHandler(h.metaIdSeries, "_id"),
-			"manifest":      h.entityHandler(h.metaManifest, "blobname"),
+			"lint":          h.entityHandler(h.metaLint, "lintwarnings"),
+			"manifest":      h.entityHandler(h.metaManifest, "blobname", "manifest"),
 			"perm":          h.baseEntityHandler(h.metaPerm, "acls"),
 			"perm/":         h.puttableBaseEntityHandler(h.metaPermWithKey, h.putMetaPermWithKey, "acls"),
+			"promulgated":   h.baseEntityHandler(h.metaPromulgated, "promulgated"),
+			"release-notes": h.puttableEntityHandler(h.metaReleaseNotes, h.putMetaReleaseNotes, "releasenotes"),
 			"revision-info": router.SingleIncludeHandler(h.metaRevisionInfo),
 			"stats":         h.entityHandler(h.metaStats),
 			"tags":          h.entityHandler(h.metaTags, "charmmeta", "bundledata"),
+			"upgrade-info":  h.entityHandler(h.metaUpgradeInfo, "baseurl", "charmmeta", "charmconfig", "charmactions"),
+			"usage":         router.SingleIncludeHandler(h.metaUsage),
 
 			// endpoints not yet implemented:
 			// "color": router.SingleIncludeHandler(h.metaColor),
@@ -124,12 +140,21 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 // ResolveURL resolves the series and revision of the given URL
 // if either is unspecified by filling them out with information retrieved
-// from the store.
+// from the store. Hidden revisions are never chosen.
 func ResolveURL(store *charmstore.Store, url *charm.Reference) error {
+	return resolveURL(store, url, func() bool {
+		return false
+	})
+}
+
+// resolveURL is the internal version of ResolveURL.
+// Hidden revisions are only chosen if canSeeHidden
+// returns true.
+func resolveURL(store *charmstore.Store, url *charm.Reference, canSeeHidden func() bool) error {
 	if url.Series != "" && url.Revision != -1 {
 		return nil
 	}
-	urls, err := store.ExpandURL(url)
+	urls, err := store.ExpandVisibleURL(url, canSeeHidden)
 	if err != nil {
 		return errgo.Notef(err, "cannot expand URL")
 	}
@@ -144,8 +169,10 @@ func noMatchingURLError(url *charm.Reference) error {
 	return errgo.WithCausef(nil, params.ErrNotFound, "no matching charm or bundle for %q", url)
 }
 
-func (h *Handler) resolveURL(url *charm.Reference) error {
-	return ResolveURL(h.store, url)
+func (h *Handler) resolveURL(url *charm.Reference, req *http.Request) error {
+	return resolveURL(h.store, url, func() bool {
+		return h.canSeeHidden(url, req)
+	})
 }
 
 type entityHandlerFunc func(entity *mongodoc.Entity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error)
@@ -208,6 +235,7 @@ func (h *Handler) updateBaseEntity(id *charm.Reference, fields map[string]interf
 	if err != nil {
 		return errgo.Notef(err, "cannot update %q", &id1)
 	}
+	h.notifyPermChange(&id1, fields)
 	return nil
 }
 
@@ -220,6 +248,7 @@ func (h *Handler) updateEntity(id *charm.Reference, fields map[string]interface{
 	if err != nil {
 		return errgo.Notef(err, "cannot update %q", id)
 	}
+	h.notifyExtraInfoChange(id, fields)
 	return nil
 }
 
@@ -369,9 +398,10 @@ func (h *Handler) serveExpandId(id *charm.Reference, _ bool, w http.ResponseWrit
 
 	// Retrieve all the entities with the same base URL.
 	var docs []mongodoc.Entity
-	if err := h.store.DB.Entities().Find(bson.D{{"baseurl", id}}).Select(bson.D{{"_id", 1}}).All(&docs); err != nil {
+	if err := h.store.DB.Entities().Find(bson.D{{"baseurl", id}}).Select(bson.D{{"_id", 1}, {"hidden", 1}}).All(&docs); err != nil {
 		return errgo.Notef(err, "cannot get ids")
 	}
+	docs = h.visibleEntities(id, docs, req)
 
 	// A not found error should have been already returned by the router in the
 	// case a partial id is provided. Here we do the same for the case when
@@ -432,25 +462,19 @@ func bundleCount(x *int) interface{} {
 // GET id/meta/manifest
 // http://tinyurl.com/p3xdcto
 func (h *Handler) metaManifest(entity *mongodoc.Entity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
-	r, size, err := h.store.BlobStore.Open(entity.BlobName)
+	files, err := h.store.EntityManifest(entity)
 	if err != nil {
-		return nil, errgo.Notef(err, "cannot open archive data for %s", id)
-	}
-	defer r.Close()
-	zipReader, err := zip.NewReader(charmstore.ReaderAtSeeker(r), size)
-	if err != nil {
-		return nil, errgo.Notef(err, "cannot read archive data for %s", id)
+		return nil, errgo.Notef(err, "cannot read archive manifest for %s", id)
 	}
 	// Collect the files.
-	manifest := make([]params.ManifestFile, 0, len(zipReader.File))
-	for _, file := range zipReader.File {
-		fileInfo := file.FileInfo()
-		if fileInfo.IsDir() {
+	manifest := make([]params.ManifestFile, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
 			continue
 		}
 		manifest = append(manifest, params.ManifestFile{
 			Name: file.Name,
-			Size: fileInfo.Size(),
+			Size: file.Size,
 		})
 	}
 	return manifest, nil
@@ -482,6 +506,13 @@ func (h *Handler) metaArchiveSize(entity *mongodoc.Entity, id *charm.Reference,
 	}, nil
 }
 
+// GET id/meta/hash
+func (h *Handler) metaHash(entity *mongodoc.Entity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	return &params.HashResponse{
+		Sum: entity.BlobHash,
+	}, nil
+}
+
 // GET id/meta/tags
 // http://tinyurl.com/njyqwj2
 func (h *Handler) metaTags(entity *mongodoc.Entity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
@@ -536,9 +567,10 @@ func (h *Handler) metaRevisionInfo(id *charm.Reference, path string, flags url.V
 	var docs []mongodoc.Entity
 	if err := h.store.DB.Entities().Find(
 		bson.D{{"baseurl", &baseURL}, {"series", id.Series}}).Select(
-		bson.D{{"_id", 1}}).Sort("-revision").All(&docs); err != nil {
+		bson.D{{"_id", 1}, {"hidden", 1}}).Sort("-revision").All(&docs); err != nil {
 		return "", errgo.Notef(err, "cannot get ids")
 	}
+	docs = h.visibleEntities(&baseURL, docs, req)
 
 	if len(docs) == 0 {
 		return "", errgo.WithCausef(nil, params.ErrNotFound, "no matching charm or bundle for %s", id)
@@ -555,6 +587,40 @@ func (h *Handler) metaRevisionInfo(id *charm.Reference, path string, flags url.V
 	return response, nil
 }
 
+// GET id/meta/lint
+func (h *Handler) metaLint(entity *mongodoc.Entity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	warnings := make([]params.LintWarning, len(entity.LintWarnings))
+	for i, w := range entity.LintWarnings {
+		warnings[i] = params.LintWarning{
+			Validator: w.Validator,
+			Message:   w.Message,
+		}
+	}
+	return &params.LintResponse{
+		Warnings: warnings,
+	}, nil
+}
+
+// GET id/meta/usage
+func (h *Handler) metaUsage(id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	revisions, err := h.store.RevisionCount(id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	storage, err := h.store.UserStorage(id.User)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &params.UsageResponse{
+		User:           id.User,
+		Revisions:      revisions,
+		MaxRevisions:   h.config.MaxRevisions,
+		Storage:        storage,
+		MaxStorage:     h.config.MaxUserStorage,
+		MaxArchiveSize: h.config.MaxArchiveSize,
+	}, nil
+}
+
 // GET id/meta/id-user
 // http://tinyurl.com/o7xmhz2
 func (h *Handler) metaIdUser(entity *mongodoc.Entity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
@@ -657,6 +723,43 @@ func checkExtraInfoKey(key string) error {
 	return nil
 }
 
+// GET id/meta/hidden
+func (h *Handler) metaHidden(entity *mongodoc.Entity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	return params.HiddenResponse{
+		Hidden: entity.Hidden,
+	}, nil
+}
+
+// PUT id/meta/hidden
+func (h *Handler) putMetaHidden(id *charm.Reference, path string, val *json.RawMessage, updater *router.FieldUpdater, req *http.Request) error {
+	var hidden params.HiddenResponse
+	if err := json.Unmarshal(*val, &hidden); err != nil {
+		return errgo.WithCausef(err, params.ErrBadRequest, "cannot unmarshal hidden value")
+	}
+	updater.UpdateField("hidden", hidden.Hidden)
+	return nil
+}
+
+// GET id/meta/release-notes
+func (h *Handler) metaReleaseNotes(entity *mongodoc.Entity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	return params.ReleaseNotesResponse{
+		ReleaseNotes: entity.ReleaseNotes,
+	}, nil
+}
+
+// PUT id/meta/release-notes
+func (h *Handler) putMetaReleaseNotes(id *charm.Reference, path string, val *json.RawMessage, updater *router.FieldUpdater, req *http.Request) error {
+	var notes params.ReleaseNotesResponse
+	if err := json.Unmarshal(*val, &notes); err != nil {
+		return errgo.WithCausef(err, params.ErrBadRequest, "cannot unmarshal release notes")
+	}
+	if len(notes.ReleaseNotes) > charmstore.MaxReleaseNotesSize {
+		return badRequestf(nil, "release notes too long (maximum %d bytes)", charmstore.MaxReleaseNotesSize)
+	}
+	updater.UpdateField("releasenotes", notes.ReleaseNotes)
+	return nil
+}
+
 func (h *Handler) metaPerm(entity *mongodoc.BaseEntity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
 	return params.PermResponse{
 		Read:  entity.ACLs.Read,
@@ -664,6 +767,46 @@ func (h *Handler) metaPerm(entity *mongodoc.BaseEntity, id *charm.Reference, pat
 	}, nil
 }
 
+// GET id/meta/promulgated
+func (h *Handler) metaPromulgated(entity *mongodoc.BaseEntity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	return params.PromulgatedResponse{
+		Promulgated: entity.Promulgated,
+	}, nil
+}
+
+// PUT id/promulgate
+func (h *Handler) servePromulgate(id *charm.Reference, fullySpecified bool, w http.ResponseWriter, req *http.Request) error {
+	if req.Method != "PUT" {
+		return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s method not allowed", req.Method)
+	}
+	// Only the administrator can change the promulgation status.
+	if err := h.authorize(req, nil); err != nil {
+		return err
+	}
+	var promulgate params.PromulgateRequest
+	if err := json.NewDecoder(req.Body).Decode(&promulgate); err != nil {
+		return badRequestf(err, "cannot unmarshal body")
+	}
+	baseEntity, err := h.store.FindBaseEntity(id, "promulgated")
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if baseEntity.Promulgated == promulgate.Promulgated {
+		return nil
+	}
+	if err := h.updateBaseEntity(id, map[string]interface{}{
+		"promulgated": promulgate.Promulgated,
+	}); err != nil {
+		return errgo.Mask(err)
+	}
+	h.notifyChange(&params.WebhookPayload{
+		Event:       params.PromulgateEvent,
+		Id:          baseEntity.URL,
+		Promulgated: &promulgate.Promulgated,
+	})
+	return nil
+}
+
 func (h *Handler) metaPermWithKey(entity *mongodoc.BaseEntity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
 	switch path {
 	case "/read":
@@ -711,9 +854,9 @@ type PublishedResponse struct {
 	Published time.Time
 }
 
-// GET changes/published[?limit=$count][&from=$fromdate][&to=$todate]
+// GET changes/published[?limit=$count][&from=$fromdate][&to=$todate][&cursor=$cursor][&owner=$user][&series=$series][&type=$type][&include=meta]
 // http://tinyurl.com/qx5zdee
-func (h *Handler) serveChangesPublished(_ http.Header, r *http.Request) (interface{}, error) {
+func (h *Handler) serveChangesPublished(header http.Header, r *http.Request) (interface{}, error) {
 	start, stop, err := parseDateRange(r.Form)
 	if err != nil {
 		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
@@ -739,29 +882,211 @@ func (h *Handler) serveChangesPublished(_ http.Header, r *http.Request) (interfa
 			Value: stop,
 		})
 	}
-	var findQuery bson.D
+	// Hidden revisions are never published.
+	findQuery := bson.D{{"hidden", bson.D{{"$ne", true}}}}
 	if len(tquery) > 0 {
-		findQuery = bson.D{{"uploadtime", tquery}}
+		findQuery = append(findQuery, bson.DocElem{
+			Name:  "uploadtime",
+			Value: tquery,
+		})
 	}
-	query := h.store.DB.Entities().
-		Find(findQuery).
-		Sort("-uploadtime").
-		Select(bson.D{{"_id", 1}, {"uploadtime", 1}})
-	if limit != -1 {
-		query = query.Limit(limit)
+	filters, err := publishedFilters(r.Form)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
 	}
-
+	findQuery = append(findQuery, filters...)
+	if cursorStr := r.Form.Get("cursor"); cursorStr != "" {
+		cursor, err := parsePublishedCursor(cursorStr)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+		// Start after the last entity returned in the previous page.
+		findQuery = append(findQuery, bson.DocElem{
+			Name: "$or",
+			Value: []bson.D{{
+				{"uploadtime", bson.D{{"$lt", cursor.UploadTime}}},
+			}, {
+				{"uploadtime", cursor.UploadTime},
+				{"_id", bson.D{{"$lt", cursor.Id}}},
+			}},
+		})
+	}
+	auth, err := h.checkRequest(r)
+	if err != nil {
+		logger.Infof("authorization failed on changes/published request, granting no privileges: %v", err)
+	}
+	// The id is used to order entities published at the same
+	// time, so that the order is stable across pages. The limit
+	// is applied as the entities are read, because those that the
+	// user is not allowed to read are left out of the results.
+	iter := h.store.DB.Entities().
+		Find(findQuery).
+		Sort("-uploadtime", "-_id").
+		Select(bson.D{{"_id", 1}, {"baseurl", 1}, {"uploadtime", 1}, {"releasenotes", 1}}).
+		Iter()
 	results := []params.Published{}
+	readable := make(map[string]bool)
 	var entity mongodoc.Entity
-	for iter := query.Iter(); iter.Next(&entity); {
+	for len(results) != limit && iter.Next(&entity) {
+		canRead, ok := readable[entity.BaseURL.String()]
+		if !ok {
+			baseEntity, err := h.store.FindBaseEntity(entity.BaseURL, "acls")
+			if err != nil {
+				iter.Close()
+				return nil, errgo.Notef(err, "cannot retrieve entity %q for authorization", entity.URL)
+			}
+			canRead = h.canRead(auth, baseEntity.ACLs.Read)
+			readable[entity.BaseURL.String()] = canRead
+		}
+		if !canRead {
+			continue
+		}
 		results = append(results, params.Published{
-			Id:          entity.URL,
-			PublishTime: entity.UploadTime.UTC(),
+			Id:           entity.URL,
+			PublishTime:  entity.UploadTime.UTC(),
+			ReleaseNotes: entity.ReleaseNotes,
 		})
 	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot get published entities")
+	}
+	if len(results) == limit {
+		// There may be more results: tell the client
+		// where to continue from.
+		last := results[len(results)-1]
+		header.Set(params.NextCursorHeader, publishedCursor{
+			UploadTime: last.PublishTime,
+			Id:         last.Id.String(),
+		}.String())
+	}
+	if includes := r.Form["include"]; len(includes) > 0 {
+		if err := h.addPublishedMeta(results, includes, r); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
 	return results, nil
 }
 
+// publishedFilters returns the query filters specified by
+// the owner, series and type parameters of a changes/published
+// request.
+func publishedFilters(form url.Values) (bson.D, error) {
+	var filters bson.D
+	if owners := form["owner"]; len(owners) > 0 {
+		filters = append(filters, bson.DocElem{
+			Name:  "user",
+			Value: bson.D{{"$in", owners}},
+		})
+	}
+	series := form["series"]
+	switch entityType := form.Get("type"); entityType {
+	case "":
+	case "charm":
+		if len(series) == 0 {
+			filters = append(filters, bson.DocElem{
+				Name:  "series",
+				Value: bson.D{{"$ne", "bundle"}},
+			})
+			break
+		}
+		series = filterSeries(series, func(s string) bool { return s != "bundle" })
+	case "bundle":
+		if len(series) == 0 {
+			series = []string{"bundle"}
+			break
+		}
+		series = filterSeries(series, func(s string) bool { return s == "bundle" })
+	default:
+		return nil, badRequestf(nil, "invalid 'type' value %q", entityType)
+	}
+	if series != nil {
+		// Note that an empty series slice matches nothing, as
+		// happens when the series and type parameters conflict.
+		filters = append(filters, bson.DocElem{
+			Name:  "series",
+			Value: bson.D{{"$in", series}},
+		})
+	}
+	return filters, nil
+}
+
+// filterSeries returns the elements of series for which
+// keep returns true. It never returns a nil slice.
+func filterSeries(series []string, keep func(string) bool) []string {
+	filtered := []string{}
+	for _, s := range series {
+		if keep(s) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// addPublishedMeta adds the metadata specified by includes to the
+// given changes/published results, which only hold the entities
+// that can be read by the user making the request.
+func (h *Handler) addPublishedMeta(results []params.Published, includes []string, req *http.Request) error {
+	run := parallel.NewRun(maxConcurrency)
+	for i := range results {
+		result := &results[i]
+		run.Do(func() error {
+			meta, err := h.Router.GetMetadata(result.Id, includes, req)
+			if err != nil {
+				return errgo.Notef(err, "cannot retrieve metadata for %v", result.Id)
+			}
+			result.Meta = meta
+			return nil
+		})
+	}
+	if err := run.Wait(); err != nil {
+		// Return the first error, as the others
+		// are likely to be the same.
+		return errgo.Mask(err.(parallel.Errors)[0])
+	}
+	return nil
+}
+
+// canRead reports whether the given authorization
+// grants read access according to the given ACL.
+func (h *Handler) canRead(auth authorization, acl []string) bool {
+	for _, name := range acl {
+		if name == params.Everyone {
+			return true
+		}
+	}
+	return h.checkACLMembership(auth, acl) == nil
+}
+
+// publishedCursor holds the position in the changes/published
+// results after which the next page of results starts.
+type publishedCursor struct {
+	UploadTime time.Time `json:"t"`
+	Id         string    `json:"id"`
+}
+
+// String returns the opaque form of the cursor
+// that is sent to clients.
+func (c publishedCursor) String() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.URLEncoding.EncodeToString(data)
+}
+
+// parsePublishedCursor parses a cursor returned by publishedCursor.String.
+func parsePublishedCursor(s string) (publishedCursor, error) {
+	var cursor publishedCursor
+	data, err := base64.URLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.Id == "" {
+		return publishedCursor{}, badRequestf(nil, "invalid 'cursor' value %q", s)
+	}
+	return cursor, nil
+}
+
 func (h *Handler) serveMacaroon(_ http.Header, _ *http.Request) (interface{}, error) {
 	return h.newMacaroon()
 }
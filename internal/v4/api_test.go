@@ -42,6 +42,9 @@ import (
 var serverParams = charmstore.ServerParams{
 	AuthUsername: "test-user",
 	AuthPassword: "test-password",
+	// Allow the webhooks used in tests, whose hosts
+	// cannot be resolved or are on the loopback interface.
+	AllowPrivateWebhooks: true,
 }
 
 var es *elasticsearch.Database = &elasticsearch.Database{"localhost:9200"}
@@ -184,6 +187,17 @@ var metaEndpoints = []metaEndpoint{{
 	}),
 	checkURL:        "cs:precise/wordpress-23",
 	assertCheckData: entitySizeChecker,
+}, {
+	name: "hash",
+	get: entityGetter(func(entity *mongodoc.Entity) interface{} {
+		return &params.HashResponse{
+			Sum: entity.BlobHash,
+		}
+	}),
+	checkURL: "cs:precise/wordpress-23",
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data.(*params.HashResponse).Sum, gc.Matches, "[0-9a-f]{96}")
+	},
 }, {
 	name: "manifest",
 	get: zipGetter(func(r *zip.Reader) interface{} {
@@ -326,6 +340,19 @@ var metaEndpoints = []metaEndpoint{{
 	assertCheckData: func(c *gc.C, data interface{}) {
 		c.Assert(data, gc.DeepEquals, []string{params.Everyone, "bob"})
 	},
+}, {
+	name: "promulgated",
+	get: func(store *charmstore.Store, url *charm.Reference) (interface{}, error) {
+		e, err := store.FindBaseEntity(url)
+		if err != nil {
+			return nil, err
+		}
+		return params.PromulgatedResponse{e.Promulgated}, nil
+	},
+	checkURL: "cs:~bob/utopic/wordpress-2",
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, gc.Equals, params.PromulgatedResponse{Promulgated: false})
+	},
 }, {
 	name: "tags",
 	get: entityGetter(func(entity *mongodoc.Entity) interface{} {
@@ -343,6 +370,87 @@ var metaEndpoints = []metaEndpoint{{
 			Tags: []string{"openstack", "storage"},
 		})
 	},
+}, {
+	name: "hidden",
+	get: entityGetter(func(entity *mongodoc.Entity) interface{} {
+		return params.HiddenResponse{entity.Hidden}
+	}),
+	checkURL: "cs:precise/wordpress-23",
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, gc.Equals, params.HiddenResponse{Hidden: false})
+	},
+}, {
+	name: "release-notes",
+	get: entityGetter(func(entity *mongodoc.Entity) interface{} {
+		return params.ReleaseNotesResponse{entity.ReleaseNotes}
+	}),
+	checkURL: "cs:precise/wordpress-23",
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, gc.Equals, params.ReleaseNotesResponse{})
+	},
+}, {
+	name: "lint",
+	get: entityGetter(func(entity *mongodoc.Entity) interface{} {
+		warnings := make([]params.LintWarning, len(entity.LintWarnings))
+		for i, w := range entity.LintWarnings {
+			warnings[i] = params.LintWarning{w.Validator, w.Message}
+		}
+		return params.LintResponse{warnings}
+	}),
+	checkURL: "cs:precise/wordpress-23",
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, jc.DeepEquals, params.LintResponse{
+			Warnings: []params.LintWarning{},
+		})
+	},
+}, {
+	name:      "upgrade-info",
+	exclusive: charmOnly,
+	get: entityGetter(func(entity *mongodoc.Entity) interface{} {
+		if entity.CharmMeta == nil {
+			return nil
+		}
+		// The test entities have no newer revisions.
+		return &params.UpgradeInfoResponse{
+			Upgrades: []params.UpgradeInfo{},
+		}
+	}),
+	checkURL: "cs:precise/wordpress-23",
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, jc.DeepEquals, &params.UpgradeInfoResponse{
+			Upgrades: []params.UpgradeInfo{},
+		})
+	},
+}, {
+	name: "usage",
+	get: func(store *charmstore.Store, url *charm.Reference) (interface{}, error) {
+		base := *url
+		base.Series, base.Revision = "", -1
+		revisions, err := store.DB.Entities().Find(bson.D{{"baseurl", &base}}).Count()
+		if err != nil {
+			return nil, err
+		}
+		var entities []mongodoc.Entity
+		if err := store.DB.Entities().Find(bson.D{{"user", url.User}}).All(&entities); err != nil {
+			return nil, err
+		}
+		var storage int64
+		for _, e := range entities {
+			storage += e.Size
+		}
+		return params.UsageResponse{
+			User:      url.User,
+			Revisions: revisions,
+			Storage:   storage,
+		}, nil
+	},
+	checkURL: "cs:~bob/utopic/wordpress-2",
+	assertCheckData: func(c *gc.C, data interface{}) {
+		usage := data.(params.UsageResponse)
+		c.Assert(usage.User, gc.Equals, "bob")
+		c.Assert(usage.Revisions, gc.Equals, 1)
+		c.Assert(usage.Storage, gc.Not(gc.Equals), int64(0))
+	},
 }, {
 	name: "id-user",
 	get: func(store *charmstore.Store, url *charm.Reference) (interface{}, error) {
@@ -623,6 +731,154 @@ func (s *APISuite) TestMetaPermPutUnauthorized(c *gc.C) {
 	})
 }
 
+func (s *APISuite) TestHiddenRevisions(c *gc.C) {
+	s.addCharm(c, "wordpress", "precise/wordpress-1")
+	s.addCharm(c, "wordpress", "precise/wordpress-2")
+	s.assertGet(c, "wordpress/meta/hidden", params.HiddenResponse{Hidden: false})
+
+	// Hide the latest revision.
+	s.assertPut(c, "precise/wordpress-2/meta/hidden", params.HiddenResponse{Hidden: true})
+	entity, err := s.store.FindEntity(charm.MustParseReference("precise/wordpress-2"), "hidden")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Hidden, jc.IsTrue)
+
+	// Anonymous users see the previous revision when resolving ids.
+	s.assertGet(c, "wordpress/meta/id-revision", params.IdRevisionResponse{1})
+	s.assertGet(c, "wordpress/expand-id", []params.ExpandedId{{"cs:precise/wordpress-1"}})
+	s.assertGet(c, "precise/wordpress-1/meta/revision-info", params.RevisionInfoResponse{
+		Revisions: []*charm.Reference{charm.MustParseReference("cs:precise/wordpress-1")},
+	})
+
+	// The hidden revision itself cannot be read anonymously.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("precise/wordpress-2/meta/hidden"),
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody: params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: "authentication failed: missing HTTP auth header",
+		},
+	})
+
+	// Users with write access still see the hidden revision.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("wordpress/meta/id-revision"),
+		Username:   serverParams.AuthUsername,
+		Password:   serverParams.AuthPassword,
+		ExpectBody: params.IdRevisionResponse{2},
+	})
+
+	// Unhide the revision.
+	s.assertPut(c, "precise/wordpress-2/meta/hidden", params.HiddenResponse{Hidden: false})
+	s.assertGet(c, "wordpress/meta/id-revision", params.IdRevisionResponse{2})
+}
+
+func (s *APISuite) TestChangesPublishedExcludesHidden(c *gc.C) {
+	s.publishCharmsAtKnownTimes(c, publishedCharms)
+	s.assertPut(c, "precise/wordpress-3/meta/hidden", params.HiddenResponse{Hidden: true})
+	expect := make([]params.Published, 0, len(publishedCharms)-1)
+	for i := len(publishedCharms) - 2; i >= 0; i-- {
+		expect = append(expect, publishedCharms[i].published())
+	}
+	s.assertGet(c, "changes/published", expect)
+}
+
+func (s *APISuite) TestPutMetaHiddenBadBody(c *gc.C) {
+	s.addCharm(c, "wordpress", "precise/wordpress-1")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("precise/wordpress-1/meta/hidden"),
+		Method:  "PUT",
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+		},
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		Body:         strings.NewReader(`"bad"`),
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: "cannot unmarshal hidden value: json: cannot unmarshal string into Go value of type params.HiddenResponse",
+		},
+	})
+}
+
+func (s *APISuite) TestMetaReleaseNotes(c *gc.C) {
+	s.addCharm(c, "wordpress", "precise/wordpress-1")
+	s.assertGet(c, "precise/wordpress-1/meta/release-notes", params.ReleaseNotesResponse{})
+
+	s.assertPut(c, "precise/wordpress-1/meta/release-notes", params.ReleaseNotesResponse{
+		ReleaseNotes: "Fixed the install hook.",
+	})
+	s.assertGet(c, "precise/wordpress-1/meta/release-notes", params.ReleaseNotesResponse{
+		ReleaseNotes: "Fixed the install hook.",
+	})
+	entity, err := s.store.FindEntity(charm.MustParseReference("precise/wordpress-1"), "releasenotes")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.ReleaseNotes, gc.Equals, "Fixed the install hook.")
+}
+
+func (s *APISuite) TestPutMetaReleaseNotesErrors(c *gc.C) {
+	s.addCharm(c, "wordpress", "precise/wordpress-1")
+	longNotes, err := json.Marshal(params.ReleaseNotesResponse{
+		ReleaseNotes: strings.Repeat("x", charmstore.MaxReleaseNotesSize+1),
+	})
+	c.Assert(err, gc.IsNil)
+	tests := []struct {
+		about       string
+		body        string
+		expectError params.Error
+	}{{
+		about: "bad body",
+		body:  `"bad"`,
+		expectError: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: "cannot unmarshal release notes: json: cannot unmarshal string into Go value of type params.ReleaseNotesResponse",
+		},
+	}, {
+		about: "notes too long",
+		body:  string(longNotes),
+		expectError: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: "release notes too long (maximum 65536 bytes)",
+		},
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler: s.srv,
+			URL:     storeURL("precise/wordpress-1/meta/release-notes"),
+			Method:  "PUT",
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			Username:     serverParams.AuthUsername,
+			Password:     serverParams.AuthPassword,
+			Body:         strings.NewReader(test.body),
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   test.expectError,
+		})
+	}
+}
+
+func (s *APISuite) TestChangesPublishedIncludesReleaseNotes(c *gc.C) {
+	s.publishCharmsAtKnownTimes(c, publishedCharms)
+	id := publishedCharms[2].id
+	s.assertPut(c, strings.TrimPrefix(id, "cs:")+"/meta/release-notes", params.ReleaseNotesResponse{
+		ReleaseNotes: "Fixed the install hook.",
+	})
+	expect := make([]params.Published, 0, len(publishedCharms))
+	for i := len(publishedCharms) - 1; i >= 0; i-- {
+		published := publishedCharms[i].published()
+		if publishedCharms[i].id == id {
+			published.ReleaseNotes = "Fixed the install hook."
+		}
+		expect = append(expect, published)
+	}
+	s.assertGet(c, "changes/published", expect)
+}
+
 func (s *APISuite) TestExtraInfo(c *gc.C) {
 	id := "precise/wordpress-23"
 	s.addCharm(c, "wordpress", id)
@@ -1571,7 +1827,10 @@ func (p publishSpec) published() params.Published {
 	if err != nil {
 		panic(err)
 	}
-	return params.Published{id, t}
+	return params.Published{
+		Id:          id,
+		PublishTime: t,
+	}
 }
 
 var publishedCharms = []publishSpec{{
@@ -1680,6 +1939,20 @@ var changesPublishedErrorsTests = []struct {
 		Message: `invalid 'stop' value "baddate": parsing time "baddate" as "2006-01-02": cannot parse "baddate" as "2006"`,
 	},
 	status: http.StatusBadRequest,
+}, {
+	args: "?cursor=bad-wolf",
+	expect: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: `invalid 'cursor' value "bad-wolf"`,
+	},
+	status: http.StatusBadRequest,
+}, {
+	args: "?type=service",
+	expect: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: `invalid 'type' value "service"`,
+	},
+	status: http.StatusBadRequest,
 }}
 
 func (s *APISuite) TestChangesPublishedErrors(c *gc.C) {
@@ -1695,6 +1968,219 @@ func (s *APISuite) TestChangesPublishedErrors(c *gc.C) {
 	}
 }
 
+var publishedFilterEntities = []publishSpec{{
+	id:   "cs:~bob/precise/mysql-0",
+	time: "5432-10-12 00:00",
+}, {
+	id:   "cs:~bob/trusty/wordpress-0",
+	time: "5432-10-12 00:00",
+}, {
+	id:   "cs:~alice/trusty/mysql-0",
+	time: "5432-10-12 01:00",
+}, {
+	id:   "cs:~alice/bundle/wordpress-simple-0",
+	time: "5432-10-12 02:00",
+}}
+
+var changesPublishedFiltersTests = []struct {
+	args string
+	// expect holds indexes into publishedFilterEntities
+	// of the expected entities returned by changes/published.
+	expect []int
+}{{
+	args:   "",
+	expect: []int{3, 2, 1, 0},
+}, {
+	args:   "?owner=bob",
+	expect: []int{1, 0},
+}, {
+	args:   "?owner=bob&owner=alice",
+	expect: []int{3, 2, 1, 0},
+}, {
+	args:   "?series=trusty",
+	expect: []int{2, 1},
+}, {
+	args:   "?series=trusty&series=precise&owner=bob",
+	expect: []int{1, 0},
+}, {
+	args:   "?type=charm",
+	expect: []int{2, 1, 0},
+}, {
+	args:   "?type=bundle",
+	expect: []int{3},
+}, {
+	args:   "?type=bundle&series=trusty",
+	expect: []int{},
+}, {
+	args:   "?type=charm&series=trusty&series=bundle",
+	expect: []int{2, 1},
+}, {
+	args:   "?owner=nobody",
+	expect: []int{},
+}}
+
+func (s *APISuite) TestChangesPublishedFilters(c *gc.C) {
+	s.publishEntitiesAtKnownTimes(c, publishedFilterEntities)
+	for i, test := range changesPublishedFiltersTests {
+		c.Logf("test %d: %q", i, test.args)
+		expect := make([]params.Published, len(test.expect))
+		for j, index := range test.expect {
+			expect[j] = publishedFilterEntities[index].published()
+		}
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:    s.srv,
+			URL:        storeURL("changes/published") + test.args,
+			ExpectBody: expect,
+		})
+	}
+}
+
+func (s *APISuite) TestChangesPublishedCursor(c *gc.C) {
+	s.publishEntitiesAtKnownTimes(c, publishedFilterEntities)
+	// Entities published at the same time are ordered by id.
+	expect := []params.Published{
+		publishedFilterEntities[3].published(),
+		publishedFilterEntities[2].published(),
+		publishedFilterEntities[1].published(),
+		publishedFilterEntities[0].published(),
+	}
+	for _, limit := range []int{1, 2, 3, 4, 5} {
+		c.Logf("limit %d", limit)
+		var got []params.Published
+		cursor := ""
+		for page := 0; ; page++ {
+			c.Assert(page <= len(expect), gc.Equals, true, gc.Commentf("too many pages"))
+			url := storeURL("changes/published") + "?limit=" + strconv.Itoa(limit)
+			if cursor != "" {
+				url += "&cursor=" + cursor
+			}
+			rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+				Handler: s.srv,
+				URL:     url,
+			})
+			c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+			var results []params.Published
+			err := json.Unmarshal(rec.Body.Bytes(), &results)
+			c.Assert(err, gc.IsNil)
+			c.Assert(len(results) <= limit, gc.Equals, true)
+			got = append(got, results...)
+			cursor = rec.Header().Get(params.NextCursorHeader)
+			if cursor == "" {
+				break
+			}
+		}
+		c.Assert(got, jc.DeepEquals, expect)
+	}
+}
+
+func (s *APISuite) TestChangesPublishedCursorWithFilters(c *gc.C) {
+	s.publishEntitiesAtKnownTimes(c, publishedFilterEntities)
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("changes/published?type=charm&limit=2"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	cursor := rec.Header().Get(params.NextCursorHeader)
+	c.Assert(cursor, gc.Not(gc.Equals), "")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("changes/published?type=charm&limit=2&cursor=" + cursor),
+		ExpectBody: []params.Published{
+			publishedFilterEntities[0].published(),
+		},
+	})
+}
+
+func (s *APISuite) TestChangesPublishedInclude(c *gc.C) {
+	s.publishEntitiesAtKnownTimes(c, publishedFilterEntities)
+	expect := []params.Published{
+		publishedFilterEntities[3].published(),
+		publishedFilterEntities[2].published(),
+		publishedFilterEntities[1].published(),
+	}
+	for i := range expect {
+		expect[i].Meta = map[string]interface{}{
+			"id-revision": params.IdRevisionResponse{0},
+		}
+	}
+	s.assertGet(c, "changes/published?limit=3&include=id-revision", expect)
+}
+
+func (s *APISuite) TestChangesPublishedExcludesUnreadable(c *gc.C) {
+	s.publishEntitiesAtKnownTimes(c, publishedFilterEntities)
+	for _, id := range []string{"~alice/trusty/mysql-0", "~bob/trusty/wordpress-0"} {
+		s.assertPut(c, id+"/meta/release-notes", params.ReleaseNotesResponse{
+			ReleaseNotes: "Fixed the install hook.",
+		})
+	}
+	s.assertPut(c, "~alice/trusty/mysql-0/meta/perm/read", []string{"alice"})
+	all := []params.Published{
+		publishedFilterEntities[3].published(),
+		publishedFilterEntities[2].published(),
+		publishedFilterEntities[1].published(),
+		publishedFilterEntities[0].published(),
+	}
+	all[1].ReleaseNotes = "Fixed the install hook."
+	all[2].ReleaseNotes = "Fixed the install hook."
+	readable := []params.Published{all[0], all[2], all[3]}
+
+	// Charms the user cannot read are not listed, and
+	// pages are full even when some charms are left out.
+	for _, limit := range []int{1, 2, 3, 4} {
+		c.Logf("limit %d", limit)
+		var got []params.Published
+		cursor := ""
+		for page := 0; ; page++ {
+			c.Assert(page <= len(readable), gc.Equals, true, gc.Commentf("too many pages"))
+			url := storeURL("changes/published") + "?limit=" + strconv.Itoa(limit)
+			if cursor != "" {
+				url += "&cursor=" + cursor
+			}
+			rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+				Handler: s.srv,
+				URL:     url,
+			})
+			c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+			var results []params.Published
+			err := json.Unmarshal(rec.Body.Bytes(), &results)
+			c.Assert(err, gc.IsNil)
+			cursor = rec.Header().Get(params.NextCursorHeader)
+			if cursor != "" {
+				c.Assert(results, gc.HasLen, limit)
+			}
+			got = append(got, results...)
+			if cursor == "" {
+				break
+			}
+		}
+		c.Assert(got, jc.DeepEquals, readable)
+	}
+
+	// The administrator can read everything.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("changes/published"),
+		Username:   serverParams.AuthUsername,
+		Password:   serverParams.AuthPassword,
+		ExpectBody: all,
+	})
+}
+
+// publishEntitiesAtKnownTimes populates the store with the given
+// charms and bundles, setting their upload times as specified.
+func (s *APISuite) publishEntitiesAtKnownTimes(c *gc.C, entities []publishSpec) {
+	for _, e := range entities {
+		id := e.published().Id
+		if id.Series == "bundle" {
+			s.addBundle(c, "wordpress-simple", e.id)
+		} else {
+			s.addCharm(c, "wordpress", e.id)
+		}
+		err := s.store.DB.Entities().UpdateId(e.id, bson.D{{"$set", bson.D{{"uploadtime", e.published().PublishTime}}}})
+		c.Assert(err, gc.IsNil)
+	}
+}
+
 // publishCharmsAtKnownTimes populates the store with
 // a range of charms with known time stamps.
 func (s *APISuite) publishCharmsAtKnownTimes(c *gc.C, charms []publishSpec) {
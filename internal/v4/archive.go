@@ -4,11 +4,12 @@
 package v4
 
 import (
-	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"io"
 	"mime"
 	"net/http"
+	"os"
 	"path"
 	"path/filepath"
 	"sort"
@@ -22,6 +23,7 @@ import (
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
+	"github.com/juju/charmstore/internal/blobstore"
 	"github.com/juju/charmstore/internal/charmstore"
 	"github.com/juju/charmstore/internal/mongodoc"
 	"github.com/juju/charmstore/params"
@@ -30,13 +32,17 @@ import (
 // GET id/archive
 // http://tinyurl.com/qjrwq53
 //
-// POST id/archive?hash=sha384hash
+// POST id/archive?hash=sha384hash[&private=1][&hidden=1][&upload=$uploadId]
 // http://tinyurl.com/lzrzrgb
 //
+// POST id/archive?hash=sha384hash&size=$size&challenge=1[&private=1][&hidden=1]
+//
+// POST id/archive?hash=sha384hash&size=$size&challenge-id=$id&challenge-response=$hash[&private=1][&hidden=1]
+//
 // DELETE id/archive
 // http://tinyurl.com/ojmlwos
 //
-// PUT id/archive?hash=sha384hash
+// PUT id/archive?hash=sha384hash[&private=1][&hidden=1][&upload=$uploadId]
 // This is like POST except that it puts the archive to a known revision
 // rather than choosing a new one. As this feature is to support legacy
 // ingestion methods, and will be removed in the future, it has no entry
@@ -48,9 +54,13 @@ func (h *Handler) serveArchive(id *charm.Reference, fullySpecified bool, w http.
 		return errgo.Newf("method not allowed")
 	case "DELETE":
 		return h.serveDeleteArchive(id, w, req)
-	case "POST":
-		return h.servePostArchive(id, w, req)
-	case "PUT":
+	case "POST", "PUT":
+		if req.Form.Get("challenge") != "" {
+			return h.serveArchiveChallenge(id, w, req)
+		}
+		if req.Method == "POST" {
+			return h.servePostArchive(id, w, req)
+		}
 		return h.servePutArchive(id, w, req)
 	case "GET":
 	}
@@ -79,6 +89,12 @@ func (h *Handler) serveDeleteArchive(id *charm.Reference, w http.ResponseWriter,
 	if err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
+	// Record whether the revision is hidden before removing it, so
+	// that its deletion is only notified to those that can see it.
+	hidden, err := h.isHidden(id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
 	// Remove the entity.
 	if err := h.store.DB.Entities().RemoveId(id); err != nil {
 		return errgo.Notef(err, "cannot remove %s", id)
@@ -88,6 +104,11 @@ func (h *Handler) serveDeleteArchive(id *charm.Reference, w http.ResponseWriter,
 		return errgo.Notef(err, "cannot remove blob %s", blobName)
 	}
 	h.store.IncCounterAsync(charmstore.EntityStatsKey(id, params.StatsArchiveDelete))
+	h.notifyChange(&params.WebhookPayload{
+		Event:  params.DeleteEvent,
+		Id:     id,
+		Hidden: hidden,
+	})
 	return nil
 }
 
@@ -95,6 +116,10 @@ func (h *Handler) updateStatsArchiveUpload(id *charm.Reference, err *error) {
 	// Upload stats don't include revision: it is assumed that each
 	// entity revision is only uploaded once.
 	id.Revision = -1
+	if errgo.Cause(*err) == params.ErrContentRequired {
+		// Nothing has been uploaded yet.
+		return
+	}
 	kind := params.StatsArchiveUpload
 	if *err != nil {
 		kind = params.StatsArchiveFailedUpload
@@ -115,31 +140,47 @@ func (h *Handler) servePostArchive(id *charm.Reference, w http.ResponseWriter, r
 	if hash == "" {
 		return badRequestf(nil, "hash parameter not specified")
 	}
-	if req.ContentLength == -1 {
-		return badRequestf(nil, "Content-Length not specified")
+	body, size, upload, err := h.archiveContent(id, req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrNotFound))
+	}
+	defer body.Close()
+	flags, err := parseUploadFlags(req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	proof, err := parseContentProof(req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
 	}
 
-	oldId, oldHash, err := h.latestRevisionInfo(id)
+	oldId, reusable, err := h.latestRevisionInfo(id, hash, flags)
 	if err != nil && errgo.Cause(err) != params.ErrNotFound {
 		return errgo.Notef(err, "cannot get hash of latest revision")
 	}
-	if oldHash == hash {
-		// The hash matches the hash of the latest revision, so
-		// no need to upload anything.
+	if reusable {
+		// The latest revision is the same as the
+		// upload, so no need to upload anything.
+		h.removeUpload(upload)
 		return jsonhttp.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
 			Id: oldId,
 		})
 	}
 
+	if err := h.checkUploadLimits(id, size); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrQuotaExceeded))
+	}
+
 	// Choose the next revision number for the upload.
 	if oldId != nil {
 		id.Revision = oldId.Revision + 1
 	} else {
 		id.Revision = 0
 	}
-	if err := h.addBlobAndEntity(id, req.Body, hash, req.ContentLength); err != nil {
-		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
+	if err := h.addBlobAndEntity(id, body, hash, size, flags, proof); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload), errgo.Is(params.ErrContentRequired), errgo.Is(params.ErrBadRequest), isArchiveCheckError)
 	}
+	h.removeUpload(upload)
 	return jsonhttp.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
 		Id: id,
 	})
@@ -157,29 +198,233 @@ func (h *Handler) servePutArchive(id *charm.Reference, w http.ResponseWriter, re
 	if hash == "" {
 		return badRequestf(nil, "hash parameter not specified")
 	}
-	if req.ContentLength == -1 {
-		return badRequestf(nil, "Content-Length not specified")
+	body, size, upload, err := h.archiveContent(id, req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrNotFound))
 	}
-	if err := h.addBlobAndEntity(id, req.Body, hash, req.ContentLength); err != nil {
-		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
+	defer body.Close()
+	flags, err := parseUploadFlags(req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
 	}
+	proof, err := parseContentProof(req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	if err := h.checkUploadLimits(id, size); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrQuotaExceeded))
+	}
+	if err := h.addBlobAndEntity(id, body, hash, size, flags, proof); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload), errgo.Is(params.ErrContentRequired), errgo.Is(params.ErrBadRequest), isArchiveCheckError)
+	}
+	h.removeUpload(upload)
 	return jsonhttp.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
 		Id: id,
 	})
 	return nil
 }
 
+// uploadFlags holds optional flags that can be
+// specified when uploading a charm or bundle.
+type uploadFlags struct {
+	// private holds whether a newly created charm or bundle
+	// should be readable only by its owner.
+	private bool
+
+	// hidden holds whether the uploaded revision should
+	// be hidden from users without write access.
+	hidden bool
+}
+
+// parseUploadFlags returns the upload flags specified
+// in the given request form.
+func parseUploadFlags(req *http.Request) (uploadFlags, error) {
+	var flags uploadFlags
+	var err error
+	if flags.private, err = parseBool(req.Form.Get("private")); err != nil {
+		return uploadFlags{}, badRequestf(err, "invalid private parameter")
+	}
+	if flags.hidden, err = parseBool(req.Form.Get("hidden")); err != nil {
+		return uploadFlags{}, badRequestf(err, "invalid hidden parameter")
+	}
+	return flags, nil
+}
+
+// contentProof holds a proof that the client holds the content
+// of the archive being uploaded, answering a challenge issued by
+// serveArchiveChallenge.
+type contentProof struct {
+	// blobName holds the name of the blob
+	// the challenge was issued for.
+	blobName string
+
+	// response holds the response to the challenge.
+	response *blobstore.ContentChallengeResponse
+}
+
+// parseContentProof returns the content proof specified in
+// the given request form, or nil if none was specified.
+func parseContentProof(req *http.Request) (*contentProof, error) {
+	challengeId := req.Form.Get("challenge-id")
+	if challengeId == "" {
+		return nil, nil
+	}
+	i := strings.Index(challengeId, ".")
+	if i == -1 || !bson.IsObjectIdHex(challengeId[:i]) {
+		return nil, badRequestf(nil, "invalid challenge-id parameter %q", challengeId)
+	}
+	response := req.Form.Get("challenge-response")
+	if response == "" {
+		return nil, badRequestf(nil, "challenge-response parameter not specified")
+	}
+	return &contentProof{
+		blobName: challengeId[:i],
+		response: &blobstore.ContentChallengeResponse{
+			RequestId: challengeId[i+1:],
+			Hash:      response,
+		},
+	}, nil
+}
+
+// parseArchiveSize returns the archive size specified
+// in the size parameter of the given request form.
+func parseArchiveSize(req *http.Request) (int64, error) {
+	sizeStr := req.Form.Get("size")
+	if sizeStr == "" {
+		return 0, badRequestf(nil, "size parameter not specified")
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil || size < 0 {
+		return 0, badRequestf(nil, "invalid size parameter %q", sizeStr)
+	}
+	return size, nil
+}
+
+// serveArchiveChallenge serves a POST or PUT request to id/archive
+// made with the challenge flag. No archive content is sent: if the
+// charm store already holds content with the given hash, it issues a
+// proof-of-content challenge that the client answers by uploading
+// again with the challenge-id and challenge-response flags, still
+// without sending the content. Otherwise it returns an error with a
+// params.ErrContentRequired cause, and the client must upload the
+// archive content as usual.
+func (h *Handler) serveArchiveChallenge(id *charm.Reference, w http.ResponseWriter, req *http.Request) error {
+	if id.Series == "" {
+		return badRequestf(nil, "series not specified")
+	}
+	if req.Method == "POST" && id.Revision != -1 {
+		return badRequestf(nil, "revision specified, but should not be specified")
+	}
+	if req.Method == "PUT" && id.Revision == -1 {
+		return badRequestf(nil, "revision not specified")
+	}
+	hash := req.Form.Get("hash")
+	if hash == "" {
+		return badRequestf(nil, "hash parameter not specified")
+	}
+	size, err := parseArchiveSize(req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	flags, err := parseUploadFlags(req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	if req.Method == "POST" {
+		oldId, reusable, err := h.latestRevisionInfo(id, hash, flags)
+		if err != nil && errgo.Cause(err) != params.ErrNotFound {
+			return errgo.Notef(err, "cannot get hash of latest revision")
+		}
+		if reusable {
+			// As for a normal upload, there is no need
+			// to upload anything.
+			return jsonhttp.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
+				Id: oldId,
+			})
+		}
+	}
+	name := bson.NewObjectId().Hex()
+	chal, err := h.store.BlobStore.Put(nil, name, size, hash, nil)
+	if err != nil {
+		if errgo.Cause(err) == blobstore.ErrContentRequired {
+			return errgo.WithCausef(nil, params.ErrContentRequired, "archive content required")
+		}
+		return errgo.Notef(err, "cannot put archive blob")
+	}
+	return jsonhttp.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
+		Challenge: &params.ContentChallenge{
+			// The blob name is included so that the
+			// response can be checked against it.
+			RequestId:   name + "." + chal.RequestId,
+			RangeStart:  chal.RangeStart,
+			RangeLength: chal.RangeLength,
+		},
+	})
+}
+
+// putBlobWithProof adds the blob with the given name, hash and
+// size by using the given response to the challenge issued for
+// it by serveArchiveChallenge.
+func (h *Handler) putBlobWithProof(name, hash string, size int64, response *blobstore.ContentChallengeResponse) error {
+	chal, err := h.store.BlobStore.Put(nil, name, size, hash, response)
+	if errgo.Cause(err) == blobstore.ErrContentRequired || (err == nil && chal != nil) {
+		// The content has been removed since the challenge was
+		// issued, so the client has to upload it after all.
+		return errgo.WithCausef(nil, params.ErrContentRequired, "archive content required")
+	}
+	if err != nil {
+		return badRequestf(err, "invalid challenge response")
+	}
+	return nil
+}
+
+// checkUploadLimits checks that uploading an archive of the given
+// size to the given id would not exceed any of the configured
+// upload limits. It returns an error with a params.ErrQuotaExceeded
+// cause if it would.
+func (h *Handler) checkUploadLimits(id *charm.Reference, size int64) error {
+	if max := h.config.MaxArchiveSize; max > 0 && size > max {
+		return errgo.WithCausef(nil, params.ErrQuotaExceeded, "archive size %d exceeds maximum of %d bytes", size, max)
+	}
+	if max := h.config.MaxRevisions; max > 0 {
+		n, err := h.store.RevisionCount(id)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if n >= max {
+			return errgo.WithCausef(nil, params.ErrQuotaExceeded, "maximum of %d revisions reached", max)
+		}
+	}
+	if max := h.config.MaxUserStorage; max > 0 {
+		used, err := h.store.UserStorage(id.User)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if used+size > max {
+			return errgo.WithCausef(nil, params.ErrQuotaExceeded, "storage quota exceeded: %d of %d bytes used", used, max)
+		}
+	}
+	return nil
+}
+
 // addBlobAndEntity streams the contents of the given body
 // to the blob store and adds an entity record for it.
 // The hash and contentLength parameters hold
 // the content hash and the content length respectively.
-func (h *Handler) addBlobAndEntity(id *charm.Reference, body io.Reader, hash string, contentLength int64) (err error) {
+// If proof is not nil, the body is ignored and the blob
+// is added by proving that its content is already stored.
+func (h *Handler) addBlobAndEntity(id *charm.Reference, body io.Reader, hash string, contentLength int64, flags uploadFlags, proof *contentProof) (err error) {
 	// Upload the actual blob, and make sure that it is removed
 	// if we fail later.
 	name := bson.NewObjectId().Hex()
-	err = h.store.BlobStore.PutUnchallenged(body, name, contentLength, hash)
+	if proof != nil {
+		name = proof.blobName
+		err = h.putBlobWithProof(name, hash, contentLength, proof.response)
+	} else {
+		err = h.store.BlobStore.PutUnchallenged(body, name, contentLength, hash)
+	}
 	if err != nil {
-		return errgo.Notef(err, "cannot put archive blob")
+		return errgo.NoteMask(err, "cannot put archive blob", errgo.Is(params.ErrContentRequired), errgo.Is(params.ErrBadRequest))
 	}
 	r, _, err := h.store.BlobStore.Open(name)
 	if err != nil {
@@ -194,16 +439,47 @@ func (h *Handler) addBlobAndEntity(id *charm.Reference, body io.Reader, hash str
 	}()
 
 	// Add the entity entry to the charm store.
-	if err := h.addEntity(id, r, name, hash, contentLength); err != nil {
-		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
+	if err := h.addEntity(id, r, name, hash, contentLength, flags); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload), isArchiveCheckError)
 	}
+	h.notifyChange(&params.WebhookPayload{
+		Event:  params.UploadEvent,
+		Id:     id,
+		Hidden: flags.hidden,
+	})
 	return nil
 }
 
 // addEntity adds the entity represented by the contents
 // of the given reader, associating it with the given id.
-func (h *Handler) addEntity(id *charm.Reference, r io.ReadSeeker, blobName string, hash string, contentLength int64) error {
+func (h *Handler) addEntity(id *charm.Reference, r io.ReadSeeker, blobName string, hash string, contentLength int64, flags uploadFlags) error {
 	readerAt := charmstore.ReaderAtSeeker(r)
+	zr, err := charmstore.CheckArchive(readerAt, contentLength, charmstore.ArchiveLimits{
+		MaxUncompressedSize: h.config.MaxArchiveUncompressedSize,
+		MaxEntries:          h.config.MaxArchiveEntries,
+		MaxCompressionRatio: h.config.MaxArchiveCompressionRatio,
+	})
+	if err != nil {
+		if isArchiveCheckError(errgo.Cause(err)) {
+			return errgo.Mask(err, isArchiveCheckError)
+		}
+		if id.Series == "bundle" {
+			return errgo.Notef(err, "cannot read bundle archive")
+		}
+		return errgo.Notef(err, "cannot read charm archive")
+	}
+	manifest, err := charmstore.NewManifest(zr)
+	if err != nil {
+		return errgo.Notef(err, "cannot read archive manifest")
+	}
+	contents, err := charmstore.ArchiveContents(zr)
+	if err != nil {
+		return errgo.Notef(err, "cannot read archive contents")
+	}
+	releaseNotes, err := charmstore.ReleaseNotes(zr)
+	if err != nil {
+		return errgo.Notef(err, "cannot read release notes")
+	}
 	if id.Series == "bundle" {
 		b, err := charm.ReadBundleArchiveFromReader(readerAt, contentLength)
 		if err != nil {
@@ -218,11 +494,25 @@ func (h *Handler) addEntity(id *charm.Reference, r io.ReadSeeker, blobName strin
 			// TODO frankban: use multiError (defined in internal/router).
 			return errgo.Notef(verificationError(err), "bundle verification failed")
 		}
+		warnings, err := charmstore.Validate(&charmstore.UploadedEntity{
+			URL:     id,
+			Bundle:  b,
+			Archive: zr,
+		}, h.validators(id))
+		if err != nil {
+			return errgo.Mask(err)
+		}
 		if err := h.store.AddBundle(b, charmstore.AddParams{
-			URL:      id,
-			BlobName: blobName,
-			BlobHash: hash,
-			BlobSize: contentLength,
+			URL:          id,
+			BlobName:     blobName,
+			BlobHash:     hash,
+			BlobSize:     contentLength,
+			Contents:     contents,
+			Manifest:     manifest,
+			Private:      flags.private,
+			Hidden:       flags.hidden,
+			LintWarnings: warnings,
+			ReleaseNotes: releaseNotes,
 		}); err != nil {
 			return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
 		}
@@ -232,49 +522,69 @@ func (h *Handler) addEntity(id *charm.Reference, r io.ReadSeeker, blobName strin
 	if err != nil {
 		return errgo.Notef(err, "cannot read charm archive")
 	}
-	if err := checkCharmIsValid(ch); err != nil {
+	if err := charmstore.CheckCharmHooks(zr, ch.Meta()); err != nil {
+		return errgo.Mask(err, isArchiveCheckError)
+	}
+	warnings, err := charmstore.Validate(&charmstore.UploadedEntity{
+		URL:     id,
+		Charm:   ch,
+		Archive: zr,
+	}, h.validators(id))
+	if err != nil {
 		return errgo.Mask(err)
 	}
 	if err := h.store.AddCharm(ch, charmstore.AddParams{
-		URL:      id,
-		BlobName: blobName,
-		BlobHash: hash,
-		BlobSize: contentLength,
+		URL:          id,
+		BlobName:     blobName,
+		BlobHash:     hash,
+		BlobSize:     contentLength,
+		Contents:     contents,
+		Manifest:     manifest,
+		Private:      flags.private,
+		Hidden:       flags.hidden,
+		LintWarnings: warnings,
+		ReleaseNotes: releaseNotes,
 	}); err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
 	}
 	return nil
 }
 
-func checkCharmIsValid(ch charm.Charm) error {
-	m := ch.Meta()
-	for _, rels := range []map[string]charm.Relation{m.Provides, m.Requires, m.Peers} {
-		if err := checkRelationsAreValid(rels); err != nil {
-			return errgo.Mask(err)
-		}
+// isArchiveCheckError reports whether the given error cause
+// is one of the causes returned by the archive checks.
+func isArchiveCheckError(err error) bool {
+	switch err {
+	case params.ErrArchiveTooLarge, params.ErrUnsafeArchive, params.ErrInvalidArchive:
+		return true
 	}
-	return nil
+	return false
 }
 
-func checkRelationsAreValid(rels map[string]charm.Relation) error {
-	for _, rel := range rels {
-		if rel.Name == "relation-name" {
-			return errgo.Newf("relation %s has almost certainly not been changed from the template", rel.Name)
-		}
-		if rel.Interface == "interface-name" {
-			return errgo.Newf("interface %s in relation %s has almost certainly not been changed from the template", rel.Interface, rel.Name)
-		}
+// validators returns the names of the validators
+// to run on an upload to the given id.
+func (h *Handler) validators(id *charm.Reference) []string {
+	if names, ok := h.config.NamespaceValidators[id.User]; ok && id.User != "" {
+		return names
 	}
-	return nil
+	if h.config.Validators != nil {
+		return h.config.Validators
+	}
+	return charmstore.DefaultValidators
 }
 
-func (h *Handler) latestRevisionInfo(id *charm.Reference) (*charm.Reference, string, error) {
-	entities, err := h.store.FindEntities(id, "_id", "blobhash")
+// latestRevisionInfo returns the id of the latest revision of the
+// charm or bundle with the given id, and reports whether that
+// revision can be reused for an upload of an archive with the given
+// hash and flags, instead of adding a new revision. This is the case
+// when it has the same content and is hidden as requested. The
+// private flag has no effect, as the charm or bundle already exists.
+func (h *Handler) latestRevisionInfo(id *charm.Reference, hash string, flags uploadFlags) (*charm.Reference, bool, error) {
+	entities, err := h.store.FindEntities(id, "_id", "blobhash", "hidden")
 	if err != nil {
-		return nil, "", errgo.Mask(err)
+		return nil, false, errgo.Mask(err)
 	}
 	if len(entities) == 0 {
-		return nil, "", params.ErrNotFound
+		return nil, false, params.ErrNotFound
 	}
 	latest := entities[0]
 	for _, entity := range entities {
@@ -282,7 +592,7 @@ func (h *Handler) latestRevisionInfo(id *charm.Reference) (*charm.Reference, str
 			latest = entity
 		}
 	}
-	return latest.URL, latest.BlobHash, nil
+	return latest.URL, latest.BlobHash == hash && latest.Hidden == flags.hidden, nil
 }
 
 func verifyConstraints(s string) error {
@@ -293,46 +603,171 @@ func verifyConstraints(s string) error {
 // GET id/archive/…
 // http://tinyurl.com/lampm24
 func (h *Handler) serveArchiveFile(id *charm.Reference, fullySpecified bool, w http.ResponseWriter, req *http.Request) error {
-	r, size, _, err := h.store.OpenBlob(id)
+	entity, err := h.store.FindEntity(id, "_id", "blobname", "manifest", "contents")
 	if err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
-	defer r.Close()
-	zipReader, err := zip.NewReader(charmstore.ReaderAtSeeker(r), size)
+	manifest, err := h.store.EntityManifest(entity)
 	if err != nil {
-		return errgo.Notef(err, "cannot read archive data for %s", id)
+		return errgo.Notef(err, "cannot read archive manifest for %s", id)
 	}
 
 	// Retrieve the requested file from the zip archive.
 	filePath := strings.TrimPrefix(path.Clean(req.URL.Path), "/")
-	for _, file := range zipReader.File {
-		if path.Clean(file.Name) != filePath {
+	if filePath == "." {
+		filePath = ""
+	}
+	for _, file := range manifest {
+		if path.Clean(file.Name) != filePath || file.IsDir() {
 			continue
 		}
-		// The file is found.
-		fileInfo := file.FileInfo()
-		if fileInfo.IsDir() {
-			return errgo.WithCausef(nil, params.ErrForbidden, "directory listing not allowed")
+		// The file is found. Archive files are served from the
+		// charm store origin, so the sanitized icon is served in
+		// place of the original, and other files are never served
+		// with a content type that a browser would render actively.
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if id.Series != "bundle" && filePath == "icon.svg" {
+			icon, err := h.entityIcon(entity)
+			if err == nil {
+				w.Header().Set("Content-Type", "image/svg+xml")
+				w.Header().Set("Content-Length", strconv.Itoa(len(icon)))
+				setArchiveCacheControl(w.Header(), fullySpecified)
+				w.WriteHeader(http.StatusOK)
+				w.Write(icon)
+				return nil
+			}
+			if errgo.Cause(err) != params.ErrNotFound {
+				return errgo.Mask(err)
+			}
 		}
-		content, err := file.Open()
+		r, _, err := h.store.BlobStore.Open(entity.BlobName)
+		if err != nil {
+			return errgo.Notef(err, "cannot open archive data for %s", id)
+		}
+		defer r.Close()
+		content, err := charmstore.ZipFileReader(r, file.ZipFile())
 		if err != nil {
 			return errgo.Notef(err, "unable to read file %q", filePath)
 		}
-		defer content.Close()
 		// Send the response to the client.
-		ctype := mime.TypeByExtension(filepath.Ext(filePath))
-		if ctype != "" {
-			w.Header().Set("Content-Type", ctype)
+		ctype, body, err := archiveFileContentType(filePath, content)
+		if err != nil {
+			return errgo.Notef(err, "unable to read file %q", filePath)
+		}
+		if isActiveContentType(ctype) {
+			ctype = "text/plain; charset=utf-8"
 		}
-		w.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
 		setArchiveCacheControl(w.Header(), fullySpecified)
 		w.WriteHeader(http.StatusOK)
-		io.Copy(w, content)
+		io.Copy(w, body)
 		return nil
 	}
+	if entries, ok := archiveDirEntries(manifest, filePath); ok {
+		setArchiveCacheControl(w.Header(), fullySpecified)
+		return jsonhttp.WriteJSON(w, http.StatusOK, entries)
+	}
 	return errgo.WithCausef(nil, params.ErrNotFound, "file %q not found in the archive", filePath)
 }
 
+// archiveFileContentType returns the content type of the archive
+// file with the given name and contents, as determined by its
+// extension or, failing that, by its first bytes. It returns a
+// reader that reads the whole file contents.
+func archiveFileContentType(name string, r io.Reader) (string, io.Reader, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype, r, nil
+	}
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, errgo.Mask(err)
+	}
+	buf = buf[:n]
+	return http.DetectContentType(buf), io.MultiReader(bytes.NewReader(buf), r), nil
+}
+
+// isActiveContentType reports whether content of the given type
+// may run scripts when rendered by a browser.
+func isActiveContentType(ctype string) bool {
+	mediaType, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		// Be safe with types that cannot be parsed.
+		return true
+	}
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml", "text/xsl":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
+// archiveDirEntries returns the entries of the directory with the
+// given path in the archive with the given manifest, sorted by name. An empty path
+// refers to the root of the archive. It reports whether the
+// directory was found.
+//
+// Archives do not necessarily hold entries for directories, so a
+// directory is also taken to exist if it holds any file.
+func archiveDirEntries(manifest []mongodoc.ManifestFile, dir string) ([]params.ArchiveDirEntry, bool) {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	found := dir == ""
+	entries := make(map[string]params.ArchiveDirEntry)
+	for _, file := range manifest {
+		name := path.Clean(file.Name)
+		if name == dir && file.IsDir() {
+			found = true
+			continue
+		}
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		found = true
+		entryName := name[len(prefix):]
+		if i := strings.Index(entryName, "/"); i >= 0 {
+			// The file is inside a subdirectory.
+			entryName = entryName[:i]
+			if _, ok := entries[entryName]; !ok {
+				entries[entryName] = params.ArchiveDirEntry{
+					Name:  entryName,
+					IsDir: true,
+					Mode:  (os.ModeDir | 0755).String(),
+				}
+			}
+			continue
+		}
+		entry := params.ArchiveDirEntry{
+			Name:    entryName,
+			IsDir:   file.IsDir(),
+			Mode:    file.Mode.String(),
+			ModTime: file.ModTime.UTC(),
+		}
+		if !entry.IsDir {
+			entry.Size = file.Size
+		}
+		entries[entryName] = entry
+	}
+	if !found {
+		return nil, false
+	}
+	result := make([]params.ArchiveDirEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Sort(archiveDirEntriesByName(result))
+	return result, true
+}
+
+type archiveDirEntriesByName []params.ArchiveDirEntry
+
+func (s archiveDirEntriesByName) Len() int           { return len(s) }
+func (s archiveDirEntriesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s archiveDirEntriesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 func (h *Handler) bundleCharms(ids []string) (map[string]charm.Charm, error) {
 	numIds := len(ids)
 	urls := make([]*charm.Reference, 0, numIds)
@@ -346,7 +781,7 @@ func (h *Handler) bundleCharms(ids []string) (map[string]charm.Charm, error) {
 			// be returned to the user along with other bundle errors.
 			continue
 		}
-		if err = h.resolveURL(url); err != nil {
+		if err = ResolveURL(h.store, url); err != nil {
 			if errgo.Cause(err) == params.ErrNotFound {
 				// Ignore this error too, for the same reasons
 				// described above.
@@ -13,6 +13,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -22,6 +23,7 @@ import (
 	jc "github.com/juju/testing/checkers"
 	"github.com/juju/testing/httptesting"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
 	"gopkg.in/juju/charm.v4"
 	charmtesting "gopkg.in/juju/charm.v4/testing"
 	"gopkg.in/mgo.v2/bson"
@@ -392,6 +394,226 @@ func (s *ArchiveSuite) TestPostBundle(c *gc.C) {
 	s.assertUploadBundle(c, "POST", charm.MustParseReference("bundle/wordpress-simple-2"), "wordpress-simple")
 }
 
+func (s *ArchiveSuite) TestPostPrivateAndHidden(c *gc.C) {
+	path := storetesting.Charms.CharmArchivePath(c.MkDir(), "wordpress")
+	s.uploadWithQuery(c, "~bob/trusty/wordpress", path, "&private=1", "cs:~bob/trusty/wordpress-0")
+
+	// The base entity has been created without read access for everyone.
+	baseEntity, err := s.store.FindBaseEntity(charm.MustParseReference("~bob/trusty/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(baseEntity.Public, jc.IsFalse)
+	c.Assert(baseEntity.ACLs, jc.DeepEquals, mongodoc.ACL{
+		Read:  []string{"bob"},
+		Write: []string{"bob"},
+	})
+
+	// Upload a hidden revision.
+	path = storetesting.Charms.CharmArchivePath(c.MkDir(), "mysql")
+	s.uploadWithQuery(c, "~bob/trusty/wordpress", path, "&hidden=1", "cs:~bob/trusty/wordpress-1")
+	entity, err := s.store.FindEntity(charm.MustParseReference("~bob/trusty/wordpress-1"), "hidden")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Hidden, jc.IsTrue)
+
+	// The private flag only applies to the first upload,
+	// so the ACLs have not been changed.
+	baseEntity, err = s.store.FindBaseEntity(charm.MustParseReference("~bob/trusty/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(baseEntity.ACLs.Read, jc.DeepEquals, []string{"bob"})
+}
+
+func (s *ArchiveSuite) TestPostSameContentWithHiddenFlag(c *gc.C) {
+	path := storetesting.Charms.CharmArchivePath(c.MkDir(), "wordpress")
+	s.uploadWithQuery(c, "~bob/trusty/wordpress", path, "", "cs:~bob/trusty/wordpress-0")
+
+	// The latest revision is not hidden, so uploading the same
+	// content as a hidden revision adds a new revision...
+	s.uploadWithQuery(c, "~bob/trusty/wordpress", path, "&hidden=1", "cs:~bob/trusty/wordpress-1")
+	entity, err := s.store.FindEntity(charm.MustParseReference("~bob/trusty/wordpress-1"), "hidden")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Hidden, jc.IsTrue)
+
+	// ... which is reused by later hidden uploads...
+	s.uploadWithQuery(c, "~bob/trusty/wordpress", path, "&hidden=1", "cs:~bob/trusty/wordpress-1")
+
+	// ... but not by visible ones.
+	s.uploadWithQuery(c, "~bob/trusty/wordpress", path, "", "cs:~bob/trusty/wordpress-2")
+	entity, err = s.store.FindEntity(charm.MustParseReference("~bob/trusty/wordpress-2"), "hidden")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Hidden, jc.IsFalse)
+}
+
+func (s *ArchiveSuite) TestPostInvalidUploadFlags(c *gc.C) {
+	for _, name := range []string{"private", "hidden"} {
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:       s.srv,
+			URL:           storeURL("~bob/trusty/wordpress/archive?hash=1234&" + name + "=yes"),
+			Method:        "POST",
+			ContentLength: 10,
+			Header: http.Header{
+				"Content-Type": {"application/zip"},
+			},
+			Body:         strings.NewReader("0123456789"),
+			Username:     serverParams.AuthUsername,
+			Password:     serverParams.AuthPassword,
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody: params.Error{
+				Message: "invalid " + name + ` parameter: unexpected bool value "yes" (must be "0" or "1")`,
+				Code:    params.ErrBadRequest,
+			},
+		})
+	}
+}
+
+func (s *ArchiveSuite) TestPostUploadLimits(c *gc.C) {
+	wordpressPath := storetesting.Charms.CharmArchivePath(c.MkDir(), "wordpress")
+	mysqlPath := storetesting.Charms.CharmArchivePath(c.MkDir(), "mysql")
+	f, err := os.Open(wordpressPath)
+	c.Assert(err, gc.IsNil)
+	_, wordpressSize := hashOf(f)
+	f.Close()
+
+	// Check the archive size limit.
+	p := serverParams
+	p.MaxArchiveSize = 100
+	s.srv, s.store = newServer(c, s.Session, nil, p)
+	s.assertUploadQuotaExceeded(c, "~bob/trusty/wordpress", wordpressPath, fmt.Sprintf("archive size %d exceeds maximum of 100 bytes", wordpressSize))
+
+	// Check the revision limit.
+	p = serverParams
+	p.MaxRevisions = 1
+	s.srv, s.store = newServer(c, s.Session, nil, p)
+	s.uploadWithQuery(c, "~bob/trusty/wordpress", wordpressPath, "", "cs:~bob/trusty/wordpress-0")
+	s.assertUploadQuotaExceeded(c, "~bob/precise/wordpress", mysqlPath, "maximum of 1 revisions reached")
+
+	// Check the user storage limit. Uploads by other users are not affected.
+	p = serverParams
+	p.MaxUserStorage = wordpressSize + 1
+	s.srv, s.store = newServer(c, s.Session, nil, p)
+	s.assertUploadQuotaExceeded(c, "~bob/trusty/mysql", mysqlPath, fmt.Sprintf("storage quota exceeded: %d of %d bytes used", wordpressSize, wordpressSize+1))
+	s.uploadWithQuery(c, "~alice/trusty/mysql", mysqlPath, "", "cs:~alice/trusty/mysql-0")
+
+	// The usage is reported by the meta/usage endpoint.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("~bob/trusty/wordpress/meta/usage"),
+		ExpectBody: params.UsageResponse{
+			User:       "bob",
+			Revisions:  1,
+			Storage:    wordpressSize,
+			MaxStorage: wordpressSize + 1,
+		},
+	})
+}
+
+func (s *ArchiveSuite) TestPostLintWarnings(c *gc.C) {
+	p := serverParams
+	p.NamespaceValidators = map[string][]string{
+		"alice": {"readme"},
+		"carol": {},
+	}
+	s.srv, s.store = newServer(c, s.Session, nil, p)
+	path := storetesting.Charms.CharmArchivePath(c.MkDir(), "mysql")
+
+	// The default validators are used for most users.
+	s.uploadWithQuery(c, "~bob/trusty/mysql", path, "", "cs:~bob/trusty/mysql-0")
+	s.assertLint(c, "~bob/trusty/mysql-0", []params.LintWarning{{
+		Validator: "icon",
+		Message:   "no icon.svg file found",
+	}, {
+		Validator: "metadata",
+		Message:   "no tags specified",
+	}, {
+		Validator: "readme",
+		Message:   "no README file found",
+	}})
+
+	// Namespaces can use their own validators.
+	s.uploadWithQuery(c, "~alice/trusty/mysql", path, "", "cs:~alice/trusty/mysql-0")
+	s.assertLint(c, "~alice/trusty/mysql-0", []params.LintWarning{{
+		Validator: "readme",
+		Message:   "no README file found",
+	}})
+	s.uploadWithQuery(c, "~carol/trusty/mysql", path, "", "cs:~carol/trusty/mysql-0")
+	s.assertLint(c, "~carol/trusty/mysql-0", []params.LintWarning{})
+}
+
+func (s *ArchiveSuite) assertLint(c *gc.C, id string, expect []params.LintWarning) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL(id + "/meta/lint"),
+		ExpectBody: params.LintResponse{
+			Warnings: expect,
+		},
+	})
+}
+
+func (s *ArchiveSuite) TestNewServerWithUnknownValidator(c *gc.C) {
+	p := serverParams
+	p.NamespaceValidators = map[string][]string{
+		"alice": {"no-such-validator"},
+	}
+	db := s.Session.DB("charmstore")
+	_, err := charmstore.NewServer(db, nil, p, map[string]charmstore.NewAPIHandlerFunc{"v4": v4.NewAPIHandler})
+	c.Assert(err, gc.ErrorMatches, `unknown validator "no-such-validator"`)
+}
+
+// assertUploadQuotaExceeded checks that uploading the archive at
+// the given path to the given id fails with a quota error
+// with the given message.
+func (s *ArchiveSuite) assertUploadQuotaExceeded(c *gc.C, id, path, expectMessage string) {
+	f, err := os.Open(path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	hash, size := hashOf(f)
+	_, err = f.Seek(0, 0)
+	c.Assert(err, gc.IsNil)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:       s.srv,
+		URL:           storeURL(id + "/archive?hash=" + hash),
+		Method:        "POST",
+		ContentLength: size,
+		Header: http.Header{
+			"Content-Type": {"application/zip"},
+		},
+		Body:         f,
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody: params.Error{
+			Message: expectMessage,
+			Code:    params.ErrQuotaExceeded,
+		},
+	})
+}
+
+// uploadWithQuery uploads the archive at the given path to
+// the given id using a POST request with the given extra
+// query parameters, and checks that it is assigned the
+// expected id.
+func (s *ArchiveSuite) uploadWithQuery(c *gc.C, id, path, query, expectId string) {
+	f, err := os.Open(path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	hash, size := hashOf(f)
+	_, err = f.Seek(0, 0)
+	c.Assert(err, gc.IsNil)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:       s.srv,
+		URL:           storeURL(id + "/archive?hash=" + hash + query),
+		Method:        "POST",
+		ContentLength: size,
+		Header: http.Header{
+			"Content-Type": {"application/zip"},
+		},
+		Body:     f,
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+		ExpectBody: params.ArchiveUploadResponse{
+			Id: charm.MustParseReference(expectId),
+		},
+	})
+}
+
 func (s *ArchiveSuite) TestPostHashMismatch(c *gc.C) {
 	content := []byte("some content")
 	hash, _ := hashOf(bytes.NewReader(content))
@@ -416,6 +638,259 @@ func (s *ArchiveSuite) TestPostHashMismatch(c *gc.C) {
 	})
 }
 
+// postArchiveChallenge makes a body-less POST request to the
+// archive of the given id with the given query and returns the
+// response.
+func (s *ArchiveSuite) postArchiveChallenge(c *gc.C, id, query string) *httptest.ResponseRecorder {
+	return httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL(id + "/archive?" + query),
+		Method:   "POST",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+}
+
+func (s *ArchiveSuite) TestPostWithContentChallenge(c *gc.C) {
+	wordpress := s.assertUploadCharm(c, "POST", charm.MustParseReference("~charmers/precise/wordpress-0"), "wordpress")
+	f, err := os.Open(wordpress.Path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	hash, size := hashOf(f)
+	query := fmt.Sprintf("hash=%s&size=%d", hash, size)
+
+	// The content is already stored, so a challenge is issued.
+	rec := s.postArchiveChallenge(c, "~charmers/trusty/wordpress", query+"&challenge=1")
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+	var result params.ArchiveUploadResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Id, gc.IsNil)
+	c.Assert(result.Challenge, gc.NotNil)
+
+	// Answer the challenge without sending the content.
+	chal := &blobstore.ContentChallenge{
+		RequestId:   result.Challenge.RequestId,
+		RangeStart:  result.Challenge.RangeStart,
+		RangeLength: result.Challenge.RangeLength,
+	}
+	resp, err := blobstore.NewContentChallengeResponse(chal, f)
+	c.Assert(err, gc.IsNil)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/trusty/wordpress/archive?" + query + "&challenge-id=" + url.QueryEscape(resp.RequestId) + "&challenge-response=" + resp.Hash),
+		Method:   "POST",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+		ExpectBody: params.ArchiveUploadResponse{
+			Id: charm.MustParseReference("~charmers/trusty/wordpress-0"),
+		},
+	})
+
+	archiveBytes, err := ioutil.ReadFile(wordpress.Path)
+	c.Assert(err, gc.IsNil)
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/trusty/wordpress-0/archive"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.Bytes(), gc.DeepEquals, archiveBytes)
+
+	// The same content as the latest revision needs no challenge.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/trusty/wordpress/archive?" + query + "&challenge=1"),
+		Method:   "POST",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+		ExpectBody: params.ArchiveUploadResponse{
+			Id: charm.MustParseReference("~charmers/trusty/wordpress-0"),
+		},
+	})
+
+	// A hidden upload of the same content adds a new
+	// revision, so a challenge is issued.
+	rec = s.postArchiveChallenge(c, "~charmers/trusty/wordpress", query+"&challenge=1&hidden=1")
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+	result = params.ArchiveUploadResponse{}
+	err = json.Unmarshal(rec.Body.Bytes(), &result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Id, gc.IsNil)
+	c.Assert(result.Challenge, gc.NotNil)
+}
+
+func (s *ArchiveSuite) TestPutWithContentChallenge(c *gc.C) {
+	wordpress := s.assertUploadCharm(c, "POST", charm.MustParseReference("~charmers/precise/wordpress-0"), "wordpress")
+	f, err := os.Open(wordpress.Path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	hash, size := hashOf(f)
+	query := fmt.Sprintf("hash=%s&size=%d", hash, size)
+
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/trusty/wordpress-7/archive?" + query + "&challenge=1"),
+		Method:   "PUT",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+	var result params.ArchiveUploadResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Challenge, gc.NotNil)
+	resp, err := blobstore.NewContentChallengeResponse(&blobstore.ContentChallenge{
+		RequestId:   result.Challenge.RequestId,
+		RangeStart:  result.Challenge.RangeStart,
+		RangeLength: result.Challenge.RangeLength,
+	}, f)
+	c.Assert(err, gc.IsNil)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/trusty/wordpress-7/archive?" + query + "&challenge-id=" + url.QueryEscape(resp.RequestId) + "&challenge-response=" + resp.Hash),
+		Method:   "PUT",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+		ExpectBody: params.ArchiveUploadResponse{
+			Id: charm.MustParseReference("~charmers/trusty/wordpress-7"),
+		},
+	})
+}
+
+func (s *ArchiveSuite) TestPostWithContentChallengeContentRequired(c *gc.C) {
+	hash, size := hashOf(strings.NewReader("some content"))
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL(fmt.Sprintf("~charmers/trusty/wordpress/archive?hash=%s&size=%d&challenge=1", hash, size)),
+		Method:       "POST",
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusPreconditionFailed,
+		ExpectBody: params.Error{
+			Message: "archive content required",
+			Code:    params.ErrContentRequired,
+		},
+	})
+}
+
+func (s *ArchiveSuite) TestPostWithInvalidChallengeResponse(c *gc.C) {
+	wordpress := s.assertUploadCharm(c, "POST", charm.MustParseReference("~charmers/precise/wordpress-0"), "wordpress")
+	f, err := os.Open(wordpress.Path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	hash, size := hashOf(f)
+	query := fmt.Sprintf("hash=%s&size=%d", hash, size)
+
+	rec := s.postArchiveChallenge(c, "~charmers/trusty/wordpress", query+"&challenge=1")
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+	var result params.ArchiveUploadResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &result)
+	c.Assert(err, gc.IsNil)
+
+	rec = s.postArchiveChallenge(c, "~charmers/trusty/wordpress", query+"&challenge-id="+url.QueryEscape(result.Challenge.RequestId)+"&challenge-response=bad")
+	c.Assert(rec.Code, gc.Equals, http.StatusBadRequest, gc.Commentf("body: %s", rec.Body.Bytes()))
+	var perr params.Error
+	err = json.Unmarshal(rec.Body.Bytes(), &perr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(perr.Code, gc.Equals, params.ErrBadRequest)
+	c.Assert(perr.Message, gc.Matches, "cannot put archive blob: invalid challenge response: .*")
+
+	// No entity has been added.
+	_, err = s.store.FindEntity(charm.MustParseReference("~charmers/trusty/wordpress-0"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *ArchiveSuite) TestPostWithChallengeIssuedByAnotherServer(c *gc.C) {
+	wordpress := s.assertUploadCharm(c, "POST", charm.MustParseReference("~charmers/precise/wordpress-0"), "wordpress")
+	f, err := os.Open(wordpress.Path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	hash, size := hashOf(f)
+	query := fmt.Sprintf("hash=%s&size=%d", hash, size)
+
+	rec := s.postArchiveChallenge(c, "~charmers/trusty/wordpress", query+"&challenge=1")
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+	var result params.ArchiveUploadResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &result)
+	c.Assert(err, gc.IsNil)
+	resp, err := blobstore.NewContentChallengeResponse(&blobstore.ContentChallenge{
+		RequestId:   result.Challenge.RequestId,
+		RangeStart:  result.Challenge.RangeStart,
+		RangeLength: result.Challenge.RangeLength,
+	}, f)
+	c.Assert(err, gc.IsNil)
+
+	// Challenges are only known to the server that issued them,
+	// so another server requires the content to be uploaded.
+	srv, _ := newServer(c, s.Session, nil, serverParams)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      srv,
+		URL:          storeURL("~charmers/trusty/wordpress/archive?" + query + "&challenge-id=" + url.QueryEscape(resp.RequestId) + "&challenge-response=" + resp.Hash),
+		Method:       "POST",
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusPreconditionFailed,
+		ExpectBody: params.Error{
+			Message: "cannot put archive blob: archive content required",
+			Code:    params.ErrContentRequired,
+		},
+	})
+	_, err = s.store.FindEntity(charm.MustParseReference("~charmers/trusty/wordpress-0"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+var archiveChallengeErrorsTests = []struct {
+	about         string
+	path          string
+	expectMessage string
+}{{
+	about:         "no size",
+	path:          "~charmers/trusty/wordpress/archive?hash=xxx&challenge=1",
+	expectMessage: "size parameter not specified",
+}, {
+	about:         "invalid size",
+	path:          "~charmers/trusty/wordpress/archive?hash=xxx&size=-1&challenge=1",
+	expectMessage: `invalid size parameter "-1"`,
+}, {
+	about:         "no hash",
+	path:          "~charmers/trusty/wordpress/archive?size=10&challenge=1",
+	expectMessage: "hash parameter not specified",
+}, {
+	about:         "revision specified",
+	path:          "~charmers/trusty/wordpress-2/archive?hash=xxx&size=10&challenge=1",
+	expectMessage: "revision specified, but should not be specified",
+}, {
+	about:         "invalid challenge id",
+	path:          "~charmers/trusty/wordpress/archive?hash=xxx&size=10&challenge-id=bad&challenge-response=xxx",
+	expectMessage: `invalid challenge-id parameter "bad"`,
+}, {
+	about:         "no challenge response",
+	path:          "~charmers/trusty/wordpress/archive?hash=xxx&size=10&challenge-id=" + bson.NewObjectId().Hex() + ".1",
+	expectMessage: "challenge-response parameter not specified",
+}, {
+	about:         "challenge id with upload",
+	path:          "~charmers/trusty/wordpress/archive?hash=xxx&size=10&challenge-id=x.1&upload=foo",
+	expectMessage: "upload and challenge-id parameters both specified",
+}}
+
+func (s *ArchiveSuite) TestArchiveChallengeErrors(c *gc.C) {
+	for i, test := range archiveChallengeErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL(test.path),
+			Method:       "POST",
+			Username:     serverParams.AuthUsername,
+			Password:     serverParams.AuthPassword,
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody: params.Error{
+				Message: test.expectMessage,
+				Code:    params.ErrBadRequest,
+			},
+		})
+	}
+}
+
 func invalidZip() io.ReadSeeker {
 	return strings.NewReader("invalid zip content")
 }
@@ -521,6 +996,231 @@ func (s *ArchiveSuite) TestPostInvalidCharmMetadata(c *gc.C) {
 	}
 }
 
+const safetyCheckMeta = `
+name: foo
+summary: bar
+description: d
+`
+
+var postArchiveSafetyTests = []struct {
+	about        string
+	id           string
+	files        map[string]string
+	modes        map[string]os.FileMode
+	expectStatus int
+	expectError  params.Error
+}{{
+	about: "path traversal in charm",
+	id:    "trusty/foo",
+	files: map[string]string{
+		"metadata.yaml":     safetyCheckMeta,
+		"../../etc/crontab": "* * * * * root rm -rf /",
+	},
+	expectStatus: http.StatusBadRequest,
+	expectError: params.Error{
+		Message: `archive entry "../../etc/crontab" refers outside the archive`,
+		Code:    params.ErrUnsafeArchive,
+	},
+}, {
+	about: "symlink escaping bundle",
+	id:    "bundle/foo",
+	files: map[string]string{
+		"bundle.yaml": "services: {}",
+		"README.md":   "/etc/passwd",
+	},
+	modes: map[string]os.FileMode{
+		"README.md": os.ModeSymlink | 0777,
+	},
+	expectStatus: http.StatusBadRequest,
+	expectError: params.Error{
+		Message: `symlink "README.md" refers outside the archive`,
+		Code:    params.ErrUnsafeArchive,
+	},
+}, {
+	about: "non-executable hook",
+	id:    "trusty/foo",
+	files: map[string]string{
+		"metadata.yaml": safetyCheckMeta,
+		"hooks/install": "#!/bin/sh\n",
+	},
+	expectStatus: http.StatusBadRequest,
+	expectError: params.Error{
+		Message: `hook "install" is not executable`,
+		Code:    params.ErrInvalidArchive,
+	},
+}}
+
+func (s *ArchiveSuite) TestPostArchiveSafetyChecks(c *gc.C) {
+	for i, test := range postArchiveSafetyTests {
+		c.Logf("test %d: %s", i, test.about)
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for name, content := range test.files {
+			header := &zip.FileHeader{
+				Name:   name,
+				Method: zip.Deflate,
+			}
+			mode, ok := test.modes[name]
+			if !ok {
+				mode = 0644
+			}
+			header.SetMode(mode)
+			f, err := w.CreateHeader(header)
+			c.Assert(err, gc.IsNil)
+			_, err = f.Write([]byte(content))
+			c.Assert(err, gc.IsNil)
+		}
+		c.Assert(w.Close(), gc.IsNil)
+		hash, size := hashOf(bytes.NewReader(buf.Bytes()))
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:       s.srv,
+			URL:           storeURL(test.id + "/archive?hash=" + hash),
+			Method:        "POST",
+			ContentLength: size,
+			Header: http.Header{
+				"Content-Type": {"application/zip"},
+			},
+			Body:         bytes.NewReader(buf.Bytes()),
+			Username:     serverParams.AuthUsername,
+			Password:     serverParams.AuthPassword,
+			ExpectStatus: test.expectStatus,
+			ExpectBody:   test.expectError,
+		})
+	}
+}
+
+func (s *ArchiveSuite) TestPostArchiveTooLarge(c *gc.C) {
+	p := serverParams
+	p.MaxArchiveEntries = 2
+	s.srv, s.store = newServer(c, s.Session, nil, p)
+	path := storetesting.Charms.CharmArchivePath(c.MkDir(), "wordpress")
+	f, err := os.Open(path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	hash, size := hashOf(f)
+	_, err = f.Seek(0, 0)
+	c.Assert(err, gc.IsNil)
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:       s.srv,
+		URL:           storeURL("trusty/wordpress/archive?hash=" + hash),
+		Method:        "POST",
+		ContentLength: size,
+		Header: http.Header{
+			"Content-Type": {"application/zip"},
+		},
+		Body:     f,
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusRequestEntityTooLarge)
+	var perr params.Error
+	err = json.Unmarshal(rec.Body.Bytes(), &perr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(perr.Code, gc.Equals, params.ErrArchiveTooLarge)
+	c.Assert(perr.Message, gc.Matches, `archive has [0-9]+ entries, exceeding the maximum of 2`)
+}
+
+func (s *ArchiveSuite) TestPostSanitizesIcon(c *gc.C) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"metadata.yaml": safetyCheckMeta,
+		"icon.svg":      `<svg xmlns="http://www.w3.org/2000/svg" onload="evil()"><script>evil()</script></svg>`,
+	} {
+		f, err := w.Create(name)
+		c.Assert(err, gc.IsNil)
+		_, err = f.Write([]byte(content))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(w.Close(), gc.IsNil)
+	hash, size := hashOf(bytes.NewReader(buf.Bytes()))
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:       s.srv,
+		URL:           storeURL("trusty/foo/archive?hash=" + hash),
+		Method:        "POST",
+		ContentLength: size,
+		Header: http.Header{
+			"Content-Type": {"application/zip"},
+		},
+		Body:     bytes.NewReader(buf.Bytes()),
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+		ExpectBody: params.ArchiveUploadResponse{
+			Id: charm.MustParseReference("cs:trusty/foo-0"),
+		},
+	})
+
+	// The sanitized icon has been cached in the entity.
+	entity, err := s.store.FindEntity(charm.MustParseReference("cs:trusty/foo-0"), "contents")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(entity.Contents[mongodoc.FileIcon].Data), gc.Equals, `<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+
+	// The removed content is reported as lint warnings.
+	s.assertLint(c, "trusty/foo-0", []params.LintWarning{{
+		Validator: "icon",
+		Message:   `icon.svg contains unsafe content: event handler attribute "onload"`,
+	}, {
+		Validator: "icon",
+		Message:   "icon.svg contains unsafe content: script element",
+	}, {
+		Validator: "metadata",
+		Message:   "no tags specified",
+	}, {
+		Validator: "readme",
+		Message:   "no README file found",
+	}})
+
+	// The sanitized icon is served in place of the original
+	// one, including by the archive file endpoint.
+	for _, path := range []string{"trusty/foo-0/icon.svg", "trusty/foo-0/archive/icon.svg"} {
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler: s.srv,
+			URL:     storeURL(path),
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusOK)
+		c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "image/svg+xml")
+		c.Assert(rec.Body.String(), gc.Equals, `<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+	}
+}
+
+func (s *ArchiveSuite) TestPostExtractsReleaseNotes(c *gc.C) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"metadata.yaml": safetyCheckMeta,
+		"CHANGELOG.md":  "# Changelog\n\n## 1.1\n- Fix the install hook.\n\n## 1.0\n- Initial release.\n",
+	} {
+		f, err := w.Create(name)
+		c.Assert(err, gc.IsNil)
+		_, err = f.Write([]byte(content))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(w.Close(), gc.IsNil)
+	hash, size := hashOf(bytes.NewReader(buf.Bytes()))
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:       s.srv,
+		URL:           storeURL("trusty/foo/archive?hash=" + hash),
+		Method:        "POST",
+		ContentLength: size,
+		Header: http.Header{
+			"Content-Type": {"application/zip"},
+		},
+		Body:     bytes.NewReader(buf.Bytes()),
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+		ExpectBody: params.ArchiveUploadResponse{
+			Id: charm.MustParseReference("cs:trusty/foo-0"),
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("trusty/foo-0/meta/release-notes"),
+		ExpectBody: params.ReleaseNotesResponse{
+			ReleaseNotes: "## 1.1\n- Fix the install hook.",
+		},
+	})
+}
+
 func (s *ArchiveSuite) TestPostInvalidBundleData(c *gc.C) {
 	path := storetesting.Charms.BundleArchivePath(c.MkDir(), "bad")
 	f, err := os.Open(path)
@@ -696,12 +1396,6 @@ var archiveFileErrorsTests = []struct {
 	expectStatus:  http.StatusNotFound,
 	expectMessage: "entity not found",
 	expectCode:    params.ErrNotFound,
-}, {
-	about:         "directory listing",
-	path:          "utopic/wordpress-0/archive/hooks",
-	expectStatus:  http.StatusForbidden,
-	expectMessage: "directory listing not allowed",
-	expectCode:    params.ErrForbidden,
 }, {
 	about:         "file not found",
 	path:          "utopic/wordpress-0/archive/no-such",
@@ -783,6 +1477,198 @@ func (s *ArchiveSuite) assertArchiveFileContents(c *gc.C, zipFile *zip.ReadClose
 	assertCacheControl(c, rec.Header(), true)
 }
 
+var archiveFileTime = time.Date(2015, 1, 2, 3, 4, 6, 0, time.UTC)
+
+// archiveFileTestFiles holds the files in the archive
+// used by the archive file tests.
+var archiveFileTestFiles = []struct {
+	name    string
+	content string
+	mode    os.FileMode
+}{
+	{"metadata.yaml", "name: foo\nsummary: foo\ndescription: bar\n", 0644},
+	{"hooks/", "", os.ModeDir | 0755},
+	{"hooks/install", "#!/bin/sh\n", 0755},
+	{"icon.svg", `<svg xmlns="http://www.w3.org/2000/svg"/>`, 0644},
+	{"templates/site/index.html", "<html></html>", 0644},
+	{"templates/site/logo.svg", `<svg xmlns="http://www.w3.org/2000/svg"><script>evil()</script></svg>`, 0644},
+	{"templates/site/feed.xml", "<?xml version=\"1.0\"?><feed/>", 0644},
+	{"templates/site/page", "<html><script>evil()</script></html>", 0644},
+	{"templates/logo", "\x89PNG\x0d\x0a\x1a\x0a", 0644},
+}
+
+// addArchiveFileTestCharm uploads a charm holding
+// archiveFileTestFiles to the given id, which must
+// not hold a revision.
+func (s *ArchiveSuite) addArchiveFileTestCharm(c *gc.C, id string) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, file := range archiveFileTestFiles {
+		header := &zip.FileHeader{
+			Name:   file.name,
+			Method: zip.Deflate,
+		}
+		header.SetMode(file.mode)
+		header.SetModTime(archiveFileTime)
+		f, err := w.CreateHeader(header)
+		c.Assert(err, gc.IsNil)
+		_, err = f.Write([]byte(file.content))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(w.Close(), gc.IsNil)
+	hash, size := hashOf(bytes.NewReader(buf.Bytes()))
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:       s.srv,
+		URL:           storeURL(id + "/archive?hash=" + hash),
+		Method:        "POST",
+		ContentLength: size,
+		Header: http.Header{
+			"Content-Type": {"application/zip"},
+		},
+		Body:     bytes.NewReader(buf.Bytes()),
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+}
+
+var archiveFileContentTypeTests = []struct {
+	path              string
+	expectContentType string
+}{{
+	path:              "hooks/install",
+	expectContentType: "text/plain; charset=utf-8",
+}, {
+	path:              "icon.svg",
+	expectContentType: "image/svg+xml",
+}, {
+	path:              "templates/site/index.html",
+	expectContentType: "text/plain; charset=utf-8",
+}, {
+	path:              "templates/site/logo.svg",
+	expectContentType: "text/plain; charset=utf-8",
+}, {
+	path:              "templates/site/feed.xml",
+	expectContentType: "text/plain; charset=utf-8",
+}, {
+	path:              "templates/site/page",
+	expectContentType: "text/plain; charset=utf-8",
+}, {
+	path:              "templates/logo",
+	expectContentType: "image/png",
+}}
+
+func (s *ArchiveSuite) TestArchiveFileContentType(c *gc.C) {
+	s.addArchiveFileTestCharm(c, "utopic/foo")
+	for i, test := range archiveFileContentTypeTests {
+		c.Logf("test %d: %s", i, test.path)
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler: s.srv,
+			URL:     storeURL("utopic/foo-0/archive/" + test.path),
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusOK)
+		c.Assert(rec.Header().Get("Content-Type"), gc.Equals, test.expectContentType)
+		c.Assert(rec.Header().Get("X-Content-Type-Options"), gc.Equals, "nosniff")
+		for _, file := range archiveFileTestFiles {
+			if file.name != test.path {
+				continue
+			}
+			expect := file.content
+			if file.name == "icon.svg" {
+				// The icon is sanitized.
+				icon, _, err := charmstore.ReadIcon(strings.NewReader(file.content))
+				c.Assert(err, gc.IsNil)
+				expect = string(icon)
+			}
+			c.Assert(rec.Body.String(), gc.Equals, expect)
+		}
+	}
+}
+
+var archiveDirListingTests = []struct {
+	about  string
+	path   string
+	expect []params.ArchiveDirEntry
+}{{
+	about: "root directory",
+	path:  "",
+	expect: []params.ArchiveDirEntry{{
+		Name:    "hooks",
+		IsDir:   true,
+		Mode:    "drwxr-xr-x",
+		ModTime: archiveFileTime,
+	}, {
+		Name:    "icon.svg",
+		Size:    41,
+		Mode:    "-rw-r--r--",
+		ModTime: archiveFileTime,
+	}, {
+		Name:    "metadata.yaml",
+		Size:    40,
+		Mode:    "-rw-r--r--",
+		ModTime: archiveFileTime,
+	}, {
+		Name:  "templates",
+		IsDir: true,
+		Mode:  "drwxr-xr-x",
+	}},
+}, {
+	about: "directory with its own archive entry",
+	path:  "hooks/",
+	expect: []params.ArchiveDirEntry{{
+		Name:    "install",
+		Size:    10,
+		Mode:    "-rwxr-xr-x",
+		ModTime: archiveFileTime,
+	}},
+}, {
+	about: "directory without a trailing slash",
+	path:  "hooks",
+	expect: []params.ArchiveDirEntry{{
+		Name:    "install",
+		Size:    10,
+		Mode:    "-rwxr-xr-x",
+		ModTime: archiveFileTime,
+	}},
+}, {
+	about: "implicit directory",
+	path:  "templates/",
+	expect: []params.ArchiveDirEntry{{
+		Name:    "logo",
+		Size:    8,
+		Mode:    "-rw-r--r--",
+		ModTime: archiveFileTime,
+	}, {
+		Name:  "site",
+		IsDir: true,
+		Mode:  "drwxr-xr-x",
+	}},
+}}
+
+func (s *ArchiveSuite) TestArchiveDirListing(c *gc.C) {
+	s.addArchiveFileTestCharm(c, "utopic/foo")
+	patchArchiveCacheAges(s)
+	for i, test := range archiveDirListingTests {
+		c.Logf("test %d: %s", i, test.about)
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler: s.srv,
+			URL:     storeURL("utopic/foo-0/archive/" + test.path),
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+		c.Assert(rec.Body.String(), jc.JSONEquals, test.expect)
+		assertCacheControl(c, rec.Header(), true)
+	}
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("utopic/foo-0/archive/templates/no-such/"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Message: `file "templates/no-such" not found in the archive`,
+			Code:    params.ErrNotFound,
+		},
+	})
+}
+
 func (s *ArchiveSuite) TestBundleCharms(c *gc.C) {
 	// Populate the store with some testing charms.
 	mysql := storetesting.Charms.CharmArchive(c.MkDir(), "mysql")
@@ -15,6 +15,7 @@ import (
 	"gopkg.in/macaroon-bakery.v0/httpbakery"
 	"gopkg.in/macaroon.v1"
 
+	"github.com/juju/charmstore/internal/mongodoc"
 	"github.com/juju/charmstore/params"
 )
 
@@ -115,7 +116,74 @@ func (h *Handler) authorizeEntity(id *charm.Reference, req *http.Request) error
 		}
 		return errgo.Notef(err, "cannot retrieve entity %q for authorization", id)
 	}
-	return h.authorizeWithPerms(req, baseEntity.ACLs.Read, baseEntity.ACLs.Write)
+	readPerm := baseEntity.ACLs.Read
+	hidden, err := h.isHidden(id)
+	if err != nil {
+		return errgo.Notef(err, "cannot retrieve entity %q for authorization", id)
+	}
+	if hidden {
+		// Hidden revisions can be read only by
+		// users that are also allowed to write.
+		readPerm = baseEntity.ACLs.Write
+	}
+	return h.authorizeWithPerms(req, readPerm, baseEntity.ACLs.Write)
+}
+
+// isHidden reports whether the entity with the given id
+// is a hidden revision. It returns false if the id is not
+// fully specified or the entity does not exist.
+func (h *Handler) isHidden(id *charm.Reference) (bool, error) {
+	if id.Series == "" || id.Revision == -1 {
+		return false, nil
+	}
+	entity, err := h.store.FindEntity(id, "hidden")
+	if errgo.Cause(err) == params.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	return entity.Hidden, nil
+}
+
+// canSeeHidden reports whether the client making the given
+// request is allowed to see hidden revisions of the charm or
+// bundle with the given id, which requires write permission.
+// No new macaroon is minted if the client is not authorized.
+func (h *Handler) canSeeHidden(id *charm.Reference, req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+	baseEntity, err := h.store.FindBaseEntity(id, "acls")
+	if err != nil {
+		return false
+	}
+	auth, err := h.checkRequest(req)
+	if err != nil {
+		return false
+	}
+	return h.checkACLMembership(auth, baseEntity.ACLs.Write) == nil
+}
+
+// visibleEntities returns the given entities omitting any hidden
+// revisions that the client making the request is not allowed
+// to see. Each entity must have at least the Hidden field
+// populated. The id is used to determine the base entity.
+func (h *Handler) visibleEntities(id *charm.Reference, entities []mongodoc.Entity, req *http.Request) []mongodoc.Entity {
+	checked, allowed := false, false
+	visible := entities[:0]
+	for _, entity := range entities {
+		if entity.Hidden {
+			if !checked {
+				allowed, checked = h.canSeeHidden(id, req), true
+			}
+			if !allowed {
+				continue
+			}
+		}
+		visible = append(visible, entity)
+	}
+	return visible
 }
 
 func (h *Handler) authorizeWithPerms(req *http.Request, read, write []string) error {
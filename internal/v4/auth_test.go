@@ -191,10 +191,11 @@ func newServerWithDischarger(c *gc.C, session *mgo.Session, username string, gro
 	// Create a charm store server that will use the test third party for
 	// its third party caveat.
 	srv, store := newServer(c, session, nil, charmstore.ServerParams{
-		AuthUsername:     serverParams.AuthUsername,
-		AuthPassword:     serverParams.AuthPassword,
-		IdentityLocation: discharger.Location(),
-		PublicKeyLocator: discharger,
+		AuthUsername:         serverParams.AuthUsername,
+		AuthPassword:         serverParams.AuthPassword,
+		IdentityLocation:     discharger.Location(),
+		PublicKeyLocator:     discharger,
+		AllowPrivateWebhooks: serverParams.AllowPrivateWebhooks,
 	})
 	return srv, store, discharger
 }
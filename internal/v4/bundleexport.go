@@ -0,0 +1,176 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+	"gopkg.in/yaml.v1"
+
+	"github.com/juju/charmstore/internal/blobstore"
+	"github.com/juju/charmstore/params"
+)
+
+// exportedBlob holds an archive to be written to a bundle export.
+type exportedBlob struct {
+	path string
+	r    blobstore.ReadSeekCloser
+	size int64
+}
+
+// GET id/export
+//
+// Returns a tar archive holding the archive of the bundle with the given
+// id, the archives of all the charms it uses, resolved as when the bundle
+// is uploaded, a manifest describing them (see params.ExportManifest)
+// and the bundle data with the charm URLs replaced by the paths of the
+// charm archives. The manifest comes first in the archive.
+func (h *Handler) serveExport(id *charm.Reference, fullySpecified bool, w http.ResponseWriter, req *http.Request) error {
+	if req.Method != "GET" {
+		return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s method not allowed", req.Method)
+	}
+	if id.Series != "bundle" {
+		return errgo.WithCausef(nil, params.ErrNotFound, "export not supported for charms")
+	}
+	entity, err := h.store.FindEntity(id, "bundledata")
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	var blobs []*exportedBlob
+	defer func() {
+		for _, blob := range blobs {
+			blob.r.Close()
+		}
+	}()
+	r, size, hash, err := h.store.OpenBlob(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	manifest := params.ExportManifest{
+		Bundle: params.ExportedArchive{
+			Id:   id,
+			Path: "bundle.zip",
+			Hash: hash,
+			Size: size,
+		},
+		Charms: make(map[string]params.ExportedArchive),
+	}
+	blobs = append(blobs, &exportedBlob{
+		path: manifest.Bundle.Path,
+		r:    r,
+		size: size,
+	})
+
+	// Resolve the charms used by the bundle and rewrite
+	// the bundle data to refer to their archives.
+	exported := make(map[string]params.ExportedArchive)
+	data := *entity.BundleData
+	data.Services = make(map[string]*charm.ServiceSpec, len(entity.BundleData.Services))
+	for name, service := range entity.BundleData.Services {
+		curl, err := charm.ParseReference(service.Charm)
+		if err != nil {
+			return errgo.Notef(err, "invalid charm URL in bundle")
+		}
+		archive, ok := manifest.Charms[curl.String()]
+		if !ok {
+			eid := *curl
+			if err := ResolveURL(h.store, &eid); err != nil {
+				return errgo.NoteMask(err, fmt.Sprintf("cannot resolve charm %q", curl), errgo.Is(params.ErrNotFound))
+			}
+			if err := h.authorizeEntity(&eid, req); err != nil {
+				return errgo.Mask(err, errgo.Any)
+			}
+			// Several charm URLs may resolve to the same charm.
+			path := "charms/" + eid.Path() + ".zip"
+			archive, ok = exported[path]
+			if !ok {
+				r, size, hash, err := h.store.OpenBlob(&eid)
+				if err != nil {
+					return errgo.NoteMask(err, fmt.Sprintf("cannot open archive of %q", &eid), errgo.Is(params.ErrNotFound))
+				}
+				blobs = append(blobs, &exportedBlob{
+					path: path,
+					r:    r,
+					size: size,
+				})
+				archive = params.ExportedArchive{
+					Id:   &eid,
+					Path: path,
+					Hash: hash,
+					Size: size,
+				}
+				exported[path] = archive
+			}
+			manifest.Charms[curl.String()] = archive
+		}
+		s := *service
+		s.Charm = archive.Path
+		data.Services[name] = &s
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal manifest")
+	}
+	bundleData, err := yaml.Marshal(&data)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal bundle data")
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "application/x-tar")
+	header.Set(params.EntityIdHeader, id.String())
+	// The charms used by the bundle may resolve to
+	// other revisions later, so the export is never
+	// cached for long.
+	setArchiveCacheControl(header, false)
+	if req.URL.Query().Get("stats") != "0" {
+		h.store.IncrementDownloadCountsAsync(id)
+	}
+	// Once the response has started, errors can only be logged:
+	// clients detect incomplete exports as the archive is truncated.
+	tw := tar.NewWriter(w)
+	if err := writeExportFile(tw, params.ExportManifestPath, bytes.NewReader(manifestData), int64(len(manifestData))); err != nil {
+		logger.Errorf("cannot export %s: %v", id, err)
+		return nil
+	}
+	if err := writeExportFile(tw, params.ExportBundlePath, bytes.NewReader(bundleData), int64(len(bundleData))); err != nil {
+		logger.Errorf("cannot export %s: %v", id, err)
+		return nil
+	}
+	for _, blob := range blobs {
+		if err := writeExportFile(tw, blob.path, blob.r, blob.size); err != nil {
+			logger.Errorf("cannot export %s: %v", id, err)
+			return nil
+		}
+	}
+	if err := tw.Close(); err != nil {
+		logger.Errorf("cannot export %s: %v", id, err)
+	}
+	return nil
+}
+
+// writeExportFile writes a file with the given path,
+// contents and size to the given tar archive.
+func writeExportFile(tw *tar.Writer, path string, r io.Reader, size int64) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    path,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}); err != nil {
+		return errgo.Mask(err)
+	}
+	if _, err := io.CopyN(tw, r, size); err != nil {
+		return errgo.Notef(err, "cannot write %q", path)
+	}
+	return nil
+}
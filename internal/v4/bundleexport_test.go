@@ -0,0 +1,127 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
+	"gopkg.in/yaml.v1"
+
+	"github.com/juju/charmstore/params"
+)
+
+func (s *APISuite) TestServeExport(c *gc.C) {
+	patchArchiveCacheAges(s)
+	wordpressId, _ := s.addCharm(c, "wordpress", "cs:utopic/wordpress-42")
+	mysqlId, _ := s.addCharm(c, "mysql", "cs:utopic/mysql-47")
+	bundleId, _ := s.addBundle(c, "wordpress-simple", "cs:bundle/wordpress-simple-3")
+
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("bundle/wordpress-simple/export"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %q", rec.Body.Bytes()))
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "application/x-tar")
+	c.Assert(rec.Header().Get(params.EntityIdHeader), gc.Equals, bundleId.String())
+	assertCacheControl(c, rec.Header(), false)
+
+	// Read the files in the export, checking that
+	// the manifest comes first.
+	var names []string
+	files := make(map[string][]byte)
+	tr := tar.NewReader(rec.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, gc.IsNil)
+		data, err := ioutil.ReadAll(tr)
+		c.Assert(err, gc.IsNil)
+		names = append(names, hdr.Name)
+		files[hdr.Name] = data
+	}
+	c.Assert(names, gc.HasLen, 5)
+	c.Assert(names[0], gc.Equals, params.ExportManifestPath)
+
+	var manifest params.ExportManifest
+	err := json.Unmarshal(files[params.ExportManifestPath], &manifest)
+	c.Assert(err, gc.IsNil)
+	for _, id := range []*charm.Reference{bundleId, wordpressId, mysqlId} {
+		_, hash, err := s.store.BlobNameAndHash(id)
+		c.Assert(err, gc.IsNil)
+		archive := manifest.Bundle
+		if id != bundleId {
+			archive = manifest.Charms["cs:"+id.Name]
+		}
+		c.Assert(archive.Id, gc.DeepEquals, id)
+		c.Assert(archive.Hash, gc.Equals, hash)
+		data := files[archive.Path]
+		c.Assert(archive.Size, gc.Equals, int64(len(data)))
+		c.Assert(hashOfBytes(data), gc.Equals, hash)
+	}
+	c.Assert(manifest.Bundle.Path, gc.Equals, "bundle.zip")
+	c.Assert(manifest.Charms, gc.HasLen, 2)
+	c.Assert(manifest.Charms["cs:wordpress"].Path, gc.Equals, "charms/utopic/wordpress-42.zip")
+
+	// The charm URLs in the bundle data refer to the charm archives.
+	var data charm.BundleData
+	err = yaml.Unmarshal(files[params.ExportBundlePath], &data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(data.Services["wordpress"].Charm, gc.Equals, "charms/utopic/wordpress-42.zip")
+	c.Assert(data.Services["mysql"].Charm, gc.Equals, "charms/utopic/mysql-47.zip")
+	c.Assert(data.Relations, gc.DeepEquals, [][]string{{"wordpress:db", "mysql:server"}})
+}
+
+var serveExportErrorsTests = []struct {
+	about        string
+	url          string
+	expectStatus int
+	expectBody   interface{}
+}{{
+	about:        "entity not found",
+	url:          "bundle/foo-23/export",
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: "entity not found",
+	},
+}, {
+	about:        "export of a charm",
+	url:          "utopic/mysql-47/export",
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: "export not supported for charms",
+	},
+}, {
+	about:        "charm not found",
+	url:          "bundle/wordpress-simple-3/export",
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: `cannot resolve charm "cs:wordpress": no matching charm or bundle for "cs:wordpress"`,
+	},
+}}
+
+func (s *APISuite) TestServeExportErrors(c *gc.C) {
+	s.addCharm(c, "mysql", "cs:utopic/mysql-47")
+	s.addBundle(c, "wordpress-simple", "cs:bundle/wordpress-simple-3")
+	for i, test := range serveExportErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL(test.url),
+			ExpectStatus: test.expectStatus,
+			ExpectBody:   test.expectBody,
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/internal/router"
+	"github.com/juju/charmstore/params"
+)
+
+var (
+	// changeStreamPollInterval holds the interval at which the
+	// change log is checked for changes made by other servers.
+	// Changes made by this server are sent immediately.
+	changeStreamPollInterval = 5 * time.Second
+
+	// changeStreamKeepAlive holds the interval at which a comment
+	// is sent when there are no changes, so that proxies do not
+	// close idle connections.
+	changeStreamKeepAlive = 30 * time.Second
+)
+
+// changeStreamBatchSize holds the maximum number of
+// changes read from the change log at a time.
+const changeStreamBatchSize = 100
+
+// GET changes/stream[?since=$id]
+//
+// Only the changes that the client is allowed to see are
+// streamed (see mongodoc.Change.ReadACL).
+func (h *Handler) serveChangesStream(w http.ResponseWriter, req *http.Request) {
+	if err := h.serveChangesStream1(w, req); err != nil {
+		router.WriteError(w, err)
+	}
+}
+
+// serveChangesStream1 serves a changes/stream request. It only
+// returns an error if the response has not been started.
+func (h *Handler) serveChangesStream1(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != "GET" {
+		return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s method not allowed", req.Method)
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errgo.New("streaming not supported")
+	}
+	last, err := h.changeStreamStart(req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	// As for hidden revisions, clients that cannot be authenticated
+	// are not asked to authenticate: they are just sent the changes
+	// that everyone can see.
+	auth, err := h.checkRequest(req)
+	if err != nil {
+		auth = authorization{}
+	}
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	lastWrite := time.Now()
+	for {
+		// Get the wait channel before reading the change log
+		// so that no change added in between is missed.
+		changed := h.store.WaitChange()
+		changes, err := h.store.ChangesSince(last, changeStreamBatchSize)
+		if err != nil {
+			// The response has already been started,
+			// so just end the stream.
+			logger.Errorf("cannot stream changes: %v", err)
+			return nil
+		}
+		written := 0
+		for i := range changes {
+			last = changes[i].Id
+			if !h.canSeeChange(auth, &changes[i]) {
+				continue
+			}
+			if err := writeChangeEvent(w, &changes[i]); err != nil {
+				// The client has gone away.
+				return nil
+			}
+			written++
+		}
+		if written > 0 {
+			flusher.Flush()
+			lastWrite = time.Now()
+		}
+		if len(changes) == changeStreamBatchSize {
+			continue
+		}
+		if written == 0 && time.Since(lastWrite) >= changeStreamKeepAlive {
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+			lastWrite = time.Now()
+		}
+		select {
+		case <-closed:
+			return nil
+		case <-changed:
+		case <-time.After(changeStreamPollInterval):
+		}
+	}
+}
+
+// changeStreamStart returns the id of the change after which
+// changes should be streamed. It is taken from the Last-Event-ID
+// header sent by clients when reconnecting, or from the since
+// parameter. If neither is specified, only changes made from now
+// on are streamed.
+func (h *Handler) changeStreamStart(req *http.Request) (int64, error) {
+	since := req.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = req.Form.Get("since")
+	}
+	if since == "" {
+		last, err := h.store.LastChangeId()
+		if err != nil {
+			return 0, errgo.Mask(err)
+		}
+		return last, nil
+	}
+	last, err := strconv.ParseInt(since, 10, 64)
+	if err != nil || last < 0 {
+		return 0, badRequestf(nil, "invalid change id %q", since)
+	}
+	return last, nil
+}
+
+// canSeeChange reports whether the client with the
+// given authorization is allowed to see the given change.
+func (h *Handler) canSeeChange(auth authorization, change *mongodoc.Change) bool {
+	for _, name := range change.ReadACL {
+		if name == params.Everyone {
+			return true
+		}
+	}
+	return h.checkACLMembership(auth, change.ReadACL) == nil
+}
+
+// writeChangeEvent writes the given change as a server-sent event.
+func writeChangeEvent(w http.ResponseWriter, change *mongodoc.Change) error {
+	// The payload is encoded JSON, so it never holds a newline.
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Id, change.Event, change.Payload)
+	return err
+}
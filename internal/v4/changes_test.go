@@ -0,0 +1,292 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/v4"
+	"github.com/juju/charmstore/params"
+)
+
+// changeEvent holds a server-sent event read from changes/stream.
+type changeEvent struct {
+	id      string
+	event   string
+	payload params.WebhookPayload
+}
+
+// openChangeStream makes a changes/stream request to the given
+// server and returns a channel that receives the events read from it.
+// The returned function closes the stream.
+func openChangeStream(c *gc.C, srv *httptest.Server, query string, header http.Header) (<-chan changeEvent, func()) {
+	req, err := http.NewRequest("GET", srv.URL+storeURL("changes/stream"+query), nil)
+	c.Assert(err, gc.IsNil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "text/event-stream")
+	events := make(chan changeEvent, 10)
+	go func() {
+		defer close(events)
+		var e changeEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if e.id != "" {
+					events <- e
+				}
+				e = changeEvent{}
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.payload)
+				e.payload.Time = time.Time{}
+			}
+		}
+	}()
+	return events, func() {
+		resp.Body.Close()
+	}
+}
+
+func receiveChangeEvent(c *gc.C, events <-chan changeEvent) changeEvent {
+	select {
+	case e, ok := <-events:
+		c.Assert(ok, gc.Equals, true, gc.Commentf("change stream closed"))
+		return e
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for change event")
+	}
+	panic("unreachable")
+}
+
+func (s *APISuite) TestChangesStream(c *gc.C) {
+	s.PatchValue(v4.ChangeStreamPollInterval, 10*time.Millisecond)
+	httpSrv := httptest.NewServer(s.srv)
+	defer httpSrv.Close()
+
+	s.addCharm(c, "wordpress", "cs:~bob/precise/wordpress-0")
+	// Read the stream as the administrator, as the perm
+	// change makes the charm private.
+	adminHeader := basicAuthHeader(serverParams.AuthUsername, serverParams.AuthPassword)
+	events, closeStream := openChangeStream(c, httpSrv, "", adminHeader)
+	defer closeStream()
+
+	s.assertPut(c, "~bob/precise/wordpress-0/meta/extra-info/foo", "bar")
+	s.assertPut(c, "~bob/precise/wordpress-0/meta/perm/read", []string{"bob"})
+
+	c.Assert(receiveChangeEvent(c, events), jc.DeepEquals, changeEvent{
+		id:    "1",
+		event: "extra-info",
+		payload: params.WebhookPayload{
+			Event: params.ExtraInfoEvent,
+			Id:    charm.MustParseReference("cs:~bob/precise/wordpress-0"),
+			ExtraInfo: map[string]json.RawMessage{
+				"foo": json.RawMessage(`"bar"`),
+			},
+		},
+	})
+	permEvent := changeEvent{
+		id:    "2",
+		event: "perm",
+		payload: params.WebhookPayload{
+			Event: params.PermEvent,
+			Id:    charm.MustParseReference("cs:~bob/wordpress"),
+			Perm: &params.PermResponse{
+				Read:  []string{"bob"},
+				Write: []string{"bob"},
+			},
+		},
+	}
+	c.Assert(receiveChangeEvent(c, events), jc.DeepEquals, permEvent)
+	closeStream()
+
+	// A client reconnecting with the id of the last event it
+	// received gets the changes made after it.
+	header := basicAuthHeader(serverParams.AuthUsername, serverParams.AuthPassword)
+	header.Set("Last-Event-ID", "1")
+	events, closeStream = openChangeStream(c, httpSrv, "", header)
+	defer closeStream()
+	c.Assert(receiveChangeEvent(c, events), jc.DeepEquals, permEvent)
+	closeStream()
+
+	events, closeStream = openChangeStream(c, httpSrv, "?since=0", adminHeader)
+	defer closeStream()
+	c.Assert(receiveChangeEvent(c, events).id, gc.Equals, "1")
+	c.Assert(receiveChangeEvent(c, events).id, gc.Equals, "2")
+}
+
+func (s *APISuite) TestChangesStreamOnlyIncludesReadableChanges(c *gc.C) {
+	s.PatchValue(v4.ChangeStreamPollInterval, 10*time.Millisecond)
+	srv, store, discharger := newServerWithDischarger(c, s.Session, "bob", nil)
+	defer discharger.Close()
+	s.srv, s.store = srv, store
+	httpSrv := httptest.NewServer(s.srv)
+	defer httpSrv.Close()
+
+	for _, change := range []struct {
+		payload *params.WebhookPayload
+		readACL []string
+	}{{
+		payload: &params.WebhookPayload{
+			Event: params.UploadEvent,
+			Id:    charm.MustParseReference("cs:~bob/precise/wordpress-0"),
+		},
+		readACL: []string{params.Everyone, "bob"},
+	}, {
+		payload: &params.WebhookPayload{
+			Event: params.UploadEvent,
+			Id:    charm.MustParseReference("cs:~bob/precise/private-0"),
+		},
+		readACL: []string{"bob"},
+	}, {
+		payload: &params.WebhookPayload{
+			Event: params.UploadEvent,
+			Id:    charm.MustParseReference("cs:~alice/precise/private-0"),
+		},
+		readACL: []string{"alice"},
+	}, {
+		payload: &params.WebhookPayload{
+			Event: params.DeleteEvent,
+			Id:    charm.MustParseReference("cs:~bob/precise/wordpress-0"),
+		},
+		readACL: []string{params.Everyone, "bob"},
+	}, {
+		// Changes with no ACL can only be seen by the administrator.
+		payload: &params.WebhookPayload{
+			Event: params.DeleteEvent,
+			Id:    charm.MustParseReference("cs:~bob/precise/other-0"),
+		},
+	}} {
+		err := s.store.AddChange(change.payload, change.readACL)
+		c.Assert(err, gc.IsNil)
+	}
+	// Add a last change visible to everyone, so that we
+	// know when all the changes have been streamed.
+	err := s.store.AddChange(&params.WebhookPayload{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:~bob/precise/wordpress-1"),
+	}, []string{params.Everyone})
+	c.Assert(err, gc.IsNil)
+
+	bobCookie := dischargedAuthCookie(c, s.srv)
+	for i, test := range []struct {
+		about     string
+		header    http.Header
+		expectIds []string
+	}{{
+		about:     "anonymous user",
+		expectIds: []string{"1", "4", "6"},
+	}, {
+		about: "authenticated user",
+		header: http.Header{
+			"Cookie": {bobCookie.Name + "=" + bobCookie.Value},
+		},
+		expectIds: []string{"1", "2", "4", "6"},
+	}, {
+		about:     "administrator",
+		header:    basicAuthHeader(serverParams.AuthUsername, serverParams.AuthPassword),
+		expectIds: []string{"1", "2", "3", "4", "5", "6"},
+	}, {
+		about:     "invalid credentials",
+		header:    basicAuthHeader(serverParams.AuthUsername, "bad-password"),
+		expectIds: []string{"1", "4", "6"},
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		events, closeStream := openChangeStream(c, httpSrv, "?since=0", test.header)
+		var ids []string
+		for len(ids) == 0 || ids[len(ids)-1] != "6" {
+			ids = append(ids, receiveChangeEvent(c, events).id)
+		}
+		closeStream()
+		c.Assert(ids, jc.DeepEquals, test.expectIds)
+	}
+}
+
+func (s *APISuite) TestChangesStreamExcludesHiddenRevisions(c *gc.C) {
+	s.PatchValue(v4.ChangeStreamPollInterval, 10*time.Millisecond)
+	httpSrv := httptest.NewServer(s.srv)
+	defer httpSrv.Close()
+
+	s.addCharm(c, "wordpress", "cs:~bob/precise/wordpress-0")
+	s.addCharm(c, "wordpress", "cs:~bob/precise/wordpress-1")
+	s.assertPut(c, "~bob/precise/wordpress-1/meta/hidden", params.HiddenResponse{
+		Hidden: true,
+	})
+	events, closeStream := openChangeStream(c, httpSrv, "", nil)
+	defer closeStream()
+	adminEvents, closeAdminStream := openChangeStream(c, httpSrv, "", basicAuthHeader(serverParams.AuthUsername, serverParams.AuthPassword))
+	defer closeAdminStream()
+
+	// Changes to the hidden revision are only streamed
+	// to those allowed to see it.
+	s.assertPut(c, "~bob/precise/wordpress-1/meta/extra-info/foo", "hidden")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~bob/precise/wordpress-1/archive"),
+		Method:       "DELETE",
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusOK,
+	})
+	s.assertPut(c, "~bob/precise/wordpress-0/meta/extra-info/foo", "public")
+
+	e := receiveChangeEvent(c, events)
+	c.Assert(e.event, gc.Equals, "extra-info")
+	c.Assert(e.payload.Id.String(), gc.Equals, "cs:~bob/precise/wordpress-0")
+
+	for _, expect := range []struct {
+		event string
+		id    string
+	}{
+		{"extra-info", "cs:~bob/precise/wordpress-1"},
+		{"delete", "cs:~bob/precise/wordpress-1"},
+		{"extra-info", "cs:~bob/precise/wordpress-0"},
+	} {
+		e := receiveChangeEvent(c, adminEvents)
+		c.Assert(e.event, gc.Equals, expect.event)
+		c.Assert(e.payload.Id.String(), gc.Equals, expect.id)
+		c.Assert(e.payload.Hidden, gc.Equals, expect.id == "cs:~bob/precise/wordpress-1")
+	}
+}
+
+func (s *APISuite) TestChangesStreamErrors(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("changes/stream"),
+		Method:       "POST",
+		ExpectStatus: http.StatusMethodNotAllowed,
+		ExpectBody: params.Error{
+			Code:    params.ErrMethodNotAllowed,
+			Message: "POST method not allowed",
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("changes/stream?since=bad"),
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: `invalid change id "bad"`,
+		},
+	})
+}
@@ -5,18 +5,19 @@ package v4
 
 import (
 	"archive/zip"
-	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"path"
 	"strings"
 
 	"github.com/juju/jujusvg"
-	"github.com/juju/xml"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/juju/charm.v4"
 
+	"github.com/juju/charmstore/internal/charmstore"
 	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/internal/readme"
 	"github.com/juju/charmstore/internal/router"
 	"github.com/juju/charmstore/params"
 )
@@ -56,40 +57,72 @@ func (h *Handler) serveDiagram(id *charm.Reference, fullySpecified bool, w http.
 	return nil
 }
 
-// These are all forms of README files
-// actually observed in charms in the wild.
-var allowedReadMe = map[string]bool{
-	"readme":          true,
-	"readme.md":       true,
-	"readme.rst":      true,
-	"readme.ex":       true,
-	"readme.markdown": true,
-	"readme.txt":      true,
-}
-
 // GET id/readme
 // http://tinyurl.com/kygyvot
 func (h *Handler) serveReadMe(id *charm.Reference, fullySpecified bool, w http.ResponseWriter, req *http.Request) error {
-	entity, err := h.store.FindEntity(id, "_id", "contents", "blobname")
+	format := req.Form.Get("format")
+	if format != "" && format != "raw" && format != "html" {
+		return badRequestf(nil, "invalid format %q", format)
+	}
+	entity, err := h.store.FindEntity(id, "_id", "contents", "blobname", "blobhash")
 	if err != nil {
 		return errgo.NoteMask(err, "cannot get README", errgo.Is(params.ErrNotFound))
 	}
+	if format == "html" {
+		html, err := h.store.RenderedReadMe(entity.BlobHash, readme.Version)
+		if err == nil {
+			serveReadMeHTML(w, html, fullySpecified)
+			return nil
+		}
+		if errgo.Cause(err) != params.ErrNotFound {
+			return errgo.Mask(err)
+		}
+	}
+	var name string
 	isReadMeFile := func(f *zip.File) bool {
-		name := strings.ToLower(path.Clean(f.Name))
-		// This is the same condition currently used by the GUI.
-		// TODO propagate likely content type from file extension.
-		return allowedReadMe[name]
+		if !charmstore.IsReadMeFile(f.Name) {
+			return false
+		}
+		name = f.Name
+		return true
 	}
 	r, err := h.store.OpenCachedBlobFile(entity, mongodoc.FileReadMe, isReadMeFile)
 	if err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
 	defer r.Close()
-	setArchiveCacheControl(w.Header(), fullySpecified)
-	io.Copy(w, r)
+	if name == "" {
+		// The README location was already cached.
+		name = entity.Contents[mongodoc.FileReadMe].Name
+	}
+	readmeFormat := readme.FormatOf(name)
+	if format != "html" {
+		setArchiveCacheControl(w.Header(), fullySpecified)
+		w.Header().Set("Content-Type", readmeFormat.ContentType())
+		io.Copy(w, r)
+		return nil
+	}
+	// Only the start of large README files is rendered, so
+	// there is no need to read more than that.
+	data, err := ioutil.ReadAll(io.LimitReader(r, readme.MaxSize+1))
+	if err != nil {
+		return errgo.Notef(err, "cannot read README")
+	}
+	html := readme.Render(data, readmeFormat)
+	if err := h.store.SetRenderedReadMe(entity.BlobHash, readme.Version, html); err != nil {
+		logger.Errorf("cannot cache README for %s: %v", entity.URL, err)
+	}
+	serveReadMeHTML(w, html, fullySpecified)
 	return nil
 }
 
+// serveReadMeHTML writes the given rendered README file.
+func serveReadMeHTML(w http.ResponseWriter, html []byte, fullySpecified bool) {
+	setArchiveCacheControl(w.Header(), fullySpecified)
+	w.Header().Set("Content-Type", readme.HTMLContentType)
+	w.Write(html)
+}
+
 // GET id/icon.svg
 // http://tinyurl.com/lhodocb
 func (h *Handler) serveIcon(id *charm.Reference, fullySpecified bool, w http.ResponseWriter, req *http.Request) error {
@@ -101,96 +134,51 @@ func (h *Handler) serveIcon(id *charm.Reference, fullySpecified bool, w http.Res
 	if err != nil {
 		return errgo.NoteMask(err, "cannot get icon", errgo.Is(params.ErrNotFound))
 	}
-	isIconFile := func(f *zip.File) bool {
-		return path.Clean(f.Name) == "icon.svg"
+	icon, err := h.entityIcon(entity)
+	if errgo.Cause(err) == params.ErrNotFound {
+		serveDefaultIcon(w, fullySpecified)
+		return nil
 	}
-	r, err := h.store.OpenCachedBlobFile(entity, mongodoc.FileIcon, isIconFile)
 	if err != nil {
-		if errgo.Cause(err) != params.ErrNotFound {
-			return errgo.Mask(err)
-		}
-		setArchiveCacheControl(w.Header(), fullySpecified)
-		w.Header().Set("Content-Type", "image/svg+xml")
-		io.Copy(w, strings.NewReader(defaultIcon))
-		return nil
+		return errgo.Mask(err)
 	}
-	defer r.Close()
 	w.Header().Set("Content-Type", "image/svg+xml")
 	setArchiveCacheControl(w.Header(), fullySpecified)
-	if err := processIcon(w, r); err != nil {
-		return errgo.Mask(err)
-	}
+	w.Write(icon)
 	return nil
 }
 
-const svgNamespace = "http://www.w3.org/2000/svg"
-
-// processIcon reads an icon SVG from r and writes
-// it to w, making any changes that need to be made.
-// Currently it adds a viewBox attribute to the <svg>
-// element if necessary.
-func processIcon(w io.Writer, r io.Reader) error {
-	dec := xml.NewDecoder(r)
-	dec.DefaultSpace = svgNamespace
-	enc := xml.NewEncoder(w)
-	ensured := false
-	// This could be slightly more efficient, because
-	// for large icons which already have a viewbox,
-	// we are doing more marshaling/unmarshaling work
-	// than we need to. But icons will be cached, and the
-	// extra overhead isn't that big, so we go with the
-	// simple approach, trusting that the icon still works
-	// after being processed through the xml package.
-	for {
-		tok, err := dec.Token()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("failed to read token: %v", err)
-		}
-		if !ensured {
-			tok, ensured = ensureViewbox(tok)
-		}
-		if err := enc.EncodeToken(tok); err != nil {
-			return fmt.Errorf("cannot encode token %#v: %v", tok, err)
-		}
+// entityIcon returns the sanitized icon of the given charm, which must
+// have been retrieved with the _id, contents and blobname fields. The
+// cause of the returned error is params.ErrNotFound if the charm has
+// no valid icon.
+func (h *Handler) entityIcon(entity *mongodoc.Entity) ([]byte, error) {
+	if icon := entity.Contents[mongodoc.FileIcon].Data; icon != nil {
+		return icon, nil
 	}
-	if err := enc.Flush(); err != nil {
-		return fmt.Errorf("cannot flush output: %v", err)
+	isIconFile := func(f *zip.File) bool {
+		return path.Clean(f.Name) == "icon.svg"
 	}
-	return nil
-}
-
-func ensureViewbox(tok0 xml.Token) (_ xml.Token, found bool) {
-	tok, ok := tok0.(xml.StartElement)
-	if !ok || tok.Name.Space != svgNamespace || tok.Name.Local != "svg" {
-		return tok0, false
-	}
-	var width, height string
-	for _, attr := range tok.Attr {
-		if attr.Name.Space != "" {
-			continue
-		}
-		switch attr.Name.Local {
-		case "width":
-			width = attr.Value
-		case "height":
-			height = attr.Value
-		case "viewBox":
-			return tok, true
-		}
+	r, err := h.store.OpenCachedBlobFile(entity, mongodoc.FileIcon, isIconFile)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	defer r.Close()
+	icon, _, err := charmstore.ReadIcon(r)
+	if err != nil {
+		logger.Infof("cannot process icon for %s: %v", entity.URL, err)
+		return nil, errgo.WithCausef(err, params.ErrNotFound, "invalid icon")
 	}
-	if width == "" || height == "" {
-		// Width and/or height have not been specified,
-		// so leave viewbox unspecified too.
-		return tok, true
+	if err := h.store.SetCachedBlobFileData(entity.URL, mongodoc.FileIcon, icon); err != nil {
+		logger.Errorf("cannot cache icon for %s: %v", entity.URL, err)
 	}
-	tok.Attr = append(tok.Attr, xml.Attr{
-		Name: xml.Name{
-			Local: "viewBox",
-		},
-		Value: fmt.Sprintf("0 0 %s %s", width, height),
-	})
-	return tok, true
+	return icon, nil
+}
+
+// serveDefaultIcon writes the icon used for
+// charms that do not have a valid icon of their own.
+func serveDefaultIcon(w http.ResponseWriter, fullySpecified bool) {
+	setArchiveCacheControl(w.Header(), fullySpecified)
+	w.Header().Set("Content-Type", "image/svg+xml")
+	io.Copy(w, strings.NewReader(defaultIcon))
 }
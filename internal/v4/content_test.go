@@ -20,6 +20,8 @@ import (
 	"gopkg.in/juju/charm.v4"
 
 	"github.com/juju/charmstore/internal/charmstore"
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/internal/readme"
 	"github.com/juju/charmstore/internal/storetesting"
 	"github.com/juju/charmstore/internal/v4"
 	"github.com/juju/charmstore/params"
@@ -140,20 +142,27 @@ func (s *APISuite) TestServeDiagram(c *gc.C) {
 }
 
 var serveReadMeTests = []struct {
-	name           string
-	expectNotFound bool
+	name              string
+	expectNotFound    bool
+	expectContentType string
 }{{
-	name: "README.md",
+	name:              "README.md",
+	expectContentType: "text/markdown; charset=utf-8",
 }, {
-	name: "README.rst",
+	name:              "README.rst",
+	expectContentType: "text/x-rst; charset=utf-8",
 }, {
-	name: "readme",
+	name:              "readme",
+	expectContentType: "text/plain; charset=utf-8",
 }, {
-	name: "README",
+	name:              "README",
+	expectContentType: "text/plain; charset=utf-8",
 }, {
-	name: "ReadMe.Txt",
+	name:              "ReadMe.Txt",
+	expectContentType: "text/plain; charset=utf-8",
 }, {
-	name: "README.ex",
+	name:              "README.ex",
+	expectContentType: "text/plain; charset=utf-8",
 }, {
 	name:           "",
 	expectNotFound: true,
@@ -200,11 +209,84 @@ func (s *APISuite) TestServeReadMe(c *gc.C) {
 		} else {
 			c.Assert(rec.Code, gc.Equals, http.StatusOK)
 			c.Assert(rec.Body.String(), gc.DeepEquals, content)
+			c.Assert(rec.Header().Get("Content-Type"), gc.Equals, test.expectContentType)
+			assertCacheControl(c, rec.Header(), true)
+
+			// The content type is the same when the README
+			// location has already been cached.
+			rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+				Handler: s.srv,
+				URL:     storeURL(url.Path() + "/readme?format=raw"),
+			})
+			c.Assert(rec.Code, gc.Equals, http.StatusOK)
+			c.Assert(rec.Body.String(), gc.DeepEquals, content)
+			c.Assert(rec.Header().Get("Content-Type"), gc.Equals, test.expectContentType)
+		}
+	}
+}
+
+var serveReadMeHTMLTests = []struct {
+	name    string
+	content string
+	expect  string
+}{{
+	name:    "README.md",
+	content: "# Title\n\nSome *text* <script>evil()</script>\n",
+	expect:  "<h1>Title</h1>\n<p>Some <em>text</em> &lt;script&gt;evil()&lt;/script&gt;</p>\n",
+}, {
+	name:    "README.rst",
+	content: "Title\n=====\n\nSome ``code``.\n",
+	expect:  "<h1>Title</h1>\n<p>Some <code>code</code>.</p>\n",
+}, {
+	name:    "README.txt",
+	content: "Some <text>\n",
+	expect:  "<pre>Some &lt;text&gt;</pre>\n",
+}}
+
+func (s *APISuite) TestServeReadMeHTML(c *gc.C) {
+	patchArchiveCacheAges(s)
+	url := charm.MustParseReference("cs:precise/wordpress-0")
+	for i, test := range serveReadMeHTMLTests {
+		c.Logf("test %d: %s", i, test.name)
+		wordpress := storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+		err := ioutil.WriteFile(filepath.Join(wordpress.Path, test.name), []byte(test.content), 0666)
+		c.Assert(err, gc.IsNil)
+		url.Revision = i
+		err = s.store.AddCharmWithArchive(url, wordpress)
+		c.Assert(err, gc.IsNil)
+
+		// Check the response twice, so that the cached
+		// rendering is also used.
+		for j := 0; j < 2; j++ {
+			rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+				Handler: s.srv,
+				URL:     storeURL(url.Path() + "/readme?format=html"),
+			})
+			c.Assert(rec.Code, gc.Equals, http.StatusOK)
+			c.Assert(rec.Body.String(), gc.Equals, test.expect)
+			c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "text/html; charset=utf-8")
 			assertCacheControl(c, rec.Header(), true)
 		}
+		entity, err := s.store.FindEntity(url, "blobhash")
+		c.Assert(err, gc.IsNil)
+		html, err := s.store.RenderedReadMe(entity.BlobHash, readme.Version)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(html), gc.Equals, test.expect)
 	}
 }
 
+func (s *APISuite) TestServeReadMeInvalidFormat(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("precise/wordpress-23/readme?format=pdf"),
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: `invalid format "pdf"`,
+		},
+	})
+}
+
 func (s *APISuite) TestServeReadMeEntityNotFound(c *gc.C) {
 	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
 		Handler:      s.srv,
@@ -279,6 +361,57 @@ func (s *APISuite) TestServeIcon(c *gc.C) {
 	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "image/svg+xml")
 }
 
+func (s *APISuite) TestServeIconSanitized(c *gc.C) {
+	url := charm.MustParseReference("cs:precise/wordpress-0")
+	wordpress := storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+	content := `<svg xmlns="http://www.w3.org/2000/svg" onload="evil()"><script>evil()</script></svg>`
+	expected := `<svg xmlns="http://www.w3.org/2000/svg"></svg>`
+	err := ioutil.WriteFile(filepath.Join(wordpress.Path, "icon.svg"), []byte(content), 0666)
+	c.Assert(err, gc.IsNil)
+	err = s.store.AddCharmWithArchive(url, wordpress)
+	c.Assert(err, gc.IsNil)
+
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL(url.Path() + "/icon.svg"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, expected)
+
+	// The sanitized icon has been cached.
+	entity, err := s.store.FindEntity(url, "contents")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(entity.Contents[mongodoc.FileIcon].Data), gc.Equals, expected)
+}
+
+func (s *APISuite) TestServeIconTooLarge(c *gc.C) {
+	url := charm.MustParseReference("cs:precise/wordpress-0")
+	wordpress := storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+	content := `<svg xmlns="http://www.w3.org/2000/svg">` + strings.Repeat(" ", charmstore.MaxIconSize) + `</svg>`
+	err := ioutil.WriteFile(filepath.Join(wordpress.Path, "icon.svg"), []byte(content), 0666)
+	c.Assert(err, gc.IsNil)
+	err = s.store.AddCharmWithArchive(url, wordpress)
+	c.Assert(err, gc.IsNil)
+
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL(url.Path() + "/icon.svg"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, v4.DefaultIcon)
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "image/svg+xml")
+
+	// The archive file endpoint serves the icon as text.
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL(url.Path() + "/archive/icon.svg"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, content)
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "text/plain; charset=utf-8")
+	c.Assert(rec.Header().Get("X-Content-Type-Options"), gc.Equals, "nosniff")
+}
+
 func (s *APISuite) TestServeBundleIcon(c *gc.C) {
 	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
 		Handler:      s.srv,
@@ -311,7 +444,7 @@ func (s *APISuite) TestServeDefaultIcon(c *gc.C) {
 
 func (s *APISuite) TestProcessIconWorksOnDefaultIcon(c *gc.C) {
 	var buf bytes.Buffer
-	err := v4.ProcessIcon(&buf, strings.NewReader(v4.DefaultIcon))
+	_, err := charmstore.ProcessIcon(&buf, strings.NewReader(v4.DefaultIcon))
 	c.Assert(err, gc.IsNil)
 	assertXMLEqual(c, buf.Bytes(), []byte(v4.DefaultIcon))
 }
@@ -324,7 +457,7 @@ func (s *APISuite) TestProcessIconDoesNotQuoteNewlines(c *gc.C) {
   <svg></svg>
 `
 	var buf bytes.Buffer
-	err := v4.ProcessIcon(&buf, strings.NewReader(icon))
+	_, err := charmstore.ProcessIcon(&buf, strings.NewReader(icon))
 	c.Assert(err, gc.IsNil)
 	if strings.Contains(buf.String(), "&#x") {
 		c.Errorf("newlines were quoted in processed icon output")
@@ -0,0 +1,320 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+
+	"github.com/juju/utils/jsonhttp"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/blobstore"
+	"github.com/juju/charmstore/internal/charmstore"
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/internal/textdiff"
+	"github.com/juju/charmstore/params"
+)
+
+// maxTextDiffSize holds the maximum size of the files
+// for which id/diff responses include a unified diff.
+const maxTextDiffSize = 64 * 1024
+
+// diffContext holds the number of context
+// lines included in unified diffs.
+const diffContext = 3
+
+// GET id/diff?to=other-id
+func (h *Handler) serveDiff(id *charm.Reference, _ bool, w http.ResponseWriter, req *http.Request) error {
+	toStr := req.Form.Get("to")
+	if toStr == "" {
+		return badRequestf(nil, "to parameter not specified")
+	}
+	to, err := charm.ParseReference(toStr)
+	if err != nil {
+		return badRequestf(err, "invalid to parameter")
+	}
+	if err := h.resolveURL(to, req); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if err := h.authorizeEntity(to, req); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	fields := []string{"blobname", "manifest", "charmmeta", "charmconfig", "charmactions"}
+	fromEntity, err := h.store.FindEntity(id, fields...)
+	if err != nil {
+		return errgo.NoteMask(err, "cannot get entity", errgo.Is(params.ErrNotFound))
+	}
+	toEntity, err := h.store.FindEntity(to, fields...)
+	if err != nil {
+		return errgo.NoteMask(err, "cannot get entity", errgo.Is(params.ErrNotFound))
+	}
+	files, err := h.diffFiles(fromEntity, toEntity)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	resp := params.DiffResponse{
+		From:  id,
+		To:    to,
+		Files: files,
+	}
+	if fromEntity.CharmMeta != nil && toEntity.CharmMeta != nil {
+		if err := diffCharms(&resp, fromEntity, toEntity); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return jsonhttp.WriteJSON(w, http.StatusOK, resp)
+}
+
+// diffFiles returns the files that differ between the archives
+// of the given entities, sorted by name.
+func (h *Handler) diffFiles(from, to *mongodoc.Entity) ([]params.FileDiff, error) {
+	fromFiles, err := h.archiveFiles(from)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	toFiles, err := h.archiveFiles(to)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var names []string
+	for name, f := range fromFiles {
+		if t, ok := toFiles[name]; !ok || t.Size != f.Size || t.CRC32 != f.CRC32 {
+			names = append(names, name)
+		}
+	}
+	for name := range toFiles {
+		if _, ok := fromFiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	diffs := make([]params.FileDiff, 0, len(names))
+	if len(names) == 0 {
+		return diffs, nil
+	}
+	sort.Strings(names)
+	fromBlob, _, err := h.store.BlobStore.Open(from.BlobName)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot open archive data for %s", from.URL)
+	}
+	defer fromBlob.Close()
+	toBlob, _, err := h.store.BlobStore.Open(to.BlobName)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot open archive data for %s", to.URL)
+	}
+	defer toBlob.Close()
+	for _, name := range names {
+		diff := params.FileDiff{
+			Name: name,
+		}
+		var oldText, newText []byte
+		isText := true
+		if f, ok := fromFiles[name]; ok {
+			diff.OldSize = f.Size
+			diff.OldHash, oldText, err = readArchiveFile(fromBlob, f)
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot read %q in %s", name, from.URL)
+			}
+			isText = isText && oldText != nil
+		}
+		if f, ok := toFiles[name]; ok {
+			diff.NewSize = f.Size
+			diff.NewHash, newText, err = readArchiveFile(toBlob, f)
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot read %q in %s", name, to.URL)
+			}
+			isText = isText && newText != nil
+		}
+		switch {
+		case diff.OldHash == "":
+			diff.Change = params.FileAdded
+		case diff.NewHash == "":
+			diff.Change = params.FileRemoved
+		default:
+			diff.Change = params.FileModified
+		}
+		if isText {
+			diff.Diff, _ = textdiff.Unified(
+				path.Join(from.URL.Path(), name),
+				path.Join(to.URL.Path(), name),
+				string(oldText),
+				string(newText),
+				diffContext,
+			)
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// archiveFiles returns the files, but not the directories, in
+// the archive of the given entity, keyed by their cleaned names.
+func (h *Handler) archiveFiles(entity *mongodoc.Entity) (map[string]mongodoc.ManifestFile, error) {
+	manifest, err := h.store.EntityManifest(entity)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read archive manifest for %s", entity.URL)
+	}
+	files := make(map[string]mongodoc.ManifestFile)
+	for _, f := range manifest {
+		if !f.IsDir() {
+			files[path.Clean(f.Name)] = f
+		}
+	}
+	return files, nil
+}
+
+// readArchiveFile returns the hash of the given file in the archive
+// read by r. If the file is a small text file, its contents are also
+// returned (as a non-nil slice).
+func readArchiveFile(r io.ReadSeeker, f mongodoc.ManifestFile) (hash string, text []byte, err error) {
+	content, err := charmstore.ZipFileReader(r, f.ZipFile())
+	if err != nil {
+		return "", nil, errgo.Mask(err)
+	}
+	h := blobstore.NewHash()
+	if f.Size > maxTextDiffSize {
+		if _, err := io.Copy(h, content); err != nil {
+			return "", nil, errgo.Mask(err)
+		}
+		return fmt.Sprintf("%x", h.Sum(nil)), nil, nil
+	}
+	data, err := ioutil.ReadAll(io.TeeReader(content, h))
+	if err != nil {
+		return "", nil, errgo.Mask(err)
+	}
+	if !textdiff.IsText(data) {
+		data = nil
+	} else if data == nil {
+		data = []byte{}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), data, nil
+}
+
+// diffCharms sets the charm metadata, configuration and
+// actions changes from the given entities in resp.
+func diffCharms(resp *params.DiffResponse, from, to *mongodoc.Entity) error {
+	fromFields, err := jsonItems(from.CharmMeta)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	toFields, err := jsonItems(to.CharmMeta)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	// Relations are compared separately.
+	for _, name := range []string{"Provides", "Requires", "Peers"} {
+		delete(fromFields, name)
+		delete(toFields, name)
+	}
+	var meta params.CharmMetadataDiff
+	meta.Fields = diffItems(fromFields, toFields)
+	relations := []struct {
+		diff     *params.ItemsDiff
+		from, to map[string]charm.Relation
+	}{
+		{&meta.Provides, from.CharmMeta.Provides, to.CharmMeta.Provides},
+		{&meta.Requires, from.CharmMeta.Requires, to.CharmMeta.Requires},
+		{&meta.Peers, from.CharmMeta.Peers, to.CharmMeta.Peers},
+	}
+	for _, r := range relations {
+		if *r.diff, err = diffJSONItems(r.from, r.to); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	resp.CharmMetadata = &meta
+
+	var fromOptions, toOptions map[string]charm.Option
+	if from.CharmConfig != nil {
+		fromOptions = from.CharmConfig.Options
+	}
+	if to.CharmConfig != nil {
+		toOptions = to.CharmConfig.Options
+	}
+	config, err := diffJSONItems(fromOptions, toOptions)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	resp.CharmConfig = &config
+
+	var fromActions, toActions map[string]charm.ActionSpec
+	if from.CharmActions != nil {
+		fromActions = from.CharmActions.ActionSpecs
+	}
+	if to.CharmActions != nil {
+		toActions = to.CharmActions.ActionSpecs
+	}
+	actions, err := diffJSONItems(fromActions, toActions)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	resp.CharmActions = &actions
+	return nil
+}
+
+// diffJSONItems returns the changes between the old and new values,
+// which must both encode to JSON objects.
+func diffJSONItems(old, new interface{}) (params.ItemsDiff, error) {
+	oldItems, err := jsonItems(old)
+	if err != nil {
+		return params.ItemsDiff{}, errgo.Mask(err)
+	}
+	newItems, err := jsonItems(new)
+	if err != nil {
+		return params.ItemsDiff{}, errgo.Mask(err)
+	}
+	return diffItems(oldItems, newItems), nil
+}
+
+// jsonItems returns the members of the JSON object
+// that the given value encodes to.
+func jsonItems(v interface{}) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var items map[string]json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return items, nil
+}
+
+// diffItems returns the changes between the old and new items.
+func diffItems(old, new map[string]json.RawMessage) params.ItemsDiff {
+	var diff params.ItemsDiff
+	for name, oldVal := range old {
+		newVal, ok := new[name]
+		switch {
+		case !ok:
+			if diff.Removed == nil {
+				diff.Removed = make(map[string]json.RawMessage)
+			}
+			diff.Removed[name] = oldVal
+		case !bytes.Equal(oldVal, newVal):
+			if diff.Changed == nil {
+				diff.Changed = make(map[string]params.ValueChange)
+			}
+			diff.Changed[name] = params.ValueChange{
+				Old: oldVal,
+				New: newVal,
+			}
+		}
+	}
+	for name, newVal := range new {
+		if _, ok := old[name]; !ok {
+			if diff.Added == nil {
+				diff.Added = make(map[string]json.RawMessage)
+			}
+			diff.Added[name] = newVal
+		}
+	}
+	return diff
+}
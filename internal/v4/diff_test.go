@@ -0,0 +1,227 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+const diffOldConfig = `options:
+  blog-title: {default: My Title, description: A descriptive title used for the blog., type: string}
+`
+
+const diffNewConfig = `options:
+  blog-title: {default: Another Title, description: A descriptive title used for the blog., type: string}
+  port: {default: 80, description: The port to listen on., type: int}
+`
+
+const diffNewMetadata = `name: wordpress
+summary: "A blog engine"
+description: "A pretty popular blog engine"
+provides:
+  url:
+    interface: http
+    limit:
+    optional: false
+  logging-dir:
+    interface: logging
+    scope: container
+  monitoring-port:
+    interface: monitoring
+    scope: container
+requires:
+  db:
+    interface: mysql
+    limit: 1
+    optional: false
+`
+
+// addDiffTestCharms adds two revisions of the wordpress
+// charm that differ in their files, metadata and config.
+// The second revision adds the README and data.bin files
+// and removes the notes.txt file.
+func (s *APISuite) addDiffTestCharms(c *gc.C) {
+	wordpress := storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+	err := ioutil.WriteFile(filepath.Join(wordpress.Path, "config.yaml"), []byte(diffOldConfig), 0644)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(filepath.Join(wordpress.Path, "notes.txt"), []byte("one\ntwo\n"), 0644)
+	c.Assert(err, gc.IsNil)
+	err = s.store.AddCharmWithArchive(charm.MustParseReference("cs:precise/wordpress-0"), wordpress)
+	c.Assert(err, gc.IsNil)
+
+	wordpress = storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+	files := map[string]string{
+		"config.yaml":   diffNewConfig,
+		"metadata.yaml": diffNewMetadata,
+		"README":        "hello\n",
+		"data.bin":      "\x00\x01\x02",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(wordpress.Path, name), []byte(content), 0644)
+		c.Assert(err, gc.IsNil)
+	}
+	err = s.store.AddCharmWithArchive(charm.MustParseReference("cs:precise/wordpress-1"), wordpress)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *APISuite) TestServeDiff(c *gc.C) {
+	s.addDiffTestCharms(c)
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("precise/wordpress-0/diff?to=precise/wordpress-1"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	var resp params.DiffResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.From, jc.DeepEquals, charm.MustParseReference("cs:precise/wordpress-0"))
+	c.Assert(resp.To, jc.DeepEquals, charm.MustParseReference("cs:precise/wordpress-1"))
+
+	// Check the file changes. The original metadata is
+	// checked only by the contents of its diff.
+	c.Assert(resp.Files, gc.HasLen, 5)
+	c.Assert(resp.Files, jc.DeepEquals, []params.FileDiff{{
+		Name:    "README",
+		Change:  params.FileAdded,
+		NewSize: 6,
+		NewHash: hashOfBytes([]byte("hello\n")),
+		Diff:    "--- precise/wordpress-0/README\n+++ precise/wordpress-1/README\n@@ -0,0 +1 @@\n+hello\n",
+	}, {
+		Name:    "config.yaml",
+		Change:  params.FileModified,
+		OldSize: int64(len(diffOldConfig)),
+		OldHash: hashOfBytes([]byte(diffOldConfig)),
+		NewSize: int64(len(diffNewConfig)),
+		NewHash: hashOfBytes([]byte(diffNewConfig)),
+		Diff: `--- precise/wordpress-0/config.yaml
++++ precise/wordpress-1/config.yaml
+@@ -1,2 +1,3 @@
+ options:
+-  blog-title: {default: My Title, description: A descriptive title used for the blog., type: string}
++  blog-title: {default: Another Title, description: A descriptive title used for the blog., type: string}
++  port: {default: 80, description: The port to listen on., type: int}
+`,
+	}, {
+		Name:    "data.bin",
+		Change:  params.FileAdded,
+		NewSize: 3,
+		NewHash: hashOfBytes([]byte("\x00\x01\x02")),
+	}, {
+		Name:    "metadata.yaml",
+		Change:  params.FileModified,
+		OldSize: resp.Files[3].OldSize,
+		OldHash: resp.Files[3].OldHash,
+		NewSize: int64(len(diffNewMetadata)),
+		NewHash: hashOfBytes([]byte(diffNewMetadata)),
+		Diff:    resp.Files[3].Diff,
+	}, {
+		Name:    "notes.txt",
+		Change:  params.FileRemoved,
+		OldSize: 8,
+		OldHash: hashOfBytes([]byte("one\ntwo\n")),
+		Diff:    "--- precise/wordpress-0/notes.txt\n+++ precise/wordpress-1/notes.txt\n@@ -1,2 +0,0 @@\n-one\n-two\n",
+	}})
+	c.Assert(resp.Files[3].Diff, jc.Contains, "\n-summary: \"Blog engine\"\n+summary: \"A blog engine\"\n")
+
+	// Check the metadata changes.
+	c.Assert(resp.CharmMetadata, gc.NotNil)
+	c.Assert(resp.CharmMetadata.Fields.Added, gc.HasLen, 0)
+	c.Assert(resp.CharmMetadata.Fields.Removed, gc.HasLen, 0)
+	c.Assert(resp.CharmMetadata.Fields.Changed, jc.DeepEquals, map[string]params.ValueChange{
+		"Summary": {
+			Old: json.RawMessage(`"Blog engine"`),
+			New: json.RawMessage(`"A blog engine"`),
+		},
+	})
+	c.Assert(resp.CharmMetadata.Provides, jc.DeepEquals, params.ItemsDiff{})
+	c.Assert(resp.CharmMetadata.Peers, jc.DeepEquals, params.ItemsDiff{})
+	c.Assert(resp.CharmMetadata.Requires.Added, gc.HasLen, 0)
+	c.Assert(resp.CharmMetadata.Requires.Changed, gc.HasLen, 0)
+	c.Assert(resp.CharmMetadata.Requires.Removed, gc.HasLen, 1)
+	c.Assert(string(resp.CharmMetadata.Requires.Removed["cache"]), jc.Contains, `"varnish"`)
+
+	// Check the config changes.
+	c.Assert(resp.CharmConfig, gc.NotNil)
+	c.Assert(resp.CharmConfig.Removed, gc.HasLen, 0)
+	c.Assert(resp.CharmConfig.Added, gc.HasLen, 1)
+	c.Assert(string(resp.CharmConfig.Added["port"]), jc.Contains, `"The port to listen on."`)
+	c.Assert(resp.CharmConfig.Changed, gc.HasLen, 1)
+	c.Assert(string(resp.CharmConfig.Changed["blog-title"].Old), jc.Contains, `"My Title"`)
+	c.Assert(string(resp.CharmConfig.Changed["blog-title"].New), jc.Contains, `"Another Title"`)
+
+	// The actions have not changed.
+	c.Assert(resp.CharmActions, jc.DeepEquals, &params.ItemsDiff{})
+}
+
+func (s *APISuite) TestServeDiffSameEntity(c *gc.C) {
+	s.addDiffTestCharms(c)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("precise/wordpress-1/diff?to=wordpress"),
+		ExpectStatus: http.StatusOK,
+		ExpectBody: params.DiffResponse{
+			From:          charm.MustParseReference("cs:precise/wordpress-1"),
+			To:            charm.MustParseReference("cs:precise/wordpress-1"),
+			Files:         []params.FileDiff{},
+			CharmMetadata: &params.CharmMetadataDiff{},
+			CharmConfig:   &params.ItemsDiff{},
+			CharmActions:  &params.ItemsDiff{},
+		},
+	})
+}
+
+var serveDiffErrorsTests = []struct {
+	about        string
+	url          string
+	expectStatus int
+	expectBody   params.Error
+}{{
+	about:        "no to parameter",
+	url:          "precise/wordpress-0/diff",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: "to parameter not specified",
+	},
+}, {
+	about:        "to not found",
+	url:          "precise/wordpress-0/diff?to=no-such",
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: `no matching charm or bundle for "cs:no-such"`,
+	},
+}, {
+	about:        "from not found",
+	url:          "precise/no-such-0/diff?to=wordpress",
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: `cannot get entity: entity not found`,
+	},
+}}
+
+func (s *APISuite) TestServeDiffErrors(c *gc.C) {
+	s.addDiffTestCharms(c)
+	for i, test := range serveDiffErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL(test.url),
+			ExpectStatus: test.expectStatus,
+			ExpectBody:   test.expectBody,
+		})
+	}
+}
@@ -11,8 +11,9 @@ var (
 	ArchiveCacheNonVersionedMaxAge = &archiveCacheNonVersionedMaxAge
 	ParamsLogLevels                = paramsLogLevels
 	ParamsLogTypes                 = paramsLogTypes
-	ProcessIcon                    = processIcon
 	UsernameAttr                   = usernameAttr
 	GroupsAttr                     = groupsAttr
 	GetPromulgatedURL              = (*Handler).getPromulgatedURL
+	ChangeStreamPollInterval       = &changeStreamPollInterval
+	ChangeStreamKeepAlive          = &changeStreamKeepAlive
 )
@@ -558,11 +558,17 @@ func (s *SearchSuite) TestSearchIncludeError(c *gc.C) {
 
 	// Now remove one of the blobs. The search should still
 	// work, but only return a single result.
-	blobName, _, err := s.store.BlobNameAndHash(charm.MustParseReference(exportTestCharms["wordpress"]))
+	id := charm.MustParseReference(exportTestCharms["wordpress"])
+	blobName, _, err := s.store.BlobNameAndHash(id)
 	c.Assert(err, gc.IsNil)
 	err = s.store.BlobStore.Remove(blobName)
 	c.Assert(err, gc.IsNil)
 
+	// Also remove the manifest recorded when the charm
+	// was added, so that it must be read from the blob.
+	err = s.store.DB.Entities().UpdateId(id, bson.D{{"$unset", bson.D{{"manifest", ""}}}})
+	c.Assert(err, gc.IsNil)
+
 	// Now search again - we should get one result less
 	// (and the error will be logged).
 
@@ -584,7 +590,7 @@ func (s *SearchSuite) TestSearchIncludeError(c *gc.C) {
 	// cs:wordpress will not be found because it has no manifest.
 	c.Assert(resp.Results, gc.HasLen, len(exportTestCharms)-2)
 
-	c.Assert(tw.Log(), jc.LogMatches, []string{"cannot retrieve metadata for cs:precise/wordpress-23: cannot open archive data for cs:precise/wordpress-23: .*"})
+	c.Assert(tw.Log(), jc.LogMatches, []string{"cannot retrieve metadata for cs:precise/wordpress-23: cannot read archive manifest for cs:precise/wordpress-23: cannot open archive blob: .*"})
 }
 
 func (s *SearchSuite) TestSorting(c *gc.C) {
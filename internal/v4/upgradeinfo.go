@@ -0,0 +1,173 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/params"
+)
+
+// GET id/meta/upgrade-info
+func (h *Handler) metaUpgradeInfo(entity *mongodoc.Entity, id *charm.Reference, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	if entity.CharmMeta == nil {
+		// Only charms can be upgraded.
+		return nil, nil
+	}
+	var docs []mongodoc.Entity
+	if err := h.store.DB.Entities().Find(bson.D{
+		{"baseurl", entity.BaseURL},
+		{"series", id.Series},
+		{"revision", bson.D{{"$gt", id.Revision}}},
+	}).Select(bson.D{
+		{"_id", 1},
+		{"hidden", 1},
+		{"charmmeta", 1},
+		{"charmconfig", 1},
+		{"charmactions", 1},
+	}).Sort("-revision").All(&docs); err != nil {
+		return nil, errgo.Notef(err, "cannot get newer revisions")
+	}
+	docs = h.visibleEntities(entity.BaseURL, docs, req)
+	response := &params.UpgradeInfoResponse{
+		Upgrades: make([]params.UpgradeInfo, len(docs)),
+	}
+	for i := range docs {
+		response.Upgrades[i] = params.UpgradeInfo{
+			Id:              docs[i].URL,
+			BreakingChanges: breakingChanges(entity, &docs[i]),
+		}
+	}
+	return response, nil
+}
+
+// breakingChanges returns the changes between the given charm
+// entities that may break a deployment of the old charm when it
+// is upgraded to the new one.
+func breakingChanges(old, new *mongodoc.Entity) []params.BreakingChange {
+	var changes []params.BreakingChange
+	if old.CharmConfig != nil {
+		var newOptions map[string]charm.Option
+		if new.CharmConfig != nil {
+			newOptions = new.CharmConfig.Options
+		}
+		for _, name := range sortedKeys(old.CharmConfig.Options) {
+			oldOption := old.CharmConfig.Options[name]
+			newOption, ok := newOptions[name]
+			switch {
+			case !ok:
+				changes = append(changes, params.BreakingChange{
+					Kind:    params.ConfigOptionRemoved,
+					Name:    name,
+					Message: fmt.Sprintf("config option %q removed", name),
+				})
+			case newOption.Type != oldOption.Type:
+				changes = append(changes, params.BreakingChange{
+					Kind:    params.ConfigOptionTypeChanged,
+					Name:    name,
+					Old:     oldOption.Type,
+					New:     newOption.Type,
+					Message: fmt.Sprintf("config option %q changed type from %s to %s", name, oldOption.Type, newOption.Type),
+				})
+			}
+		}
+	}
+	var newMeta charm.Meta
+	if new.CharmMeta != nil {
+		newMeta = *new.CharmMeta
+	}
+	changes = append(changes, relationChanges(old.CharmMeta.Provides, newMeta.Provides)...)
+	changes = append(changes, relationChanges(old.CharmMeta.Requires, newMeta.Requires)...)
+	changes = append(changes, relationChanges(old.CharmMeta.Peers, newMeta.Peers)...)
+	if old.CharmActions != nil {
+		var newActions map[string]charm.ActionSpec
+		if new.CharmActions != nil {
+			newActions = new.CharmActions.ActionSpecs
+		}
+		for _, name := range sortedKeys(old.CharmActions.ActionSpecs) {
+			if _, ok := newActions[name]; !ok {
+				changes = append(changes, params.BreakingChange{
+					Kind:    params.ActionRemoved,
+					Name:    name,
+					Message: fmt.Sprintf("action %q removed", name),
+				})
+			}
+		}
+	}
+	return changes
+}
+
+// relationChanges returns the breaking changes between the old and
+// new relations with the same role. A relation that is no longer
+// present is considered to be renamed if a relation with the same
+// interface has been added.
+func relationChanges(old, new map[string]charm.Relation) []params.BreakingChange {
+	var changes []params.BreakingChange
+	// added holds the names of the new relations, in order,
+	// that are not present in the old relations.
+	var added []string
+	for _, name := range sortedKeys(new) {
+		if _, ok := old[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for _, name := range sortedKeys(old) {
+		oldRel := old[name]
+		if newRel, ok := new[name]; ok {
+			if newRel.Interface != oldRel.Interface {
+				changes = append(changes, params.BreakingChange{
+					Kind:    params.RelationInterfaceChanged,
+					Name:    name,
+					Old:     oldRel.Interface,
+					New:     newRel.Interface,
+					Message: fmt.Sprintf("relation %q changed interface from %s to %s", name, oldRel.Interface, newRel.Interface),
+				})
+			}
+			continue
+		}
+		renamed := false
+		for i, newName := range added {
+			if new[newName].Interface != oldRel.Interface {
+				continue
+			}
+			changes = append(changes, params.BreakingChange{
+				Kind:    params.RelationRenamed,
+				Name:    name,
+				New:     newName,
+				Message: fmt.Sprintf("relation %q renamed to %q", name, newName),
+			})
+			added = append(added[:i], added[i+1:]...)
+			renamed = true
+			break
+		}
+		if !renamed {
+			changes = append(changes, params.BreakingChange{
+				Kind:    params.RelationRemoved,
+				Name:    name,
+				Message: fmt.Sprintf("relation %q removed", name),
+			})
+		}
+	}
+	return changes
+}
+
+// sortedKeys returns the keys of the given map,
+// which must have string keys, in sorted order.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
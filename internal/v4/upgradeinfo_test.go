@@ -0,0 +1,195 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+const upgradeOldActions = `actions:
+  snapshot:
+    description: Take a snapshot.
+  backup:
+    description: Back up the data.
+`
+
+const upgradeNewConfig = `options:
+  port: {default: "80", description: The port to listen on., type: string}
+  debug: {default: false, description: Enable debugging., type: boolean}
+`
+
+const upgradeNewMetadata = `name: wordpress
+summary: "Blog engine"
+description: "A pretty popular blog engine"
+provides:
+  website:
+    interface: http
+  logging-dir:
+    interface: logging
+    scope: container
+  monitoring-port:
+    interface: nagios
+    scope: container
+requires:
+  db:
+    interface: mysql
+    limit: 1
+    optional: false
+`
+
+// addUpgradeTestCharms adds revisions of the wordpress charm. The
+// second and third revisions have the same breaking changes from the
+// first one.
+func (s *APISuite) addUpgradeTestCharms(c *gc.C) {
+	wordpress := storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+	files := map[string]string{
+		"config.yaml": `options:
+  blog-title: {default: My Title, description: The title of the blog., type: string}
+  port: {default: 80, description: The port to listen on., type: int}
+`,
+		"actions.yaml": upgradeOldActions,
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(wordpress.Path, name), []byte(content), 0644)
+		c.Assert(err, gc.IsNil)
+	}
+	err := s.store.AddCharmWithArchive(charm.MustParseReference("cs:precise/wordpress-1"), wordpress)
+	c.Assert(err, gc.IsNil)
+	err = s.store.AddCharmWithArchive(charm.MustParseReference("cs:trusty/wordpress-2"), wordpress)
+	c.Assert(err, gc.IsNil)
+
+	wordpress = storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+	files = map[string]string{
+		"config.yaml":   upgradeNewConfig,
+		"metadata.yaml": upgradeNewMetadata,
+		"actions.yaml": `actions:
+  backup:
+    description: Back up the data.
+`,
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(wordpress.Path, name), []byte(content), 0644)
+		c.Assert(err, gc.IsNil)
+	}
+	for _, id := range []string{"cs:precise/wordpress-2", "cs:precise/wordpress-3"} {
+		err = s.store.AddCharmWithArchive(charm.MustParseReference(id), wordpress)
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+var upgradeBreakingChanges = []params.BreakingChange{{
+	Kind:    params.ConfigOptionRemoved,
+	Name:    "blog-title",
+	Message: `config option "blog-title" removed`,
+}, {
+	Kind:    params.ConfigOptionTypeChanged,
+	Name:    "port",
+	Old:     "int",
+	New:     "string",
+	Message: `config option "port" changed type from int to string`,
+}, {
+	Kind:    params.RelationInterfaceChanged,
+	Name:    "monitoring-port",
+	Old:     "monitoring",
+	New:     "nagios",
+	Message: `relation "monitoring-port" changed interface from monitoring to nagios`,
+}, {
+	Kind:    params.RelationRenamed,
+	Name:    "url",
+	New:     "website",
+	Message: `relation "url" renamed to "website"`,
+}, {
+	Kind:    params.RelationRemoved,
+	Name:    "cache",
+	Message: `relation "cache" removed`,
+}, {
+	Kind:    params.ActionRemoved,
+	Name:    "snapshot",
+	Message: `action "snapshot" removed`,
+}}
+
+func (s *APISuite) TestMetaUpgradeInfo(c *gc.C) {
+	s.addUpgradeTestCharms(c)
+	s.assertGet(c, "precise/wordpress-1/meta/upgrade-info", &params.UpgradeInfoResponse{
+		Upgrades: []params.UpgradeInfo{{
+			Id:              charm.MustParseReference("cs:precise/wordpress-3"),
+			BreakingChanges: upgradeBreakingChanges,
+		}, {
+			Id:              charm.MustParseReference("cs:precise/wordpress-2"),
+			BreakingChanges: upgradeBreakingChanges,
+		}},
+	})
+	s.assertGet(c, "precise/wordpress-2/meta/upgrade-info", &params.UpgradeInfoResponse{
+		Upgrades: []params.UpgradeInfo{{
+			Id: charm.MustParseReference("cs:precise/wordpress-3"),
+		}},
+	})
+	s.assertGet(c, "precise/wordpress-3/meta/upgrade-info", &params.UpgradeInfoResponse{
+		Upgrades: []params.UpgradeInfo{},
+	})
+
+	// Revisions in other series are not included.
+	s.assertGet(c, "trusty/wordpress-2/meta/upgrade-info", &params.UpgradeInfoResponse{
+		Upgrades: []params.UpgradeInfo{},
+	})
+}
+
+func (s *APISuite) TestMetaUpgradeInfoHiddenRevision(c *gc.C) {
+	s.addUpgradeTestCharms(c)
+	s.assertPut(c, "precise/wordpress-3/meta/hidden", params.HiddenResponse{Hidden: true})
+
+	// Hidden revisions are not included for anonymous users.
+	s.assertGet(c, "precise/wordpress-2/meta/upgrade-info", &params.UpgradeInfoResponse{
+		Upgrades: []params.UpgradeInfo{},
+	})
+}
+
+func (s *APISuite) TestMetaUpgradeInfoNoConfigOrActions(c *gc.C) {
+	s.addUpgradeTestCharms(c)
+	wordpress := storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+	for _, name := range []string{"config.yaml", "actions"} {
+		err := os.RemoveAll(filepath.Join(wordpress.Path, name))
+		c.Assert(err, gc.IsNil)
+	}
+	err := s.store.AddCharmWithArchive(charm.MustParseReference("cs:precise/wordpress-4"), wordpress)
+	c.Assert(err, gc.IsNil)
+
+	s.assertGet(c, "precise/wordpress-3/meta/upgrade-info", &params.UpgradeInfoResponse{
+		Upgrades: []params.UpgradeInfo{{
+			Id: charm.MustParseReference("cs:precise/wordpress-4"),
+			BreakingChanges: []params.BreakingChange{{
+				Kind:    params.ConfigOptionRemoved,
+				Name:    "debug",
+				Message: `config option "debug" removed`,
+			}, {
+				Kind:    params.ConfigOptionRemoved,
+				Name:    "port",
+				Message: `config option "port" removed`,
+			}, {
+				Kind:    params.RelationInterfaceChanged,
+				Name:    "monitoring-port",
+				Old:     "nagios",
+				New:     "monitoring",
+				Message: `relation "monitoring-port" changed interface from nagios to monitoring`,
+			}, {
+				Kind:    params.RelationRenamed,
+				Name:    "website",
+				New:     "url",
+				Message: `relation "website" renamed to "url"`,
+			}, {
+				Kind:    params.ActionRemoved,
+				Name:    "backup",
+				Message: `action "backup" removed`,
+			}},
+		}},
+	})
+}
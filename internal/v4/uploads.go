@@ -0,0 +1,206 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/utils/jsonhttp"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/params"
+)
+
+// POST id/upload
+func (h *Handler) serveUpload(id *charm.Reference, fullySpecified bool, w http.ResponseWriter, req *http.Request) error {
+	if req.Method != "POST" {
+		return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s method not allowed", req.Method)
+	}
+	if id.Series == "" {
+		return badRequestf(nil, "series not specified")
+	}
+	upload, err := h.store.NewUpload(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrQuotaExceeded))
+	}
+	return jsonhttp.WriteJSON(w, http.StatusOK, &params.UploadResponse{
+		UploadId: upload.Id,
+		Expires:  upload.Expires.UTC(),
+	})
+}
+
+// GET upload/$uploadId
+//
+// DELETE upload/$uploadId
+//
+// PUT upload/$uploadId/$part?hash=$sha384hash
+func (h *Handler) serveUploads(w http.ResponseWriter, req *http.Request) error {
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	uploadId, partStr := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		uploadId, partStr = path[:i], path[i+1:]
+	}
+	if uploadId == "" || strings.Contains(partStr, "/") {
+		return errgo.WithCausef(nil, params.ErrNotFound, "not found")
+	}
+	upload, err := h.store.FindUpload(uploadId)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if err := h.authorizeUpload(upload, req); err != nil {
+		return err
+	}
+	if partStr != "" {
+		if req.Method != "PUT" {
+			return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s method not allowed", req.Method)
+		}
+		part, err := strconv.Atoi(partStr)
+		if err != nil {
+			return badRequestf(nil, "invalid part number %q", partStr)
+		}
+		return h.putUploadPart(upload, part, req)
+	}
+	switch req.Method {
+	case "GET":
+		return jsonhttp.WriteJSON(w, http.StatusOK, uploadInfoResponse(upload))
+	case "DELETE":
+		if err := h.store.RemoveUpload(upload); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		return nil
+	}
+	return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s method not allowed", req.Method)
+}
+
+func (h *Handler) putUploadPart(upload *mongodoc.Upload, part int, req *http.Request) error {
+	hash := req.Form.Get("hash")
+	if hash == "" {
+		return badRequestf(nil, "hash parameter not specified")
+	}
+	if req.ContentLength == -1 {
+		return badRequestf(nil, "Content-Length not specified")
+	}
+	if max := h.config.MaxArchiveSize; max > 0 {
+		// Check the size of the whole upload, not counting
+		// any previous upload of the part, which is replaced.
+		size := req.ContentLength
+		key := strconv.Itoa(part)
+		for k, p := range upload.Parts {
+			if k != key {
+				size += p.Size
+			}
+		}
+		if size > max {
+			return errgo.WithCausef(nil, params.ErrQuotaExceeded, "upload size %d exceeds maximum archive size of %d bytes", size, max)
+		}
+	}
+	if err := h.store.PutUploadPart(upload, part, req.Body, req.ContentLength, hash); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrNotFound))
+	}
+	return nil
+}
+
+// authorizeUpload checks that the given request is allowed to
+// access the given upload. This requires write access to the
+// charm or bundle being uploaded.
+func (h *Handler) authorizeUpload(upload *mongodoc.Upload, req *http.Request) error {
+	baseEntity, err := h.store.FindBaseEntity(upload.URL, "acls")
+	if err == nil {
+		return h.authorize(req, baseEntity.ACLs.Write)
+	}
+	if errgo.Cause(err) != params.ErrNotFound {
+		return errgo.Notef(err, "cannot retrieve entity %q for authorization", upload.URL)
+	}
+	// The charm or bundle does not exist yet: as in authorizeEntity,
+	// only its owner is allowed to write it.
+	var acl []string
+	if upload.URL.User != "" {
+		acl = []string{upload.URL.User}
+	}
+	return h.authorize(req, acl)
+}
+
+// archiveContent returns the archive content of the given
+// id/archive upload request, and its size. The content is the
+// request body or, if the upload parameter is specified, the
+// concatenated parts of that upload, which is also returned.
+// If the request answers a content challenge, no content is
+// sent and the size is taken from the size parameter.
+func (h *Handler) archiveContent(id *charm.Reference, req *http.Request) (io.ReadCloser, int64, *mongodoc.Upload, error) {
+	uploadId := req.Form.Get("upload")
+	if req.Form.Get("challenge-id") != "" {
+		if uploadId != "" {
+			return nil, 0, nil, badRequestf(nil, "upload and challenge-id parameters both specified")
+		}
+		size, err := parseArchiveSize(req)
+		if err != nil {
+			return nil, 0, nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+		return ioutil.NopCloser(req.Body), size, nil, nil
+	}
+	if uploadId == "" {
+		if req.ContentLength == -1 {
+			return nil, 0, nil, badRequestf(nil, "Content-Length not specified")
+		}
+		return ioutil.NopCloser(req.Body), req.ContentLength, nil, nil
+	}
+	upload, err := h.store.FindUpload(uploadId)
+	if err != nil {
+		return nil, 0, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if upload.URL.User != id.User || upload.URL.Name != id.Name || upload.URL.Series != id.Series {
+		return nil, 0, nil, badRequestf(nil, "upload %q is for %s, not %s", uploadId, upload.URL, id)
+	}
+	r, size, err := h.store.OpenUpload(upload)
+	if err != nil {
+		return nil, 0, nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	return r, size, upload, nil
+}
+
+// removeUpload removes the given upload, if any, once
+// its archive has been successfully added.
+func (h *Handler) removeUpload(upload *mongodoc.Upload) {
+	if upload == nil {
+		return
+	}
+	if err := h.store.RemoveUpload(upload); err != nil {
+		logger.Errorf("cannot remove upload %q: %v", upload.Id, err)
+	}
+}
+
+func uploadInfoResponse(upload *mongodoc.Upload) *params.UploadInfoResponse {
+	r := &params.UploadInfoResponse{
+		UploadId: upload.Id,
+		Id:       upload.URL,
+		Expires:  upload.Expires.UTC(),
+		Parts:    make([]params.UploadPart, 0, len(upload.Parts)),
+	}
+	for key, part := range upload.Parts {
+		n, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		r.Parts = append(r.Parts, params.UploadPart{
+			Part: n,
+			Hash: part.Hash,
+			Size: part.Size,
+		})
+	}
+	sort.Sort(uploadPartsByNumber(r.Parts))
+	return r
+}
+
+type uploadPartsByNumber []params.UploadPart
+
+func (p uploadPartsByNumber) Len() int           { return len(p) }
+func (p uploadPartsByNumber) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p uploadPartsByNumber) Less(i, j int) bool { return p[i].Part < p[j].Part }
@@ -0,0 +1,324 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
+
+	"github.com/juju/charmstore/internal/charmstore"
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+type UploadsSuite struct {
+	storetesting.IsolatedMgoSuite
+	srv   http.Handler
+	store *charmstore.Store
+}
+
+var _ = gc.Suite(&UploadsSuite{})
+
+func (s *UploadsSuite) SetUpTest(c *gc.C) {
+	s.IsolatedMgoSuite.SetUpTest(c)
+	s.srv, s.store = newServer(c, s.Session, nil, serverParams)
+}
+
+// startUpload starts an upload for the given id and returns its id.
+func (s *UploadsSuite) startUpload(c *gc.C, id string) string {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL(id + "/upload"),
+		Method:   "POST",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+	var resp params.UploadResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.UploadId, gc.Not(gc.Equals), "")
+	c.Assert(resp.Expires.IsZero(), gc.Equals, false)
+	return resp.UploadId
+}
+
+// putPart uploads the given data as the given part of an upload.
+func (s *UploadsSuite) putPart(c *gc.C, uploadId, part string, data []byte) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:       s.srv,
+		URL:           storeURL("upload/" + uploadId + "/" + part + "?hash=" + hashOfBytes(data)),
+		Method:        "PUT",
+		ContentLength: int64(len(data)),
+		Body:          bytes.NewReader(data),
+		Username:      serverParams.AuthUsername,
+		Password:      serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+}
+
+func (s *UploadsSuite) getUploadInfo(c *gc.C, uploadId string) params.UploadInfoResponse {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("upload/" + uploadId),
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+	var info params.UploadInfoResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &info)
+	c.Assert(err, gc.IsNil)
+	return info
+}
+
+func (s *UploadsSuite) TestUploadInParts(c *gc.C) {
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	data, err := ioutil.ReadFile(ch.Path)
+	c.Assert(err, gc.IsNil)
+	n := len(data) / 3
+	parts := [][]byte{data[:n], data[n : 2*n], data[2*n:]}
+
+	uploadId := s.startUpload(c, "~charmers/precise/wordpress")
+
+	// Parts can be uploaded in any order and uploaded again.
+	s.putPart(c, uploadId, "2", parts[2])
+	s.putPart(c, uploadId, "0", []byte("bad data"))
+	s.putPart(c, uploadId, "0", parts[0])
+
+	info := s.getUploadInfo(c, uploadId)
+	c.Assert(info.UploadId, gc.Equals, uploadId)
+	c.Assert(info.Id, jc.DeepEquals, charm.MustParseReference("~charmers/precise/wordpress"))
+	c.Assert(info.Parts, jc.DeepEquals, []params.UploadPart{{
+		Part: 0,
+		Hash: hashOfBytes(parts[0]),
+		Size: int64(len(parts[0])),
+	}, {
+		Part: 2,
+		Hash: hashOfBytes(parts[2]),
+		Size: int64(len(parts[2])),
+	}})
+
+	// The upload cannot be committed while a part is missing.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/precise/wordpress/archive?hash=" + hashOfBytes(data) + "&upload=" + uploadId),
+		Method:       "POST",
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Message: `upload "` + uploadId + `" has no part 1`,
+			Code:    params.ErrBadRequest,
+		},
+	})
+
+	s.putPart(c, uploadId, "1", parts[1])
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/precise/wordpress/archive?hash=" + hashOfBytes(data) + "&upload=" + uploadId),
+		Method:   "POST",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+		ExpectBody: params.ArchiveUploadResponse{
+			Id: charm.MustParseReference("~charmers/precise/wordpress-0"),
+		},
+	})
+	entity, err := s.store.FindEntity(charm.MustParseReference("~charmers/precise/wordpress-0"), "size", "blobhash")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Size, gc.Equals, int64(len(data)))
+	c.Assert(entity.BlobHash, gc.Equals, hashOfBytes(data))
+
+	// The upload is removed once committed.
+	_, err = s.store.FindUpload(uploadId)
+	c.Assert(err, gc.ErrorMatches, `upload ".*" not found`)
+}
+
+func (s *UploadsSuite) TestPutArchiveFromUpload(c *gc.C) {
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	data, err := ioutil.ReadFile(ch.Path)
+	c.Assert(err, gc.IsNil)
+	uploadId := s.startUpload(c, "~charmers/precise/wordpress-5")
+	s.putPart(c, uploadId, "0", data)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/precise/wordpress-5/archive?hash=" + hashOfBytes(data) + "&upload=" + uploadId),
+		Method:   "PUT",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+		ExpectBody: params.ArchiveUploadResponse{
+			Id: charm.MustParseReference("~charmers/precise/wordpress-5"),
+		},
+	})
+}
+
+func (s *UploadsSuite) TestUploadSizeLimit(c *gc.C) {
+	p := serverParams
+	p.MaxArchiveSize = 10
+	s.srv, s.store = newServer(c, s.Session, nil, p)
+	uploadId := s.startUpload(c, "~charmers/precise/wordpress")
+	s.putPart(c, uploadId, "0", []byte("123456"))
+
+	// The parts together cannot exceed the maximum archive size.
+	data := []byte("abcdef")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:       s.srv,
+		URL:           storeURL("upload/" + uploadId + "/1?hash=" + hashOfBytes(data)),
+		Method:        "PUT",
+		ContentLength: int64(len(data)),
+		Body:          bytes.NewReader(data),
+		Username:      serverParams.AuthUsername,
+		Password:      serverParams.AuthPassword,
+		ExpectStatus:  http.StatusForbidden,
+		ExpectBody: params.Error{
+			Message: "upload size 12 exceeds maximum archive size of 10 bytes",
+			Code:    params.ErrQuotaExceeded,
+		},
+	})
+
+	// A replaced part does not count towards the limit.
+	s.putPart(c, uploadId, "0", []byte("1234"))
+	s.putPart(c, uploadId, "1", data)
+	c.Assert(s.getUploadInfo(c, uploadId).Parts, gc.HasLen, 2)
+}
+
+func (s *UploadsSuite) TestDeleteUpload(c *gc.C) {
+	uploadId := s.startUpload(c, "~charmers/precise/wordpress")
+	s.putPart(c, uploadId, "0", []byte("data"))
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("upload/" + uploadId),
+		Method:   "DELETE",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("upload/" + uploadId),
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Message: `upload "` + uploadId + `" not found`,
+			Code:    params.ErrNotFound,
+		},
+	})
+}
+
+func (s *UploadsSuite) TestUploadForDifferentEntity(c *gc.C) {
+	uploadId := s.startUpload(c, "~charmers/precise/wordpress")
+	s.putPart(c, uploadId, "0", []byte("data"))
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/trusty/wordpress/archive?hash=" + hashOfBytes([]byte("data")) + "&upload=" + uploadId),
+		Method:       "POST",
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Message: `upload "` + uploadId + `" is for cs:~charmers/precise/wordpress, not cs:~charmers/trusty/wordpress`,
+			Code:    params.ErrBadRequest,
+		},
+	})
+}
+
+func (s *UploadsSuite) TestUploadsUnauthorized(c *gc.C) {
+	uploadId := s.startUpload(c, "~charmers/precise/wordpress")
+	for _, path := range []string{
+		"upload/" + uploadId,
+		"~charmers/precise/wordpress/upload",
+	} {
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler: s.srv,
+			URL:     storeURL(path),
+			Method:  "POST",
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized, gc.Commentf("path %q; body: %s", path, rec.Body.Bytes()))
+	}
+}
+
+var uploadsErrorsTests = []struct {
+	about         string
+	method        string
+	path          string
+	expectStatus  int
+	expectMessage string
+	expectCode    params.ErrorCode
+}{{
+	about:         "unknown upload",
+	method:        "GET",
+	path:          "upload/unknown",
+	expectStatus:  http.StatusNotFound,
+	expectMessage: `upload "unknown" not found`,
+	expectCode:    params.ErrNotFound,
+}, {
+	about:         "post to upload",
+	method:        "POST",
+	path:          "upload/$upload",
+	expectStatus:  http.StatusMethodNotAllowed,
+	expectMessage: "POST method not allowed",
+	expectCode:    params.ErrMethodNotAllowed,
+}, {
+	about:         "get part",
+	method:        "GET",
+	path:          "upload/$upload/0",
+	expectStatus:  http.StatusMethodNotAllowed,
+	expectMessage: "GET method not allowed",
+	expectCode:    params.ErrMethodNotAllowed,
+}, {
+	about:         "invalid part number",
+	method:        "PUT",
+	path:          "upload/$upload/foo?hash=x",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `invalid part number "foo"`,
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "part number out of range",
+	method:        "PUT",
+	path:          "upload/$upload/-1?hash=" + hashOfBytes(nil),
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "part number -1 out of range [0, 10000)",
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "no hash",
+	method:        "PUT",
+	path:          "upload/$upload/0",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "hash parameter not specified",
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "no series",
+	method:        "POST",
+	path:          "~charmers/wordpress/upload",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "series not specified",
+	expectCode:    params.ErrBadRequest,
+}}
+
+func (s *UploadsSuite) TestUploadsErrors(c *gc.C) {
+	uploadId := s.startUpload(c, "~charmers/precise/wordpress")
+	for i, test := range uploadsErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		path := strings.Replace(test.path, "$upload", uploadId, 1)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL(path),
+			Method:       test.method,
+			Username:     serverParams.AuthUsername,
+			Password:     serverParams.AuthPassword,
+			ExpectStatus: test.expectStatus,
+			ExpectBody: params.Error{
+				Message: test.expectMessage,
+				Code:    test.expectCode,
+			},
+		})
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/juju/utils/jsonhttp"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v4"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/juju/charmstore/internal/charmstore"
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/params"
+)
+
+// GET /webhooks[?namespace=$namespace]
+//
+// POST /webhooks
+func (h *Handler) serveWebhooks(w http.ResponseWriter, req *http.Request) error {
+	switch req.Method {
+	case "GET":
+		return h.getWebhooks(w, req)
+	case "POST":
+		return h.postWebhook(w, req)
+	}
+	return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s method not allowed", req.Method)
+}
+
+func (h *Handler) getWebhooks(w http.ResponseWriter, req *http.Request) error {
+	namespace := req.Form.Get("namespace")
+	if err := h.authorize(req, namespaceACL(namespace)); err != nil {
+		return err
+	}
+	var hooks []mongodoc.Webhook
+	if err := h.store.DB.Webhooks().Find(bson.D{
+		{"namespace", namespace},
+	}).Sort("createdtime").All(&hooks); err != nil {
+		return errgo.Notef(err, "cannot get webhooks")
+	}
+	response := make([]params.Webhook, len(hooks))
+	for i := range hooks {
+		response[i] = webhookResponse(&hooks[i])
+	}
+	return jsonhttp.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) postWebhook(w http.ResponseWriter, req *http.Request) error {
+	if ctype := req.Header.Get("Content-Type"); ctype != "application/json" {
+		return badRequestf(nil, "unexpected Content-Type %q; expected 'application/json'", ctype)
+	}
+	var hookReq params.WebhookRequest
+	if err := json.NewDecoder(req.Body).Decode(&hookReq); err != nil {
+		return badRequestf(err, "cannot unmarshal body")
+	}
+	if err := h.authorize(req, namespaceACL(hookReq.Namespace)); err != nil {
+		return err
+	}
+	u, err := url.Parse(hookReq.URL)
+	if err != nil {
+		return badRequestf(err, "invalid webhook URL")
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return badRequestf(nil, "invalid webhook URL %q: must be an absolute http or https URL", hookReq.URL)
+	}
+	if !h.config.AllowPrivateWebhooks {
+		if err := charmstore.CheckWebhookURL(u); err != nil {
+			return badRequestf(err, "invalid webhook URL %q", hookReq.URL)
+		}
+	}
+	if hookReq.Secret == "" {
+		return badRequestf(nil, "webhook secret not specified")
+	}
+	hook := &mongodoc.Webhook{
+		Namespace: hookReq.Namespace,
+		URL:       hookReq.URL,
+		Secret:    hookReq.Secret,
+	}
+	for _, event := range hookReq.Events {
+		if !isWebhookEvent(event) {
+			return badRequestf(nil, "unknown webhook event %q", event)
+		}
+		hook.Events = append(hook.Events, string(event))
+	}
+	if err := h.store.AddWebhook(hook); err != nil {
+		return errgo.Mask(err)
+	}
+	return jsonhttp.WriteJSON(w, http.StatusOK, webhookResponse(hook))
+}
+
+// GET /webhooks/$id
+//
+// DELETE /webhooks/$id
+//
+// GET /webhooks/$id/deliveries[?limit=$count]
+func (h *Handler) serveWebhook(w http.ResponseWriter, req *http.Request) error {
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	id, rest := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		id, rest = path[:i], path[i+1:]
+	}
+	if id == "" || (rest != "" && rest != "deliveries") {
+		return errgo.WithCausef(nil, params.ErrNotFound, "not found")
+	}
+	hook, err := h.store.FindWebhook(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if err := h.authorize(req, namespaceACL(hook.Namespace)); err != nil {
+		return err
+	}
+	if rest == "deliveries" {
+		if req.Method != "GET" {
+			return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s method not allowed", req.Method)
+		}
+		return h.getWebhookDeliveries(hook, w, req)
+	}
+	switch req.Method {
+	case "GET":
+		return jsonhttp.WriteJSON(w, http.StatusOK, webhookResponse(hook))
+	case "DELETE":
+		if err := h.store.RemoveWebhook(hook.Id); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		return nil
+	}
+	return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s method not allowed", req.Method)
+}
+
+func (h *Handler) getWebhookDeliveries(hook *mongodoc.Webhook, w http.ResponseWriter, req *http.Request) error {
+	limit, err := intValue(req.Form.Get("limit"), 1, 100)
+	if err != nil {
+		return badRequestf(err, "invalid limit value")
+	}
+	var deliveries []mongodoc.WebhookDelivery
+	if err := h.store.DB.WebhookDeliveries().Find(bson.D{
+		{"webhookid", hook.Id},
+	}).Sort("-_id").Limit(limit).All(&deliveries); err != nil {
+		return errgo.Notef(err, "cannot get webhook deliveries")
+	}
+	response := make([]params.WebhookDelivery, len(deliveries))
+	for i, d := range deliveries {
+		r := params.WebhookDelivery{
+			Id:          d.Id.Hex(),
+			Event:       params.WebhookEvent(d.Event),
+			Payload:     json.RawMessage(d.Payload),
+			CreatedTime: d.CreatedTime.UTC(),
+			Status:      params.WebhookDeliveryStatus(d.Status),
+			Attempts:    make([]params.WebhookAttempt, len(d.Attempts)),
+		}
+		for j, a := range d.Attempts {
+			r.Attempts[j] = params.WebhookAttempt{
+				Time:       a.Time.UTC(),
+				StatusCode: a.StatusCode,
+				Error:      a.Error,
+			}
+		}
+		if d.Status == mongodoc.DeliveryPending {
+			next := d.NextAttempt.UTC()
+			r.NextAttempt = &next
+		}
+		response[i] = r
+	}
+	return jsonhttp.WriteJSON(w, http.StatusOK, response)
+}
+
+// notifyPermChange notifies a perm change if the
+// given base entity fields include permissions.
+func (h *Handler) notifyPermChange(id *charm.Reference, fields map[string]interface{}) {
+	_, readChanged := fields["acls.read"]
+	_, writeChanged := fields["acls.write"]
+	if !readChanged && !writeChanged {
+		return
+	}
+	baseEntity, err := h.store.FindBaseEntity(id, "acls")
+	if err != nil {
+		logger.Errorf("cannot notify perm change for %s: %v", id, err)
+		return
+	}
+	h.notifyChange(&params.WebhookPayload{
+		Event: params.PermEvent,
+		Id:    id,
+		Perm: &params.PermResponse{
+			Read:  baseEntity.ACLs.Read,
+			Write: baseEntity.ACLs.Write,
+		},
+	})
+}
+
+// notifyExtraInfoChange notifies an extra-info change if
+// the given entity fields include extra-info values.
+func (h *Handler) notifyExtraInfoChange(id *charm.Reference, fields map[string]interface{}) {
+	var extraInfo map[string]json.RawMessage
+	for field, val := range fields {
+		if !strings.HasPrefix(field, "extrainfo.") {
+			continue
+		}
+		data, ok := val.(json.RawMessage)
+		if !ok {
+			continue
+		}
+		if extraInfo == nil {
+			extraInfo = make(map[string]json.RawMessage)
+		}
+		extraInfo[strings.TrimPrefix(field, "extrainfo.")] = data
+	}
+	if extraInfo == nil {
+		return
+	}
+	hidden, err := h.isHidden(id)
+	if err != nil {
+		logger.Errorf("cannot notify extra-info change for %s: %v", id, err)
+		return
+	}
+	h.notifyChange(&params.WebhookPayload{
+		Event:     params.ExtraInfoEvent,
+		Id:        id,
+		Hidden:    hidden,
+		ExtraInfo: extraInfo,
+	})
+}
+
+// notifyChange adds the change described by the given payload
+// to the change log and queues the payload for delivery to the
+// subscribed webhooks. Errors are logged rather than returned
+// because the change being notified has already been made.
+func (h *Handler) notifyChange(payload *params.WebhookPayload) {
+	if err := h.store.AddChange(payload, h.changeReadACL(payload)); err != nil {
+		logger.Errorf("cannot log %s change for %s: %v", payload.Event, payload.Id, err)
+	}
+	if err := h.store.NotifyWebhooks(payload); err != nil {
+		logger.Errorf("cannot notify webhooks of %s event for %s: %v", payload.Event, payload.Id, err)
+	}
+}
+
+// changeReadACL returns the users and groups allowed to see the
+// change described by the given payload: those allowed to read the
+// charm or bundle, or to write it if the change is to a hidden
+// revision. If the ACL cannot be determined, only the administrator
+// is allowed to see the change.
+func (h *Handler) changeReadACL(payload *params.WebhookPayload) []string {
+	baseEntity, err := h.store.FindBaseEntity(payload.Id, "acls")
+	if err != nil {
+		logger.Errorf("cannot get ACL of %s change for %s: %v", payload.Event, payload.Id, err)
+		return nil
+	}
+	if payload.Hidden {
+		return baseEntity.ACLs.Write
+	}
+	return baseEntity.ACLs.Read
+}
+
+// namespaceACL returns the ACL allowing access to the webhooks
+// in the given namespace. Only the administrator is allowed to
+// access global webhooks.
+func namespaceACL(namespace string) []string {
+	if namespace == "" {
+		return nil
+	}
+	return []string{namespace}
+}
+
+func isWebhookEvent(event params.WebhookEvent) bool {
+	for _, e := range params.WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookResponse(hook *mongodoc.Webhook) params.Webhook {
+	r := params.Webhook{
+		Id:          hook.Id,
+		Namespace:   hook.Namespace,
+		URL:         hook.URL,
+		CreatedTime: hook.CreatedTime.UTC(),
+	}
+	for _, event := range hook.Events {
+		r.Events = append(r.Events, params.WebhookEvent(event))
+	}
+	return r
+}
@@ -0,0 +1,557 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v4"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/juju/charmstore/internal/charmstore"
+	"github.com/juju/charmstore/internal/mongodoc"
+	"github.com/juju/charmstore/internal/storetesting"
+	"github.com/juju/charmstore/params"
+)
+
+type webhooksSuite struct {
+	storetesting.IsolatedMgoSuite
+	srv   http.Handler
+	store *charmstore.Store
+}
+
+var _ = gc.Suite(&webhooksSuite{})
+
+func (s *webhooksSuite) SetUpTest(c *gc.C) {
+	s.IsolatedMgoSuite.SetUpTest(c)
+	s.srv, s.store = newServer(c, s.Session, nil, serverParams)
+}
+
+// postWebhook creates a webhook using the API
+// with admin credentials.
+func postWebhook(c *gc.C, srv http.Handler, hookReq params.WebhookRequest) params.Webhook {
+	body, err := json.Marshal(hookReq)
+	c.Assert(err, gc.IsNil)
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: srv,
+		URL:     storeURL("webhooks"),
+		Method:  "POST",
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+		},
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+		Body:     bytes.NewReader(body),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	var hook params.Webhook
+	err = json.Unmarshal(rec.Body.Bytes(), &hook)
+	c.Assert(err, gc.IsNil)
+	return hook
+}
+
+func (s *webhooksSuite) TestPostAndGetWebhooks(c *gc.C) {
+	before := time.Now()
+	global := postWebhook(c, s.srv, params.WebhookRequest{
+		URL:    "http://example.com/global",
+		Secret: "secret",
+	})
+	joe := postWebhook(c, s.srv, params.WebhookRequest{
+		Namespace: "joe",
+		URL:       "https://example.com/joe",
+		Secret:    "secret",
+		Events:    []params.WebhookEvent{params.UploadEvent, params.PermEvent},
+	})
+	c.Assert(global.Id, gc.Not(gc.Equals), "")
+	c.Assert(global.CreatedTime, jc.TimeBetween(before.Add(-time.Second), time.Now()))
+	c.Assert(joe, jc.DeepEquals, params.Webhook{
+		Id:          joe.Id,
+		Namespace:   "joe",
+		URL:         "https://example.com/joe",
+		Events:      []params.WebhookEvent{params.UploadEvent, params.PermEvent},
+		CreatedTime: joe.CreatedTime,
+	})
+
+	// Webhooks are listed by namespace.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("webhooks"),
+		Username:   serverParams.AuthUsername,
+		Password:   serverParams.AuthPassword,
+		ExpectBody: []params.Webhook{global},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("webhooks?namespace=joe"),
+		Username:   serverParams.AuthUsername,
+		Password:   serverParams.AuthPassword,
+		ExpectBody: []params.Webhook{joe},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("webhooks?namespace=bob"),
+		Username:   serverParams.AuthUsername,
+		Password:   serverParams.AuthPassword,
+		ExpectBody: []params.Webhook{},
+	})
+
+	// A webhook can be retrieved by id.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("webhooks/" + joe.Id),
+		Username:   serverParams.AuthUsername,
+		Password:   serverParams.AuthPassword,
+		ExpectBody: joe,
+	})
+
+	// The secret is stored but never returned.
+	hook, err := s.store.FindWebhook(joe.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(hook.Secret, gc.Equals, "secret")
+
+	// A webhook can be removed.
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("webhooks/" + joe.Id),
+		Method:   "DELETE",
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("webhooks/" + joe.Id),
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: `webhook "` + joe.Id + `" not found`,
+		},
+	})
+}
+
+var postWebhookErrorsTests = []struct {
+	about         string
+	contentType   string
+	body          string
+	expectMessage string
+}{{
+	about:         "invalid content type",
+	contentType:   "text/plain",
+	body:          `{}`,
+	expectMessage: `unexpected Content-Type "text/plain"; expected 'application/json'`,
+}, {
+	about:         "invalid body",
+	body:          `bad wolf`,
+	expectMessage: `cannot unmarshal body: invalid character 'b' looking for beginning of value`,
+}, {
+	about:         "relative URL",
+	body:          `{"URL": "/hook", "Secret": "secret"}`,
+	expectMessage: `invalid webhook URL "/hook": must be an absolute http or https URL`,
+}, {
+	about:         "unsupported URL scheme",
+	body:          `{"URL": "ftp://example.com/hook", "Secret": "secret"}`,
+	expectMessage: `invalid webhook URL "ftp://example.com/hook": must be an absolute http or https URL`,
+}, {
+	about:         "no secret",
+	body:          `{"URL": "http://example.com/hook"}`,
+	expectMessage: `webhook secret not specified`,
+}, {
+	about:         "unknown event",
+	body:          `{"URL": "http://example.com/hook", "Secret": "secret", "Events": ["upload", "bad-wolf"]}`,
+	expectMessage: `unknown webhook event "bad-wolf"`,
+}}
+
+func (s *webhooksSuite) TestPostWebhookErrors(c *gc.C) {
+	for i, test := range postWebhookErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		contentType := test.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler: s.srv,
+			URL:     storeURL("webhooks"),
+			Method:  "POST",
+			Header: http.Header{
+				"Content-Type": {contentType},
+			},
+			Body:         strings.NewReader(test.body),
+			Username:     serverParams.AuthUsername,
+			Password:     serverParams.AuthPassword,
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody: params.Error{
+				Code:    params.ErrBadRequest,
+				Message: test.expectMessage,
+			},
+		})
+	}
+	count, err := s.store.DB.Webhooks().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 0)
+}
+
+var postWebhookReservedAddressTests = []struct {
+	url  string
+	host string
+	addr string
+}{
+	{"http://127.0.0.1/hook", "127.0.0.1", "127.0.0.1"},
+	{"http://127.0.0.1:8080/hook", "127.0.0.1", "127.0.0.1"},
+	{"http://10.1.2.3/hook", "10.1.2.3", "10.1.2.3"},
+	{"http://192.168.0.1/hook", "192.168.0.1", "192.168.0.1"},
+	{"http://169.254.169.254/latest/meta-data", "169.254.169.254", "169.254.169.254"},
+	{"https://0.0.0.0/hook", "0.0.0.0", "0.0.0.0"},
+	{"http://[::1]:8080/hook", "::1", "::1"},
+	{"http://[fd00::1]/hook", "fd00::1", "fd00::1"},
+	{"http://[::ffff:127.0.0.1]/hook", "::ffff:127.0.0.1", "127.0.0.1"},
+}
+
+func (s *webhooksSuite) TestPostWebhookReservedAddress(c *gc.C) {
+	p := serverParams
+	p.AllowPrivateWebhooks = false
+	srv, store := newServer(c, s.Session, nil, p)
+	for i, test := range postWebhookReservedAddressTests {
+		c.Logf("test %d: %s", i, test.url)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler: srv,
+			URL:     storeURL("webhooks"),
+			Method:  "POST",
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			Body:         strings.NewReader(`{"URL": "` + test.url + `", "Secret": "secret"}`),
+			Username:     serverParams.AuthUsername,
+			Password:     serverParams.AuthPassword,
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody: params.Error{
+				Code:    params.ErrBadRequest,
+				Message: `invalid webhook URL "` + test.url + `": webhook host "` + test.host + `" has reserved address ` + test.addr,
+			},
+		})
+	}
+	count, err := store.DB.Webhooks().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 0)
+}
+
+func (s *webhooksSuite) TestWebhookNotFound(c *gc.C) {
+	for _, path := range []string{"webhooks/no-such-hook", "webhooks/no-such-hook/deliveries"} {
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL(path),
+			Username:     serverParams.AuthUsername,
+			Password:     serverParams.AuthPassword,
+			ExpectStatus: http.StatusNotFound,
+			ExpectBody: params.Error{
+				Code:    params.ErrNotFound,
+				Message: `webhook "no-such-hook" not found`,
+			},
+		})
+	}
+	hook := postWebhook(c, s.srv, params.WebhookRequest{
+		URL:    "http://example.com/global",
+		Secret: "secret",
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("webhooks/" + hook.Id + "/bad-wolf"),
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: "not found",
+		},
+	})
+}
+
+func (s *webhooksSuite) TestWebhooksAuthorization(c *gc.C) {
+	hook := postWebhook(c, s.srv, params.WebhookRequest{
+		URL:    "http://example.com/global",
+		Secret: "secret",
+	})
+	for _, path := range []string{"webhooks", "webhooks/" + hook.Id, "webhooks/" + hook.Id + "/deliveries"} {
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL(path),
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectBody: params.Error{
+				Code:    params.ErrUnauthorized,
+				Message: "authentication failed: missing HTTP auth header",
+			},
+		})
+	}
+}
+
+func (s *webhooksSuite) TestNamespaceWebhooksAuthorization(c *gc.C) {
+	srv, _, discharger := newServerWithDischarger(c, s.Session, "joe", []string{"acme"})
+	defer discharger.Close()
+	cookies := []*http.Cookie{dischargedAuthCookie(c, srv)}
+
+	post := func(namespace string) *httptesting.DoRequestParams {
+		body, err := json.Marshal(params.WebhookRequest{
+			Namespace: namespace,
+			URL:       "http://example.com/" + namespace,
+			Secret:    "secret",
+		})
+		c.Assert(err, gc.IsNil)
+		return &httptesting.DoRequestParams{
+			Handler: srv,
+			URL:     storeURL("webhooks"),
+			Method:  "POST",
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			Cookies: cookies,
+			Body:    bytes.NewReader(body),
+		}
+	}
+
+	// Users can manage webhooks in their own namespace and
+	// in the namespaces of their groups.
+	for _, namespace := range []string{"joe", "acme"} {
+		rec := httptesting.DoRequest(c, *post(namespace))
+		c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+		rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler: srv,
+			URL:     storeURL("webhooks?namespace=" + namespace),
+			Cookies: cookies,
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+		var hooks []params.Webhook
+		err := json.Unmarshal(rec.Body.Bytes(), &hooks)
+		c.Assert(err, gc.IsNil)
+		c.Assert(hooks, gc.HasLen, 1)
+		c.Assert(hooks[0].Namespace, gc.Equals, namespace)
+	}
+
+	// Other namespaces and global webhooks are not accessible.
+	for _, namespace := range []string{"bob", ""} {
+		rec := httptesting.DoRequest(c, *post(namespace))
+		c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized, gc.Commentf("body: %s", rec.Body.String()))
+		c.Assert(rec.Body.String(), jc.JSONEquals, params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: `unauthorized: access denied for user "joe"`,
+		})
+	}
+	count, err := s.store.DB.Webhooks().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 2)
+}
+
+func (s *webhooksSuite) TestGetWebhookDeliveries(c *gc.C) {
+	hook := postWebhook(c, s.srv, params.WebhookRequest{
+		URL:    "http://0.1.2.3/global",
+		Secret: "secret",
+	})
+	for _, event := range []params.WebhookEvent{params.UploadEvent, params.DeleteEvent} {
+		err := s.store.NotifyWebhooks(&params.WebhookPayload{
+			Event: event,
+			Id:    charm.MustParseReference("cs:precise/wordpress-0"),
+		})
+		c.Assert(err, gc.IsNil)
+	}
+	attemptTime := time.Now().UTC().Truncate(time.Millisecond)
+	err := s.store.DB.WebhookDeliveries().Update(bson.D{{"event", "upload"}}, bson.D{{"$set", bson.D{
+		{"status", mongodoc.DeliveryFailed},
+		{"attempts", []mongodoc.WebhookAttempt{{
+			Time:  attemptTime,
+			Error: "connection refused",
+		}}},
+	}}})
+	c.Assert(err, gc.IsNil)
+
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("webhooks/" + hook.Id + "/deliveries"),
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	var deliveries []params.WebhookDelivery
+	err = json.Unmarshal(rec.Body.Bytes(), &deliveries)
+	c.Assert(err, gc.IsNil)
+
+	// The most recent delivery is returned first.
+	c.Assert(deliveries, gc.HasLen, 2)
+	c.Assert(deliveries[0].Event, gc.Equals, params.DeleteEvent)
+	c.Assert(deliveries[0].Status, gc.Equals, params.DeliveryPending)
+	c.Assert(deliveries[0].Attempts, gc.HasLen, 0)
+	c.Assert(deliveries[0].NextAttempt, gc.NotNil)
+	var payload params.WebhookPayload
+	err = json.Unmarshal(deliveries[0].Payload, &payload)
+	c.Assert(err, gc.IsNil)
+	c.Assert(payload.Event, gc.Equals, params.DeleteEvent)
+	c.Assert(payload.Id.String(), gc.Equals, "cs:precise/wordpress-0")
+
+	c.Assert(deliveries[1].Event, gc.Equals, params.UploadEvent)
+	c.Assert(deliveries[1].Status, gc.Equals, params.DeliveryFailed)
+	c.Assert(deliveries[1].NextAttempt, gc.IsNil)
+	c.Assert(deliveries[1].Attempts, jc.DeepEquals, []params.WebhookAttempt{{
+		Time:  attemptTime,
+		Error: "connection refused",
+	}})
+
+	// The number of deliveries can be limited.
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("webhooks/" + hook.Id + "/deliveries?limit=1"),
+		Username: serverParams.AuthUsername,
+		Password: serverParams.AuthPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	err = json.Unmarshal(rec.Body.Bytes(), &deliveries)
+	c.Assert(err, gc.IsNil)
+	c.Assert(deliveries, gc.HasLen, 1)
+	c.Assert(deliveries[0].Event, gc.Equals, params.DeleteEvent)
+}
+
+// webhookPayloads returns the payloads queued for the given webhook,
+// oldest first.
+func webhookPayloads(c *gc.C, store *charmstore.Store, hookId string) []params.WebhookPayload {
+	var deliveries []mongodoc.WebhookDelivery
+	err := store.DB.WebhookDeliveries().Find(bson.D{{"webhookid", hookId}}).Sort("_id").All(&deliveries)
+	c.Assert(err, gc.IsNil)
+	payloads := make([]params.WebhookPayload, len(deliveries))
+	for i, d := range deliveries {
+		err := json.Unmarshal(d.Payload, &payloads[i])
+		c.Assert(err, gc.IsNil)
+		payloads[i].Time = time.Time{}
+	}
+	return payloads
+}
+
+func (s *ArchiveSuite) TestUploadAndDeleteNotifyWebhooks(c *gc.C) {
+	hook := postWebhook(c, s.srv, params.WebhookRequest{
+		Namespace: "who",
+		URL:       "http://0.1.2.3/who",
+		Secret:    "secret",
+	})
+	s.assertUploadCharm(c, "POST", charm.MustParseReference("~who/utopic/wordpress-0"), "wordpress")
+	s.assertUploadCharm(c, "POST", charm.MustParseReference("~bob/utopic/wordpress-0"), "wordpress")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~who/utopic/wordpress-0/archive"),
+		Method:       "DELETE",
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusOK,
+	})
+	c.Assert(webhookPayloads(c, s.store, hook.Id), jc.DeepEquals, []params.WebhookPayload{{
+		Event: params.UploadEvent,
+		Id:    charm.MustParseReference("cs:~who/utopic/wordpress-0"),
+	}, {
+		Event: params.DeleteEvent,
+		Id:    charm.MustParseReference("cs:~who/utopic/wordpress-0"),
+	}})
+}
+
+func (s *APISuite) TestMetadataChangesNotifyWebhooks(c *gc.C) {
+	hook := postWebhook(c, s.srv, params.WebhookRequest{
+		URL:    "http://0.1.2.3/global",
+		Secret: "secret",
+		Events: []params.WebhookEvent{params.PermEvent, params.ExtraInfoEvent},
+	})
+	s.addCharm(c, "wordpress", "cs:precise/wordpress-23")
+	s.assertPut(c, "precise/wordpress-23/meta/perm/read", []string{"bob", params.Everyone})
+	s.assertPut(c, "precise/wordpress-23/meta/extra-info", map[string]interface{}{
+		"foo": 42,
+		"bar": "hello",
+	})
+	s.assertPut(c, "precise/wordpress-23/meta/extra-info/baz", true)
+	// Other changes do not notify webhooks.
+	s.assertPut(c, "precise/wordpress-23/meta/hidden", params.HiddenResponse{Hidden: true})
+
+	c.Assert(webhookPayloads(c, s.store, hook.Id), jc.DeepEquals, []params.WebhookPayload{{
+		Event: params.PermEvent,
+		Id:    charm.MustParseReference("cs:wordpress"),
+		Perm: &params.PermResponse{
+			Read:  []string{"bob", params.Everyone},
+			Write: []string{},
+		},
+	}, {
+		Event: params.ExtraInfoEvent,
+		Id:    charm.MustParseReference("cs:precise/wordpress-23"),
+		ExtraInfo: map[string]json.RawMessage{
+			"foo": json.RawMessage("42"),
+			"bar": json.RawMessage(`"hello"`),
+		},
+	}, {
+		Event: params.ExtraInfoEvent,
+		Id:    charm.MustParseReference("cs:precise/wordpress-23"),
+		ExtraInfo: map[string]json.RawMessage{
+			"baz": json.RawMessage("true"),
+		},
+	}})
+}
+
+func (s *APISuite) TestPromulgate(c *gc.C) {
+	hook := postWebhook(c, s.srv, params.WebhookRequest{
+		URL:    "http://0.1.2.3/global",
+		Secret: "secret",
+		Events: []params.WebhookEvent{params.PromulgateEvent},
+	})
+	s.addCharm(c, "wordpress", "cs:~bob/precise/wordpress-0")
+	for _, promulgated := range []bool{true, true, false} {
+		s.assertPut(c, "~bob/precise/wordpress-0/promulgate", params.PromulgateRequest{
+			Promulgated: promulgated,
+		})
+		entity, err := s.store.FindBaseEntity(charm.MustParseReference("cs:~bob/wordpress"), "promulgated")
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Promulgated, gc.Equals, promulgated)
+	}
+
+	// Webhooks are only notified when the promulgation status changes.
+	yes, no := true, false
+	c.Assert(webhookPayloads(c, s.store, hook.Id), jc.DeepEquals, []params.WebhookPayload{{
+		Event:       params.PromulgateEvent,
+		Id:          charm.MustParseReference("cs:~bob/wordpress"),
+		Promulgated: &yes,
+	}, {
+		Event:       params.PromulgateEvent,
+		Id:          charm.MustParseReference("cs:~bob/wordpress"),
+		Promulgated: &no,
+	}})
+}
+
+func (s *APISuite) TestPromulgateErrors(c *gc.C) {
+	s.addCharm(c, "wordpress", "cs:~bob/precise/wordpress-0")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~bob/precise/wordpress-0/promulgate"),
+		Method:       "GET",
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusMethodNotAllowed,
+		ExpectBody: params.Error{
+			Code:    params.ErrMethodNotAllowed,
+			Message: "GET method not allowed",
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("~bob/precise/wordpress-0/promulgate"),
+		Method:  "PUT",
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+		},
+		Body:         strings.NewReader("bad wolf"),
+		Username:     serverParams.AuthUsername,
+		Password:     serverParams.AuthPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: "cannot unmarshal body: invalid character 'b' looking for beginning of value",
+		},
+	})
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package params
+
+import (
+	"gopkg.in/juju/charm.v4"
+)
+
+const (
+	// ExportManifestPath holds the path of the manifest
+	// in the tar archive returned by id/export GET requests.
+	ExportManifestPath = "manifest.json"
+
+	// ExportBundlePath holds the path of the bundle.yaml file in the
+	// tar archive returned by id/export GET requests, in which the
+	// charm URLs are replaced by the paths of the charm archives.
+	ExportBundlePath = "bundle.yaml"
+)
+
+// ExportManifest holds the manifest of the tar archive returned by an
+// id/export GET request, which holds the archive of a bundle and the
+// archives of all the charms it uses.
+type ExportManifest struct {
+	// Bundle holds the exported bundle.
+	Bundle ExportedArchive
+
+	// Charms holds the charms used by the bundle, keyed by
+	// the charm URLs found in the bundle. Several URLs may
+	// refer to the same archive.
+	Charms map[string]ExportedArchive
+}
+
+// ExportedArchive holds information on an
+// archive included in a bundle export.
+type ExportedArchive struct {
+	// Id holds the fully qualified id of the charm or bundle.
+	Id *charm.Reference
+
+	// Path holds the path of the archive in the export.
+	Path string
+
+	// Hash and Size hold the SHA384 hash and
+	// the size of the archive.
+	Hash string
+	Size int64
+}
@@ -0,0 +1,44 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package params
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// NewHash returns a hash that calculates the checksums used by the
+// charm store to identify content, such as the ContentHashHeader
+// value and the hash of archive uploads.
+func NewHash() hash.Hash {
+	return sha512.New384()
+}
+
+// ContentChallengeResponse holds a response to a ContentChallenge.
+type ContentChallengeResponse struct {
+	RequestId string
+	Hash      string
+}
+
+// NewContentChallengeResponse returns the response to the given
+// content challenge for the content read from r.
+func NewContentChallengeResponse(chal *ContentChallenge, r io.ReadSeeker) (*ContentChallengeResponse, error) {
+	if _, err := r.Seek(chal.RangeStart, 0); err != nil {
+		return nil, err
+	}
+	h := NewHash()
+	n, err := io.CopyN(h, r, chal.RangeLength)
+	if err != nil {
+		return nil, err
+	}
+	if n != chal.RangeLength {
+		return nil, fmt.Errorf("content is not long enough")
+	}
+	return &ContentChallengeResponse{
+		RequestId: chal.RequestId,
+		Hash:      fmt.Sprintf("%x", h.Sum(nil)),
+	}, nil
+}
@@ -29,6 +29,11 @@ const (
 	ErrMultipleErrors   ErrorCode = "multiple errors"
 	ErrUnauthorized     ErrorCode = "unauthorized"
 	ErrMethodNotAllowed ErrorCode = "method not allowed"
+	ErrQuotaExceeded    ErrorCode = "quota exceeded"
+	ErrArchiveTooLarge  ErrorCode = "archive too large"
+	ErrUnsafeArchive    ErrorCode = "unsafe archive"
+	ErrInvalidArchive   ErrorCode = "invalid archive"
+	ErrContentRequired  ErrorCode = "content required"
 
 	// Note that these error codes sit in the same name space
 	// as the bakery error codes defined in gopkg.in/macaroon-bakery.v0/httpbakery .
@@ -22,6 +22,11 @@ const (
 	// EntityIdHeader specifies the header attribute that will hold the
 	// id of the entity for archive GET responses.
 	EntityIdHeader = "Entity-Id"
+
+	// NextCursorHeader specifies the header attribute that will hold
+	// the cursor to use to get the next page of changes/published
+	// results. It is only set when there may be more results.
+	NextCursorHeader = "Next-Cursor"
 )
 
 // Special user/group names.
@@ -41,6 +46,21 @@ type MetaAnyResponse struct {
 // a post or a put to /$id/archive. See http://tinyurl.com/lzrzrgb
 type ArchiveUploadResponse struct {
 	Id *charm.Reference
+
+	// Challenge holds the proof-of-content challenge issued
+	// in response to an upload made with the challenge flag.
+	// When it is set, Id is nil.
+	Challenge *ContentChallenge `json:",omitempty"`
+}
+
+// ContentChallenge holds a proof-of-content challenge. The client can
+// prove that it holds the content of the archive it is uploading by
+// responding with the SHA384 hash of RangeLength bytes of the archive
+// starting at RangeStart.
+type ContentChallenge struct {
+	RequestId   string
+	RangeStart  int64
+	RangeLength int64
 }
 
 // ExpandedId holds a charm or bundle fully qualified id.
@@ -56,6 +76,13 @@ type ArchiveSizeResponse struct {
 	Size int64
 }
 
+// HashResponse holds the result of an id/meta/hash GET request.
+type HashResponse struct {
+	// Sum holds the SHA384 hash of the archive,
+	// in hexadecimal format.
+	Sum string
+}
+
 // ManifestFile holds information about a charm or bundle file.
 // A slice of ManifestFile is used as response for
 // id/meta/manifest GET requests. See http://tinyurl.com/p3xdcto
@@ -64,6 +91,115 @@ type ManifestFile struct {
 	Size int64
 }
 
+// ArchiveDirEntry holds information about an entry in a directory
+// of a charm or bundle archive. A slice of ArchiveDirEntry is used as
+// response for id/archive/path GET requests when path refers to a
+// directory.
+type ArchiveDirEntry struct {
+	// Name holds the name of the entry within the directory.
+	Name string
+
+	// IsDir holds whether the entry is a directory.
+	IsDir bool
+
+	// Size holds the size of the file in bytes.
+	// It is zero for directories.
+	Size int64
+
+	// Mode holds the file mode and permissions in
+	// symbolic form, for instance "-rwxr-xr-x".
+	Mode string
+
+	// ModTime holds the modification time recorded in the
+	// archive. It is zero for directories that do not have
+	// their own entry in the archive.
+	ModTime time.Time
+}
+
+// DiffResponse holds the result of an id/diff GET request.
+// It describes the changes from the charm or bundle with the
+// requested id to the one specified by the "to" parameter.
+type DiffResponse struct {
+	// From and To hold the resolved ids of the
+	// compared charms or bundles.
+	From *charm.Reference
+	To   *charm.Reference
+
+	// Files holds the archive files that differ,
+	// sorted by name.
+	Files []FileDiff
+
+	// CharmMetadata, CharmConfig and CharmActions hold
+	// the changes to the charm metadata, configuration
+	// options and actions. They are only present when
+	// both ids refer to charms.
+	CharmMetadata *CharmMetadataDiff `json:",omitempty"`
+	CharmConfig   *ItemsDiff         `json:",omitempty"`
+	CharmActions  *ItemsDiff         `json:",omitempty"`
+}
+
+// FileChange describes how an archive file has changed.
+type FileChange string
+
+const (
+	FileAdded    FileChange = "added"
+	FileRemoved  FileChange = "removed"
+	FileModified FileChange = "modified"
+)
+
+// FileDiff holds information about an archive file that
+// differs between two charms or bundles.
+type FileDiff struct {
+	// Name holds the name of the file in the archives.
+	Name string
+
+	// Change holds how the file has changed.
+	Change FileChange
+
+	// OldSize and OldHash hold the size and the SHA384 hash
+	// of the original file. They are omitted for added files.
+	OldSize int64  `json:",omitempty"`
+	OldHash string `json:",omitempty"`
+
+	// NewSize and NewHash hold the size and the SHA384 hash
+	// of the changed file. They are omitted for removed files.
+	NewSize int64  `json:",omitempty"`
+	NewHash string `json:",omitempty"`
+
+	// Diff holds the changes to the file contents as a unified
+	// diff. It is only present for small text files.
+	Diff string `json:",omitempty"`
+}
+
+// CharmMetadataDiff holds the changes to the metadata of a charm.
+type CharmMetadataDiff struct {
+	// Fields holds the changes to the metadata fields other than
+	// relations, keyed by the field names used in the results of
+	// id/meta/charm-metadata requests.
+	Fields ItemsDiff
+
+	// Provides, Requires and Peers hold the
+	// changes to the charm relations.
+	Provides ItemsDiff
+	Requires ItemsDiff
+	Peers    ItemsDiff
+}
+
+// ItemsDiff holds the changes to a set of named items, such as
+// charm configuration options. Items are held in the JSON form used
+// in the results of the corresponding id/meta requests.
+type ItemsDiff struct {
+	Added   map[string]json.RawMessage `json:",omitempty"`
+	Removed map[string]json.RawMessage `json:",omitempty"`
+	Changed map[string]ValueChange     `json:",omitempty"`
+}
+
+// ValueChange holds the original and changed values of an item.
+type ValueChange struct {
+	Old json.RawMessage
+	New json.RawMessage
+}
+
 // ArchiveUploadTimeResponse holds the result of an
 // id/meta/archive-upload-time GET request. See http://tinyurl.com/nmujuqk
 type ArchiveUploadTimeResponse struct {
@@ -104,6 +240,15 @@ type TagsResponse struct {
 type Published struct {
 	Id          *charm.Reference
 	PublishTime time.Time
+
+	// ReleaseNotes holds the release notes for
+	// the published revision, if there are any.
+	ReleaseNotes string `json:",omitempty"`
+
+	// Meta holds the metadata specified in the include
+	// parameters of the request, in the same form as
+	// returned by meta/any.
+	Meta map[string]interface{} `json:",omitempty"`
 }
 
 // DebugStatus holds the result of the status checks.
@@ -170,6 +315,127 @@ type PermResponse struct {
 	Write []string
 }
 
+// PromulgatedResponse holds the result of an id/meta/promulgated
+// GET request.
+type PromulgatedResponse struct {
+	Promulgated bool
+}
+
+// PromulgateRequest holds the body of an id/promulgate PUT request.
+type PromulgateRequest struct {
+	Promulgated bool
+}
+
+// HiddenResponse holds the result of an id/meta/hidden GET request.
+// It is also used as the body of an id/meta/hidden PUT request.
+type HiddenResponse struct {
+	Hidden bool
+}
+
+// ReleaseNotesResponse holds the result of an id/meta/release-notes
+// GET request. It is also used as the body of an id/meta/release-notes
+// PUT request.
+type ReleaseNotesResponse struct {
+	// ReleaseNotes holds the release notes for the revision.
+	// It is empty if there are no release notes.
+	ReleaseNotes string
+}
+
+// LintResponse holds the result of an id/meta/lint GET request.
+type LintResponse struct {
+	// Warnings holds the warnings produced by the upload
+	// validators when the charm or bundle was uploaded.
+	Warnings []LintWarning
+}
+
+// LintWarning holds a warning produced by an upload validator.
+type LintWarning struct {
+	// Validator holds the name of the validator
+	// that produced the warning.
+	Validator string
+
+	// Message holds the warning message.
+	Message string
+}
+
+// UpgradeInfoResponse holds the result of an id/meta/upgrade-info
+// GET request.
+type UpgradeInfoResponse struct {
+	// Upgrades holds an entry for each newer revision of the
+	// charm in the same series, from newest to oldest.
+	Upgrades []UpgradeInfo
+}
+
+// UpgradeInfo holds information about upgrading a charm
+// to a newer revision.
+type UpgradeInfo struct {
+	// Id holds the id of the newer revision.
+	Id *charm.Reference
+
+	// BreakingChanges holds the changes that may break an
+	// existing deployment when upgrading to the newer revision.
+	BreakingChanges []BreakingChange `json:",omitempty"`
+}
+
+// BreakingChangeKind holds the kind of a breaking change.
+type BreakingChangeKind string
+
+const (
+	ConfigOptionRemoved      BreakingChangeKind = "config-option-removed"
+	ConfigOptionTypeChanged  BreakingChangeKind = "config-option-type-changed"
+	RelationRemoved          BreakingChangeKind = "relation-removed"
+	RelationRenamed          BreakingChangeKind = "relation-renamed"
+	RelationInterfaceChanged BreakingChangeKind = "relation-interface-changed"
+	ActionRemoved            BreakingChangeKind = "action-removed"
+)
+
+// BreakingChange holds a change between two revisions
+// of a charm that may break an existing deployment.
+type BreakingChange struct {
+	Kind BreakingChangeKind
+
+	// Name holds the name of the config option,
+	// relation or action in the older revision.
+	Name string
+
+	// Old and New hold the old and new values of what was
+	// changed: the option type for ConfigOptionTypeChanged and
+	// the relation interface for RelationInterfaceChanged. For
+	// RelationRenamed, New holds the new name of the relation.
+	Old string `json:",omitempty"`
+	New string `json:",omitempty"`
+
+	// Message holds a human readable description of the change.
+	Message string
+}
+
+// UsageResponse holds the result of an id/meta/usage GET request.
+// It reports the storage used by the owner of the charm or bundle
+// and the number of its revisions, along with the limits that
+// apply to them. A zero limit means that there is no limit.
+type UsageResponse struct {
+	// User holds the owner of the charm or bundle.
+	User string
+
+	// Revisions holds the number of stored revisions
+	// of the charm or bundle, across all series.
+	Revisions int
+
+	// MaxRevisions holds the maximum number of revisions.
+	MaxRevisions int
+
+	// Storage holds the total size in bytes of the
+	// archives owned by User.
+	Storage int64
+
+	// MaxStorage holds the maximum value of Storage.
+	MaxStorage int64
+
+	// MaxArchiveSize holds the maximum size in bytes
+	// of a single archive.
+	MaxArchiveSize int64
+}
+
 const (
 	// BzrDigestKey is the extra-info key used to store the Bazaar digest
 	BzrDigestKey = "bzr-digest"
@@ -5,7 +5,9 @@ package params_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/textproto"
+	"strings"
 
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
@@ -39,3 +41,26 @@ func (*suite) TestBakeryErrorCompatibility(c *gc.C) {
 	c.Assert(err, gc.IsNil)
 	c.Assert(string(data1), jc.JSONEquals, err2)
 }
+
+func (*suite) TestNewContentChallengeResponse(c *gc.C) {
+	content := "some content"
+	resp, err := params.NewContentChallengeResponse(&params.ContentChallenge{
+		RequestId:   "req",
+		RangeStart:  5,
+		RangeLength: 3,
+	}, strings.NewReader(content))
+	c.Assert(err, gc.IsNil)
+	h := params.NewHash()
+	h.Write([]byte("con"))
+	c.Assert(resp, jc.DeepEquals, &params.ContentChallengeResponse{
+		RequestId: "req",
+		Hash:      fmt.Sprintf("%x", h.Sum(nil)),
+	})
+
+	_, err = params.NewContentChallengeResponse(&params.ContentChallenge{
+		RequestId:   "req",
+		RangeStart:  5,
+		RangeLength: 100,
+	}, strings.NewReader(content))
+	c.Assert(err, gc.ErrorMatches, "EOF")
+}
@@ -0,0 +1,43 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package params
+
+import (
+	"time"
+
+	"gopkg.in/juju/charm.v4"
+)
+
+// UploadResponse holds the result of an id/upload POST request,
+// which starts an archive upload made in parts.
+type UploadResponse struct {
+	UploadId string
+
+	// Expires holds the time after which the upload is
+	// discarded unless another part is uploaded.
+	Expires time.Time
+}
+
+// UploadInfoResponse holds the result of an upload/id GET request.
+type UploadInfoResponse struct {
+	UploadId string
+
+	// Id holds the id of the charm or bundle being uploaded.
+	Id *charm.Reference
+
+	// Expires holds the time after which the upload is
+	// discarded unless another part is uploaded.
+	Expires time.Time
+
+	// Parts holds the parts uploaded so far, ordered
+	// by part number.
+	Parts []UploadPart
+}
+
+// UploadPart holds information on an uploaded part.
+type UploadPart struct {
+	Part int
+	Hash string
+	Size int64
+}
@@ -0,0 +1,164 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package params
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/juju/charm.v4"
+)
+
+// WebhookEvent holds the name of an event that webhooks
+// can be notified about.
+type WebhookEvent string
+
+const (
+	// UploadEvent happens when a new revision of
+	// a charm or bundle is uploaded.
+	UploadEvent WebhookEvent = "upload"
+
+	// DeleteEvent happens when a revision of a charm
+	// or bundle is deleted.
+	DeleteEvent WebhookEvent = "delete"
+
+	// PromulgateEvent happens when a charm or bundle
+	// is promulgated or unpromulgated.
+	PromulgateEvent WebhookEvent = "promulgate"
+
+	// PermEvent happens when the permissions of a charm
+	// or bundle are changed.
+	PermEvent WebhookEvent = "perm"
+
+	// ExtraInfoEvent happens when the extra-info of a
+	// charm or bundle revision is changed.
+	ExtraInfoEvent WebhookEvent = "extra-info"
+)
+
+// WebhookEvents holds all the known webhook events.
+var WebhookEvents = []WebhookEvent{
+	UploadEvent,
+	DeleteEvent,
+	PromulgateEvent,
+	PermEvent,
+	ExtraInfoEvent,
+}
+
+// Headers sent with webhook payloads.
+const (
+	// WebhookEventHeader holds the name of the event.
+	WebhookEventHeader = "X-Charmstore-Event"
+
+	// WebhookDeliveryHeader holds the id of the delivery.
+	// It is the same for all the attempts to deliver a payload.
+	WebhookDeliveryHeader = "X-Charmstore-Delivery"
+
+	// WebhookSignatureHeader holds the signature of the payload:
+	// "sha256=" followed by the hex-encoded HMAC-SHA256 of the
+	// request body, keyed with the webhook secret.
+	WebhookSignatureHeader = "X-Charmstore-Signature"
+)
+
+// WebhookRequest holds the body of a webhooks POST request.
+type WebhookRequest struct {
+	// Namespace holds the user or team whose charms and bundles
+	// the webhook is notified about. If it is empty, the webhook
+	// is notified about all charms and bundles, and only the
+	// administrator can create it.
+	Namespace string `json:",omitempty"`
+
+	// URL holds the http or https URL that payloads are posted to.
+	URL string
+
+	// Secret holds the key used to sign payloads.
+	Secret string
+
+	// Events holds the events the webhook is notified about.
+	// If it is empty, the webhook is notified about all events.
+	Events []WebhookEvent `json:",omitempty"`
+}
+
+// Webhook holds a webhook subscription. It is returned by
+// webhooks GET and POST requests. The secret is never returned.
+type Webhook struct {
+	Id          string
+	Namespace   string `json:",omitempty"`
+	URL         string
+	Events      []WebhookEvent `json:",omitempty"`
+	CreatedTime time.Time
+}
+
+// WebhookPayload holds the body posted to webhooks.
+// It is also sent as the data of changes/stream events.
+type WebhookPayload struct {
+	// Event holds the name of the event.
+	Event WebhookEvent
+
+	// Id holds the id of the charm or bundle. It holds no series
+	// or revision for perm and promulgate events, which apply
+	// to all revisions.
+	Id *charm.Reference
+
+	// Time holds the time the event happened.
+	Time time.Time
+
+	// Hidden holds whether the revision is hidden (see
+	// id/meta/hidden). It is only set for upload, delete
+	// and extra-info events.
+	Hidden bool `json:",omitempty"`
+
+	// Perm holds the new permissions of the charm or bundle.
+	// It is only set for perm events.
+	Perm *PermResponse `json:",omitempty"`
+
+	// ExtraInfo holds the extra-info values that changed.
+	// It is only set for extra-info events.
+	ExtraInfo map[string]json.RawMessage `json:",omitempty"`
+
+	// Promulgated holds whether the charm or bundle is now
+	// promulgated. It is only set for promulgate events.
+	Promulgated *bool `json:",omitempty"`
+}
+
+// WebhookDeliveryStatus holds the status of a webhook delivery.
+type WebhookDeliveryStatus string
+
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliveryDelivered WebhookDeliveryStatus = "delivered"
+	DeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery holds a payload sent, or to be sent, to a webhook.
+// A slice of WebhookDelivery values is returned by a
+// webhooks/id/deliveries GET request, most recent first.
+type WebhookDelivery struct {
+	Id          string
+	Event       WebhookEvent
+	Payload     json.RawMessage
+	CreatedTime time.Time
+	Status      WebhookDeliveryStatus
+
+	// Attempts holds the delivery attempts made so far,
+	// oldest first.
+	Attempts []WebhookAttempt
+
+	// NextAttempt holds the earliest time the next delivery
+	// attempt will be made. It is only set for pending deliveries.
+	NextAttempt *time.Time `json:",omitempty"`
+}
+
+// WebhookAttempt holds the outcome of an attempt to deliver
+// a payload to a webhook.
+type WebhookAttempt struct {
+	Time time.Time
+
+	// StatusCode holds the HTTP status code of the response,
+	// or zero if no response was received.
+	StatusCode int `json:",omitempty"`
+
+	// Error holds why the attempt failed. It is empty if
+	// the attempt succeeded.
+	Error string `json:",omitempty"`
+}
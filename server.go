@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"sort"
+	"time"
 
 	"gopkg.in/macaroon-bakery.v0/bakery"
 	"gopkg.in/mgo.v2"
@@ -52,12 +53,62 @@ type ServerParams struct {
 	// PublicKeyLocator holds a public key store.
 	// It may be nil.
 	PublicKeyLocator bakery.PublicKeyLocator
+
+	// MaxArchiveSize holds the maximum size in bytes
+	// of an uploaded archive. If it is zero, there is no limit.
+	MaxArchiveSize int64
+
+	// MaxRevisions holds the maximum number of revisions
+	// that can be stored for a charm or bundle, across
+	// all series. If it is zero, there is no limit.
+	MaxRevisions int
+
+	// MaxUserStorage holds the maximum total size in bytes
+	// of the archives owned by a single user or team.
+	// If it is zero, there is no limit.
+	MaxUserStorage int64
+
+	// MaxArchiveUncompressedSize, MaxArchiveEntries and
+	// MaxArchiveCompressionRatio hold the limits checked when
+	// inspecting uploaded archives. If any of them is zero,
+	// a default limit is used.
+	MaxArchiveUncompressedSize int64
+	MaxArchiveEntries          int
+	MaxArchiveCompressionRatio float64
+
+	// Validators holds the names of the validators run
+	// on uploaded charms and bundles. If it is nil,
+	// the default validators are used.
+	Validators []string
+
+	// NamespaceValidators holds the validators run on charms
+	// and bundles owned by specific users or teams, keyed by
+	// user or team name. Each entry overrides Validators.
+	NamespaceValidators map[string][]string
+
+	// WebhookDeliveryInterval holds the interval between
+	// attempts to deliver queued webhook payloads. If it is
+	// zero, payloads are queued but not delivered.
+	WebhookDeliveryInterval time.Duration
+
+	// AllowPrivateWebhooks allows webhooks to be registered
+	// with, and payloads to be delivered to, hosts that resolve
+	// to loopback, private or other reserved addresses.
+	AllowPrivateWebhooks bool
+}
+
+// HTTPCloseHandler represents a HTTP handler that
+// must be closed after use.
+type HTTPCloseHandler interface {
+	http.Handler
+	Close()
 }
 
 // NewServer returns a new handler that handles charm store requests and stores
 // its data in the given database. The handler will serve the specified
 // versions of the API using the given configuration.
-func NewServer(db *mgo.Database, es *elasticsearch.Database, idx string, config ServerParams, serveVersions ...string) (http.Handler, error) {
+// The handler must be closed after use.
+func NewServer(db *mgo.Database, es *elasticsearch.Database, idx string, config ServerParams, serveVersions ...string) (HTTPCloseHandler, error) {
 	newAPIs := make(map[string]charmstore.NewAPIHandlerFunc)
 	for _, vers := range serveVersions {
 		newAPI := versions[vers]